@@ -0,0 +1,76 @@
+package pgconn
+
+import (
+	"context"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// AuthenticationHandler handles an authentication request from the server in place of the built-in handling. msg is
+// the Authentication* message that started the exchange. The handler sends its responses and receives any further
+// messages of the exchange (e.g. AuthenticationSASLContinue) through frontend. It returns once it has sent its final
+// response. The connection attempt then continues by waiting for AuthenticationOk or an error from the server. If the
+// handler returns an error the connection attempt fails.
+//
+// ctx is the context of the connection attempt. Reads and writes through frontend are interrupted when it is canceled.
+type AuthenticationHandler func(ctx context.Context, frontend AuthenticationFrontend, msg pgproto3.BackendMessage) error
+
+// AuthenticationFrontend is the connection to the server used by an AuthenticationHandler.
+type AuthenticationFrontend interface {
+	// Send sends msg to the server.
+	Send(msg pgproto3.FrontendMessage) error
+
+	// Receive receives the next message from the server. An ErrorResponse with severity FATAL is returned as a *PgError.
+	// A NoticeResponse or ParameterStatus is not returned. It is handled as usual (e.g. by calling Config.OnNotice).
+	Receive() (pgproto3.BackendMessage, error)
+}
+
+// AuthenticationTypeMessage can be implemented by messages returned by a custom Frontend (see Config.BuildFrontend) to
+// report the authentication type code of a vendor-specific Authentication* message so it can be dispatched to the
+// registered AuthenticationHandler.
+type AuthenticationTypeMessage interface {
+	pgproto3.BackendMessage
+	AuthenticationType() uint32
+}
+
+// authenticationType returns the authentication type code of msg if it is an authentication request.
+func authenticationType(msg pgproto3.BackendMessage) (uint32, bool) {
+	switch msg := msg.(type) {
+	case *pgproto3.AuthenticationCleartextPassword:
+		return pgproto3.AuthTypeCleartextPassword, true
+	case *pgproto3.AuthenticationMD5Password:
+		return pgproto3.AuthTypeMD5Password, true
+	case *pgproto3.AuthenticationGSS:
+		return pgproto3.AuthTypeGSS, true
+	case *pgproto3.AuthenticationGSSContinue:
+		return pgproto3.AuthTypeGSSCont, true
+	case *pgproto3.AuthenticationSASL:
+		return pgproto3.AuthTypeSASL, true
+	case *pgproto3.AuthenticationSASLContinue:
+		return pgproto3.AuthTypeSASLContinue, true
+	case *pgproto3.AuthenticationSASLFinal:
+		return pgproto3.AuthTypeSASLFinal, true
+	case AuthenticationTypeMessage:
+		return msg.AuthenticationType(), true
+	default:
+		return 0, false
+	}
+}
+
+// authFrontend implements AuthenticationFrontend for a connection that is being established.
+type authFrontend struct {
+	pgConn *PgConn
+}
+
+func (f authFrontend) Send(msg pgproto3.FrontendMessage) error {
+	buf, err := msg.Encode(f.pgConn.wbuf)
+	if err != nil {
+		return err
+	}
+	_, err = f.pgConn.write(buf)
+	return err
+}
+
+func (f authFrontend) Receive() (pgproto3.BackendMessage, error) {
+	return skipAsyncMessages(f.pgConn.receiveAnyMessage)
+}
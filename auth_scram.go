@@ -20,6 +20,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/jackc/pgproto3/v2"
@@ -29,6 +30,10 @@ import (
 
 const clientNonceLen = 18
 
+// scramNonceReader is the source of the random bytes of SCRAM client nonces. Tests replace it to verify an exchange
+// byte-for-byte.
+var scramNonceReader io.Reader = rand.Reader
+
 // Perform SCRAM authentication.
 func (c *PgConn) scramAuth(serverAuthMechanisms []string) error {
 	sc, err := newScramClient(serverAuthMechanisms, c.config.Password)
@@ -45,7 +50,7 @@ func (c *PgConn) scramAuth(serverAuthMechanisms []string) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.conn.Write(buf)
+	_, err = c.write(buf)
 	if err != nil {
 		return err
 	}
@@ -68,7 +73,7 @@ func (c *PgConn) scramAuth(serverAuthMechanisms []string) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.conn.Write(buf)
+	_, err = c.write(buf)
 	if err != nil {
 		return err
 	}
@@ -82,7 +87,7 @@ func (c *PgConn) scramAuth(serverAuthMechanisms []string) error {
 }
 
 func (c *PgConn) rxSASLContinue() (*pgproto3.AuthenticationSASLContinue, error) {
-	msg, err := c.receiveMessage()
+	msg, err := c.receiveAuthMessage()
 	if err != nil {
 		return nil, err
 	}
@@ -93,11 +98,11 @@ func (c *PgConn) rxSASLContinue() (*pgproto3.AuthenticationSASLContinue, error)
 		return nil, ErrorResponseToPgError(m)
 	}
 
-	return nil, fmt.Errorf("expected AuthenticationSASLContinue message but received unexpected message %T", msg)
+	return nil, c.newProtocolError(fmt.Sprintf("expected AuthenticationSASLContinue message but received %T", msg), nil)
 }
 
 func (c *PgConn) rxSASLFinal() (*pgproto3.AuthenticationSASLFinal, error) {
-	msg, err := c.receiveMessage()
+	msg, err := c.receiveAuthMessage()
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +113,7 @@ func (c *PgConn) rxSASLFinal() (*pgproto3.AuthenticationSASLFinal, error) {
 		return nil, ErrorResponseToPgError(m)
 	}
 
-	return nil, fmt.Errorf("expected AuthenticationSASLFinal message but received unexpected message %T", msg)
+	return nil, c.newProtocolError(fmt.Sprintf("expected AuthenticationSASLFinal message but received %T", msg), nil)
 }
 
 type scramClient struct {
@@ -144,16 +149,10 @@ func newScramClient(serverAuthMechanisms []string, password string) (*scramClien
 		return nil, errors.New("server does not support SCRAM-SHA-256")
 	}
 
-	// precis.OpaqueString is equivalent to SASLprep for password.
-	var err error
-	sc.password, err = precis.OpaqueString.Bytes([]byte(password))
-	if err != nil {
-		// PostgreSQL allows passwords invalid according to SCRAM / SASLprep.
-		sc.password = []byte(password)
-	}
+	sc.password = scramNormalizePassword(password)
 
 	buf := make([]byte, clientNonceLen)
-	_, err = rand.Read(buf)
+	_, err := io.ReadFull(scramNonceReader, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -248,6 +247,17 @@ func (sc *scramClient) recvServerFinalMessage(serverFinalMessage []byte) error {
 	return nil
 }
 
+// scramNormalizePassword prepares password for SCRAM with SASLprep like PostgreSQL.
+func scramNormalizePassword(password string) []byte {
+	// precis.OpaqueString is equivalent to SASLprep for password.
+	normalized, err := precis.OpaqueString.Bytes([]byte(password))
+	if err != nil {
+		// PostgreSQL allows passwords invalid according to SCRAM / SASLprep.
+		return []byte(password)
+	}
+	return normalized
+}
+
 func computeHMAC(key, msg []byte) []byte {
 	mac := hmac.New(sha256.New, key)
 	mac.Write(msg)
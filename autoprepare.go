@@ -0,0 +1,80 @@
+package pgconn
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	errCodeInvalidSQLStatementName    = "26000" // prepared statement does not exist
+	errCodeDuplicatePreparedStatement = "42P05"
+)
+
+// ExecAuto executes sql as a prepared statement and reads the result. The statement is prepared the first time ExecAuto
+// is called with sql on the connection and reused afterwards, so repeated queries are only parsed and planned once.
+// The statement name is derived from a hash of sql. paramValues and results are in text format and the server infers
+// the parameter types.
+//
+// If the statement no longer exists on the server, e.g. because it was deallocated by DEALLOCATE ALL or because the
+// connection is behind a proxy that switched the server connection, it is prepared again and the execution is retried
+// once. This is not possible in a transaction as the failed execution aborts it. Statements prepared by ExecAuto are
+// only deallocated when the connection is closed or Reset.
+//
+// Behind a transaction pooler (see Config.PoolerMode) sql is executed with ExecParams instead as the prepared statement
+// may not exist on the server connection used for the next transaction.
+func (pgConn *PgConn) ExecAuto(ctx context.Context, sql string, paramValues ...[]byte) *Result {
+	if pgConn.pooler != nil {
+		return pgConn.ExecParams(ctx, sql, paramValues, nil, nil, textResultFormats).Read()
+	}
+
+	name, prepared := pgConn.autoStatements[sql]
+	if !prepared {
+		var err error
+		name, err = pgConn.prepareAuto(ctx, sql)
+		if err != nil {
+			return &Result{Err: err}
+		}
+	}
+
+	result := pgConn.ExecPrepared(ctx, name, paramValues, nil, textResultFormats).Read()
+	if prepared && isPgErrorCode(result.Err, errCodeInvalidSQLStatementName) && pgConn.TxStatus() == 'I' {
+		delete(pgConn.autoStatements, sql)
+		if _, err := pgConn.prepareAuto(ctx, sql); err != nil {
+			return &Result{Err: err}
+		}
+		result = pgConn.ExecPrepared(ctx, name, paramValues, nil, textResultFormats).Read()
+	}
+
+	return result
+}
+
+// prepareAuto prepares sql for ExecAuto and returns the statement name.
+func (pgConn *PgConn) prepareAuto(ctx context.Context, sql string) (string, error) {
+	name := autoStatementName(sql)
+
+	_, err := pgConn.Prepare(ctx, name, sql, nil)
+	// The name is derived from sql so an existing statement with the same name was prepared for the same sql, e.g. by
+	// another PgConn that shared the server connection.
+	if err != nil && !(isPgErrorCode(err, errCodeDuplicatePreparedStatement) && pgConn.TxStatus() == 'I') {
+		return "", err
+	}
+
+	if pgConn.autoStatements == nil {
+		pgConn.autoStatements = make(map[string]string)
+	}
+	pgConn.autoStatements[sql] = name
+
+	return name, nil
+}
+
+func autoStatementName(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return "pgconn_auto_" + hex.EncodeToString(sum[:16])
+}
+
+func isPgErrorCode(err error, code string) bool {
+	var pgErr *PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
@@ -254,6 +254,29 @@ func BenchmarkExecPreparedPossibleToCancel(b *testing.B) {
 // 	}
 // }
 
+func BenchmarkCopyFrom(b *testing.B) {
+	conn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.Nil(b, err)
+	defer closeConn(b, conn)
+
+	_, err = conn.Exec(context.Background(), "create temporary table foo(a int4, b varchar)").ReadAll()
+	require.Nil(b, err)
+
+	var buf bytes.Buffer
+	for i := 0; i < 100000; i++ {
+		buf.WriteString("42\tfoo bar baz\n")
+	}
+	data := buf.Bytes()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := conn.CopyFrom(context.Background(), bytes.NewReader(data), "copy foo from stdin")
+		require.Nil(b, err)
+	}
+}
+
 func BenchmarkCommandTagRowsAffected(b *testing.B) {
 	benchmarks := []struct {
 		commandTag   string
@@ -0,0 +1,132 @@
+package pgconn
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// CancelConnPool keeps connections for CancelRequest established in advance so a query can be canceled without waiting
+// to connect to the server. Set Config.CancelConnPool to use it. The same CancelConnPool can be shared by any number of
+// Configs and connections. It is safe for concurrent usage.
+//
+// PostgreSQL closes a cancel request connection after reading the request so each connection is only used once. The
+// pool holds up to size idle connections per server address and replaces each connection as it is used. The server
+// also closes a connection that has not sent a request within authentication_timeout (one minute by default). Idle
+// connections are discarded after maxIdleTime which must be less than that.
+type CancelConnPool struct {
+	size        int
+	maxIdleTime time.Duration
+
+	mux     sync.Mutex
+	idle    map[cancelConnKey][]idleCancelConn
+	dialing map[cancelConnKey]int
+	closed  bool
+}
+
+type cancelConnKey struct {
+	network string
+	address string
+}
+
+type idleCancelConn struct {
+	conn      net.Conn
+	createdAt time.Time
+}
+
+// NewCancelConnPool returns a CancelConnPool that keeps up to size idle connections per server address for at most
+// maxIdleTime.
+func NewCancelConnPool(size int, maxIdleTime time.Duration) *CancelConnPool {
+	return &CancelConnPool{
+		size:        size,
+		maxIdleTime: maxIdleTime,
+		idle:        make(map[cancelConnKey][]idleCancelConn),
+		dialing:     make(map[cancelConnKey]int),
+	}
+}
+
+// Close closes all idle connections. Connections being established are closed when they are ready. CancelRequest
+// establishes a new connection for each request after the pool is closed.
+func (p *CancelConnPool) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.closed = true
+	for key, conns := range p.idle {
+		for _, ic := range conns {
+			ic.conn.Close()
+		}
+		delete(p.idle, key)
+	}
+}
+
+// take returns an idle connection to addr or nil if there is none. It starts establishing replacement connections in
+// the background.
+func (p *CancelConnPool) take(config *Config, addr net.Addr) net.Conn {
+	key := cancelConnKey{network: addr.Network(), address: addr.String()}
+	now := config.clock().Now()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var conn net.Conn
+	conns := p.idle[key]
+	for len(conns) > 0 && conn == nil {
+		ic := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if now.Sub(ic.createdAt) < p.maxIdleTime {
+			conn = ic.conn
+		} else {
+			ic.conn.Close()
+		}
+	}
+	p.idle[key] = conns
+
+	p.fillLocked(config, key)
+
+	return conn
+}
+
+// fill starts establishing connections to addr until the pool is full.
+func (p *CancelConnPool) fill(config *Config, addr net.Addr) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.fillLocked(config, cancelConnKey{network: addr.Network(), address: addr.String()})
+}
+
+func (p *CancelConnPool) fillLocked(config *Config, key cancelConnKey) {
+	if p.closed {
+		return
+	}
+
+	for n := len(p.idle[key]) + p.dialing[key]; n < p.size; n++ {
+		p.dialing[key]++
+		go p.dial(config, key)
+	}
+}
+
+func (p *CancelConnPool) dial(config *Config, key cancelConnKey) {
+	timeout := config.CancelRequestDialTimeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	ctx, cancel := contextWithTimeout(context.Background(), config.clock(), timeout)
+	defer cancel()
+
+	conn, err := config.DialFunc(ctx, key.network, key.address)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.dialing[key]--
+	if err != nil {
+		return
+	}
+	if p.closed {
+		conn.Close()
+		return
+	}
+	p.idle[key] = append(p.idle[key], idleCancelConn{conn: conn, createdAt: config.clock().Now()})
+}
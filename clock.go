@@ -0,0 +1,93 @@
+package pgconn
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the timeouts and timers of pgconn: ConnectTimeout, the cancel request timeouts,
+// IdleKeepaliveInterval, CopyFromMaxBufferDelay, RetryPolicy.Backoff, PoolConfig.MaxConnLifetime, the timeout of
+// ValidateConnectPing, and the delays of CancelRequestContextWatcherHandler. It can be replaced with a fake clock to
+// test code that depends on them without real sleeps. Other deadlines of the underlying net.Conn are enforced by the
+// operating system and use the real time.
+type Clock interface {
+	Now() time.Time
+
+	// NewTimer returns a Timer that sends on its channel after d.
+	NewTimer(d time.Duration) Timer
+
+	// AfterFunc returns a Timer that calls f in its own goroutine after d.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by a Clock. Its methods have the same semantics as the methods of *time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on. It is nil for a Timer created by AfterFunc.
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is the Clock used when Config.Clock is nil.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return realTimer{time.AfterFunc(d, f)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// clock returns the Clock of config.
+func (config *Config) clock() Clock {
+	if config.Clock == nil {
+		return realClock{}
+	}
+	return config.Clock
+}
+
+// contextWithTimeout is like context.WithTimeout but measures timeout with clock.
+func contextWithTimeout(parent context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(parent, timeout)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	c := &clockTimeoutContext{Context: ctx}
+	timer := clock.AfterFunc(timeout, func() {
+		c.mux.Lock()
+		c.timedOut = ctx.Err() == nil
+		c.mux.Unlock()
+		cancel()
+	})
+
+	return c, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+// clockTimeoutContext is a context canceled by a Clock timer. It reports context.DeadlineExceeded like a context
+// created by context.WithTimeout. It does not have a deadline as the time of a fake Clock is unrelated to the real time.
+type clockTimeoutContext struct {
+	context.Context
+
+	mux      sync.Mutex
+	timedOut bool
+}
+
+func (c *clockTimeoutContext) Err() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.timedOut {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
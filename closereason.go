@@ -0,0 +1,102 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// CloseReasonKind classifies why a connection was closed.
+type CloseReasonKind int
+
+const (
+	CloseReasonClose          CloseReasonKind = iota // Close was called
+	CloseReasonCanceled                              // an operation was interrupted by its context or a deadline
+	CloseReasonNetworkError                          // reading from or writing to the server failed
+	CloseReasonFatalError                            // the server reported a FATAL error, e.g. the backend was terminated
+	CloseReasonOperationError                        // an operation failed in a way that left the connection unusable
+)
+
+func (k CloseReasonKind) String() string {
+	switch k {
+	case CloseReasonClose:
+		return "close"
+	case CloseReasonCanceled:
+		return "canceled"
+	case CloseReasonNetworkError:
+		return "network error"
+	case CloseReasonFatalError:
+		return "fatal error"
+	case CloseReasonOperationError:
+		return "operation error"
+	default:
+		return "unknown"
+	}
+}
+
+// CloseReason describes why a connection was closed. It is passed to Config.OnClose.
+type CloseReason struct {
+	Kind CloseReasonKind
+
+	// Op is the name of the PgConn method that was in progress (e.g. "Exec" or "CopyFrom"). It is empty if the
+	// connection was idle.
+	Op string
+
+	// Err is the error that caused the connection to be closed. It is a *PgError for CloseReasonFatalError and nil for
+	// CloseReasonClose.
+	Err error
+}
+
+// CloseHandler is a function that is called when a connection that has been established is closed. The *PgConn is
+// provided so the handler is aware of the origin of the closure, but it must not invoke any query method.
+type CloseHandler func(pgConn *PgConn, reason CloseReason)
+
+// newCloseReason classifies err that caused the connection to be closed during op.
+func newCloseReason(op string, err error) CloseReason {
+	reason := CloseReason{Op: op, Err: err}
+
+	var pgErr *PgError
+	var netErr net.Error
+	var writeErr *writeError
+	switch {
+	case errors.As(err, &pgErr) && isFatalPgError(pgErr):
+		reason.Kind = CloseReasonFatalError
+		reason.Err = pgErr
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || causedByTimeout(err):
+		reason.Kind = CloseReasonCanceled
+	case errors.As(err, &netErr) || errors.As(err, &writeErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		reason.Kind = CloseReasonNetworkError
+	default:
+		reason.Kind = CloseReasonOperationError
+	}
+
+	return reason
+}
+
+// isFatalPgError returns true if err has a severity that terminates the session.
+func isFatalPgError(err *PgError) bool {
+	severity := err.SeverityUnlocalized
+	if severity == "" {
+		severity = err.Severity
+	}
+	return severity == "FATAL" || severity == "PANIC"
+}
+
+// markClosed marks the connection as closed and reports reason to Config.OnClose if the connection had been
+// established.
+func (pgConn *PgConn) markClosed(reason CloseReason) {
+	switch atomic.SwapUint32(&pgConn.status, connStatusClosed) {
+	case connStatusIdle, connStatusBusy:
+		pgConn.reportClose(reason)
+	}
+}
+
+// reportClose calls Config.OnClose with reason unless a reason has already been reported. It may be called from the
+// idle keepalive goroutine.
+func (pgConn *PgConn) reportClose(reason CloseReason) {
+	if pgConn.config.OnClose != nil {
+		pgConn.closeReportOnce.Do(func() { pgConn.config.OnClose(pgConn, reason) })
+	}
+}
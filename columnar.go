@@ -0,0 +1,92 @@
+package pgconn
+
+import "github.com/jackc/pgproto3/v2"
+
+// ColumnBatch holds the values of consecutive rows of a result stored by column. The layout follows the variable size
+// binary layout of Apache Arrow so that a batch of binary format values can be converted to Arrow or Parquet columns
+// without transposing rows. A ColumnBatch is filled by ResultReader.NextColumnBatch and can be reused for the next
+// batch to avoid allocations.
+type ColumnBatch struct {
+	// Fields describes the columns. It is only valid until the ResultReader is closed.
+	Fields []pgproto3.FieldDescription
+
+	// Columns holds the values of each column in the order of Fields.
+	Columns []Column
+
+	// Len is the number of rows in the batch.
+	Len int
+}
+
+// Column holds the values of a column of a ColumnBatch.
+type Column struct {
+	// Data is the concatenated values of all rows.
+	Data []byte
+
+	// Offsets has one element more than the batch has rows. The value of row i is Data[Offsets[i]:Offsets[i+1]].
+	Offsets []int
+
+	// Nulls reports for each row if the value is NULL. The value of a NULL is empty in Data.
+	Nulls []bool
+}
+
+// Value returns the value of row i or nil if it is NULL. It references Data.
+func (c *Column) Value(i int) []byte {
+	if c.Nulls[i] {
+		return nil
+	}
+	return c.Data[c.Offsets[i]:c.Offsets[i+1]:c.Offsets[i+1]]
+}
+
+// reset empties c while keeping the allocated buffers.
+func (c *Column) reset() {
+	c.Data = c.Data[:0]
+	c.Offsets = append(c.Offsets[:0], 0)
+	c.Nulls = c.Nulls[:0]
+}
+
+func (c *Column) append(value []byte) {
+	c.Data = append(c.Data, value...)
+	c.Offsets = append(c.Offsets, len(c.Data))
+	c.Nulls = append(c.Nulls, value == nil)
+}
+
+// reset empties batch for the columns of fields while keeping the allocated buffers.
+func (batch *ColumnBatch) reset(fields []pgproto3.FieldDescription) {
+	batch.Fields = fields
+	batch.Len = 0
+
+	if cap(batch.Columns) >= len(fields) {
+		batch.Columns = batch.Columns[:len(fields)]
+	} else {
+		batch.Columns = append(batch.Columns[:cap(batch.Columns)], make([]Column, len(fields)-cap(batch.Columns))...)
+	}
+	for i := range batch.Columns {
+		batch.Columns[i].reset()
+	}
+}
+
+// NextColumnBatch reads up to maxRows rows into batch and returns true if at least one row was read. The previous
+// content of batch is replaced. The values are copied so batch remains valid after further rows are read. Result
+// formats of binary should be requested to receive values in the format used by columnar formats.
+//
+//	var batch pgconn.ColumnBatch
+//	for rr.NextColumnBatch(&batch, 1024) {
+//		// process batch
+//	}
+//	commandTag, err := rr.Close()
+func (rr *ResultReader) NextColumnBatch(batch *ColumnBatch, maxRows int) bool {
+	batch.reset(rr.fieldDescriptions)
+
+	for batch.Len < maxRows && rr.NextRow() {
+		if batch.Len == 0 {
+			// The field descriptions are only known when the first row has been received.
+			batch.reset(rr.fieldDescriptions)
+		}
+		for i, value := range rr.rowValues {
+			batch.Columns[i].append(value)
+		}
+		batch.Len++
+	}
+
+	return batch.Len > 0
+}
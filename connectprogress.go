@@ -0,0 +1,89 @@
+package pgconn
+
+import (
+	"time"
+)
+
+// ConnectStage is a stage of a connection attempt reported to Config.OnConnectProgress.
+type ConnectStage int
+
+const (
+	ConnectStageResolve  ConnectStage = iota // resolving the host name of Host or a fallback with LookupFunc
+	ConnectStageDial                         // establishing the network connection with DialFunc
+	ConnectStageTLS                          // performing the TLS handshake
+	ConnectStageAuth                         // sending the startup message and authenticating
+	ConnectStageValidate                     // calling ValidateConnect
+)
+
+func (s ConnectStage) String() string {
+	switch s {
+	case ConnectStageResolve:
+		return "resolve"
+	case ConnectStageDial:
+		return "dial"
+	case ConnectStageTLS:
+		return "tls"
+	case ConnectStageAuth:
+		return "auth"
+	case ConnectStageValidate:
+		return "validate"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectProgress describes the start or the end of a stage of a connection attempt.
+type ConnectProgress struct {
+	Stage ConnectStage
+
+	// Host and Port are the target of the attempt. Host is the name being resolved in ConnectStageResolve and the
+	// resolved IP address or the unix domain socket directory in the later stages.
+	Host string
+	Port uint16
+
+	// Done is false when the stage starts and true when it ends. Err and Duration are only set when the stage ends. Err
+	// is nil if the stage succeeded.
+	Done     bool
+	Err      error
+	Duration time.Duration
+}
+
+// ConnectProgressFunc is a function that is called at the start and the end of each stage of each connection attempt,
+// including the attempts of fallbacks.
+type ConnectProgressFunc func(ConnectProgress)
+
+// connectStage reports the progress of a stage of a connection attempt to Config.OnConnectProgress.
+type connectStage struct {
+	config   *Config
+	progress ConnectProgress
+	start    time.Time
+}
+
+// startConnectStage reports the start of stage of the attempt to connect to host and port.
+func startConnectStage(config *Config, stage ConnectStage, host string, port uint16) *connectStage {
+	cs := &connectStage{config: config, progress: ConnectProgress{Stage: stage, Host: host, Port: port}}
+	if config.OnConnectProgress != nil {
+		cs.start = config.clock().Now()
+		config.OnConnectProgress(cs.progress)
+	}
+	return cs
+}
+
+// end reports the end of the stage with err as the outcome. It does nothing if the end has already been reported.
+func (cs *connectStage) end(err error) {
+	if cs.progress.Done {
+		return
+	}
+	cs.progress.Done = true
+	if cs.config.OnConnectProgress != nil {
+		cs.progress.Err = err
+		cs.progress.Duration = cs.config.clock().Now().Sub(cs.start)
+		cs.config.OnConnectProgress(cs.progress)
+	}
+}
+
+// next reports the successful end of the stage and the start of the next stage of the same attempt.
+func (cs *connectStage) next(stage ConnectStage) {
+	cs.end(nil)
+	*cs = *startConnectStage(cs.config, stage, cs.progress.Host, cs.progress.Port)
+}
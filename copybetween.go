@@ -0,0 +1,124 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// CopyBetweenOptions controls CopyBetween. The zero value is valid.
+type CopyBetweenOptions struct {
+	// OnProgress, if set, is called with the total number of bytes read from the source so far each time data is
+	// received. It is called from a different goroutine than the one calling CopyBetween.
+	OnProgress func(bytesCopied int64)
+}
+
+// CopyBetween executes the copy to command srcSQL (e.g. COPY t TO STDOUT) on src and the copy from command dstSQL (e.g.
+// COPY t FROM STDIN) on dst and streams the data directly from src to dst. src and dst may be connected to the same or
+// different servers but must be different connections. Data is only read from src as fast as it can be written to dst,
+// so a slow destination slows down the source instead of causing data to be buffered. It returns the command tag of
+// dstSQL.
+//
+// If the copy fails on src the copy on dst is aborted with CopyFail and the error of src is returned. If it fails on
+// dst the copy on src is canceled with a cancel request and the error of dst is returned. In both cases the connection
+// that did not fail remains usable unless the cancel request could not be sent, in which case src is closed. A
+// canceled ctx interrupts both connections.
+func CopyBetween(ctx context.Context, dst *PgConn, dstSQL string, src *PgConn, srcSQL string, options CopyBetweenOptions) (CommandTag, error) {
+	if dst == src {
+		return nil, errors.New("source and destination of CopyBetween must be different connections")
+	}
+
+	cr := src.CopyToStream(ctx, srcSQL)
+	if cr.closed {
+		return cr.Close()
+	}
+
+	r := &copyBetweenReader{cr: cr, onProgress: options.OnProgress}
+	commandTag, err := dst.CopyFrom(ctx, r, dstSQL)
+
+	// CopyFrom may return while its goroutine is still reading from src. Wait for the read to end and prevent further
+	// reads before src is used here. If the copy on dst failed first the read may only end when src is canceled.
+	canceled := false
+	if !r.finished() {
+		if cancelErr := src.CancelRequest(ctx); cancelErr != nil {
+			src.asyncClose(&pgconnError{msg: "failed to cancel copy to", err: cancelErr})
+		}
+		canceled = true
+	}
+	srcErr := r.stop()
+	_, closeErr := cr.Close()
+
+	// When src was canceled because dst failed, srcErr is only the result of the cancellation.
+	if err != nil && canceled {
+		return nil, err
+	}
+	if srcErr != nil {
+		return nil, srcErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	return commandTag, nil
+}
+
+// copyBetweenReader reads the data of a CopyToReader for CopyFrom. It records the error of the source and reports
+// progress.
+type copyBetweenReader struct {
+	cr         *CopyToReader
+	onProgress func(bytesCopied int64)
+
+	ended uint32 // set to 1 when cr returned an error or io.EOF
+
+	mux     sync.Mutex
+	n       int64
+	err     error // error of cr; io.EOF when all data has been read
+	stopped bool
+}
+
+func (r *copyBetweenReader) Read(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.stopped {
+		return 0, errors.New("copy stopped")
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.cr.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		if r.onProgress != nil {
+			r.onProgress(r.n)
+		}
+	}
+	if err != nil {
+		r.err = err
+		atomic.StoreUint32(&r.ended, 1)
+	}
+	return n, err
+}
+
+// finished returns true if the source has ended successfully or with an error. It does not wait for a Read in
+// progress.
+func (r *copyBetweenReader) finished() bool {
+	return atomic.LoadUint32(&r.ended) == 1
+}
+
+// stop waits for a Read in progress, prevents further reads, and returns the error of the source other than io.EOF.
+func (r *copyBetweenReader) stop() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.stopped = true
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
@@ -0,0 +1,200 @@
+package pgconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// binaryCopySignature is the signature that starts the header of the binary COPY format.
+var binaryCopySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// binaryCopyOIDsFlag is the header flag that indicates that each tuple includes an OID. It is only set by servers before
+// PostgreSQL 12.
+const binaryCopyOIDsFlag = 1 << 16
+
+// BinaryCopyReader reads the data of a COPY ... TO STDOUT (FORMAT binary) command and validates its framing. The header
+// and the trailer are checked and removed, so reading returns only the tuples. Each tuple is a 16-bit field count
+// followed by each field as a 32-bit length (-1 for NULL) and the field bytes. Field values are passed through without
+// being buffered.
+//
+// Read returns an error wrapping io.ErrUnexpectedEOF if the data ends before the trailer. It returns an error if the
+// signature is wrong, an unknown critical flag is set, the tuples are malformed, or there is data after the trailer.
+// Errors of the underlying reader, such as the PgError of a failed command, are returned unchanged.
+//
+//	cr := pgConn.CopyToStream(ctx, "copy t to stdout (format binary)")
+//	_, err := io.Copy(w, pgconn.NewBinaryCopyReader(cr))
+//	commandTag, closeErr := cr.Close()
+type BinaryCopyReader struct {
+	r io.Reader
+
+	headerRead bool
+	done       bool
+	err        error
+
+	oids       bool    // true if each tuple includes an OID
+	small      [4]byte // buffer for field counts and lengths
+	pending    []byte  // unread field count or length in small
+	fieldsLeft int     // fields of the current tuple whose length has not been read
+	dataLeft   int     // unread bytes of the current field value
+}
+
+// NewBinaryCopyReader returns a BinaryCopyReader that reads binary COPY data from r (e.g. a CopyToReader).
+func NewBinaryCopyReader(r io.Reader) *BinaryCopyReader {
+	return &BinaryCopyReader{r: r}
+}
+
+// Read reads tuple data into p. It returns io.EOF after the trailer has been read.
+func (br *BinaryCopyReader) Read(p []byte) (int, error) {
+	if br.err != nil {
+		return 0, br.err
+	}
+	if br.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if !br.headerRead {
+		if err := br.readHeader(); err != nil {
+			br.err = err
+			return 0, err
+		}
+		br.headerRead = true
+	}
+
+	for {
+		if len(br.pending) > 0 {
+			n := copy(p, br.pending)
+			br.pending = br.pending[n:]
+			return n, nil
+		}
+
+		if br.dataLeft > 0 {
+			if len(p) > br.dataLeft {
+				p = p[:br.dataLeft]
+			}
+			n, err := br.r.Read(p)
+			br.dataLeft -= n
+			if err != nil {
+				br.err = br.translateEOF(err, "field value")
+				if n == 0 {
+					return 0, br.err
+				}
+			}
+			return n, nil
+		}
+
+		var err error
+		if br.fieldsLeft > 0 {
+			err = br.readFieldLength()
+		} else {
+			err = br.readFieldCount()
+		}
+		if err != nil {
+			br.err = err
+			return 0, err
+		}
+		if br.done {
+			return 0, io.EOF
+		}
+	}
+}
+
+func (br *BinaryCopyReader) readHeader() error {
+	header := make([]byte, len(binaryCopySignature)+8)
+	sigLen, err := io.ReadFull(br.r, header[:len(binaryCopySignature)])
+	if !bytes.Equal(header[:sigLen], binaryCopySignature[:sigLen]) {
+		return errors.New("binary copy: invalid signature")
+	}
+	if err != nil {
+		return br.translateEOF(err, "header")
+	}
+	if _, err := io.ReadFull(br.r, header[len(binaryCopySignature):]); err != nil {
+		return br.translateEOF(err, "header")
+	}
+
+	flags := binary.BigEndian.Uint32(header[len(binaryCopySignature):])
+	if flags&^binaryCopyOIDsFlag&0xffff0000 != 0 {
+		return fmt.Errorf("binary copy: unknown critical header flags 0x%08x", flags)
+	}
+	br.oids = flags&binaryCopyOIDsFlag != 0
+
+	extensionLen := int32(binary.BigEndian.Uint32(header[len(binaryCopySignature)+4:]))
+	if extensionLen < 0 {
+		return errors.New("binary copy: invalid header extension length")
+	}
+	if _, err := io.CopyN(io.Discard, br.r, int64(extensionLen)); err != nil {
+		return br.translateEOF(err, "header extension")
+	}
+
+	return nil
+}
+
+func (br *BinaryCopyReader) readFieldCount() error {
+	buf := br.small[:2]
+	if _, err := io.ReadFull(br.r, buf); err != nil {
+		return br.translateEOF(err, "tuple")
+	}
+
+	count := int16(binary.BigEndian.Uint16(buf))
+	if count == -1 {
+		return br.readEnd()
+	}
+	if count < 0 {
+		return fmt.Errorf("binary copy: invalid field count %d", count)
+	}
+
+	br.fieldsLeft = int(count)
+	if br.oids {
+		br.fieldsLeft++
+	}
+	br.pending = buf
+	return nil
+}
+
+func (br *BinaryCopyReader) readFieldLength() error {
+	buf := br.small[:4]
+	if _, err := io.ReadFull(br.r, buf); err != nil {
+		return br.translateEOF(err, "field length")
+	}
+
+	length := int32(binary.BigEndian.Uint32(buf))
+	if length < -1 {
+		return fmt.Errorf("binary copy: invalid field length %d", length)
+	}
+
+	br.fieldsLeft--
+	if length > 0 {
+		br.dataLeft = int(length)
+	}
+	br.pending = buf
+	return nil
+}
+
+// readEnd checks that nothing follows the trailer.
+func (br *BinaryCopyReader) readEnd() error {
+	n, err := br.r.Read(br.small[:1])
+	for n == 0 && err == nil {
+		n, err = br.r.Read(br.small[:1])
+	}
+	if n > 0 {
+		return errors.New("binary copy: data after trailer")
+	}
+	if err != io.EOF {
+		return err
+	}
+
+	br.done = true
+	return nil
+}
+
+func (br *BinaryCopyReader) translateEOF(err error, what string) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("binary copy: truncated %s: %w", what, io.ErrUnexpectedEOF)
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package pgconn_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func binaryCopyHeader(flags, extension []byte) []byte {
+	header := append([]byte("PGCOPY\n\377\r\n\000"), flags...)
+	header = append(header, 0, 0, 0, byte(len(extension)))
+	return append(header, extension...)
+}
+
+func TestBinaryCopyReader(t *testing.T) {
+	t.Parallel()
+
+	tuples := []byte{
+		0, 2, // 2 fields
+		0, 0, 0, 3, 'f', 'o', 'o',
+		0xff, 0xff, 0xff, 0xff, // NULL
+		0, 1, // 1 field
+		0, 0, 0, 0, // empty value
+	}
+	trailer := []byte{0xff, 0xff}
+
+	for _, tt := range []struct {
+		name   string
+		data   []byte
+		tuples []byte
+	}{
+		{
+			name:   "tuples",
+			data:   append(append(binaryCopyHeader([]byte{0, 0, 0, 0}, nil), tuples...), trailer...),
+			tuples: tuples,
+		},
+		{
+			name:   "no tuples",
+			data:   append(binaryCopyHeader([]byte{0, 0, 0, 0}, nil), trailer...),
+			tuples: []byte{},
+		},
+		{
+			name:   "header extension and non-critical flag",
+			data:   append(append(binaryCopyHeader([]byte{0, 0, 0, 1}, []byte("ext")), tuples...), trailer...),
+			tuples: tuples,
+		},
+		{
+			name:   "OIDs",
+			data:   append(binaryCopyHeader([]byte{0, 1, 0, 0}, nil), 0, 1, 0, 0, 0, 1, 7, 0, 0, 0, 1, 'a', 0xff, 0xff),
+			tuples: []byte{0, 1, 0, 0, 0, 1, 7, 0, 0, 0, 1, 'a'},
+		},
+	} {
+		// OneByteReader checks that values split across reads are handled.
+		buf, err := ioutil.ReadAll(pgconn.NewBinaryCopyReader(iotest.OneByteReader(bytes.NewReader(tt.data))))
+		require.NoErrorf(t, err, "%s", tt.name)
+		assert.Equalf(t, tt.tuples, buf, "%s", tt.name)
+
+		buf, err = ioutil.ReadAll(pgconn.NewBinaryCopyReader(bytes.NewReader(tt.data)))
+		require.NoErrorf(t, err, "%s", tt.name)
+		assert.Equalf(t, tt.tuples, buf, "%s", tt.name)
+	}
+}
+
+func TestBinaryCopyReaderInvalidData(t *testing.T) {
+	t.Parallel()
+
+	header := binaryCopyHeader([]byte{0, 0, 0, 0}, nil)
+	tuple := []byte{0, 1, 0, 0, 0, 3, 'f', 'o', 'o'}
+
+	for _, tt := range []struct {
+		name      string
+		data      []byte
+		errMsg    string
+		truncated bool
+	}{
+		{name: "empty", data: nil, errMsg: "truncated header", truncated: true},
+		{name: "text format", data: []byte("1\tfoo\n2\tbar\n\\.\n"), errMsg: "invalid signature"},
+		{name: "unknown critical flag", data: binaryCopyHeader([]byte{0, 2, 0, 0}, nil), errMsg: "unknown critical header flags 0x00020000"},
+		{name: "truncated header extension", data: append(binaryCopyHeader([]byte{0, 0, 0, 0}, nil)[:len(header)-1], 5, 'x'), errMsg: "truncated header extension", truncated: true},
+		{name: "missing trailer", data: append(append([]byte{}, header...), tuple...), errMsg: "truncated tuple", truncated: true},
+		{name: "truncated field length", data: append(append([]byte{}, header...), tuple[:4]...), errMsg: "truncated field length", truncated: true},
+		{name: "truncated field value", data: append(append([]byte{}, header...), tuple[:7]...), errMsg: "truncated field value", truncated: true},
+		{name: "invalid field count", data: append(append([]byte{}, header...), 0xff, 0xfe), errMsg: "invalid field count -2"},
+		{name: "invalid field length", data: append(append([]byte{}, header...), 0, 1, 0xff, 0xff, 0xff, 0xfe), errMsg: "invalid field length -2"},
+		{name: "data after trailer", data: append(append([]byte{}, header...), 0xff, 0xff, 0), errMsg: "data after trailer"},
+	} {
+		_, err := ioutil.ReadAll(pgconn.NewBinaryCopyReader(bytes.NewReader(tt.data)))
+		require.Errorf(t, err, "%s", tt.name)
+		assert.Containsf(t, err.Error(), tt.errMsg, "%s", tt.name)
+		assert.Equalf(t, tt.truncated, errors.Is(err, io.ErrUnexpectedEOF), "%s", tt.name)
+	}
+}
+
+func TestBinaryCopyReaderPassesThroughReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	readErr := errors.New("copy failed")
+	r := io.MultiReader(bytes.NewReader(binaryCopyHeader([]byte{0, 0, 0, 0}, nil)), iotest.ErrReader(readErr))
+	_, err := ioutil.ReadAll(pgconn.NewBinaryCopyReader(r))
+	assert.Equal(t, readErr, err)
+}
@@ -0,0 +1,140 @@
+package pgconn
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgio"
+)
+
+// copyDataBufferLen is the size of the buffer CopyFrom fills with source data before it is sent as a CopyData message.
+const copyDataBufferLen = 65536
+
+// copyDataSender writes the CopyData messages of CopyFrom from the goroutine reading the source. Once abandoned it does
+// not write anymore, so CopyFrom can finish the copy while a Read of the source is blocked.
+type copyDataSender struct {
+	pgConn *PgConn
+
+	mux       sync.Mutex
+	abandoned bool
+	err       error // write error; the connection has been closed
+
+	// Used by copyWithDelay.
+	buf   []byte
+	timer Timer
+	gen   uint64 // incremented whenever buf is sent so a timer for data already sent does nothing
+}
+
+// write writes msg unless the sender has been abandoned. It returns false if nothing was written because the sender
+// was abandoned.
+func (s *copyDataSender) write(msg []byte) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.abandoned {
+		return false, nil
+	}
+	return true, s.writeLocked(msg)
+}
+
+func (s *copyDataSender) writeLocked(msg []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	_, err := s.pgConn.write(msg)
+	if err != nil {
+		// Write errors are always fatal, but we can't use asyncClose because we are in a different goroutine.
+		s.pgConn.conn.Close()
+		s.err = err
+	}
+	return err
+}
+
+// abandon prevents any further writes. It waits for a write in progress.
+func (s *copyDataSender) abandon() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.abandoned = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// copyWithDelay reads r until it fails and sends the data in CopyData messages. A message is sent when it is full or
+// delay after its first byte was read, whichever comes first, even if a Read of r is blocked. It returns the error of
+// r, a write error, or nil if the sender was abandoned or abort was closed.
+func (s *copyDataSender) copyWithDelay(r io.Reader, clock Clock, delay time.Duration, abort <-chan struct{}) error {
+	s.buf = make([]byte, 5, copyDataBufferLen)
+	s.buf[0] = 'd'
+	rbuf := make([]byte, copyDataBufferLen-5)
+
+	for {
+		n, readErr := r.Read(rbuf)
+
+		s.mux.Lock()
+		if s.abandoned {
+			s.mux.Unlock()
+			return nil
+		}
+		data := rbuf[:n]
+		for len(data) > 0 && s.err == nil {
+			if len(s.buf) == 5 {
+				gen := s.gen
+				s.timer = clock.AfterFunc(delay, func() { s.flushAfterDelay(gen) })
+			}
+			m := copy(s.buf[len(s.buf):cap(s.buf)], data)
+			s.buf = s.buf[:len(s.buf)+m]
+			data = data[m:]
+			if len(s.buf) == cap(s.buf) {
+				s.flushLocked()
+			}
+		}
+		if readErr != nil {
+			s.flushLocked()
+		}
+		err := s.err
+		s.mux.Unlock()
+
+		if err != nil {
+			return err
+		}
+		if readErr != nil {
+			return readErr
+		}
+
+		select {
+		case <-abort:
+			return nil
+		default:
+		}
+	}
+}
+
+// flushAfterDelay sends the buffered data if it has not been sent since the timer of gen was started.
+func (s *copyDataSender) flushAfterDelay(gen uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.abandoned && s.gen == gen {
+		s.flushLocked()
+	}
+}
+
+// flushLocked sends the buffered data as a CopyData message. s.mux must be held.
+func (s *copyDataSender) flushLocked() {
+	if len(s.buf) == 5 {
+		return
+	}
+
+	s.gen++
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	pgio.SetInt32(s.buf[1:], int32(len(s.buf)-1))
+	s.writeLocked(s.buf)
+	s.buf = s.buf[:5]
+}
@@ -0,0 +1,79 @@
+package pgconn
+
+import (
+	"encoding/binary"
+)
+
+// skipChunkSize is the maximum number of bytes of a DataRow that skipDataRows consumes at once. This prevents the
+// read buffer from growing to the size of a large row that is never decoded.
+const skipChunkSize = 8192
+
+// Discard consumes the remaining rows of the result and returns the command tag or error like Close. Unlike Close the
+// rows are skipped without being decoded when the default Config.BuildFrontend is used. This is useful when only the
+// command tag is needed or the caller stops reading rows early.
+func (rr *ResultReader) Discard() (CommandTag, error) {
+	for !rr.closed && !rr.commandConcluded {
+		rr.pgConn.skipDataRows()
+
+		// Receive the message that ended the skipped rows or the error that stopped skipping.
+		_, err := rr.receiveMessage()
+		if err != nil {
+			break
+		}
+	}
+
+	return rr.Close()
+}
+
+// skipDataRows skips the DataRow messages that are next without decoding them. It does nothing if pgConn does not use
+// the default Frontend or messages have already been received ahead (e.g. by peekMessage).
+func (pgConn *PgConn) skipDataRows() {
+	if pgConn.statsReader == nil || pgConn.statsReader.chunkReader == nil || pgConn.peekedMsg != nil ||
+		len(pgConn.pendingMsgs) > 0 || pgConn.bufferingReceive {
+		return
+	}
+	pgConn.statsReader.chunkReader.skipDataRows()
+}
+
+// skipDataRows consumes DataRow messages until the header of another message has been read. That header is returned
+// to the Frontend by the next call to Next. Errors are not returned. A failed read leaves the ChunkReader able to retry
+// it, so the Frontend receives the error when it reads next.
+func (scr *statsChunkReader) skipDataRows() {
+	if scr.inMessage || scr.headerRead {
+		return
+	}
+
+	for {
+		if err := scr.finishSkip(); err != nil {
+			return
+		}
+
+		header, err := scr.nextHeader()
+		if err != nil {
+			return
+		}
+
+		bodyLen := int(binary.BigEndian.Uint32(header[1:])) - 4
+		if header[0] != 'D' || bodyLen < 0 {
+			copy(scr.header[:], header)
+			scr.headerRead = true
+			return
+		}
+		scr.skip = bodyLen
+	}
+}
+
+// finishSkip consumes the rest of the body of a DataRow being skipped.
+func (scr *statsChunkReader) finishSkip() error {
+	for scr.skip > 0 {
+		n := scr.skip
+		if n > skipChunkSize {
+			n = skipChunkSize
+		}
+		if _, err := scr.next(n); err != nil {
+			return err
+		}
+		scr.skip -= n
+	}
+	return nil
+}
@@ -30,23 +30,24 @@ func Timeout(err error) bool {
 // http://www.postgresql.org/docs/11/static/protocol-error-fields.html for
 // detailed field description.
 type PgError struct {
-	Severity         string
-	Code             string
-	Message          string
-	Detail           string
-	Hint             string
-	Position         int32
-	InternalPosition int32
-	InternalQuery    string
-	Where            string
-	SchemaName       string
-	TableName        string
-	ColumnName       string
-	DataTypeName     string
-	ConstraintName   string
-	File             string
-	Line             int32
-	Routine          string
+	Severity            string
+	SeverityUnlocalized string // the V field, not localized. Only sent by PostgreSQL 9.6 and greater.
+	Code                string
+	Message             string
+	Detail              string
+	Hint                string
+	Position            int32
+	InternalPosition    int32
+	InternalQuery       string
+	Where               string
+	SchemaName          string
+	TableName           string
+	ColumnName          string
+	DataTypeName        string
+	ConstraintName      string
+	File                string
+	Line                int32
+	Routine             string
 }
 
 func (pe *PgError) Error() string {
@@ -77,6 +78,16 @@ func (e *connectError) Unwrap() error {
 	return e.err
 }
 
+// Timeout implements net.Error. It is true if the connection attempt failed because of a timeout.
+func (e *connectError) Timeout() bool {
+	return causedByTimeout(e.err)
+}
+
+// Temporary implements net.Error. It reports the Temporary method of an underlying net.Error.
+func (e *connectError) Temporary() bool {
+	return causedByTemporaryError(e.err)
+}
+
 type connLockError struct {
 	status string
 }
@@ -196,7 +207,35 @@ func (e *writeError) Unwrap() error {
 	return e.err
 }
 
+// Timeout implements net.Error. It is true if the write failed because of a timeout.
+func (e *writeError) Timeout() bool {
+	return causedByTimeout(e.err)
+}
+
+// Temporary implements net.Error. It reports the Temporary method of an underlying net.Error.
+func (e *writeError) Temporary() bool {
+	return causedByTemporaryError(e.err)
+}
+
+// causedByTimeout reports if err is or wraps a timeout (see Timeout) or a net.Error whose Timeout method returns true.
+func causedByTimeout(err error) bool {
+	if Timeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func causedByTemporaryError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Temporary()
+}
+
 func redactPW(connString string) string {
+	// sslkey may contain the private key inline as a data URI.
+	inlineKey := regexp.MustCompile(`sslkey='?(data:|data%3[aA])[^ &']*'?`)
+	connString = inlineKey.ReplaceAllLiteralString(connString, "sslkey=data:xxxxx")
+
 	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
 		if u, err := url.Parse(connString); err == nil {
 			return redactURL(u)
@@ -237,3 +276,36 @@ func (e *NotPreferredError) SafeToRetry() bool {
 func (e *NotPreferredError) Unwrap() error {
 	return e.err
 }
+
+// ResultTruncatedError is returned by MultiResultReader.ReadAllWithLimit when results were not completely buffered
+// because they exceeded the limit.
+type ResultTruncatedError struct {
+	Rows  int // number of rows buffered
+	Bytes int // total size in bytes of the row values buffered
+}
+
+func (e *ResultTruncatedError) Error() string {
+	return fmt.Sprintf("result truncated after %d rows and %d bytes", e.Rows, e.Bytes)
+}
+
+// UnexpectedRowCountError is returned by PgConn.ExecRow and PgConn.ExecScalar when the result did not have exactly one
+// row.
+type UnexpectedRowCountError struct {
+	Rows int // number of rows returned
+}
+
+func (e *UnexpectedRowCountError) Error() string {
+	if e.Rows == 0 {
+		return "no rows in result"
+	}
+	return fmt.Sprintf("expected 1 row, got %d", e.Rows)
+}
+
+// UnexpectedColumnCountError is returned by PgConn.ExecScalar when the result did not have exactly one column.
+type UnexpectedColumnCountError struct {
+	Columns int // number of columns returned
+}
+
+func (e *UnexpectedColumnCountError) Error() string {
+	return fmt.Sprintf("expected 1 column, got %d", e.Columns)
+}
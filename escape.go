@@ -0,0 +1,75 @@
+package pgconn
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// unsafeClientEncodings are the client encodings whose multibyte characters may contain bytes of ASCII characters
+// such as a backslash. Strings in them can not be escaped without decoding the characters.
+var unsafeClientEncodings = map[string]struct{}{
+	"BIG5":           {},
+	"GB18030":        {},
+	"GBK":            {},
+	"JOHAB":          {},
+	"SHIFT_JIS_2004": {},
+	"SJIS":           {},
+	"UHC":            {},
+}
+
+var (
+	quoteEscaper          = strings.NewReplacer("'", "''")
+	quoteBackslashEscaper = strings.NewReplacer("'", "''", `\`, `\\`)
+	identifierEscaper     = strings.NewReplacer(`"`, `""`)
+)
+
+// EscapeIdentifier escapes and quotes an identifier such as a table or column name such that it can safely be
+// interpolated into a SQL command string. The result includes the surrounding double quotes, so the identifier is
+// case-sensitive.
+//
+// Like EscapeString it does not require a round trip to the server and returns an error under the same conditions.
+func (pgConn *PgConn) EscapeIdentifier(s string) (string, error) {
+	if err := pgConn.checkEscapable("EscapeIdentifier", s); err != nil {
+		return "", err
+	}
+
+	return `"` + identifierEscaper.Replace(s) + `"`, nil
+}
+
+// EscapeLiteral escapes and quotes a string such that it can safely be interpolated into a SQL command string as a
+// string literal. The result includes the surrounding single quotes. If standard_conforming_strings is off and s
+// contains a backslash an escape string literal (E'...') is returned.
+//
+// Like EscapeString it does not require a round trip to the server and returns an error under the same conditions.
+func (pgConn *PgConn) EscapeLiteral(s string) (string, error) {
+	if err := pgConn.checkEscapable("EscapeLiteral", s); err != nil {
+		return "", err
+	}
+
+	if !pgConn.StandardConformingStrings() && strings.Contains(s, `\`) {
+		return "E'" + quoteBackslashEscaper.Replace(s) + "'", nil
+	}
+	return "'" + quoteEscaper.Replace(s) + "'", nil
+}
+
+// checkEscapable returns an error if s can not be escaped by op without a round trip to the server. That is the case
+// if the client encoding is unknown or unsafe or s is not a valid string in it.
+func (pgConn *PgConn) checkEscapable(op, s string) error {
+	encoding := pgConn.ClientEncoding()
+	if encoding == "" {
+		return fmt.Errorf("%s requires the client_encoding parameter status", op)
+	}
+	if _, ok := unsafeClientEncodings[strings.ToUpper(encoding)]; ok {
+		return fmt.Errorf("%s must not be run with client_encoding=%s", op, encoding)
+	}
+
+	if strings.IndexByte(s, 0) >= 0 {
+		return fmt.Errorf("%s: string contains a zero byte", op)
+	}
+	if strings.EqualFold(encoding, "UTF8") && !utf8.ValidString(s) {
+		return fmt.Errorf("%s: string is not valid UTF-8", op)
+	}
+
+	return nil
+}
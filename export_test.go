@@ -2,6 +2,12 @@
 
 package pgconn
 
+import (
+	"io"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
 func NewParseConfigError(conn, msg string, err error) error {
 	return &parseConfigError{
 		connString: conn,
@@ -9,3 +15,31 @@ func NewParseConfigError(conn, msg string, err error) error {
 		err:        err,
 	}
 }
+
+func NewConnectError(config *Config, msg string, err error) error {
+	return &connectError{
+		config: config,
+		msg:    msg,
+		err:    err,
+	}
+}
+
+func NewWriteError(err error, safeToRetry bool) error {
+	return &writeError{
+		err:         err,
+		safeToRetry: safeToRetry,
+	}
+}
+
+// SetSCRAMNonceReader replaces the source of SCRAM client nonces with r and returns a function that restores it.
+func SetSCRAMNonceReader(r io.Reader) (restore func()) {
+	original := scramNonceReader
+	scramNonceReader = r
+	return func() { scramNonceReader = original }
+}
+
+// NewStatsChunkReader returns the ChunkReader that the Frontend built by the default Config.BuildFrontend reads r with.
+func NewStatsChunkReader(r io.Reader) pgproto3.ChunkReader {
+	sr := &statsReader{r: r, stats: &ioStats{}}
+	return newStatsChunkReader(sr, pgproto3.NewChunkReader(sr))
+}
@@ -0,0 +1,76 @@
+package pgconn
+
+import (
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// fatalErrorReadTimeout limits how long newWriteError waits for the error that the server may have sent before it
+// closed the connection.
+const fatalErrorReadTimeout = 100 * time.Millisecond
+
+// FatalError returns the error with severity FATAL that the server reported before it closed the connection, e.g.
+// because the backend was terminated by an administrator with pg_terminate_backend or the server is shutting down. It
+// returns nil if no such error has been received. Unlike the error of the failed operation, which may only describe
+// the broken network connection, it gives the true cause of the closure. It is safe to call concurrently with an
+// operation, e.g. by a connection pool that discards the connection.
+func (pgConn *PgConn) FatalError() *PgError {
+	pgConn.fatalErrMux.Lock()
+	defer pgConn.fatalErrMux.Unlock()
+	return pgConn.fatalErr
+}
+
+// setFatalError records err unless a fatal error has already been recorded.
+func (pgConn *PgConn) setFatalError(err *PgError) {
+	pgConn.fatalErrMux.Lock()
+	defer pgConn.fatalErrMux.Unlock()
+	if pgConn.fatalErr == nil {
+		pgConn.fatalErr = err
+	}
+}
+
+// newWriteError returns the error for a write to the server that failed with err after n bytes were written. It must
+// be called before the connection is closed. A server that closes an idle connection first sends an ErrorResponse with
+// the reason. As the write failed the operation will never read it, so it is received here and returned in place of
+// the less meaningful network error (e.g. broken pipe).
+func (pgConn *PgConn) newWriteError(err error, n int) error {
+	if causedByTimeout(err) {
+		return &writeError{err: err, safeToRetry: n == 0}
+	}
+
+	fatalErr := pgConn.FatalError()
+	if fatalErr == nil {
+		fatalErr = pgConn.receiveFatalError()
+	}
+	if fatalErr != nil {
+		return &writeError{err: fatalErr, safeToRetry: n == 0}
+	}
+
+	return &writeError{err: err, safeToRetry: n == 0}
+}
+
+// receiveFatalError reads the messages the server sent before it closed the connection until a fatal ErrorResponse is
+// found. It returns nil if none is received before the connection fails or fatalErrorReadTimeout passes.
+func (pgConn *PgConn) receiveFatalError() *PgError {
+	if pgConn.peekedMsg != nil || pgConn.bufferingReceive {
+		return nil
+	}
+
+	if pgConn.conn.SetReadDeadline(time.Now().Add(fatalErrorReadTimeout)) != nil {
+		return nil
+	}
+
+	for {
+		msg, err := pgConn.frontend.Receive()
+		if err != nil {
+			return nil
+		}
+
+		if msg, ok := msg.(*pgproto3.ErrorResponse); ok && isFatalErrorResponse(msg) {
+			pgErr := ErrorResponseToPgError(msg)
+			pgConn.setFatalError(pgErr)
+			return pgErr
+		}
+	}
+}
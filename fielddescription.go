@@ -0,0 +1,76 @@
+package pgconn
+
+import (
+	"github.com/jackc/pgproto3/v2"
+)
+
+// Format codes of parameters and result columns.
+const (
+	TextFormatCode   = 0
+	BinaryFormatCode = 1
+)
+
+// Type OIDs whose type modifiers are decoded by FieldLength and FieldPrecisionScale.
+const (
+	bpcharOID      = 1042
+	varcharOID     = 1043
+	bitOID         = 1560
+	varbitOID      = 1562
+	numericOID     = 1700
+	timeOID        = 1083
+	timestampOID   = 1114
+	timestamptzOID = 1184
+	intervalOID    = 1186
+	timetzOID      = 1266
+)
+
+// FieldFromTable returns true if the column described by fd is a simple reference to a table column identified by
+// fd.TableOID and fd.TableAttributeNumber.
+func FieldFromTable(fd pgproto3.FieldDescription) bool {
+	return fd.TableOID != 0 && fd.TableAttributeNumber != 0
+}
+
+// FieldLength returns the declared length of a char, varchar, bit, or varbit column described by fd (e.g. 10 for
+// varchar(10)). ok is false for other types or if no length was declared.
+func FieldLength(fd pgproto3.FieldDescription) (length int64, ok bool) {
+	switch fd.DataTypeOID {
+	case bpcharOID, varcharOID:
+		if fd.TypeModifier < 4 {
+			return 0, false
+		}
+		return int64(fd.TypeModifier - 4), true
+	case bitOID, varbitOID:
+		if fd.TypeModifier < 0 {
+			return 0, false
+		}
+		return int64(fd.TypeModifier), true
+	}
+	return 0, false
+}
+
+// FieldPrecisionScale returns the declared precision and scale of a numeric column described by fd (e.g. 10 and 2 for
+// numeric(10,2)). For time, timetz, timestamp, timestamptz, and interval columns it returns the declared fractional
+// seconds precision and a zero scale. ok is false for other types or if no precision was declared.
+func FieldPrecisionScale(fd pgproto3.FieldDescription) (precision, scale int64, ok bool) {
+	switch fd.DataTypeOID {
+	case numericOID:
+		if fd.TypeModifier < 4 {
+			return 0, 0, false
+		}
+		mod := fd.TypeModifier - 4
+		return int64((mod >> 16) & 0xffff), int64(mod & 0xffff), true
+	case timeOID, timetzOID, timestampOID, timestamptzOID:
+		if fd.TypeModifier < 0 {
+			return 0, 0, false
+		}
+		return int64(fd.TypeModifier), 0, true
+	case intervalOID:
+		// The high bits of an interval type modifier are the field restriction (e.g. DAY TO SECOND). 0xffff is the
+		// precision when none was declared.
+		if fd.TypeModifier < 0 || fd.TypeModifier&0xffff == 0xffff {
+			return 0, 0, false
+		}
+		return int64(fd.TypeModifier & 0xffff), 0, true
+	}
+	return 0, 0, false
+}
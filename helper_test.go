@@ -2,10 +2,16 @@ package pgconn_test
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgmock"
+	"github.com/jackc/pgproto3/v2"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,3 +40,130 @@ func ensureConnValid(t *testing.T, pgConn *pgconn.PgConn) {
 	assert.Equal(t, "2", string(result.Rows[1][0]))
 	assert.Equal(t, "3", string(result.Rows[2][0]))
 }
+
+// runPgmockServer starts a mock server that accepts a single connection and runs script on it. It returns a
+// connection string for the server and a channel that receives the error, if any, from running the script. The channel
+// is closed when the script is done.
+func runPgmockServer(t testing.TB, script *pgmock.Script) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		err = script.Run(pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+	}()
+
+	parts := strings.Split(ln.Addr().String(), ":")
+	host := parts[0]
+	port := parts[1]
+	return fmt.Sprintf("sslmode=disable host=%s port=%s", host, port), serverErrChan
+}
+
+// fakeClock is a pgconn.Clock whose time only moves when Advance is called.
+type fakeClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) pgconn.Timer {
+	return c.newTimer(d, nil)
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) pgconn.Timer {
+	return c.newTimer(d, f)
+}
+
+func (c *fakeClock) newTimer(d time.Duration, f func()) *fakeTimer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &fakeTimer{clock: c, f: f, when: c.now.Add(d), active: true}
+	if f == nil {
+		t.c = make(chan time.Time, 1)
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d and fires the timers that expire. AfterFunc functions are called synchronously.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var expired []*fakeTimer
+	for _, t := range c.timers {
+		if t.active && !t.when.After(now) {
+			t.active = false
+			expired = append(expired, t)
+		}
+	}
+	c.mux.Unlock()
+
+	for _, t := range expired {
+		if t.f != nil {
+			t.f()
+		} else {
+			t.c <- now
+		}
+	}
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	f      func()
+	when   time.Time
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	wasActive := t.active
+	t.when = t.clock.now.Add(d)
+	t.active = true
+	return wasActive
+}
@@ -5,14 +5,33 @@ import (
 	"sync"
 )
 
-// ContextWatcher watches a context and performs an action when the context is canceled. It can watch one context at a
-// time.
-type ContextWatcher struct {
+// Handler is the interface for handling context cancellation for a ContextWatcher.
+type Handler interface {
+	// HandleCancel is called when the context that a ContextWatcher is currently watching is canceled. canceledCtx is the
+	// context that was canceled.
+	HandleCancel(canceledCtx context.Context)
+
+	// HandleUnwatchAfterCancel is called when a ContextWatcher that called HandleCancel on this Handler is unwatched.
+	HandleUnwatchAfterCancel()
+}
+
+// funcHandler adapts a pair of functions to the Handler interface.
+type funcHandler struct {
 	onCancel             func()
 	onUnwatchAfterCancel func()
-	unwatchChan          chan struct{}
+}
+
+func (h funcHandler) HandleCancel(context.Context) { h.onCancel() }
+func (h funcHandler) HandleUnwatchAfterCancel()    { h.onUnwatchAfterCancel() }
+
+// ContextWatcher watches a context and performs an action when the context is canceled. It can watch one context at a
+// time. In addition, a base context set with SetBaseContext is watched together with every watched context.
+type ContextWatcher struct {
+	handler     Handler
+	unwatchChan chan struct{}
 
 	lock              sync.Mutex
+	base              context.Context
 	watchInProgress   bool
 	onCancelWasCalled bool
 }
@@ -21,16 +40,31 @@ type ContextWatcher struct {
 // OnUnwatchAfterCancel will be called when Unwatch is called and the watched context had already been canceled and
 // onCancel called.
 func NewContextWatcher(onCancel func(), onUnwatchAfterCancel func()) *ContextWatcher {
+	return NewHandlerContextWatcher(funcHandler{onCancel: onCancel, onUnwatchAfterCancel: onUnwatchAfterCancel})
+}
+
+// NewHandlerContextWatcher returns a ContextWatcher that delegates context cancellation to handler.
+func NewHandlerContextWatcher(handler Handler) *ContextWatcher {
 	cw := &ContextWatcher{
-		onCancel:             onCancel,
-		onUnwatchAfterCancel: onUnwatchAfterCancel,
-		unwatchChan:          make(chan struct{}),
+		handler:     handler,
+		unwatchChan: make(chan struct{}),
 	}
 
 	return cw
 }
 
-// Watch starts watching ctx. If ctx is canceled then the onCancel function passed to NewContextWatcher will be called.
+// SetBaseContext sets a context that is watched in addition to the context passed to each Watch. The handler's
+// HandleCancel method is called with whichever of the two is canceled first. A nil ctx removes the base context. It
+// takes effect with the next call to Watch.
+func (cw *ContextWatcher) SetBaseContext(ctx context.Context) {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	cw.base = ctx
+}
+
+// Watch starts watching ctx and the base context, if any. If either is canceled then the handler's HandleCancel method
+// will be called.
 func (cw *ContextWatcher) Watch(ctx context.Context) {
 	cw.lock.Lock()
 	defer cw.lock.Unlock()
@@ -41,12 +75,23 @@ func (cw *ContextWatcher) Watch(ctx context.Context) {
 
 	cw.onCancelWasCalled = false
 
-	if ctx.Done() != nil {
+	base := cw.base
+	var baseDone <-chan struct{}
+	if base != nil {
+		baseDone = base.Done()
+	}
+
+	if ctx.Done() != nil || baseDone != nil {
 		cw.watchInProgress = true
 		go func() {
+			// A nil Done channel is never ready so only the other context is watched.
 			select {
 			case <-ctx.Done():
-				cw.onCancel()
+				cw.handler.HandleCancel(ctx)
+				cw.onCancelWasCalled = true
+				<-cw.unwatchChan
+			case <-baseDone:
+				cw.handler.HandleCancel(base)
 				cw.onCancelWasCalled = true
 				<-cw.unwatchChan
 			case <-cw.unwatchChan:
@@ -57,8 +102,8 @@ func (cw *ContextWatcher) Watch(ctx context.Context) {
 	}
 }
 
-// Unwatch stops watching the previously watched context. If the onCancel function passed to NewContextWatcher was
-// called then onUnwatchAfterCancel will also be called.
+// Unwatch stops watching the previously watched context. If the handler's HandleCancel method was called then
+// HandleUnwatchAfterCancel will also be called.
 func (cw *ContextWatcher) Unwatch() {
 	cw.lock.Lock()
 	defer cw.lock.Unlock()
@@ -66,7 +111,7 @@ func (cw *ContextWatcher) Unwatch() {
 	if cw.watchInProgress {
 		cw.unwatchChan <- struct{}{}
 		if cw.onCancelWasCalled {
-			cw.onUnwatchAfterCancel()
+			cw.handler.HandleUnwatchAfterCancel()
 		}
 		cw.watchInProgress = false
 	}
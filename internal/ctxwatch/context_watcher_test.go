@@ -130,6 +130,69 @@ func TestContextWatcherStress(t *testing.T) {
 	}
 }
 
+type testHandler struct {
+	handleCancel             func(context.Context)
+	handleUnwatchAfterCancel func()
+}
+
+func (h *testHandler) HandleCancel(ctx context.Context) {
+	h.handleCancel(ctx)
+}
+
+func (h *testHandler) HandleUnwatchAfterCancel() {
+	h.handleUnwatchAfterCancel()
+}
+
+func TestContextWatcherBaseContext(t *testing.T) {
+	canceledChan := make(chan context.Context, 1)
+	cleanupCalled := false
+	handler := &testHandler{
+		handleCancel: func(ctx context.Context) {
+			canceledChan <- ctx
+		},
+		handleUnwatchAfterCancel: func() {
+			cleanupCalled = true
+		},
+	}
+
+	cw := ctxwatch.NewHandlerContextWatcher(handler)
+
+	baseCtx, baseCancel := context.WithCancel(context.Background())
+	cw.SetBaseContext(baseCtx)
+
+	// The operation context is still watched.
+	ctx, cancel := context.WithCancel(context.Background())
+	cw.Watch(ctx)
+	cancel()
+	select {
+	case canceledCtx := <-canceledChan:
+		require.Equal(t, ctx, canceledCtx)
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("Timed out waiting for cancel func to be called")
+	}
+	cw.Unwatch()
+	require.True(t, cleanupCalled, "Cleanup func was not called")
+
+	// The base context is watched even when the operation context can never be canceled.
+	cleanupCalled = false
+	cw.Watch(context.Background())
+	baseCancel()
+	select {
+	case canceledCtx := <-canceledChan:
+		require.Equal(t, baseCtx, canceledCtx)
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("Timed out waiting for cancel func to be called")
+	}
+	cw.Unwatch()
+	require.True(t, cleanupCalled, "Cleanup func was not called")
+
+	// Neither context is watched after the base context is removed.
+	cw.SetBaseContext(nil)
+	cw.Watch(context.Background())
+	cw.Unwatch()
+	require.Len(t, canceledChan, 0)
+}
+
 func BenchmarkContextWatcherUncancellable(b *testing.B) {
 	cw := ctxwatch.NewContextWatcher(func() {}, func() {})
 
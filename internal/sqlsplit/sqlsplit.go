@@ -0,0 +1,231 @@
+// Package sqlsplit splits a string containing multiple SQL statements into individual statements.
+package sqlsplit
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Statement is a single statement of a multi-statement SQL string.
+type Statement struct {
+	// SQL is the statement text with its parameter placeholders renumbered to start at $1.
+	SQL string
+
+	// Params maps the placeholders of SQL to the placeholders of the original SQL string. Placeholder $i+1 in SQL was
+	// placeholder $Params[i] in the original string.
+	Params []int
+}
+
+type placeholder struct {
+	start, end int // position in the original SQL string
+	num        int
+}
+
+// Split splits sql into individual statements at semicolons that are not inside string literals, quoted identifiers,
+// dollar-quoted strings, comments, or parentheses. Leading and trailing whitespace is trimmed from each statement and
+// statements that contain only whitespace and comments are omitted. The terminating semicolon is not included.
+//
+// Parameter placeholders are numbered across the whole of sql. Each statement is returned with its placeholders
+// renumbered so it can be executed on its own with the subset of the parameters it references.
+//
+// Split assumes standard_conforming_strings is on. Backslash escapes are only recognized in E'...' strings. SQL-standard
+// function bodies (BEGIN ATOMIC ... END) are not recognized and will be split at their inner semicolons.
+func Split(sql string) []Statement {
+	var statements []Statement
+
+	start := 0
+	hasContent := false
+	parenDepth := 0
+	var placeholders []placeholder
+
+	appendStatement := func(end int) {
+		if hasContent {
+			statements = append(statements, newStatement(sql, start, end, placeholders))
+		}
+		hasContent = false
+		parenDepth = 0
+		placeholders = placeholders[:0]
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ';' && parenDepth == 0:
+			appendStatement(i)
+			i++
+			start = i
+		case c == '\'':
+			hasContent = true
+			escapes := i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E') && (i == 1 || !isIdentChar(sql[i-2]))
+			i = skipQuoted(sql, i, '\'', escapes)
+		case c == '"':
+			hasContent = true
+			i = skipQuoted(sql, i, '"', false)
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			i = skipLineComment(sql, i)
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			i = skipBlockComment(sql, i)
+		case c == '$' && (i == 0 || !isIdentChar(sql[i-1])):
+			hasContent = true
+			if tag, ok := dollarQuoteTag(sql, i); ok {
+				i = skipDollarQuoted(sql, i, tag)
+			} else if ph, ok := parsePlaceholder(sql, i); ok {
+				placeholders = append(placeholders, ph)
+				i = ph.end
+			} else {
+				i++
+			}
+		default:
+			if c == '(' {
+				parenDepth++
+			} else if c == ')' && parenDepth > 0 {
+				parenDepth--
+			}
+			if !isSpace(c) {
+				hasContent = true
+			}
+			i++
+		}
+	}
+	appendStatement(len(sql))
+
+	return statements
+}
+
+func newStatement(sql string, start, end int, placeholders []placeholder) Statement {
+	if len(placeholders) == 0 {
+		return Statement{SQL: strings.TrimSpace(sql[start:end])}
+	}
+
+	var params []int
+	for _, ph := range placeholders {
+		params = append(params, ph.num)
+	}
+	sort.Ints(params)
+	params = uniqueInts(params)
+
+	renumbered := make(map[int]int, len(params))
+	for i, n := range params {
+		renumbered[n] = i + 1
+	}
+
+	var sb strings.Builder
+	pos := start
+	for _, ph := range placeholders {
+		sb.WriteString(sql[pos:ph.start])
+		sb.WriteByte('$')
+		sb.WriteString(strconv.Itoa(renumbered[ph.num]))
+		pos = ph.end
+	}
+	sb.WriteString(sql[pos:end])
+
+	return Statement{SQL: strings.TrimSpace(sb.String()), Params: params}
+}
+
+func uniqueInts(sorted []int) []int {
+	unique := sorted[:0]
+	for i, n := range sorted {
+		if i == 0 || n != sorted[i-1] {
+			unique = append(unique, n)
+		}
+	}
+	return unique
+}
+
+// parsePlaceholder parses the parameter placeholder (e.g. $1) starting at sql[start].
+func parsePlaceholder(sql string, start int) (placeholder, bool) {
+	end := start + 1
+	for end < len(sql) && sql[end] >= '0' && sql[end] <= '9' {
+		end++
+	}
+	if end == start+1 || (end < len(sql) && isIdentChar(sql[end])) {
+		return placeholder{}, false
+	}
+
+	num, err := strconv.Atoi(sql[start+1 : end])
+	if err != nil || num == 0 {
+		return placeholder{}, false
+	}
+
+	return placeholder{start: start, end: end, num: num}, true
+}
+
+// skipQuoted returns the index just past the quoted string or identifier starting at sql[start]. A doubled quote is an
+// escaped quote. If escapes is true a backslash escapes the following character.
+func skipQuoted(sql string, start int, quote byte, escapes bool) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+func skipLineComment(sql string, start int) int {
+	if i := strings.IndexByte(sql[start:], '\n'); i >= 0 {
+		return start + i + 1
+	}
+	return len(sql)
+}
+
+// skipBlockComment returns the index just past the block comment starting at sql[start]. Block comments may be nested.
+func skipBlockComment(sql string, start int) int {
+	depth := 0
+	for i := start; i+1 < len(sql); {
+		switch {
+		case sql[i] == '/' && sql[i+1] == '*':
+			depth++
+			i += 2
+		case sql[i] == '*' && sql[i+1] == '/':
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// dollarQuoteTag returns the tag including the enclosing dollar signs (e.g. "$$" or "$body$") if sql[start] begins a
+// dollar quote. A dollar sign followed by digits is a parameter placeholder, not a dollar quote.
+func dollarQuoteTag(sql string, start int) (string, bool) {
+	for i := start + 1; i < len(sql); i++ {
+		c := sql[i]
+		if c == '$' {
+			return sql[start : i+1], true
+		}
+		if !(isIdentChar(c) && !(i == start+1 && c >= '0' && c <= '9')) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func skipDollarQuoted(sql string, start int, tag string) int {
+	bodyStart := start + len(tag)
+	if i := strings.Index(sql[bodyStart:], tag); i >= 0 {
+		return bodyStart + i + len(tag)
+	}
+	return len(sql)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,61 @@
+package sqlsplit_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pgconn/internal/sqlsplit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		sql        string
+		statements []string
+	}{
+		{"", nil},
+		{"  ;; ; ", nil},
+		{"select 1", []string{"select 1"}},
+		{"select 1;", []string{"select 1"}},
+		{"select 1; select 2", []string{"select 1", "select 2"}},
+		{"\n\tselect 1 ;\n\nselect 2;\n", []string{"select 1", "select 2"}},
+		{"select ';'; select 2", []string{"select ';'", "select 2"}},
+		{"select 'it''s;'; select 2", []string{"select 'it''s;'", "select 2"}},
+		{`select E'\';'; select 2`, []string{`select E'\';'`, "select 2"}},
+		{`select '\'; select 2`, []string{`select '\'`, "select 2"}},
+		{`select 1 as "a;b"; select 2`, []string{`select 1 as "a;b"`, "select 2"}},
+		{`select 1 as "a"";b"; select 2`, []string{`select 1 as "a"";b"`, "select 2"}},
+		{"select $$;$$; select 2", []string{"select $$;$$", "select 2"}},
+		{"select $body$ $$; $body$; select 2", []string{"select $body$ $$; $body$", "select 2"}},
+		{"select $1::text; select $2", []string{"select $1::text", "select $1"}},
+		{"select a$b; select 2", []string{"select a$b", "select 2"}},
+		{"select 1 -- comment;\n; select 2", []string{"select 1 -- comment;", "select 2"}},
+		{"select 1 /* comment; */; select 2", []string{"select 1 /* comment; */", "select 2"}},
+		{"select 1 /* nested /* comment; */ ; */; select 2", []string{"select 1 /* nested /* comment; */ ; */", "select 2"}},
+		{"-- only a comment;\n; select 2", []string{"select 2"}},
+		{"select 1; /* trailing comment */", []string{"select 1"}},
+		{"create rule r as on insert to t do also (insert into a values (1); insert into b values (2)); select 2", []string{"create rule r as on insert to t do also (insert into a values (1); insert into b values (2))", "select 2"}},
+		{
+			"create function f() returns int language plpgsql as $$ begin return 1; end; $$; select f()",
+			[]string{"create function f() returns int language plpgsql as $$ begin return 1; end; $$", "select f()"},
+		},
+		{"select 'unterminated;", []string{"select 'unterminated;"}},
+	}
+
+	for i, tt := range tests {
+		var statements []string
+		for _, statement := range sqlsplit.Split(tt.sql) {
+			statements = append(statements, statement.SQL)
+		}
+		assert.Equalf(t, tt.statements, statements, "%d. %s", i, tt.sql)
+	}
+}
+
+func TestSplitRenumbersPlaceholders(t *testing.T) {
+	statements := sqlsplit.Split("insert into t values ($1, $2); select $3::int + $1, '$4', $$ $5 $$; select $2 $tag$$6$tag$; select 1")
+	assert.Equal(t, []sqlsplit.Statement{
+		{SQL: "insert into t values ($1, $2)", Params: []int{1, 2}},
+		{SQL: "select $2::int + $1, '$4', $$ $5 $$", Params: []int{1, 3}},
+		{SQL: "select $1 $tag$$6$tag$", Params: []int{2}},
+		{SQL: "select 1"},
+	}, statements)
+}
@@ -0,0 +1,186 @@
+package pgconn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// IOStats describes the data buffered between a PgConn and the server. It can help to diagnose slow consumers,
+// backpressure, and protocol desynchronization.
+type IOStats struct {
+	// BufferedUnread is the number of bytes that have been read from the server but not yet consumed as messages. It is
+	// -1 if it is unknown because the Frontend was not built by the default Config.BuildFrontend or the PgConn was
+	// created by Construct.
+	BufferedUnread int64
+
+	// BufferedUnreadHighWater is the greatest BufferedUnread since the connection was established.
+	BufferedUnreadHighWater int64
+
+	// QueuedUnflushed is the number of bytes of messages queued by BufferMessage that have not been sent yet. All other
+	// messages are written to the net.Conn immediately.
+	QueuedUnflushed int64
+
+	// QueuedUnflushedHighWater is the greatest QueuedUnflushed since the connection was established.
+	QueuedUnflushedHighWater int64
+}
+
+// IOStats returns the current IOStats of the connection. It is safe to call from any goroutine.
+func (pgConn *PgConn) IOStats() IOStats {
+	stats := pgConn.ioStats
+
+	unread := int64(-1)
+	if atomic.LoadInt32(&stats.consumedTracked) != 0 {
+		// Load consumed first. It never exceeds read so unread can not be negative.
+		consumed := atomic.LoadInt64(&stats.consumed)
+		unread = atomic.LoadInt64(&stats.read) - consumed
+	}
+
+	return IOStats{
+		BufferedUnread:           unread,
+		BufferedUnreadHighWater:  atomic.LoadInt64(&stats.unreadHighWater),
+		QueuedUnflushed:          atomic.LoadInt64(&stats.queued),
+		QueuedUnflushedHighWater: atomic.LoadInt64(&stats.queuedHighWater),
+	}
+}
+
+// ioStats holds the counters behind IOStats. All fields are accessed atomically. It is allocated separately from PgConn
+// so the 64-bit fields are aligned for atomic access on 32-bit platforms.
+type ioStats struct {
+	read            int64 // bytes read from the net.Conn
+	consumed        int64 // bytes consumed by the Frontend
+	consumedTracked int32 // non-zero if consumed is counted
+	unreadHighWater int64
+	queued          int64
+	queuedHighWater int64
+}
+
+func (s *ioStats) setQueued(n int64) {
+	atomic.StoreInt64(&s.queued, n)
+	storeMaxInt64(&s.queuedHighWater, n)
+}
+
+func storeMaxInt64(addr *int64, n int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if n <= old || atomic.CompareAndSwapInt64(addr, old, n) {
+			return
+		}
+	}
+}
+
+// statsReader counts the bytes read from the server. It is the io.Reader passed to Config.BuildFrontend.
+type statsReader struct {
+	r       io.Reader
+	stats   *ioStats
+	history *messageHistory
+
+	chunkReader *statsChunkReader // set if the default Frontend reads from this statsReader
+}
+
+func (sr *statsReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if n > 0 {
+		read := atomic.AddInt64(&sr.stats.read, int64(n))
+		storeMaxInt64(&sr.stats.unreadHighWater, read-atomic.LoadInt64(&sr.stats.consumed))
+	}
+	return n, err
+}
+
+// statsChunkReader counts the bytes consumed from a ChunkReader. It also allows DataRow messages to be skipped without
+// decoding them (see skipDataRows).
+//
+// Skipping depends on how the Frontend of pgproto3 v2 (as of v2.3.3, the version required by go.mod) reads from its
+// ChunkReader: each message with one Next call for the 5 byte header and one for the whole body, retrying a call that
+// failed. Next checks that every read is the header or body that is expected next and returns an error otherwise, so a
+// Frontend that reads differently fails instead of misinterpreting the stream.
+type statsChunkReader struct {
+	cr      pgproto3.ChunkReader
+	stats   *ioStats
+	history *messageHistory
+	readErr error // error of the last read from cr
+
+	inMessage  bool    // true if the Frontend has read the header of a message but not its body
+	bodyLen    int     // body length of the message whose header the Frontend has read
+	body       []byte  // body of the last message the Frontend has read
+	header     [5]byte // message header read by skipDataRows that the Frontend has not read yet
+	headerRead bool
+	skip       int // remaining body bytes of a DataRow that skipDataRows did not skip because of an error
+}
+
+// newStatsChunkReader wraps cr to count consumed bytes if r is the statsReader of a PgConn.
+func newStatsChunkReader(r io.Reader, cr pgproto3.ChunkReader) pgproto3.ChunkReader {
+	sr, ok := r.(*statsReader)
+	if !ok {
+		return cr
+	}
+	atomic.StoreInt32(&sr.stats.consumedTracked, 1)
+	sr.chunkReader = &statsChunkReader{cr: cr, stats: sr.stats, history: sr.history}
+	return sr.chunkReader
+}
+
+func (scr *statsChunkReader) Next(n int) ([]byte, error) {
+	if err := scr.finishSkip(); err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if !scr.inMessage {
+		if n != len(scr.header) {
+			return nil, scr.unexpectedRead(n, len(scr.header))
+		}
+		if scr.headerRead {
+			scr.headerRead = false
+			buf = scr.header[:]
+		} else {
+			var err error
+			buf, err = scr.nextHeader()
+			if err != nil {
+				return nil, err
+			}
+		}
+		scr.bodyLen = int(binary.BigEndian.Uint32(buf[1:])) - 4
+	} else {
+		if n != scr.bodyLen {
+			return nil, scr.unexpectedRead(n, scr.bodyLen)
+		}
+		var err error
+		buf, err = scr.next(n)
+		if err != nil {
+			return nil, err
+		}
+		scr.body = buf
+	}
+
+	scr.inMessage = !scr.inMessage
+	return buf, nil
+}
+
+func (scr *statsChunkReader) unexpectedRead(n, expected int) error {
+	part := "header"
+	if scr.inMessage {
+		part = "body"
+	}
+	return &pgconnError{msg: fmt.Sprintf("Frontend read %d bytes instead of the %d byte message %s (unsupported pgproto3 version?)", n, expected, part)}
+}
+
+func (scr *statsChunkReader) next(n int) ([]byte, error) {
+	buf, err := scr.cr.Next(n)
+	scr.readErr = err
+	if err == nil {
+		atomic.AddInt64(&scr.stats.consumed, int64(n))
+	}
+	return buf, err
+}
+
+// nextHeader reads the header of the next message and records it in the message history.
+func (scr *statsChunkReader) nextHeader() ([]byte, error) {
+	header, err := scr.next(len(scr.header))
+	if err == nil {
+		scr.history.received(header)
+	}
+	return header, err
+}
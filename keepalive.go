@@ -0,0 +1,147 @@
+package pgconn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// idleKeepalive performs a Sync round trip on a connection that has been idle for interval. It only uses the
+// connection while it is not locked by an operation. mux serializes the keepalive with PgConn.lock so the two never use
+// the connection at the same time.
+//
+// A connection is not idle between operations while the server may still respond to messages sent earlier, e.g. during
+// a CopyBoth stream or while the results of messages pipelined with SendBytes or SendMessage have not been received.
+// The keepalive is suspended from the first such message until the ReadyForQuery that answers the last of them.
+type idleKeepalive struct {
+	pgConn   *PgConn
+	interval time.Duration
+
+	mux     sync.Mutex
+	timer   Timer
+	busy    bool                      // the connection is locked by an operation
+	stopped bool                      // the connection has been closed or hijacked
+	queued  []pgproto3.BackendMessage // asynchronous messages received during a keepalive
+	err     error                     // error that broke the connection during a keepalive
+}
+
+func startIdleKeepalive(pgConn *PgConn, interval time.Duration) *idleKeepalive {
+	k := &idleKeepalive{pgConn: pgConn, interval: interval}
+
+	// Hold mux so a ping cannot observe k.timer before it is assigned.
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.timer = pgConn.config.clock().AfterFunc(interval, k.ping)
+	return k
+}
+
+// pause is called when an operation locks the connection. It waits for a keepalive in progress to finish. It returns
+// the asynchronous messages received during keepalives so the operation can process them and the error, if any, that
+// broke the connection.
+func (k *idleKeepalive) pause() ([]pgproto3.BackendMessage, error) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	k.busy = true
+	k.timer.Stop()
+	queued := k.queued
+	k.queued = nil
+	return queued, k.err
+}
+
+// resume is called when an operation unlocks the connection.
+func (k *idleKeepalive) resume() {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	k.busy = false
+	if !k.stopped {
+		k.timer.Reset(k.interval)
+	}
+}
+
+func (k *idleKeepalive) stop() {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	k.stopped = true
+	k.timer.Stop()
+}
+
+func (k *idleKeepalive) ping() {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	// The timer is reset when the operation that receives the outstanding ReadyForQuery unlocks the connection.
+	if k.busy || k.stopped || k.err != nil || k.pgConn.history.isAwaitingReadyForQuery() {
+		return
+	}
+
+	err := k.roundTrip()
+	if err != nil {
+		// Only close the net.Conn, signal Done, and report the closure to OnClose. The PgConn itself is only modified by
+		// the goroutine that uses it. The next operation will find k.err and mark the PgConn as closed.
+		k.err = err
+		k.pgConn.conn.Close()
+		k.pgConn.closeDone()
+		k.pgConn.reportClose(newCloseReason("", err))
+		return
+	}
+
+	k.timer.Reset(k.interval)
+}
+
+func (k *idleKeepalive) roundTrip() error {
+	conn := k.pgConn.conn
+
+	buf, err := (&pgproto3.Sync{}).Encode(nil)
+	if err != nil {
+		return err
+	}
+
+	// The server must respond within the interval.
+	err = conn.SetDeadline(time.Now().Add(k.interval))
+	if err != nil {
+		return err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	_, err = k.pgConn.write(buf)
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := k.pgConn.frontend.Receive()
+		if err != nil {
+			return err
+		}
+
+		// Asynchronous messages are copied because the Frontend may reuse them for later messages. Their fields do not
+		// reference the read buffer.
+		switch msg := msg.(type) {
+		case *pgproto3.ReadyForQuery:
+			k.pgConn.history.receivedReadyForQuery()
+			return nil
+		case *pgproto3.NoticeResponse:
+			m := *msg
+			k.queued = append(k.queued, &m)
+		case *pgproto3.NotificationResponse:
+			m := *msg
+			k.queued = append(k.queued, &m)
+		case *pgproto3.ParameterStatus:
+			m := *msg
+			k.queued = append(k.queued, &m)
+		case *pgproto3.ErrorResponse:
+			pgErr := ErrorResponseToPgError(msg)
+			if isFatalErrorResponse(msg) {
+				k.pgConn.setFatalError(pgErr)
+			}
+			return pgErr
+		default:
+			return k.pgConn.newProtocolError(fmt.Sprintf("unexpected %T during idle keepalive", msg), nil)
+		}
+	}
+}
@@ -24,6 +24,32 @@ var newGSS NewGSSFunc
 //	}
 func RegisterGSSProvider(newGSSArg NewGSSFunc) {
 	newGSS = newGSSArg
+	newGSSWithOptions = nil
+}
+
+// GSSOptions are the per connection GSSAPI options from the Config.
+type GSSOptions struct {
+	// ClientPrincipal is the client principal to authenticate as. If empty the provider's default is used.
+	ClientPrincipal string
+
+	// CredentialCache is the path of the credential cache. If empty the provider's default (e.g. KRB5CCNAME) is used.
+	CredentialCache string
+
+	// DelegateCredentials requests that the client's credentials are delegated to the server.
+	DelegateCredentials bool
+}
+
+// NewGSSWithOptionsFunc creates a GSS authentication provider configured for a single connection, for use with
+// RegisterGSSProviderWithOptions.
+type NewGSSWithOptionsFunc func(opts GSSOptions) (GSS, error)
+
+var newGSSWithOptions NewGSSWithOptionsFunc
+
+// RegisterGSSProviderWithOptions registers a GSS authentication provider that supports per connection options. It
+// replaces any provider registered with RegisterGSSProvider.
+func RegisterGSSProviderWithOptions(newGSSArg NewGSSWithOptionsFunc) {
+	newGSSWithOptions = newGSSArg
+	newGSS = nil
 }
 
 // GSS provides GSSAPI authentication (e.g., Kerberos).
@@ -34,10 +60,7 @@ type GSS interface {
 }
 
 func (c *PgConn) gssAuth() error {
-	if newGSS == nil {
-		return errors.New("kerberos error: no GSSAPI provider registered, see https://github.com/otan/gopgkrb5")
-	}
-	cli, err := newGSS()
+	cli, err := c.newGSS()
 	if err != nil {
 		return err
 	}
@@ -66,7 +89,7 @@ func (c *PgConn) gssAuth() error {
 		if err != nil {
 			return err
 		}
-		_, err = c.conn.Write(buf)
+		_, err = c.write(buf)
 		if err != nil {
 			return err
 		}
@@ -86,8 +109,30 @@ func (c *PgConn) gssAuth() error {
 	return nil
 }
 
+func (c *PgConn) newGSS() (GSS, error) {
+	opts := GSSOptions{
+		ClientPrincipal:     c.config.KerberosClientPrincipal,
+		CredentialCache:     c.config.KerberosCredentialCache,
+		DelegateCredentials: c.config.KerberosDelegateCredentials,
+	}
+
+	if newGSSWithOptions != nil {
+		return newGSSWithOptions(opts)
+	}
+
+	if newGSS == nil {
+		return nil, errors.New("kerberos error: no GSSAPI provider registered, see https://github.com/otan/gopgkrb5")
+	}
+
+	if opts != (GSSOptions{}) {
+		return nil, errors.New("kerberos error: registered GSSAPI provider does not support per connection options, use RegisterGSSProviderWithOptions")
+	}
+
+	return newGSS()
+}
+
 func (c *PgConn) rxGSSContinue() (*pgproto3.AuthenticationGSSContinue, error) {
-	msg, err := c.receiveMessage()
+	msg, err := c.receiveAuthMessage()
 	if err != nil {
 		return nil, err
 	}
@@ -99,5 +144,5 @@ func (c *PgConn) rxGSSContinue() (*pgproto3.AuthenticationGSSContinue, error) {
 		return nil, ErrorResponseToPgError(m)
 	}
 
-	return nil, fmt.Errorf("expected AuthenticationGSSContinue message but received unexpected message %T", msg)
+	return nil, c.newProtocolError(fmt.Sprintf("expected AuthenticationGSSContinue message but received %T", msg), nil)
 }
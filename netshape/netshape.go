@@ -0,0 +1,426 @@
+// Package netshape simulates slow networks for testing. It wraps a net.Conn (usually through Config.DialFunc of
+// pgconn) to add latency, limit bandwidth, and coalesce writes, so that applications and their timeouts can be tested
+// locally under the conditions of a WAN.
+//
+//	config, err := pgconn.ParseConfig(connString)
+//	config.DialFunc = netshape.DialFunc(config.DialFunc, netshape.Config{Latency: 50 * time.Millisecond})
+package netshape
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+const (
+	// readBufferSize is the maximum number of bytes read from the underlying connection at once.
+	readBufferSize = 32 * 1024
+
+	// queueLen is the number of reads or writes that can be in flight in each direction.
+	queueLen = 64
+
+	// closeFlushTimeout limits how long Close waits for data in flight to be written to the underlying connection.
+	closeFlushTimeout = time.Second
+)
+
+// Config describes the simulated network.
+type Config struct {
+	// Latency is the one-way delay of data in each direction. The round trip time is twice Latency.
+	Latency time.Duration
+
+	// ReadBandwidth and WriteBandwidth limit the bytes per second received and sent. Zero means no limit. A write
+	// blocks while the data of the previous writes is still being sent.
+	ReadBandwidth  int
+	WriteBandwidth int
+
+	// CoalesceWrites is how long written data is held before it is sent. Writes made in that time are sent together in
+	// a single write to the underlying connection, like the Nagle algorithm of TCP. Zero means each write is sent on
+	// its own.
+	CoalesceWrites time.Duration
+}
+
+// DialFunc returns a DialFunc that wraps the connections of dial with config.
+func DialFunc(dial pgconn.DialFunc, config Config) pgconn.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return Wrap(conn, config), nil
+	}
+}
+
+// chunk is data read or written at once and the time it is delivered.
+type chunk struct {
+	data []byte
+	at   time.Time
+	err  error // read error of the underlying connection; data is empty
+}
+
+type conn struct {
+	net.Conn
+	config Config
+
+	readCh       chan chunk
+	readMu       sync.Mutex // serializes Read
+	readChunk    chunk      // chunk being read; under readMu
+	hasReadChunk bool
+
+	writeCh   chan chunk
+	writeMu   sync.Mutex // serializes Write
+	writeNext time.Time  // when the data of the previous writes has been sent; under writeMu
+	writeDone chan struct{}
+	writeErr  error // set before writeDone is closed
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+// Wrap returns a net.Conn that simulates the network described by config on top of conn. Deadlines are enforced by
+// the returned net.Conn and are not set on conn. Close sends the data in flight before closing conn.
+func Wrap(netConn net.Conn, config Config) net.Conn {
+	c := &conn{
+		Conn:          netConn,
+		config:        config,
+		readCh:        make(chan chunk, queueLen),
+		writeCh:       make(chan chunk, queueLen),
+		writeDone:     make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		closed:        make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.writeLoop()
+	return c
+}
+
+// transmitEnd returns when n bytes have been sent at bandwidth bytes per second if sending starts when the data sent
+// before has been sent at next or at now, whichever is later.
+func transmitEnd(next, now time.Time, n, bandwidth int) time.Time {
+	if next.Before(now) {
+		next = now
+	}
+	if bandwidth > 0 {
+		next = next.Add(time.Duration(int64(n) * int64(time.Second) / int64(bandwidth)))
+	}
+	return next
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if !c.hasReadChunk {
+		if err := c.checkState(&c.readDeadline); err != nil {
+			return 0, err
+		}
+		select {
+		case c.readChunk = <-c.readCh:
+			c.hasReadChunk = true
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+
+	if err := c.sleepUntil(c.readChunk.at, &c.readDeadline); err != nil {
+		return 0, err
+	}
+
+	// An error chunk is kept so that later reads return the error again.
+	if c.readChunk.err != nil {
+		return 0, c.readChunk.err
+	}
+
+	n := copy(p, c.readChunk.data)
+	c.readChunk.data = c.readChunk.data[n:]
+	if len(c.readChunk.data) == 0 {
+		c.hasReadChunk = false
+	}
+	return n, nil
+}
+
+func (c *conn) readLoop() {
+	var next time.Time
+	for {
+		buf := make([]byte, readBufferSize)
+		n, err := c.Conn.Read(buf)
+		next = transmitEnd(next, time.Now(), n, c.config.ReadBandwidth)
+		ch := chunk{data: buf[:n], at: next.Add(c.config.Latency)}
+		if n == 0 && err == nil {
+			continue
+		}
+		if n == 0 {
+			ch.err = err
+		}
+
+		select {
+		case c.readCh <- ch:
+		case <-c.closed:
+			return
+		}
+
+		if err != nil {
+			if n > 0 {
+				select {
+				case c.readCh <- chunk{at: ch.at, err: err}:
+				case <-c.closed:
+				}
+			}
+			return
+		}
+	}
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.checkState(&c.writeDeadline); err != nil {
+		return 0, err
+	}
+	select {
+	case <-c.writeDone:
+		return 0, c.writeLoopErr()
+	default:
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Wait until the previous writes have been sent so that a saturated link makes writes time out.
+	if err := c.sleepUntil(c.writeNext, &c.writeDeadline); err != nil {
+		return 0, err
+	}
+
+	next := transmitEnd(c.writeNext, time.Now(), len(p), c.config.WriteBandwidth)
+	ch := chunk{data: append([]byte(nil), p...), at: next.Add(c.config.Latency)}
+	select {
+	case c.writeCh <- ch:
+		c.writeNext = next
+		return len(p), nil
+	case <-c.writeDone:
+		return 0, c.writeLoopErr()
+	case <-c.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// writeLoopErr returns the error that stopped writeLoop. It must only be called after writeDone is closed.
+func (c *conn) writeLoopErr() error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	return net.ErrClosed
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.writeDone)
+
+	var held *chunk
+	for {
+		if held == nil {
+			select {
+			case ch := <-c.writeCh:
+				held = &ch
+			case <-c.closed:
+				c.flush(nil)
+				return
+			}
+		}
+
+		data := held.data
+		at := held.at.Add(c.config.CoalesceWrites)
+		held = nil
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-timer.C:
+		case <-c.closed:
+			timer.Stop()
+			c.flush(data)
+			return
+		}
+
+		if c.config.CoalesceWrites > 0 {
+			data, held = c.coalesce(data, at)
+		}
+
+		if _, err := c.Conn.Write(data); err != nil {
+			c.writeErr = err
+			return
+		}
+	}
+}
+
+// coalesce appends the data of the queued writes that are delivered by at to data. It returns the first queued write
+// that is delivered later.
+func (c *conn) coalesce(data []byte, at time.Time) ([]byte, *chunk) {
+	for {
+		select {
+		case ch := <-c.writeCh:
+			if ch.at.After(at) {
+				return data, &ch
+			}
+			data = append(data, ch.data...)
+		default:
+			return data, nil
+		}
+	}
+}
+
+// flush writes data and the data of the queued writes to the underlying connection without delay. It is used by Close
+// so that messages written just before closing (e.g. Terminate) are not lost.
+func (c *conn) flush(data []byte) {
+drain:
+	for {
+		select {
+		case ch := <-c.writeCh:
+			data = append(data, ch.data...)
+		default:
+			break drain
+		}
+	}
+
+	if len(data) > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+		c.Conn.Write(data)
+	}
+}
+
+// checkState returns an error if c is closed or the deadline d has passed.
+func (c *conn) checkState(d *deadline) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	case <-d.wait():
+		return os.ErrDeadlineExceeded
+	default:
+		return nil
+	}
+}
+
+// sleepUntil waits until t unless c is closed or the deadline d passes first.
+func (c *conn) sleepUntil(t time.Time, d *deadline) error {
+	if err := c.checkState(d); err != nil {
+		return err
+	}
+
+	wait := time.Until(t)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-d.wait():
+		return os.ErrDeadlineExceeded
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+// Close sends the data in flight and closes the underlying connection.
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		<-c.writeDone
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// deadline is a deadline that interrupts blocked reads or writes when it passes or is changed to a time in the past.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed when the deadline has passed
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline to t. The zero value means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Wait for the timer function to close cancel if it has already fired.
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if wait := time.Until(t); wait > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(wait, func() { close(cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline passes.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,176 @@
+package netshape_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn/netshape"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatency(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	conn := netshape.Wrap(client, netshape.Config{Latency: 50 * time.Millisecond})
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return
+		}
+		server.Write(buf)
+	}()
+
+	start := time.Now()
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(50*time.Millisecond), "write waited for latency")
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestBandwidth(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	conn := netshape.Wrap(client, netshape.Config{WriteBandwidth: 10000})
+	defer conn.Close()
+
+	received := make(chan []byte)
+	go func() {
+		buf := make([]byte, 3000)
+		io.ReadFull(server, buf)
+		received <- buf
+	}()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := conn.Write(make([]byte, 1000))
+		require.NoError(t, err)
+	}
+	<-received
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(300*time.Millisecond))
+}
+
+func TestCoalesceWrites(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	conn := netshape.Wrap(client, netshape.Config{CoalesceWrites: 50 * time.Millisecond})
+	defer conn.Close()
+
+	for _, s := range []string{"a", "b", "c"} {
+		_, err := conn.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	buf := make([]byte, 10)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(buf[:n]))
+}
+
+func TestReadDeadline(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	conn := netshape.Wrap(client, netshape.Config{Latency: 100 * time.Millisecond})
+	defer conn.Close()
+
+	go server.Write([]byte("x"))
+
+	buf := make([]byte, 1)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err := conn.Read(buf)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+
+	// Data in flight is not lost when a read times out.
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(buf[:n]))
+
+	// Setting a deadline in the past interrupts a blocked read.
+	errChan := make(chan error)
+	go func() {
+		_, err := conn.Read(buf)
+		errChan <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, conn.SetReadDeadline(time.Unix(1, 0)))
+	select {
+	case err := <-errChan:
+		require.True(t, errors.As(err, &netErr))
+		assert.True(t, netErr.Timeout())
+	case <-time.After(time.Second):
+		t.Fatal("read was not interrupted")
+	}
+}
+
+func TestCloseSendsDataInFlight(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	conn := netshape.Wrap(client, netshape.Config{Latency: time.Minute})
+
+	received := make(chan []byte)
+	go func() {
+		buf, _ := ioutil.ReadAll(server)
+		received <- buf
+	}()
+
+	_, err := conn.Write([]byte("bye"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	assert.Equal(t, "bye", string(<-received))
+
+	_, err = conn.Write([]byte("more"))
+	assert.True(t, errors.Is(err, net.ErrClosed))
+}
+
+func TestDialFunc(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	dialer := &net.Dialer{}
+	dial := netshape.DialFunc(dialer.DialContext, netshape.Config{Latency: 25 * time.Millisecond})
+	conn, err := dial(context.Background(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, ln.Addr().String(), conn.RemoteAddr().String())
+
+	start := time.Now()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+}
@@ -0,0 +1,151 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// ConnNotification is a notification returned by WaitForNotifications with the connection it was received on.
+type ConnNotification struct {
+	Conn         *PgConn
+	Notification *Notification
+}
+
+// WaitForNotifications waits for a LISTEN/NOTIFY message to be received on one of channels by any of conns. It lets a
+// listener that is spread over multiple connections, e.g. to different servers, wait for all of them at once instead
+// of calling WaitForNotificationOnChannels from a goroutine per connection. If channels is empty a notification on any
+// channel ends the wait. Notifications on other channels are still delivered to the OnNotification callback like every
+// notification.
+//
+// When a notification is received the waits on the other connections are interrupted with a read deadline, which
+// leaves them usable. A notification that they received in the meantime is returned as well, so all of the returned
+// notifications must be handled. The wait also ends when ctx is canceled or a connection fails. The error then
+// identifies the connection by its index in conns and is returned together with any notifications that were received.
+// All conns must be idle and are locked for the duration of the wait.
+func WaitForNotifications(ctx context.Context, conns []*PgConn, channels ...string) ([]ConnNotification, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("no connections to wait for notifications on")
+	}
+
+	for i, pgConn := range conns {
+		if err := pgConn.lock("WaitForNotifications"); err != nil {
+			for _, c := range conns[:i] {
+				c.unlock()
+			}
+			return nil, &pgconnError{msg: fmt.Sprintf("connection %d", i), err: err, safeToRetry: true}
+		}
+	}
+	defer func() {
+		for _, pgConn := range conns {
+			pgConn.unlock()
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, newContextAlreadyDoneError(ctx)
+	default:
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+
+	var mux sync.Mutex
+	var notifications []ConnNotification
+	errs := make([]error, len(conns))
+
+	var wg sync.WaitGroup
+	for i, pgConn := range conns {
+		wg.Add(1)
+		go func(i int, pgConn *PgConn) {
+			defer wg.Done()
+
+			n, err := pgConn.receiveNotification(ctx, channels, stop)
+			if n != nil {
+				mux.Lock()
+				notifications = append(notifications, ConnNotification{Conn: pgConn, Notification: n})
+				mux.Unlock()
+			}
+			errs[i] = err
+			stopOnce.Do(func() { close(stop) })
+		}(i, pgConn)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return notifications, &pgconnError{msg: fmt.Sprintf("failed to wait for notification on connection %d", i), err: err}
+		}
+	}
+	return notifications, nil
+}
+
+// receiveNotification receives messages until a notification on one of channels is received or stop is closed. If
+// stop is closed before a notification is received it returns nil and no error. The connection must be locked.
+func (pgConn *PgConn) receiveNotification(ctx context.Context, channels []string, stop <-chan struct{}) (*Notification, error) {
+	if pgConn.watchRequired(ctx) {
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	// Interrupt a blocked read when stop is closed. The deadline is cleared again once the interrupting goroutine has
+	// ended.
+	done := make(chan struct{})
+	interrupted := make(chan bool)
+	go func() {
+		select {
+		case <-stop:
+			pgConn.conn.SetReadDeadline(time.Now())
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	defer func() {
+		close(done)
+		if <-interrupted {
+			pgConn.conn.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	for {
+		msg, err := pgConn.receiveMessage()
+		if err != nil {
+			if ctx.Err() == nil && causedByTimeout(err) && isClosedChan(stop) {
+				return nil, nil
+			}
+			return nil, preferContextOverNetTimeoutError(ctx, err)
+		}
+
+		if msg, ok := msg.(*pgproto3.NotificationResponse); ok && matchesChannel(msg.Channel, channels) {
+			return &Notification{PID: msg.PID, Channel: msg.Channel, Payload: msg.Payload}, nil
+		}
+	}
+}
+
+// matchesChannel returns true if channel is one of channels or channels is empty.
+func matchesChannel(channel string, channels []string) bool {
+	if len(channels) == 0 {
+		return true
+	}
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
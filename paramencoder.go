@@ -0,0 +1,92 @@
+package pgconn
+
+import (
+	"context"
+	"fmt"
+)
+
+// ParamEncoder encodes Go values as query parameters for ExecParamsValues and ExecPreparedValues. It is the integration
+// point for a type system such as pgtype, which pgconn does not depend on.
+type ParamEncoder interface {
+	// EncodeParam returns value encoded in the format given by formatCode (0 for text or 1 for binary) and the OID of
+	// its PostgreSQL type. A nil buf is sent as NULL. An OID of 0 lets the server infer the type. The OID is ignored
+	// when executing a prepared statement as the parameter types are already known.
+	EncodeParam(value interface{}) (buf []byte, oid uint32, formatCode int16, err error)
+}
+
+// defaultParamEncoder is used when Config.ParamEncoder is nil. It only supports values that need no conversion.
+type defaultParamEncoder struct{}
+
+func (defaultParamEncoder) EncodeParam(value interface{}) ([]byte, uint32, int16, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, 0, 0, nil
+	case string:
+		return []byte(value), 0, 0, nil
+	case []byte:
+		return value, 0, 0, nil
+	}
+	return nil, 0, 0, fmt.Errorf("unsupported type %T: Config.ParamEncoder is not set", value)
+}
+
+// ExecParamsValues is like ExecParams but takes Go values as parameters. They are encoded by Config.ParamEncoder, which
+// also selects the parameter types and formats. Without a ParamEncoder only nil, string, and []byte are supported and
+// are sent as text for the server to infer their types. Results are in the format of Config.DefaultResultFormat.
+//
+// ResultReader must be closed before PgConn can be used again.
+func (pgConn *PgConn) ExecParamsValues(ctx context.Context, sql string, args ...interface{}) *ResultReader {
+	paramValues, paramOIDs, paramFormats, err := pgConn.encodeParams(args)
+	if err != nil {
+		return pgConn.encodeParamsErrorResultReader(ctx, err)
+	}
+
+	return pgConn.ExecParams(ctx, sql, paramValues, paramOIDs, paramFormats, nil)
+}
+
+// ExecPreparedValues is like ExecPrepared but takes Go values as parameters. They are encoded in the same way as by
+// ExecParamsValues. Results are in the format of Config.DefaultResultFormat.
+//
+// ResultReader must be closed before PgConn can be used again.
+func (pgConn *PgConn) ExecPreparedValues(ctx context.Context, stmtName string, args ...interface{}) *ResultReader {
+	paramValues, _, paramFormats, err := pgConn.encodeParams(args)
+	if err != nil {
+		return pgConn.encodeParamsErrorResultReader(ctx, err)
+	}
+
+	return pgConn.ExecPrepared(ctx, stmtName, paramValues, paramFormats, nil)
+}
+
+func (pgConn *PgConn) encodeParams(args []interface{}) (paramValues [][]byte, paramOIDs []uint32, paramFormats []int16, err error) {
+	if len(args) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	var encoder ParamEncoder = defaultParamEncoder{}
+	if pgConn.config.ParamEncoder != nil {
+		encoder = pgConn.config.ParamEncoder
+	}
+
+	paramValues = make([][]byte, len(args))
+	paramOIDs = make([]uint32, len(args))
+	paramFormats = make([]int16, len(args))
+	for i, arg := range args {
+		paramValues[i], paramOIDs[i], paramFormats[i], err = encoder.EncodeParam(arg)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("cannot encode parameter %d: %w", i+1, err)
+		}
+	}
+
+	return paramValues, paramOIDs, paramFormats, nil
+}
+
+// encodeParamsErrorResultReader returns a closed ResultReader for an error that occurred before the connection was
+// used. It does not replace the ResultReader of an operation that may be in progress.
+func (pgConn *PgConn) encodeParamsErrorResultReader(ctx context.Context, err error) *ResultReader {
+	return &ResultReader{
+		pgConn:           pgConn,
+		ctx:              ctx,
+		commandConcluded: true,
+		closed:           true,
+		err:              err,
+	}
+}
@@ -0,0 +1,78 @@
+package pgconn
+
+import (
+	"time"
+)
+
+// parameterStatusCache holds values parsed from parameter statuses. Each value is parsed again only when the server
+// reports a different parameter status.
+type parameterStatusCache struct {
+	serverVersion    string
+	serverVersionNum int
+
+	timeZone string
+	location *time.Location
+}
+
+// ParameterStatuses returns a copy of all parameter statuses reported by the server. A proxy can pass them to
+// ServerConn.Ready.
+func (pgConn *PgConn) ParameterStatuses() map[string]string {
+	pgConn.parameterStatusMux.RLock()
+	defer pgConn.parameterStatusMux.RUnlock()
+
+	m := make(map[string]string, len(pgConn.parameterStatuses))
+	for k, v := range pgConn.parameterStatuses {
+		m[k] = v
+	}
+	return m
+}
+
+// ServerVersion returns the server version in the format of server_version_num (e.g. 140005 for 14.5 or 90624 for
+// 9.6.24) as parsed from the server_version parameter status. It returns 0 if the version is unknown.
+func (pgConn *PgConn) ServerVersion() int {
+	// The cache is updated so the write lock is required.
+	pgConn.parameterStatusMux.Lock()
+	defer pgConn.parameterStatusMux.Unlock()
+
+	s := pgConn.parameterStatuses["server_version"]
+	if s != pgConn.parameterStatusCache.serverVersion {
+		pgConn.parameterStatusCache.serverVersion = s
+		pgConn.parameterStatusCache.serverVersionNum, _ = parseServerVersion(s)
+	}
+	return pgConn.parameterStatusCache.serverVersionNum
+}
+
+// TimeZone returns the location of the TimeZone parameter status. It returns nil if the server did not report a time
+// zone or it is not known to Go (e.g. a POSIX-style time zone such as <+03>-03). The name is available with
+// ParameterStatus("TimeZone").
+func (pgConn *PgConn) TimeZone() *time.Location {
+	pgConn.parameterStatusMux.Lock()
+	defer pgConn.parameterStatusMux.Unlock()
+
+	s := pgConn.parameterStatuses["TimeZone"]
+	if s != pgConn.parameterStatusCache.timeZone {
+		pgConn.parameterStatusCache.timeZone = s
+		pgConn.parameterStatusCache.location = nil
+		if s != "" {
+			pgConn.parameterStatusCache.location, _ = time.LoadLocation(s)
+		}
+	}
+	return pgConn.parameterStatusCache.location
+}
+
+// ClientEncoding returns the client_encoding parameter status (e.g. UTF8).
+func (pgConn *PgConn) ClientEncoding() string {
+	return pgConn.ParameterStatus("client_encoding")
+}
+
+// StandardConformingStrings returns true if the standard_conforming_strings parameter status is on. Backslashes in
+// ordinary string literals are then literal characters.
+func (pgConn *PgConn) StandardConformingStrings() bool {
+	return pgConn.ParameterStatus("standard_conforming_strings") == "on"
+}
+
+// IntegerDatetimes returns true if the integer_datetimes parameter status is on. It is on for all servers since
+// PostgreSQL 10. The binary format of date and time values depends on it.
+func (pgConn *PgConn) IntegerDatetimes() bool {
+	return pgConn.ParameterStatus("integer_datetimes") == "on"
+}
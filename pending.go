@@ -0,0 +1,65 @@
+package pgconn
+
+import (
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// pendingMessagesReadTimeout limits how long ProcessPendingMessages waits for data on the socket. A read deadline that
+// has already passed fails without returning data that is available, so a deadline slightly in the future is used.
+const pendingMessagesReadTimeout = time.Millisecond
+
+// ProcessPendingMessages handles the messages that the server sent while the connection was idle without waiting for
+// more. Notifications, notices, and parameter statuses are dispatched to OnNotification, OnNotice, and
+// OnParameterStatus as they would be during the next operation. This lets an application stay current on asynchronous
+// messages between operations without a goroutine blocked in WaitForNotification. It must only be called when the
+// connection is idle.
+//
+// Messages that are already buffered are processed first. Then it reads what is immediately available on the socket. A
+// message that has only partially arrived is completed by a later call or operation. If the server reported a FATAL
+// error, e.g. because the backend was terminated, it is returned and the connection is closed.
+func (pgConn *PgConn) ProcessPendingMessages() error {
+	if err := pgConn.lock("ProcessPendingMessages"); err != nil {
+		return err
+	}
+	defer pgConn.unlock()
+
+	for pgConn.peekedMsg != nil || len(pgConn.pendingMsgs) > 0 {
+		err := pgConn.processPendingMessage()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := pgConn.conn.SetReadDeadline(time.Now().Add(pendingMessagesReadTimeout))
+	if err != nil {
+		pgConn.asyncClose(err)
+		return &pgconnError{msg: "failed to set read deadline", err: err}
+	}
+	defer pgConn.conn.SetReadDeadline(time.Time{})
+
+	for {
+		err := pgConn.processPendingMessage()
+		if err != nil {
+			if causedByTimeout(err) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// processPendingMessage receives and handles a single message for ProcessPendingMessages.
+func (pgConn *PgConn) processPendingMessage() error {
+	msg, err := pgConn.receiveMessage()
+	if err != nil {
+		return err
+	}
+
+	if msg, ok := msg.(*pgproto3.ErrorResponse); ok {
+		return ErrorResponseToPgError(msg)
+	}
+
+	return nil
+}
@@ -14,9 +14,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgconn/internal/ctxwatch"
+	"github.com/jackc/pgconn/internal/sqlsplit"
 	"github.com/jackc/pgio"
 	"github.com/jackc/pgproto3/v2"
 )
@@ -64,23 +66,49 @@ type NoticeHandler func(*PgConn, *Notice)
 // notice event.
 type NotificationHandler func(*PgConn, *Notification)
 
+// ParameterStatusHandler is a function that is called when the PostgreSQL server reports the value of a run-time
+// parameter. This happens during connection establishment and whenever a reported parameter (e.g. TimeZone) changes.
+// The *PgConn is provided so the handler is aware of the origin of the parameter status, but it must not invoke any
+// query method.
+type ParameterStatusHandler func(pgConn *PgConn, name, value string)
+
+// ReadyForQueryHandler is a function that is called when the PostgreSQL server reports with a ReadyForQuery message
+// that it is ready for a new query. txStatus is the transaction status ('I' idle, 'T' in a transaction block, or 'E' in
+// a failed transaction block) and op is the name of the PgConn method that received it (e.g. "Exec" or "CopyFrom"),
+// or "Connect" for the ReadyForQuery that completes the connection establishment. The *PgConn is provided so the
+// handler is aware of the origin of the message, but it must not invoke any query method.
+type ReadyForQueryHandler func(pgConn *PgConn, txStatus byte, op string)
+
+// UnexpectedMessageHandler is a function that is called with a message received from the PostgreSQL server that
+// pgconn does not handle. The *PgConn is provided so the handler is aware of the origin of the message, but it must not
+// invoke any query method.
+type UnexpectedMessageHandler func(pgConn *PgConn, msg pgproto3.BackendMessage)
+
 // Frontend used to receive messages from backend.
 type Frontend interface {
 	Receive() (pgproto3.BackendMessage, error)
 }
 
 // PgConn is a low-level PostgreSQL connection handle. It is not safe for concurrent usage.
+//
+// The exception are the read-only accessors IsClosed, IsBusy, IsIdle, TxStatus, PID, SecretKey, CancelKey,
+// ProtocolVersion, Identity, ParameterStatus, ParameterStatuses, ServerVersion, TimeZone, ClientEncoding, StandardConformingStrings,
+// IntegerDatetimes, IOStats, FatalError, Done, and CleanupDone. They may be called from any goroutine, even while
+// another goroutine is using the connection, e.g. to monitor connections that are in use. Their results may already be
+// out of date when they are returned.
 type PgConn struct {
 	conn              net.Conn          // the underlying TCP or unix domain socket connection
 	pid               uint32            // backend pid
 	secretKey         uint32            // key to use to send a cancel query message to the server
+	cancelKey         []byte            // key that is not 4 bytes long, e.g. with protocol 3.2, or nil
+	protocolVersion   ProtocolVersion   // negotiated protocol version
 	parameterStatuses map[string]string // parameters that have been reported by the server
-	txStatus          byte
+	txStatus          uint32            // accessed atomically, see loadTxStatus
 	frontend          Frontend
 
 	config *Config
 
-	status byte // One of connStatus* constants
+	status uint32 // One of connStatus* constants. Accessed atomically, see loadStatus.
 
 	bufferingReceive    bool
 	bufferingReceiveMux sync.Mutex
@@ -89,13 +117,42 @@ type PgConn struct {
 
 	peekedMsg pgproto3.BackendMessage
 
+	nextOperationDeadline time.Time // set by SetNextOperationDeadline
+	operationDeadlineSet  bool      // true while nextOperationDeadline is applied to conn
+
+	keepalive   *idleKeepalive            // nil unless Config.IdleKeepaliveInterval is set
+	pendingMsgs []pgproto3.BackendMessage // messages received by keepalive that have not been processed yet
+
 	// Reusable / preallocated resources
 	wbuf              []byte // write buffer
+	sendBuf           []byte // messages queued by BufferMessage and reused by SendMessage
+	ioStats           *ioStats
+	statsReader       *statsReader    // nil if the connection was constructed from a HijackedConn
+	history           *messageHistory // recent messages for ProtocolError
 	resultReader      ResultReader
 	multiResultReader MultiResultReader
 	contextWatcher    *ctxwatch.ContextWatcher
 
+	connCtx context.Context // watched in addition to the context of each operation
+
+	autoStatements map[string]string // maps SQL to the name of the statement prepared for it by ExecAuto
+
+	modifiedRuntimeParams map[string]struct{} // names of the run-time parameters set by SetRuntimeParam
+
+	pooler *Pooler // transaction pooler the connection adapts to or nil; see Config.PoolerMode
+
+	parameterStatusCache parameterStatusCache // values parsed from parameterStatuses
+	parameterStatusMux   sync.RWMutex         // protects parameterStatuses and parameterStatusCache
+
+	fatalErr    *PgError // FATAL error reported by the server before it closed the connection; see FatalError
+	fatalErrMux sync.Mutex
+
+	op              string // name of the method that locked the connection; reported in CloseReason.Op
+	closeReportOnce sync.Once
+
 	cleanupDone chan struct{}
+	done        chan struct{}
+	doneOnce    sync.Once
 }
 
 // Connect establishes a connection to a PostgreSQL server using the environment and connString (in URL or DSN format)
@@ -135,23 +192,10 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 		panic("config must be created by ParseConfig")
 	}
 
-	// Simplify usage by treating primary config and fallbacks the same.
-	fallbackConfigs := []*FallbackConfig{
-		{
-			Host:      config.Host,
-			Port:      config.Port,
-			TLSConfig: config.TLSConfig,
-		},
-	}
-	fallbackConfigs = append(fallbackConfigs, config.Fallbacks...)
 	ctx := octx
-	fallbackConfigs, err = expandWithIPs(ctx, config.LookupFunc, fallbackConfigs)
+	fallbackConfigs, err := resolveFallbackConfigs(ctx, config)
 	if err != nil {
-		return nil, &connectError{config: config, msg: "hostname resolving error", err: err}
-	}
-
-	if len(fallbackConfigs) == 0 {
-		return nil, &connectError{config: config, msg: "hostname resolving error", err: errors.New("ip addr wasn't found")}
+		return nil, err
 	}
 
 	foundBestServer := false
@@ -162,7 +206,7 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 			// create new context first time or when previous host was different
 			if i == 0 || (fallbackConfigs[i].Host != fallbackConfigs[i-1].Host) {
 				var cancel context.CancelFunc
-				ctx, cancel = context.WithTimeout(octx, config.ConnectTimeout)
+				ctx, cancel = contextWithTimeout(octx, config.clock(), config.ConnectTimeout)
 				defer cancel()
 			}
 		} else {
@@ -174,14 +218,7 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 			break
 		} else if pgerr, ok := err.(*PgError); ok {
 			err = &connectError{config: config, msg: "server error", err: pgerr}
-			const ERRCODE_INVALID_PASSWORD = "28P01"                    // wrong password
-			const ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION = "28000" // wrong password or bad pg_hba.conf settings
-			const ERRCODE_INVALID_CATALOG_NAME = "3D000"                // db does not exist
-			const ERRCODE_INSUFFICIENT_PRIVILEGE = "42501"              // missing connect privilege
-			if pgerr.Code == ERRCODE_INVALID_PASSWORD ||
-				pgerr.Code == ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION && fc.TLSConfig != nil ||
-				pgerr.Code == ERRCODE_INVALID_CATALOG_NAME ||
-				pgerr.Code == ERRCODE_INSUFFICIENT_PRIVILEGE {
+			if isFinalConnectError(pgerr, fc) {
 				break
 			}
 		} else if cerr, ok := err.(*connectError); ok {
@@ -202,6 +239,24 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 		return nil, err // no need to wrap in connectError because it will already be wrapped in all cases except PgError
 	}
 
+	return finishConnect(ctx, config, pgConn)
+}
+
+// isFinalConnectError returns true if pgerr received while connecting with fc would be received from the remaining
+// fallback configs too, so they are not tried.
+func isFinalConnectError(pgerr *PgError, fc *FallbackConfig) bool {
+	const ERRCODE_INVALID_PASSWORD = "28P01"                    // wrong password
+	const ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION = "28000" // wrong password or bad pg_hba.conf settings
+	const ERRCODE_INVALID_CATALOG_NAME = "3D000"                // db does not exist
+	const ERRCODE_INSUFFICIENT_PRIVILEGE = "42501"              // missing connect privilege
+	return pgerr.Code == ERRCODE_INVALID_PASSWORD ||
+		pgerr.Code == ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION && fc.TLSConfig != nil ||
+		pgerr.Code == ERRCODE_INVALID_CATALOG_NAME ||
+		pgerr.Code == ERRCODE_INSUFFICIENT_PRIVILEGE
+}
+
+// finishConnect performs the steps that follow establishing pgConn in ConnectConfig.
+func finishConnect(ctx context.Context, config *Config, pgConn *PgConn) (*PgConn, error) {
 	if config.AfterConnect != nil {
 		err := config.AfterConnect(ctx, pgConn)
 		if err != nil {
@@ -210,9 +265,57 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 		}
 	}
 
+	// A replication connection spends most of its life in a CopyBoth stream where a Sync would be misinterpreted.
+	if config.IdleKeepaliveInterval > 0 && config.ReplicationMode == ReplicationModeOff {
+		pgConn.keepalive = startIdleKeepalive(pgConn, config.IdleKeepaliveInterval)
+	}
+
+	if config.CancelConnPool != nil {
+		config.CancelConnPool.fill(config, pgConn.conn.RemoteAddr())
+	}
+
 	return pgConn, nil
 }
 
+// resolveFallbackConfigs returns the servers to try to connect to in order with host names resolved to IP addresses.
+func resolveFallbackConfigs(ctx context.Context, config *Config) ([]*FallbackConfig, error) {
+	// Simplify usage by treating primary config and fallbacks the same.
+	fallbackConfigs := []*FallbackConfig{
+		{
+			Host:      config.Host,
+			Port:      config.Port,
+			TLSConfig: config.TLSConfig,
+		},
+	}
+	fallbackConfigs = append(fallbackConfigs, config.Fallbacks...)
+
+	var resolvedConfigs []*FallbackConfig
+	for _, fc := range fallbackConfigs {
+		// Each host is resolved separately so its resolution can be reported.
+		var stage *connectStage
+		if !isAbsolutePath(fc.Host) {
+			stage = startConnectStage(config, ConnectStageResolve, fc.Host, fc.Port)
+		}
+		resolved, err := expandWithIPs(ctx, config.LookupFunc, []*FallbackConfig{fc})
+		if err != nil {
+			err = &connectError{config: config, msg: "hostname resolving error", err: err}
+		}
+		if stage != nil {
+			stage.end(err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resolvedConfigs = append(resolvedConfigs, resolved...)
+	}
+
+	if len(resolvedConfigs) == 0 {
+		return nil, &connectError{config: config, msg: "hostname resolving error", err: errors.New("ip addr wasn't found")}
+	}
+
+	return resolvedConfigs, nil
+}
+
 func expandWithIPs(ctx context.Context, lookupFn LookupFunc, fallbacks []*FallbackConfig) ([]*FallbackConfig, error) {
 	var configs []*FallbackConfig
 
@@ -258,49 +361,104 @@ func expandWithIPs(ctx context.Context, lookupFn LookupFunc, fallbacks []*Fallba
 	return configs, nil
 }
 
+// dialHost dials host and port with config.DialFunc. If host is a list of Unix domain socket directories each is tried
+// in order until one succeeds.
+func dialHost(ctx context.Context, config *Config, host string, port uint16) (net.Conn, error) {
+	dirs := splitSocketDirs(host)
+	if dirs == nil {
+		network, address := NetworkAddress(host, port)
+		return config.DialFunc(ctx, network, address)
+	}
+
+	var err error
+	for _, dir := range dirs {
+		var netConn net.Conn
+		network, address := NetworkAddress(dir, port)
+		netConn, err = config.DialFunc(ctx, network, address)
+		if err == nil {
+			return netConn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, &pgconnError{msg: fmt.Sprintf("failed to dial socket directories %s", strings.Join(dirs, ", ")), err: err}
+}
+
 func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig,
 	ignoreNotPreferredErr bool) (*PgConn, error) {
+	pgConn, err := dial(ctx, config, fallbackConfig)
+	if err != nil {
+		return nil, err
+	}
+	return pgConn.startup(ctx, fallbackConfig, ignoreNotPreferredErr)
+}
+
+// dial establishes the network connection to the server described by fallbackConfig including the TLS handshake. The
+// connection must be completed with startup.
+func dial(ctx context.Context, config *Config, fallbackConfig *FallbackConfig) (*PgConn, error) {
 	pgConn := new(PgConn)
 	pgConn.config = config
 	pgConn.wbuf = make([]byte, 0, wbufLen)
 	pgConn.cleanupDone = make(chan struct{})
+	pgConn.done = make(chan struct{})
 
 	var err error
-	network, address := NetworkAddress(fallbackConfig.Host, fallbackConfig.Port)
-	netConn, err := config.DialFunc(ctx, network, address)
+	stage := startConnectStage(config, ConnectStageDial, fallbackConfig.Host, fallbackConfig.Port)
+	netConn, err := dialHost(ctx, config, fallbackConfig.Host, fallbackConfig.Port)
 	if err != nil {
 		var netErr net.Error
 		if errors.As(err, &netErr) && netErr.Timeout() {
 			err = &errTimeout{err: err}
 		}
-		return nil, &connectError{config: config, msg: "dial error", err: err}
+		err = &connectError{config: config, msg: "dial error", err: err}
+		stage.end(err)
+		return nil, err
 	}
 
 	pgConn.conn = netConn
 	pgConn.contextWatcher = newContextWatcher(netConn)
-	pgConn.contextWatcher.Watch(ctx)
 
 	if fallbackConfig.TLSConfig != nil {
-		tlsConn, err := startTLS(netConn, fallbackConfig.TLSConfig)
+		stage.next(ConnectStageTLS)
+		pgConn.contextWatcher.Watch(ctx)
+		tlsConn, err := startTLS(netConn, connectTLSConfig(config, fallbackConfig.TLSConfig))
 		pgConn.contextWatcher.Unwatch() // Always unwatch `netConn` after TLS.
 		if err != nil {
 			netConn.Close()
-			return nil, &connectError{config: config, msg: "tls error", err: err}
+			err = &connectError{config: config, msg: "tls error", err: err}
+			stage.end(err)
+			return nil, err
 		}
 
 		pgConn.conn = tlsConn
 		pgConn.contextWatcher = newContextWatcher(tlsConn)
-		pgConn.contextWatcher.Watch(ctx)
 	}
 
+	stage.end(nil)
+	return pgConn, nil
+}
+
+// startup sends the startup message on a connection established by dial and authenticates.
+func (pgConn *PgConn) startup(ctx context.Context, fallbackConfig *FallbackConfig, ignoreNotPreferredErr bool) (_ *PgConn, err error) {
+	config := pgConn.config
+
+	stage := startConnectStage(config, ConnectStageAuth, fallbackConfig.Host, fallbackConfig.Port)
+	defer func() { stage.end(err) }()
+
+	pgConn.contextWatcher.Watch(ctx)
 	defer pgConn.contextWatcher.Unwatch()
 
 	pgConn.parameterStatuses = make(map[string]string)
-	pgConn.status = connStatusConnecting
-	pgConn.frontend = config.BuildFrontend(pgConn.conn, pgConn.conn)
+	pgConn.storeStatus(connStatusConnecting)
+	pgConn.ioStats = &ioStats{}
+	pgConn.history = &messageHistory{}
+	pgConn.statsReader = &statsReader{r: pgConn.conn, stats: pgConn.ioStats, history: pgConn.history}
+	pgConn.frontend = config.BuildFrontend(pgConn.statsReader, pgConn.conn)
 
+	pgConn.protocolVersion = config.MaxProtocolVersion
 	startupMsg := pgproto3.StartupMessage{
-		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		ProtocolVersion: uint32(config.MaxProtocolVersion),
 		Parameters:      make(map[string]string),
 	}
 
@@ -309,22 +467,31 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 		startupMsg.Parameters[k] = v
 	}
 
+	if replication := config.ReplicationMode.startupValue(); replication != "" {
+		startupMsg.Parameters["replication"] = replication
+	}
 	startupMsg.Parameters["user"] = config.User
 	if config.Database != "" {
 		startupMsg.Parameters["database"] = config.Database
 	}
 
+	// secure is true if the password can not be observed on the network.
+	network, _ := NetworkAddress(fallbackConfig.Host, fallbackConfig.Port)
+	secure := network == "unix" || fallbackConfig.TLSConfig != nil
+
 	buf, err := startupMsg.Encode(pgConn.wbuf)
 	if err != nil {
 		return nil, &connectError{config: config, msg: "failed to write startup message", err: err}
 	}
+	pgConn.history.sentStartup(len(buf))
 	if _, err := pgConn.conn.Write(buf); err != nil {
 		pgConn.conn.Close()
 		return nil, &connectError{config: config, msg: "failed to write startup message", err: err}
 	}
 
+	receivedCancelKey := false
 	for {
-		msg, err := pgConn.receiveMessage()
+		msg, err := pgConn.receiveStartupMessage()
 		if err != nil {
 			pgConn.conn.Close()
 			if err, ok := err.(*PgError); ok {
@@ -333,19 +500,59 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 			return nil, &connectError{config: config, msg: "failed to receive message", err: preferContextOverNetTimeoutError(ctx, err)}
 		}
 
+		if authType, ok := authenticationType(msg); ok {
+			if handler := config.AuthenticationHandlers[authType]; handler != nil {
+				err = handler(ctx, authFrontend{pgConn: pgConn}, msg)
+				if err != nil {
+					pgConn.conn.Close()
+					if err, ok := err.(*PgError); ok {
+						return nil, err
+					}
+					return nil, &connectError{config: config, msg: "failed authentication", err: preferContextOverNetTimeoutError(ctx, err)}
+				}
+				continue
+			}
+		}
+
 		switch msg := msg.(type) {
 		case *pgproto3.BackendKeyData:
 			pgConn.pid = msg.ProcessID
 			pgConn.secretKey = msg.SecretKey
+			receivedCancelKey = true
+		case *ExtendedBackendKeyData:
+			if pgConn.protocolVersion < ProtocolVersion32 {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: fmt.Sprintf("received a %d byte cancel key with protocol version %v", len(msg.SecretKey), pgConn.protocolVersion)}
+			}
+			pgConn.pid = msg.ProcessID
+			pgConn.cancelKey = msg.SecretKey
+			receivedCancelKey = true
+		case *NegotiateProtocolVersion:
+			version := ProtocolVersion(3<<16 | msg.NewestMinorProtocol)
+			if version < config.MinProtocolVersion {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: fmt.Sprintf("server supports protocol version %v but min_protocol_version is %v", version, config.MinProtocolVersion)}
+			}
+			if version < pgConn.protocolVersion {
+				pgConn.protocolVersion = version
+			}
 
 		case *pgproto3.AuthenticationOk:
 		case *pgproto3.AuthenticationCleartextPassword:
+			if !secure && config.UnencryptedPasswordAuth >= UnencryptedPasswordAuthRefuseCleartext {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: "server requested cleartext password authentication on an unencrypted connection"}
+			}
 			err = pgConn.txPasswordMessage(pgConn.config.Password)
 			if err != nil {
 				pgConn.conn.Close()
 				return nil, &connectError{config: config, msg: "failed to write password message", err: err}
 			}
 		case *pgproto3.AuthenticationMD5Password:
+			if !secure && config.UnencryptedPasswordAuth >= UnencryptedPasswordAuthRefuseMD5 {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: "server requested MD5 password authentication on an unencrypted connection"}
+			}
 			digestedPassword := "md5" + hexMD5(hexMD5(pgConn.config.Password+pgConn.config.User)+string(msg.Salt[:]))
 			err = pgConn.txPasswordMessage(digestedPassword)
 			if err != nil {
@@ -365,14 +572,23 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 				return nil, &connectError{config: config, msg: "failed GSS auth", err: err}
 			}
 		case *pgproto3.ReadyForQuery:
-			pgConn.status = connStatusIdle
+			pgConn.storeStatus(connStatusIdle)
+
+			// The connection is now established. Replace the context watcher used while connecting with the one configured
+			// for normal operation.
+			pgConn.contextWatcher.Unwatch()
+			pgConn.contextWatcher = pgConn.buildContextWatcher()
+
+			pgConn.pooler = pgConn.detectPooler(receivedCancelKey)
+
 			if config.ValidateConnect != nil {
-				// ValidateConnect may execute commands that cause the context to be watched again. Unwatch first to avoid
-				// the watch already in progress panic. This is that last thing done by this method so there is no need to
-				// restart the watch after ValidateConnect returns.
+				stage.next(ConnectStageValidate)
+
+				// ValidateConnect may execute commands that cause the context to be watched again. The connect context watch
+				// has already been ended above. This is that last thing done by this method so there is no need to restart
+				// the watch after ValidateConnect returns.
 				//
 				// See https://github.com/jackc/pgconn/issues/40.
-				pgConn.contextWatcher.Unwatch()
 
 				err := config.ValidateConnect(ctx, pgConn)
 				if err != nil {
@@ -390,17 +606,186 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 			pgConn.conn.Close()
 			return nil, ErrorResponseToPgError(msg)
 		default:
+			pgConn.unexpectedMessage(msg)
 			pgConn.conn.Close()
-			return nil, &connectError{config: config, msg: "received unexpected message", err: err}
+			return nil, &connectError{config: config, msg: "received unexpected message", err: pgConn.newProtocolError(fmt.Sprintf("unexpected %T while establishing the connection", msg), nil)}
 		}
 	}
 }
 
+// ContextWatcherHandler handles context cancellation for the operations of a PgConn. HandleCancel is called when the
+// context passed to an in-progress operation is canceled. It must cause any blocked reads or writes on the connection to
+// return promptly (e.g. by setting a deadline on the net.Conn), possibly after first giving the server a chance to
+// cancel the query. HandleUnwatchAfterCancel is called when the operation concludes after HandleCancel was called. It
+// must undo anything done by HandleCancel that would interfere with further use of the connection.
+//
+// Implementations can wrap DeadlineContextWatcherHandler or CancelRequestContextWatcherHandler to add instrumentation.
+type ContextWatcherHandler interface {
+	HandleCancel(canceledCtx context.Context)
+	HandleUnwatchAfterCancel()
+}
+
+// BuildContextWatcherHandlerFunc is a function that builds the ContextWatcherHandler for a newly established
+// connection.
+type BuildContextWatcherHandlerFunc func(*PgConn) ContextWatcherHandler
+
+// DeadlineContextWatcherHandler handles canceled contexts by setting a deadline on a net.Conn. This interrupts the
+// operation in progress and generally causes the connection to be closed. This is the default behavior.
+type DeadlineContextWatcherHandler struct {
+	Conn net.Conn
+
+	// DeadlineDelay is the delay until the deadline is reached after the context is canceled. If zero the deadline is
+	// set in the past so blocked reads and writes are interrupted immediately.
+	DeadlineDelay time.Duration
+}
+
+func (h *DeadlineContextWatcherHandler) HandleCancel(ctx context.Context) {
+	if h.DeadlineDelay == 0 {
+		h.Conn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC))
+		return
+	}
+	h.Conn.SetDeadline(time.Now().Add(h.DeadlineDelay))
+}
+
+func (h *DeadlineContextWatcherHandler) HandleUnwatchAfterCancel() {
+	h.Conn.SetDeadline(time.Time{})
+}
+
+// CancelRequestContextWatcherHandler handles canceled contexts by sending a cancel request to the server. It also sets
+// a deadline on the net.Conn as a fallback in case the server does not respond to the cancel request in time. If the
+// server cancels the query the operation returns the server's query_canceled error and the connection remains usable.
+type CancelRequestContextWatcherHandler struct {
+	Conn *PgConn
+
+	// CancelRequestDelay is the delay before sending the cancel request to the server.
+	CancelRequestDelay time.Duration
+
+	// DeadlineDelay is the delay until the deadline is reached after the context is canceled.
+	DeadlineDelay time.Duration
+
+	cancelFinishedChan chan struct{}
+	stopCancelRequest  context.CancelFunc
+	deadline           *clockDeadline
+}
+
+func (h *CancelRequestContextWatcherHandler) HandleCancel(context.Context) {
+	clock := h.Conn.config.clock()
+
+	h.cancelFinishedChan = make(chan struct{})
+	var stopCancelRequestCtx context.Context
+	stopCancelRequestCtx, h.stopCancelRequest = context.WithCancel(context.Background())
+
+	// The deadline is measured with the Clock of the connection so it reaches the net.Conn when a timer of the Clock
+	// fires rather than as a deadline of the net.Conn.
+	h.deadline = startClockDeadline(h.Conn.conn, clock, h.DeadlineDelay)
+	cancelRequestCtx, cancelCancelRequestCtx := contextWithTimeout(stopCancelRequestCtx, clock, h.DeadlineDelay)
+
+	go func() {
+		defer close(h.cancelFinishedChan)
+		defer cancelCancelRequestCtx()
+
+		delayTimer := clock.NewTimer(h.CancelRequestDelay)
+		select {
+		case <-stopCancelRequestCtx.Done():
+			delayTimer.Stop()
+			return
+		case <-delayTimer.C():
+		}
+
+		h.Conn.CancelRequest(cancelRequestCtx)
+
+		// The cancel request may have been received by the server without yet having been delivered to the backend
+		// process. Returning immediately could allow it to cancel the next query on this connection instead.
+		<-clock.NewTimer(100 * time.Millisecond).C()
+	}()
+}
+
+func (h *CancelRequestContextWatcherHandler) HandleUnwatchAfterCancel() {
+	h.stopCancelRequest()
+	<-h.cancelFinishedChan
+
+	h.deadline.stop()
+	h.Conn.conn.SetDeadline(time.Time{})
+}
+
+// clockDeadline interrupts the reads and writes of a net.Conn after a delay measured with a Clock.
+type clockDeadline struct {
+	mux     sync.Mutex
+	timer   Timer
+	stopped bool
+}
+
+func startClockDeadline(conn net.Conn, clock Clock, delay time.Duration) *clockDeadline {
+	d := &clockDeadline{}
+	if delay <= 0 {
+		conn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC))
+		return d
+	}
+
+	// Hold mux so the timer function cannot observe d.timer before it is assigned.
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.timer = clock.AfterFunc(delay, func() {
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		if !d.stopped {
+			conn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC))
+		}
+	})
+	return d
+}
+
+// stop prevents the deadline from being set if it has not been reached yet. It waits for the timer function if it is
+// running.
+func (d *clockDeadline) stop() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
 func newContextWatcher(conn net.Conn) *ctxwatch.ContextWatcher {
-	return ctxwatch.NewContextWatcher(
-		func() { conn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC)) },
-		func() { conn.SetDeadline(time.Time{}) },
-	)
+	return ctxwatch.NewHandlerContextWatcher(&DeadlineContextWatcherHandler{Conn: conn})
+}
+
+// buildContextWatcher builds the context watcher used for operations on an established connection.
+func (pgConn *PgConn) buildContextWatcher() *ctxwatch.ContextWatcher {
+	if pgConn.config.BuildContextWatcherHandler == nil {
+		return newContextWatcher(pgConn.conn)
+	}
+	return ctxwatch.NewHandlerContextWatcher(pgConn.config.BuildContextWatcherHandler(pgConn))
+}
+
+// connectTLSConfig returns the tls.Config to use for a connection attempt. It applies the settings of config that
+// override the TLS configuration built by ParseConfig.
+func connectTLSConfig(config *Config, tlsConfig *tls.Config) *tls.Config {
+	if config.TLSServerName == "" && config.VerifyServerCertificate == nil && config.GetClientCertificate == nil {
+		return tlsConfig
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	if config.TLSServerName != "" {
+		tlsConfig.ServerName = config.TLSServerName
+	}
+	if verify := config.VerifyServerCertificate; verify != nil {
+		verifyConnection := tlsConfig.VerifyConnection
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verifyConnection != nil {
+				if err := verifyConnection(cs); err != nil {
+					return err
+				}
+			}
+			return verify(cs)
+		}
+	}
+	if config.GetClientCertificate != nil {
+		tlsConfig.GetClientCertificate = config.GetClientCertificate
+	}
+
+	return tlsConfig
 }
 
 func startTLS(conn net.Conn, tlsConfig *tls.Config) (net.Conn, error) {
@@ -427,7 +812,7 @@ func (pgConn *PgConn) txPasswordMessage(password string) (err error) {
 	if err != nil {
 		return err
 	}
-	_, err = pgConn.conn.Write(buf)
+	_, err = pgConn.write(buf)
 	return err
 }
 
@@ -461,12 +846,12 @@ func (pgConn *PgConn) signalMessage() chan struct{} {
 // This is a very low level method that requires deep understanding of the PostgreSQL wire protocol to use correctly.
 // See https://www.postgresql.org/docs/current/protocol.html.
 func (pgConn *PgConn) SendBytes(ctx context.Context, buf []byte) error {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("SendBytes"); err != nil {
 		return err
 	}
 	defer pgConn.unlock()
 
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			return newContextAlreadyDoneError(ctx)
@@ -476,15 +861,77 @@ func (pgConn *PgConn) SendBytes(ctx context.Context, buf []byte) error {
 		defer pgConn.contextWatcher.Unwatch()
 	}
 
-	n, err := pgConn.conn.Write(buf)
+	n, err := pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
-		return &writeError{err: err, safeToRetry: n == 0}
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
+		return err
+	}
+
+	return nil
+}
+
+// SendMessage encodes msg and sends it to the PostgreSQL server together with any messages queued by BufferMessage in a
+// single write. The messages are encoded into a buffer owned by pgConn that is reused so no buffer is allocated per
+// message. If an error is returned the queued messages are discarded. Like SendBytes, it must only be used when the
+// connection is not busy.
+//
+// This is a very low level method that requires deep understanding of the PostgreSQL wire protocol to use correctly.
+// See https://www.postgresql.org/docs/current/protocol.html.
+func (pgConn *PgConn) SendMessage(ctx context.Context, msg pgproto3.FrontendMessage) error {
+	if err := pgConn.lock("SendMessage"); err != nil {
+		return err
+	}
+	defer pgConn.unlock()
+
+	buf, err := msg.Encode(pgConn.sendBuf)
+	pgConn.sendBuf = pgConn.sendBuf[:0]
+	pgConn.ioStats.setQueued(0)
+	if err != nil {
+		return err
+	}
+
+	if pgConn.watchRequired(ctx) {
+		select {
+		case <-ctx.Done():
+			return newContextAlreadyDoneError(ctx)
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	n, err := pgConn.write(buf)
+	pgConn.sendBuf = buf[:0]
+	if err != nil {
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
+		return err
 	}
 
 	return nil
 }
 
+// BufferMessage encodes msg and queues it to be sent by the next call to SendMessage. This allows a sequence of messages
+// such as Parse, Bind, Execute, and Sync to be sent in one write. Queued messages must be sent with SendMessage before
+// the connection is used in any other way.
+func (pgConn *PgConn) BufferMessage(msg pgproto3.FrontendMessage) error {
+	switch {
+	case pgConn.IsBusy():
+		return &connLockError{status: "conn busy"}
+	case pgConn.IsClosed():
+		return &connLockError{status: "conn closed"}
+	}
+
+	buf, err := msg.Encode(pgConn.sendBuf)
+	if err != nil {
+		return err
+	}
+	pgConn.sendBuf = buf
+	pgConn.ioStats.setQueued(int64(len(buf)))
+	return nil
+}
+
 // ReceiveMessage receives one wire protocol message from the PostgreSQL server. It must only be used when the
 // connection is not busy. e.g. It is an error to call ReceiveMessage while reading the result of a query. The messages
 // are still handled by the core pgconn message handling system so receiving a NotificationResponse will still trigger
@@ -493,12 +940,12 @@ func (pgConn *PgConn) SendBytes(ctx context.Context, buf []byte) error {
 // This is a very low level method that requires deep understanding of the PostgreSQL wire protocol to use correctly.
 // See https://www.postgresql.org/docs/current/protocol.html.
 func (pgConn *PgConn) ReceiveMessage(ctx context.Context) (pgproto3.BackendMessage, error) {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("ReceiveMessage"); err != nil {
 		return nil, err
 	}
 	defer pgConn.unlock()
 
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			return nil, newContextAlreadyDoneError(ctx)
@@ -508,7 +955,7 @@ func (pgConn *PgConn) ReceiveMessage(ctx context.Context) (pgproto3.BackendMessa
 		defer pgConn.contextWatcher.Unwatch()
 	}
 
-	msg, err := pgConn.receiveMessage()
+	msg, err := pgConn.receiveAnyMessage()
 	if err != nil {
 		err = &pgconnError{
 			msg:         "receive message failed",
@@ -518,12 +965,50 @@ func (pgConn *PgConn) ReceiveMessage(ctx context.Context) (pgproto3.BackendMessa
 	return msg, err
 }
 
+// ReceiveCopyBothMessage receives the next message of a CopyBoth stream such as streaming replication. It is like
+// ReceiveMessage except that the asynchronous messages the server may send at any time during the stream
+// (NoticeResponse, NotificationResponse, and ParameterStatus) are not returned. They are handled by the OnNotice,
+// OnNotification, and OnParameterStatus callbacks and ReceiveCopyBothMessage continues with the next message. This
+// allows a stream consumer to only handle CopyData, CopyDone, and the messages that conclude the stream without losing
+// the asynchronous messages.
+func (pgConn *PgConn) ReceiveCopyBothMessage(ctx context.Context) (pgproto3.BackendMessage, error) {
+	if err := pgConn.lock("ReceiveCopyBothMessage"); err != nil {
+		return nil, err
+	}
+	defer pgConn.unlock()
+
+	if pgConn.watchRequired(ctx) {
+		select {
+		case <-ctx.Done():
+			return nil, newContextAlreadyDoneError(ctx)
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	msg, err := skipAsyncMessages(pgConn.receiveAnyMessage)
+	if err != nil {
+		return nil, &pgconnError{
+			msg:         "receive message failed",
+			err:         preferContextOverNetTimeoutError(ctx, err),
+			safeToRetry: true}
+	}
+	return msg, nil
+}
+
 // peekMessage peeks at the next message without setting up context cancellation.
 func (pgConn *PgConn) peekMessage() (pgproto3.BackendMessage, error) {
 	if pgConn.peekedMsg != nil {
 		return pgConn.peekedMsg, nil
 	}
 
+	if len(pgConn.pendingMsgs) > 0 {
+		pgConn.peekedMsg = pgConn.pendingMsgs[0]
+		pgConn.pendingMsgs = pgConn.pendingMsgs[1:]
+		return pgConn.peekedMsg, nil
+	}
+
 	var msg pgproto3.BackendMessage
 	var err error
 	if pgConn.bufferingReceive {
@@ -543,29 +1028,69 @@ func (pgConn *PgConn) peekMessage() (pgproto3.BackendMessage, error) {
 	}
 
 	if err != nil {
+		err = pgConn.decodeError(err)
+
 		// Close on anything other than timeout error - everything else is fatal
 		var netErr net.Error
 		isNetErr := errors.As(err, &netErr)
 		if !(isNetErr && netErr.Timeout()) {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 		}
 
 		return nil, err
 	}
 
+	if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+		pgConn.history.receivedReadyForQuery()
+	}
+
 	pgConn.peekedMsg = msg
 	return msg, nil
 }
 
-// receiveMessage receives a message without setting up context cancellation
+// receiveMessage receives the next message. Messages of a type that pgconn never handles are reported to
+// Config.OnUnexpectedMessage.
 func (pgConn *PgConn) receiveMessage() (pgproto3.BackendMessage, error) {
+	msg, err := pgConn.receiveAnyMessage()
+	if err == nil && !isHandledBackendMessage(msg) {
+		pgConn.unexpectedMessage(msg)
+	}
+	return msg, err
+}
+
+func (pgConn *PgConn) unexpectedMessage(msg pgproto3.BackendMessage) {
+	if pgConn.config.OnUnexpectedMessage != nil {
+		pgConn.config.OnUnexpectedMessage(pgConn, msg)
+	}
+}
+
+// isHandledBackendMessage returns true if msg is of a type that pgconn handles in the course of its operations.
+func isHandledBackendMessage(msg pgproto3.BackendMessage) bool {
+	switch msg.(type) {
+	case *pgproto3.AuthenticationOk, *pgproto3.AuthenticationCleartextPassword, *pgproto3.AuthenticationMD5Password,
+		*pgproto3.AuthenticationGSS, *pgproto3.AuthenticationGSSContinue, *pgproto3.AuthenticationSASL,
+		*pgproto3.AuthenticationSASLContinue, *pgproto3.AuthenticationSASLFinal, *pgproto3.BackendKeyData,
+		*pgproto3.BindComplete, *pgproto3.CloseComplete, *pgproto3.CommandComplete, *pgproto3.CopyData,
+		*pgproto3.CopyDone, *pgproto3.CopyInResponse, *pgproto3.CopyOutResponse, *pgproto3.DataRow,
+		*pgproto3.EmptyQueryResponse, *pgproto3.ErrorResponse, *pgproto3.NoData, *pgproto3.NoticeResponse,
+		*pgproto3.NotificationResponse, *pgproto3.ParameterDescription, *pgproto3.ParameterStatus,
+		*pgproto3.ParseComplete, *pgproto3.PortalSuspended, *pgproto3.ReadyForQuery, *pgproto3.RowDescription:
+		return true
+	default:
+		return false
+	}
+}
+
+// receiveAnyMessage receives the next message without reporting unexpected messages. It is used where the caller
+// interprets every message itself (e.g. ReceiveMessage).
+func (pgConn *PgConn) receiveAnyMessage() (pgproto3.BackendMessage, error) {
 	msg, err := pgConn.peekMessage()
 	if err != nil {
 		// Close on anything other than timeout error - everything else is fatal
 		var netErr net.Error
 		isNetErr := errors.As(err, &netErr)
 		if !(isNetErr && netErr.Timeout()) {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 		}
 
 		return nil, err
@@ -574,15 +1099,29 @@ func (pgConn *PgConn) receiveMessage() (pgproto3.BackendMessage, error) {
 
 	switch msg := msg.(type) {
 	case *pgproto3.ReadyForQuery:
-		pgConn.txStatus = msg.TxStatus
+		pgConn.storeTxStatus(msg.TxStatus)
+		if pgConn.config.OnReadyForQuery != nil {
+			op := pgConn.op
+			if pgConn.loadStatus() == connStatusConnecting {
+				op = "Connect"
+			}
+			pgConn.config.OnReadyForQuery(pgConn, msg.TxStatus, op)
+		}
 	case *pgproto3.ParameterStatus:
+		pgConn.parameterStatusMux.Lock()
 		pgConn.parameterStatuses[msg.Name] = msg.Value
+		pgConn.parameterStatusMux.Unlock()
+		if pgConn.config.OnParameterStatus != nil {
+			pgConn.config.OnParameterStatus(pgConn, msg.Name, msg.Value)
+		}
 	case *pgproto3.ErrorResponse:
-		if msg.Severity == "FATAL" {
-			pgConn.status = connStatusClosed
+		if isFatalErrorResponse(msg) {
+			pgErr := ErrorResponseToPgError(msg)
+			pgConn.markClosed(CloseReason{Kind: CloseReasonFatalError, Op: pgConn.op, Err: pgErr})
 			pgConn.conn.Close() // Ignore error as the connection is already broken and there is already an error to return.
-			close(pgConn.cleanupDone)
-			return nil, ErrorResponseToPgError(msg)
+			pgConn.finishCleanup()
+			pgConn.setFatalError(pgErr)
+			return nil, pgErr
 		}
 	case *pgproto3.NoticeResponse:
 		if pgConn.config.OnNotice != nil {
@@ -597,16 +1136,56 @@ func (pgConn *PgConn) receiveMessage() (pgproto3.BackendMessage, error) {
 	return msg, nil
 }
 
+// receiveAuthMessage receives the next message of an authentication exchange. Asynchronous messages that the server
+// may send at any time, such as a NoticeResponse, are handled by receiveMessage and skipped.
+func (pgConn *PgConn) receiveAuthMessage() (pgproto3.BackendMessage, error) {
+	return skipAsyncMessages(pgConn.receiveMessage)
+}
+
+// skipAsyncMessages calls receive until it returns a message other than NoticeResponse, NotificationResponse, or
+// ParameterStatus.
+func skipAsyncMessages(receive func() (pgproto3.BackendMessage, error)) (pgproto3.BackendMessage, error) {
+	for {
+		msg, err := receive()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.(type) {
+		case *pgproto3.NoticeResponse, *pgproto3.NotificationResponse, *pgproto3.ParameterStatus:
+		default:
+			return msg, nil
+		}
+	}
+}
+
 // Conn returns the underlying net.Conn.
 func (pgConn *PgConn) Conn() net.Conn {
 	return pgConn.conn
 }
 
-// PID returns the backend PID.
+// PID returns the backend PID. It is set when the connection is established and never changes afterwards, so PID is
+// safe to call from any goroutine.
 func (pgConn *PgConn) PID() uint32 {
 	return pgConn.pid
 }
 
+// Identity returns a human-readable description of the connection for logs and error annotations. It contains the
+// server address, database, user, backend PID, and local address. The PID and local address match the pid and
+// client_addr / client_port columns of pg_stat_activity. The local address is omitted for Unix domain sockets. For
+// example:
+//
+//	10.0.0.5:5432/mydb user=jack pid=12345 local=10.0.0.9:51234
+//
+// It does not change during the lifetime of the connection and is safe to call from any goroutine.
+func (pgConn *PgConn) Identity() string {
+	identity := fmt.Sprintf("%s/%s user=%s pid=%d", pgConn.conn.RemoteAddr(), pgConn.config.Database, pgConn.config.User, pgConn.pid)
+	if local := pgConn.conn.LocalAddr(); local != nil && local.String() != "" {
+		identity += " local=" + local.String()
+	}
+	return identity
+}
+
 // TxStatus returns the current TxStatus as reported by the server in the ReadyForQuery message.
 //
 // Possible return values:
@@ -616,25 +1195,48 @@ func (pgConn *PgConn) PID() uint32 {
 //	'E' - in a failed transaction
 //
 // See https://www.postgresql.org/docs/current/protocol-message-formats.html.
+//
+// TxStatus is safe to call from any goroutine, e.g. to monitor a connection that is in use by another goroutine.
 func (pgConn *PgConn) TxStatus() byte {
-	return pgConn.txStatus
+	return pgConn.loadTxStatus()
 }
 
-// SecretKey returns the backend secret key used to send a cancel query message to the server.
+// SecretKey returns the backend secret key used to send a cancel query message to the server. Like PID it never
+// changes after the connection is established. It is 0 if the server sent a key that is not 4 bytes long, which is
+// possible with protocol 3.2. CancelKey returns the key in all cases.
 func (pgConn *PgConn) SecretKey() uint32 {
 	return pgConn.secretKey
 }
 
+// CancelKey returns the backend secret key used to send a cancel query message to the server as sent by the server.
+// It is 4 bytes long unless the server sent a longer key, which is possible with protocol 3.2. It must not be modified.
+func (pgConn *PgConn) CancelKey() []byte {
+	if pgConn.cancelKey != nil {
+		return pgConn.cancelKey
+	}
+	return secretKeyBytes(pgConn.secretKey)
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server. It is Config.MaxProtocolVersion unless the
+// server only supports an older version.
+func (pgConn *PgConn) ProtocolVersion() ProtocolVersion {
+	return pgConn.protocolVersion
+}
+
 // Close closes a connection. It is safe to call Close on a already closed connection. Close attempts a clean close by
 // sending the exit message to PostgreSQL. However, this could block so ctx is available to limit the time to wait. The
 // underlying net.Conn.Close() will always be called regardless of any other errors.
 func (pgConn *PgConn) Close(ctx context.Context) error {
-	if pgConn.status == connStatusClosed {
+	if pgConn.loadStatus() == connStatusClosed {
 		return nil
 	}
-	pgConn.status = connStatusClosed
+	pgConn.markClosed(CloseReason{Kind: CloseReasonClose, Op: pgConn.op})
+
+	if pgConn.keepalive != nil {
+		pgConn.keepalive.stop()
+	}
 
-	defer close(pgConn.cleanupDone)
+	defer pgConn.finishCleanup()
 	defer pgConn.conn.Close()
 
 	if ctx != context.Background() {
@@ -654,21 +1256,38 @@ func (pgConn *PgConn) Close(ctx context.Context) error {
 	// ignores errors.
 	//
 	// See https://github.com/jackc/pgx/issues/637
-	pgConn.conn.Write([]byte{'X', 0, 0, 0, 4})
+	pgConn.write([]byte{'X', 0, 0, 0, 4})
 
 	return pgConn.conn.Close()
 }
 
+// Closer returns an io.Closer that closes the connection. PgConn can not implement io.Closer itself because Close takes
+// a context. This allows a PgConn to be used with generic resource cleanup code. The io.Closer calls Close with a
+// context that times out after 15 seconds, which only limits the time spent sending the exit message to PostgreSQL.
+func (pgConn *PgConn) Closer() io.Closer {
+	return pgConnCloser{pgConn: pgConn}
+}
+
+type pgConnCloser struct {
+	pgConn *PgConn
+}
+
+func (c pgConnCloser) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return c.pgConn.Close(ctx)
+}
+
 // asyncClose marks the connection as closed and asynchronously sends a cancel query message and closes the underlying
-// connection.
-func (pgConn *PgConn) asyncClose() {
-	if pgConn.status == connStatusClosed {
+// connection. err is the cause reported to Config.OnClose.
+func (pgConn *PgConn) asyncClose(err error) {
+	if pgConn.loadStatus() == connStatusClosed {
 		return
 	}
-	pgConn.status = connStatusClosed
+	pgConn.markClosed(newCloseReason(pgConn.op, err))
 
 	go func() {
-		defer close(pgConn.cleanupDone)
+		defer pgConn.finishCleanup()
 		defer pgConn.conn.Close()
 
 		deadline := time.Now().Add(time.Second * 15)
@@ -680,7 +1299,7 @@ func (pgConn *PgConn) asyncClose() {
 
 		pgConn.conn.SetDeadline(deadline)
 
-		pgConn.conn.Write([]byte{'X', 0, 0, 0, 4})
+		pgConn.write([]byte{'X', 0, 0, 0, 4})
 	}()
 }
 
@@ -696,21 +1315,112 @@ func (pgConn *PgConn) CleanupDone() chan (struct{}) {
 	return pgConn.cleanupDone
 }
 
+// Done returns a channel that is closed when the connection has been closed, whether by Close or because of an error,
+// and its underlying resources have been cleaned up. Unlike IsClosed it can be used to wait for teardown or to detect
+// the unexpected closure of a connection, e.g. because the server crashed, without polling.
+//
+// A broken connection is only detected when it is used. An idle connection is not read from unless
+// Config.IdleKeepaliveInterval is set. In that case Done is closed when a keepalive fails. The channel is never closed
+// for a connection that has been hijacked. FatalError returns the reason if the server closed the connection.
+func (pgConn *PgConn) Done() <-chan struct{} {
+	return pgConn.done
+}
+
+// finishCleanup is called when all underlying resources have been cleaned up after the connection was closed.
+func (pgConn *PgConn) finishCleanup() {
+	close(pgConn.cleanupDone)
+	pgConn.closeDone()
+}
+
+func (pgConn *PgConn) closeDone() {
+	pgConn.doneOnce.Do(func() { close(pgConn.done) })
+}
+
+// SetConnContext sets a context that is watched during every operation in addition to the context passed to the
+// operation. If it is canceled, the operation in progress and all further operations are interrupted as if their own
+// context had been canceled. This allows a connection-level context or shutdown signal to be combined with per-call
+// contexts without merging them for each call. A nil ctx removes the connection context.
+func (pgConn *PgConn) SetConnContext(ctx context.Context) {
+	pgConn.connCtx = ctx
+	pgConn.contextWatcher.SetBaseContext(ctx)
+}
+
+// watchRequired reports if the context watcher must watch during an operation with ctx. Watching context.Background()
+// is skipped as it can never be canceled unless there is a connection context.
+func (pgConn *PgConn) watchRequired(ctx context.Context) bool {
+	return ctx != context.Background() || pgConn.connCtx != nil
+}
+
 // IsClosed reports if the connection has been closed.
 //
 // CleanupDone() can be used to determine if all cleanup has been completed.
+//
+// Like the other read-only accessors listed in the PgConn documentation, IsClosed is safe to call from any goroutine,
+// even while another goroutine is using the connection. The result may already be out of date when it is returned.
 func (pgConn *PgConn) IsClosed() bool {
-	return pgConn.status < connStatusIdle
+	return pgConn.loadStatus() < connStatusIdle
 }
 
-// IsBusy reports if the connection is busy.
+// IsBusy reports if the connection is busy. It is safe to call from any goroutine.
 func (pgConn *PgConn) IsBusy() bool {
-	return pgConn.status == connStatusBusy
+	return pgConn.loadStatus() == connStatusBusy
+}
+
+// IsIdle reports if the connection is open, not busy, and not in a transaction. That is, it can be used for a new
+// operation without affecting another user of the connection. It is safe to call from any goroutine.
+func (pgConn *PgConn) IsIdle() bool {
+	return pgConn.loadStatus() == connStatusIdle && pgConn.loadTxStatus() == 'I'
+}
+
+// loadStatus and storeStatus access status atomically so the status accessors can be called concurrently with an
+// operation in progress.
+func (pgConn *PgConn) loadStatus() uint32 {
+	return atomic.LoadUint32(&pgConn.status)
+}
+
+func (pgConn *PgConn) storeStatus(status uint32) {
+	atomic.StoreUint32(&pgConn.status, status)
+}
+
+// loadTxStatus and storeTxStatus access txStatus atomically for the same reason.
+func (pgConn *PgConn) loadTxStatus() byte {
+	return byte(atomic.LoadUint32(&pgConn.txStatus))
+}
+
+func (pgConn *PgConn) storeTxStatus(txStatus byte) {
+	atomic.StoreUint32(&pgConn.txStatus, uint32(txStatus))
+}
+
+// TryLock locks the connection for exclusive use if it is idle (see IsIdle) and reports whether it succeeded. While
+// locked all operations fail as if the connection was busy. The lock is released with Unlock.
+//
+// This is only likely to be useful to connection pools. It lets them check out a connection and verify its state in
+// a single step instead of inferring it from a conn busy error. It is safe to call concurrently. At most one caller
+// acquires the lock.
+func (pgConn *PgConn) TryLock() bool {
+	if !atomic.CompareAndSwapUint32(&pgConn.status, connStatusIdle, connStatusBusy) {
+		return false
+	}
+	if pgConn.locked("TryLock") != nil {
+		return false
+	}
+	if pgConn.loadTxStatus() != 'I' {
+		pgConn.unlock()
+		return false
+	}
+	return true
+}
+
+// Unlock releases a lock acquired by TryLock. It panics if the connection is not locked. It has no effect if the
+// connection was closed while locked.
+func (pgConn *PgConn) Unlock() {
+	pgConn.unlock()
 }
 
-// lock locks the connection.
-func (pgConn *PgConn) lock() error {
-	switch pgConn.status {
+// lock locks the connection for the method op.
+func (pgConn *PgConn) lock(op string) error {
+	status := pgConn.loadStatus()
+	switch status {
 	case connStatusBusy:
 		return &connLockError{status: "conn busy"} // This only should be possible in case of an application bug.
 	case connStatusClosed:
@@ -718,23 +1428,72 @@ func (pgConn *PgConn) lock() error {
 	case connStatusUninitialized:
 		return &connLockError{status: "conn uninitialized"}
 	}
-	pgConn.status = connStatusBusy
+
+	// Another goroutine may have locked the connection with TryLock since status was loaded.
+	if !atomic.CompareAndSwapUint32(&pgConn.status, status, connStatusBusy) {
+		return &connLockError{status: "conn busy"}
+	}
+
+	return pgConn.locked(op)
+}
+
+// locked prepares the connection for the method op after its status was changed to busy.
+func (pgConn *PgConn) locked(op string) error {
+	if pgConn.keepalive != nil {
+		msgs, err := pgConn.keepalive.pause()
+		if err != nil {
+			pgConn.markClosed(newCloseReason("", err))
+			pgConn.conn.Close()
+			pgConn.finishCleanup()
+			return &pgconnError{msg: "idle keepalive failed", err: err, safeToRetry: true}
+		}
+		pgConn.pendingMsgs = append(pgConn.pendingMsgs, msgs...)
+	}
+
+	pgConn.op = op
+
+	if !pgConn.nextOperationDeadline.IsZero() {
+		pgConn.conn.SetDeadline(pgConn.nextOperationDeadline)
+		pgConn.nextOperationDeadline = time.Time{}
+		pgConn.operationDeadlineSet = true
+	}
+
 	return nil
 }
 
 func (pgConn *PgConn) unlock() {
-	switch pgConn.status {
+	switch pgConn.loadStatus() {
 	case connStatusBusy:
-		pgConn.status = connStatusIdle
+		pgConn.storeStatus(connStatusIdle)
+		pgConn.op = ""
+		if pgConn.operationDeadlineSet {
+			pgConn.conn.SetDeadline(time.Time{})
+			pgConn.operationDeadlineSet = false
+		}
+		if pgConn.keepalive != nil {
+			pgConn.keepalive.resume()
+		}
 	case connStatusClosed:
 	default:
 		panic("BUG: cannot unlock unlocked connection") // This should only be possible if there is a bug in this package.
 	}
 }
 
+// SetNextOperationDeadline sets an absolute deadline for the next operation on the connection (e.g. Exec, ExecParams,
+// Prepare, CopyFrom, or WaitForNotification). It is applied to the underlying net.Conn when the operation starts and
+// cleared when the operation completes, including reading all of its results. It is an alternative for callers that do
+// not use contexts. The context passed to the operation is still observed. If the deadline is reached the operation
+// fails with a net.Error whose Timeout method returns true and, as with a canceled context, the connection is usually
+// closed. A zero value clears a previously set deadline that has not yet been used.
+func (pgConn *PgConn) SetNextOperationDeadline(t time.Time) {
+	pgConn.nextOperationDeadline = t
+}
+
 // ParameterStatus returns the value of a parameter reported by the server (e.g.
-// server_version). Returns an empty string for unknown parameters.
+// server_version). Returns an empty string for unknown parameters. It is safe to call from any goroutine.
 func (pgConn *PgConn) ParameterStatus(key string) string {
+	pgConn.parameterStatusMux.RLock()
+	defer pgConn.parameterStatusMux.RUnlock()
 	return pgConn.parameterStatuses[key]
 }
 
@@ -743,6 +1502,9 @@ type CommandTag []byte
 
 // RowsAffected returns the number of rows affected. If the CommandTag was not
 // for a row affecting command (e.g. "CREATE TABLE") then it returns 0.
+//
+// The row count is the last space separated token of the tag when it is numeric. This covers INSERT, UPDATE, DELETE,
+// MERGE, SELECT, COPY, FETCH, MOVE, and any future tags that follow the same convention.
 func (ct CommandTag) RowsAffected() int64 {
 	// Find last non-digit
 	idx := -1
@@ -754,7 +1516,8 @@ func (ct CommandTag) RowsAffected() int64 {
 		}
 	}
 
-	if idx == -1 {
+	// The count must be a separate token. Digits attached to a word are not a row count.
+	if idx <= 0 || ct[idx-1] != ' ' {
 		return 0
 	}
 
@@ -822,14 +1585,24 @@ type StatementDescription struct {
 }
 
 // Prepare creates a prepared statement. If the name is empty, the anonymous prepared statement will be used. This
-// allows Prepare to also to describe statements without creating a server-side prepared statement.
+// allows Prepare to also to describe statements without creating a server-side prepared statement. Behind a
+// transaction pooler (see Config.PoolerMode) only the anonymous prepared statement can be used.
 func (pgConn *PgConn) Prepare(ctx context.Context, name, sql string, paramOIDs []uint32) (*StatementDescription, error) {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("Prepare"); err != nil {
 		return nil, err
 	}
 	defer pgConn.unlock()
 
-	if ctx != context.Background() {
+	if err := pgConn.checkExtendedProtocol("Prepare"); err != nil {
+		return nil, err
+	}
+	if name != "" {
+		if err := pgConn.checkPooler("Prepare of a named statement"); err != nil {
+			return nil, err
+		}
+	}
+
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			return nil, newContextAlreadyDoneError(ctx)
@@ -854,10 +1627,11 @@ func (pgConn *PgConn) Prepare(ctx context.Context, name, sql string, paramOIDs [
 		return nil, err
 	}
 
-	n, err := pgConn.conn.Write(buf)
+	n, err := pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
-		return nil, &writeError{err: err, safeToRetry: n == 0}
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
+		return nil, err
 	}
 
 	psd := &StatementDescription{Name: name, SQL: sql}
@@ -868,7 +1642,7 @@ readloop:
 	for {
 		msg, err := pgConn.receiveMessage()
 		if err != nil {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 			return nil, preferContextOverNetTimeoutError(ctx, err)
 		}
 
@@ -895,24 +1669,34 @@ readloop:
 // ErrorResponseToPgError converts a wire protocol error message to a *PgError.
 func ErrorResponseToPgError(msg *pgproto3.ErrorResponse) *PgError {
 	return &PgError{
-		Severity:         msg.Severity,
-		Code:             string(msg.Code),
-		Message:          string(msg.Message),
-		Detail:           string(msg.Detail),
-		Hint:             msg.Hint,
-		Position:         msg.Position,
-		InternalPosition: msg.InternalPosition,
-		InternalQuery:    string(msg.InternalQuery),
-		Where:            string(msg.Where),
-		SchemaName:       string(msg.SchemaName),
-		TableName:        string(msg.TableName),
-		ColumnName:       string(msg.ColumnName),
-		DataTypeName:     string(msg.DataTypeName),
-		ConstraintName:   msg.ConstraintName,
-		File:             string(msg.File),
-		Line:             msg.Line,
-		Routine:          string(msg.Routine),
-	}
+		Severity:            msg.Severity,
+		SeverityUnlocalized: msg.SeverityUnlocalized,
+		Code:                string(msg.Code),
+		Message:             string(msg.Message),
+		Detail:              string(msg.Detail),
+		Hint:                msg.Hint,
+		Position:            msg.Position,
+		InternalPosition:    msg.InternalPosition,
+		InternalQuery:       string(msg.InternalQuery),
+		Where:               string(msg.Where),
+		SchemaName:          string(msg.SchemaName),
+		TableName:           string(msg.TableName),
+		ColumnName:          string(msg.ColumnName),
+		DataTypeName:        string(msg.DataTypeName),
+		ConstraintName:      msg.ConstraintName,
+		File:                string(msg.File),
+		Line:                msg.Line,
+		Routine:             string(msg.Routine),
+	}
+}
+
+// isFatalErrorResponse reports if msg has severity FATAL. The untranslated severity is used when the server sends it so
+// the check works regardless of lc_messages.
+func isFatalErrorResponse(msg *pgproto3.ErrorResponse) bool {
+	if msg.SeverityUnlocalized != "" {
+		return msg.SeverityUnlocalized == "FATAL"
+	}
+	return msg.Severity == "FATAL"
 }
 
 func noticeResponseToNotice(msg *pgproto3.NoticeResponse) *Notice {
@@ -923,32 +1707,100 @@ func noticeResponseToNotice(msg *pgproto3.NoticeResponse) *Notice {
 // CancelRequest sends a cancel request to the PostgreSQL server. It returns an error if unable to deliver the cancel
 // request, but lack of an error does not ensure that the query was canceled. As specified in the documentation, there
 // is no way to be sure a query was canceled. See https://www.postgresql.org/docs/11/protocol-flow.html#id-1.10.5.7.9
+//
+// If Config.CancelConnPool is set, a connection established in advance is used if one is available. If
+// Config.CancelRequestFallbackToQuery is set and the cancel request connection cannot be established, the query is
+// canceled with pg_cancel_backend over a new regular connection instead.
+//
+// Behind a transaction pooler that did not send a cancel key (see Pooler) it fails without connecting.
 func (pgConn *PgConn) CancelRequest(ctx context.Context) error {
+	if pgConn.pooler != nil && !pgConn.pooler.HasCancelKey {
+		return &pgconnError{msg: "cancel request is not supported as the connection pooler did not send a cancel key", safeToRetry: true}
+	}
+
 	// Open a cancellation request to the same server. The address is taken from the net.Conn directly instead of reusing
 	// the connection config. This is important in high availability configurations where fallback connections may be
 	// specified or DNS may be used to load balance.
 	serverAddr := pgConn.conn.RemoteAddr()
-	cancelConn, err := pgConn.config.DialFunc(ctx, serverAddr.Network(), serverAddr.String())
-	if err != nil {
-		return err
+
+	var onDialError func(context.Context, error) error
+	if pgConn.config.CancelRequestFallbackToQuery {
+		onDialError = func(ctx context.Context, err error) error {
+			if ctx.Err() != nil {
+				return err
+			}
+			return pgConn.cancelBackend(ctx, serverAddr, err)
+		}
+	}
+
+	return sendCancelRequest(ctx, pgConn.config, serverAddr, pgConn.pid, pgConn.CancelKey(), onDialError)
+}
+
+// SendCancelRequest sends a cancel request for the backend process identified by pid and secretKey to the server at
+// serverAddr. Unlike CancelRequest the backend does not have to be that of a PgConn. This lets a connection pooler that
+// multiplexes client sessions onto server connections forward a cancel request from a client to the server connection
+// that is executing the query of the client.
+//
+// The connection is established with config.DialFunc. CancelRequestDialTimeout, CancelRequestTimeout, and
+// CancelConnPool of config are used like by CancelRequest. CancelRequestFallbackToQuery is ignored. config must have
+// been created by ParseConfig.
+func SendCancelRequest(ctx context.Context, config *Config, serverAddr net.Addr, pid, secretKey uint32) error {
+	return sendCancelRequest(ctx, config, serverAddr, pid, secretKeyBytes(secretKey), nil)
+}
+
+// SendCancelRequestWithKey is like SendCancelRequest but takes the cancel key as returned by PgConn.CancelKey. This
+// supports the longer keys of protocol 3.2.
+func SendCancelRequestWithKey(ctx context.Context, config *Config, serverAddr net.Addr, pid uint32, cancelKey []byte) error {
+	if len(cancelKey) < 4 || len(cancelKey) > maxCancelKeyLen {
+		return &pgconnError{msg: fmt.Sprintf("invalid cancel key length %d", len(cancelKey))}
+	}
+	return sendCancelRequest(ctx, config, serverAddr, pid, cancelKey, nil)
+}
+
+// sendCancelRequest sends a cancel request for pid and cancelKey to serverAddr. If the connection cannot be
+// established and onDialError is not nil the result of onDialError is returned instead of the dial error.
+func sendCancelRequest(ctx context.Context, config *Config, serverAddr net.Addr, pid uint32, cancelKey []byte, onDialError func(context.Context, error) error) error {
+	if config.CancelRequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = contextWithTimeout(ctx, config.clock(), config.CancelRequestTimeout)
+		defer cancel()
+	}
+
+	dialCtx := ctx
+	if config.CancelRequestDialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = contextWithTimeout(ctx, config.clock(), config.CancelRequestDialTimeout)
+		defer cancel()
+	}
+
+	var cancelConn net.Conn
+	if config.CancelConnPool != nil {
+		cancelConn = config.CancelConnPool.take(config, serverAddr)
+	}
+	if cancelConn == nil {
+		var err error
+		cancelConn, err = config.DialFunc(dialCtx, serverAddr.Network(), serverAddr.String())
+		if err != nil {
+			if onDialError != nil {
+				return onDialError(ctx, err)
+			}
+			return err
+		}
 	}
 	defer cancelConn.Close()
 
 	if ctx != context.Background() {
-		contextWatcher := ctxwatch.NewContextWatcher(
-			func() { cancelConn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC)) },
-			func() { cancelConn.SetDeadline(time.Time{}) },
-		)
+		contextWatcher := newContextWatcher(cancelConn)
 		contextWatcher.Watch(ctx)
 		defer contextWatcher.Unwatch()
 	}
 
-	buf := make([]byte, 16)
-	binary.BigEndian.PutUint32(buf[0:4], 16)
+	buf := make([]byte, 12+len(cancelKey))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)))
 	binary.BigEndian.PutUint32(buf[4:8], 80877102)
-	binary.BigEndian.PutUint32(buf[8:12], uint32(pgConn.pid))
-	binary.BigEndian.PutUint32(buf[12:16], uint32(pgConn.secretKey))
-	_, err = cancelConn.Write(buf)
+	binary.BigEndian.PutUint32(buf[8:12], pid)
+	copy(buf[12:], cancelKey)
+	_, err := cancelConn.Write(buf)
 	if err != nil {
 		return err
 	}
@@ -961,36 +1813,227 @@ func (pgConn *PgConn) CancelRequest(ctx context.Context) error {
 	return nil
 }
 
+// cancelBackend cancels the query in progress on pgConn by calling pg_cancel_backend over a new connection to the
+// server at serverAddr. dialErr is the error that prevented sending a cancel request.
+func (pgConn *PgConn) cancelBackend(ctx context.Context, serverAddr net.Addr, dialErr error) error {
+	conn, err := ConnectConfig(ctx, cancelBackendConfig(pgConn.config))
+	if err != nil {
+		return &pgconnError{msg: fmt.Sprintf("failed to dial cancel request connection (%v) and to connect for pg_cancel_backend", dialErr), err: err}
+	}
+	defer conn.Close(ctx)
+
+	if addr := conn.conn.RemoteAddr(); addr.Network() != serverAddr.Network() || addr.String() != serverAddr.String() {
+		return &pgconnError{msg: fmt.Sprintf("failed to dial cancel request connection (%v) and connection for pg_cancel_backend reached %s instead of %s", dialErr, addr, serverAddr)}
+	}
+
+	results, err := conn.Exec(ctx, "select pg_cancel_backend("+strconv.FormatUint(uint64(pgConn.pid), 10)+")").ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || len(results[0].Rows) != 1 || len(results[0].Rows[0]) != 1 {
+		return &pgconnError{msg: "unexpected result of pg_cancel_backend"}
+	}
+	if string(results[0].Rows[0][0]) != "t" {
+		return &pgconnError{msg: fmt.Sprintf("pg_cancel_backend could not signal backend %d", pgConn.pid)}
+	}
+	return nil
+}
+
+// cancelBackendConfig returns a copy of config for the connection that calls pg_cancel_backend. It is a plain
+// connection: the hooks and callbacks of the canceled connection are not run for it, and it neither sends keepalives,
+// uses the cancel connection pool, nor is a replication connection.
+func cancelBackendConfig(config *Config) *Config {
+	config = config.Copy()
+	config.IdleKeepaliveInterval = 0
+	config.CancelConnPool = nil
+	config.CancelRequestFallbackToQuery = false
+	config.ReplicationMode = ReplicationModeOff
+	config.ValidateConnect = nil
+	config.AfterConnect = nil
+	config.OnNotice = nil
+	config.OnNotification = nil
+	config.OnParameterStatus = nil
+	config.OnReadyForQuery = nil
+	config.OnUnexpectedMessage = nil
+	config.OnConnectProgress = nil
+	config.OnClose = nil
+	config.BuildContextWatcherHandler = nil
+	return config
+}
+
 // WaitForNotification waits for a LISTON/NOTIFY message to be received. It returns an error if a notification was not
 // received.
 func (pgConn *PgConn) WaitForNotification(ctx context.Context) error {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("WaitForNotification"); err != nil {
 		return err
 	}
 	defer pgConn.unlock()
 
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			return newContextAlreadyDoneError(ctx)
 		default:
 		}
 
-		pgConn.contextWatcher.Watch(ctx)
-		defer pgConn.contextWatcher.Unwatch()
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	for {
+		msg, err := pgConn.receiveMessage()
+		if err != nil {
+			return preferContextOverNetTimeoutError(ctx, err)
+		}
+
+		switch msg.(type) {
+		case *pgproto3.NotificationResponse:
+			return nil
+		}
+	}
+}
+
+// WaitForNotificationOnChannels waits for a LISTEN/NOTIFY message to be received on one of channels and returns it.
+// Notifications on other channels do not end the wait. They are still delivered to the OnNotification callback like
+// every notification. If channels is empty a notification on any channel ends the wait.
+func (pgConn *PgConn) WaitForNotificationOnChannels(ctx context.Context, channels ...string) (*Notification, error) {
+	if err := pgConn.lock("WaitForNotificationOnChannels"); err != nil {
+		return nil, err
+	}
+	defer pgConn.unlock()
+
+	if pgConn.watchRequired(ctx) {
+		select {
+		case <-ctx.Done():
+			return nil, newContextAlreadyDoneError(ctx)
+		default:
+		}
+
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	for {
+		msg, err := pgConn.receiveMessage()
+		if err != nil {
+			return nil, preferContextOverNetTimeoutError(ctx, err)
+		}
+
+		if msg, ok := msg.(*pgproto3.NotificationResponse); ok && matchesChannel(msg.Channel, channels) {
+			return &Notification{PID: msg.PID, Channel: msg.Channel, Payload: msg.Payload}, nil
+		}
+	}
+}
+
+// Reset returns the connection to the state of a new session so it can be safely reused (e.g. by a connection pool). A
+// transaction in progress is rolled back and then DISCARD ALL is executed to release prepared statements, LISTEN
+// registrations, temporary tables, session variables, advisory locks, etc. The statements prepared by ExecAuto and the
+// run-time parameters recorded by SetRuntimeParam are forgotten as well. An error is returned if the connection is not
+// idle and outside of a transaction afterwards.
+func (pgConn *PgConn) Reset(ctx context.Context) error {
+	// DISCARD ALL cannot be executed in a transaction block so it must be sent separately from the rollback.
+	if pgConn.loadTxStatus() != 'I' {
+		_, err := pgConn.Exec(ctx, "rollback").ReadAll()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := pgConn.Exec(ctx, "discard all").ReadAll()
+	pgConn.autoStatements = nil
+	if err != nil {
+		return err
+	}
+	pgConn.modifiedRuntimeParams = nil
+
+	if pgConn.loadTxStatus() != 'I' {
+		return fmt.Errorf("connection is not idle after reset: transaction status %q", pgConn.loadTxStatus())
+	}
+
+	return nil
+}
+
+// WithStatementTimeout calls f with the server-side statement_timeout set to timeout and then restores the previous
+// value. If a transaction is in progress the timeout is set with the equivalent of SET LOCAL so it is also reverted if
+// the transaction is rolled back. Otherwise it is set for the session and f must not leave a transaction open. If the
+// previous value cannot be reliably restored (e.g. f left a transaction open or the restore fails) the connection is
+// closed so the altered timeout is never visible to subsequent users of the connection.
+//
+// The previous value is restored even if ctx is canceled while f is running. The error returned by f takes precedence
+// over an error restoring the timeout.
+//
+// timeout must be greater than zero as a statement_timeout of zero disables the timeout. It is rounded up to whole
+// milliseconds, the resolution of statement_timeout.
+func (pgConn *PgConn) WithStatementTimeout(ctx context.Context, timeout time.Duration, f func() error) error {
+	if timeout <= 0 {
+		return fmt.Errorf("statement timeout must be greater than zero: %v", timeout)
+	}
+	timeoutMillis := (timeout + time.Millisecond - 1) / time.Millisecond
+
+	var isLocal string
+	switch pgConn.loadTxStatus() {
+	case 'I':
+		isLocal = "false"
+	case 'T':
+		isLocal = "true"
+	default:
+		return errors.New("cannot set statement_timeout in a failed transaction")
+	}
+
+	result := pgConn.ExecParams(ctx,
+		"select current_setting('statement_timeout'), set_config('statement_timeout', $1, $2::boolean)",
+		[][]byte{[]byte(strconv.FormatInt(int64(timeoutMillis), 10)), []byte(isLocal)},
+		nil, nil, textResultFormats,
+	).Read()
+	if result.Err != nil {
+		return result.Err
+	}
+	previous := result.Rows[0][0]
+
+	fErr := f()
+
+	restoreErr := pgConn.restoreStatementTimeout(ctx, isLocal, previous)
+	if fErr != nil {
+		return fErr
+	}
+	return restoreErr
+}
+
+func (pgConn *PgConn) restoreStatementTimeout(ctx context.Context, isLocal string, previous []byte) error {
+	if pgConn.IsClosed() {
+		return nil
 	}
 
-	for {
-		msg, err := pgConn.receiveMessage()
-		if err != nil {
-			return preferContextOverNetTimeoutError(ctx, err)
+	if isLocal == "true" {
+		// Ending the transaction in any way reverts the setting.
+		if pgConn.loadTxStatus() != 'T' {
+			return nil
 		}
+	} else if pgConn.loadTxStatus() != 'I' {
+		err := errors.New("cannot restore statement_timeout because a transaction was left open")
+		pgConn.asyncClose(err)
+		return err
+	}
 
-		switch msg.(type) {
-		case *pgproto3.NotificationResponse:
-			return nil
+	// Canceling ctx must not prevent the restore.
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	result := pgConn.ExecParams(ctx,
+		"select set_config('statement_timeout', $1, $2::boolean)",
+		[][]byte{previous, []byte(isLocal)},
+		nil, nil, textResultFormats,
+	).Read()
+	if result.Err != nil {
+		// A failed restore within a transaction aborts it and rolling it back reverts the setting.
+		if isLocal != "true" {
+			pgConn.asyncClose(result.Err)
 		}
+		return fmt.Errorf("failed to restore statement_timeout: %w", result.Err)
 	}
+
+	return nil
 }
 
 // Exec executes SQL via the PostgreSQL simple query protocol. SQL may contain multiple queries. Execution is
@@ -999,7 +2042,7 @@ func (pgConn *PgConn) WaitForNotification(ctx context.Context) error {
 //
 // Prefer ExecParams unless executing arbitrary SQL that may contain multiple queries.
 func (pgConn *PgConn) Exec(ctx context.Context, sql string) *MultiResultReader {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("Exec"); err != nil {
 		return &MultiResultReader{
 			closed: true,
 			err:    err,
@@ -1011,7 +2054,7 @@ func (pgConn *PgConn) Exec(ctx context.Context, sql string) *MultiResultReader {
 		ctx:    ctx,
 	}
 	multiResult := &pgConn.multiResultReader
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			multiResult.closed = true
@@ -1033,12 +2076,13 @@ func (pgConn *PgConn) Exec(ctx context.Context, sql string) *MultiResultReader {
 		}
 	}
 
-	n, err := pgConn.conn.Write(buf)
+	n, err := pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
 		pgConn.contextWatcher.Unwatch()
 		multiResult.closed = true
-		multiResult.err = &writeError{err: err, safeToRetry: n == 0}
+		multiResult.err = err
 		pgConn.unlock()
 		return multiResult
 	}
@@ -1052,7 +2096,7 @@ func (pgConn *PgConn) Exec(ctx context.Context, sql string) *MultiResultReader {
 // This is a very low level method that requires deep understanding of the PostgreSQL wire protocol to use correctly.
 // See https://www.postgresql.org/docs/current/protocol.html.
 func (pgConn *PgConn) ReceiveResults(ctx context.Context) *MultiResultReader {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("ReceiveResults"); err != nil {
 		return &MultiResultReader{
 			closed: true,
 			err:    err,
@@ -1064,7 +2108,7 @@ func (pgConn *PgConn) ReceiveResults(ctx context.Context) *MultiResultReader {
 		ctx:    ctx,
 	}
 	multiResult := &pgConn.multiResultReader
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			multiResult.closed = true
@@ -1079,6 +2123,21 @@ func (pgConn *PgConn) ReceiveResults(ctx context.Context) *MultiResultReader {
 	return multiResult
 }
 
+// textResultFormats requests all result columns in text format regardless of Config.DefaultResultFormat. It is used
+// by queries whose results are parsed by pgconn.
+var textResultFormats = []int16{}
+
+// binaryResultFormats requests all result columns in binary format.
+var binaryResultFormats = []int16{BinaryFormatCode}
+
+// resultFormatsOrDefault returns resultFormats or the result formats of Config.DefaultResultFormat if it is nil.
+func (pgConn *PgConn) resultFormatsOrDefault(resultFormats []int16) []int16 {
+	if resultFormats == nil && pgConn.config.DefaultResultFormat == BinaryFormatCode {
+		return binaryResultFormats
+	}
+	return resultFormats
+}
+
 // ExecParams executes a command via the PostgreSQL extended query protocol.
 //
 // sql is a SQL command string. It may only contain one query. Parameter substitution is positional using $1, $2, $3,
@@ -1095,11 +2154,12 @@ func (pgConn *PgConn) ReceiveResults(ctx context.Context) *MultiResultReader {
 // len(paramFormats) is not 0, 1, or len(paramValues).
 //
 // resultFormats is a slice of format codes determining for each result column whether it is encoded in text or
-// binary format. If resultFormats is nil all results will be in text format.
+// binary format. If resultFormats is nil all results will be in the format of Config.DefaultResultFormat, which is text
+// unless configured otherwise.
 //
 // ResultReader must be closed before PgConn can be used again.
 func (pgConn *PgConn) ExecParams(ctx context.Context, sql string, paramValues [][]byte, paramOIDs []uint32, paramFormats []int16, resultFormats []int16) *ResultReader {
-	result := pgConn.execExtendedPrefix(ctx, paramValues)
+	result := pgConn.execExtendedPrefix(ctx, "ExecParams", len(paramValues))
 	if result.closed {
 		return result
 	}
@@ -1115,7 +2175,7 @@ func (pgConn *PgConn) ExecParams(ctx context.Context, sql string, paramValues []
 		return result
 	}
 
-	buf, err = (&pgproto3.Bind{ParameterFormatCodes: paramFormats, Parameters: paramValues, ResultFormatCodes: resultFormats}).Encode(buf)
+	buf, err = (&pgproto3.Bind{ParameterFormatCodes: paramFormats, Parameters: paramValues, ResultFormatCodes: pgConn.resultFormatsOrDefault(resultFormats)}).Encode(buf)
 	if err != nil {
 		result.concludeCommand(nil, err)
 		pgConn.contextWatcher.Unwatch()
@@ -1129,6 +2189,69 @@ func (pgConn *PgConn) ExecParams(ctx context.Context, sql string, paramValues []
 	return result
 }
 
+// ExecParamsText is a convenience wrapper around ExecParams for the common case where all parameters and results are in
+// text format and the server infers the parameter types. A nil element of args is sent as NULL.
+//
+// ResultReader must be closed before PgConn can be used again.
+func (pgConn *PgConn) ExecParamsText(ctx context.Context, sql string, args ...*string) *ResultReader {
+	var paramValues [][]byte
+	if len(args) > 0 {
+		paramValues = make([][]byte, len(args))
+		for i, arg := range args {
+			if arg != nil {
+				paramValues[i] = []byte(*arg)
+			}
+		}
+	}
+
+	return pgConn.ExecParams(ctx, sql, paramValues, nil, nil, textResultFormats)
+}
+
+// ExecRow executes sql like ExecParamsText and returns the values of its only row in text format and the command tag.
+// It is a shortcut for administrative and metadata queries that are known to return a single row. A NULL value is nil.
+//
+// If the result does not have exactly one row an *UnexpectedRowCountError is returned. In particular, a query that
+// found no rows returns an *UnexpectedRowCountError with Rows equal to 0.
+func (pgConn *PgConn) ExecRow(ctx context.Context, sql string, args ...*string) ([][]byte, CommandTag, error) {
+	rr := pgConn.ExecParamsText(ctx, sql, args...)
+
+	var values [][]byte
+	rows := 0
+	for rr.NextRow() {
+		if rows == 0 {
+			values = append([][]byte(nil), rr.Values()...)
+		}
+		rows++
+	}
+
+	commandTag, err := rr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rows != 1 {
+		return nil, commandTag, &UnexpectedRowCountError{Rows: rows}
+	}
+
+	return values, commandTag, nil
+}
+
+// ExecScalar executes sql like ExecRow and returns the single value of its only row. It is a shortcut for queries such
+// as "show server_version" or "select count(*) from t". The value is nil if it is NULL.
+//
+// If the result does not have exactly one row an *UnexpectedRowCountError is returned. If the row does not have exactly
+// one column an *UnexpectedColumnCountError is returned.
+func (pgConn *PgConn) ExecScalar(ctx context.Context, sql string, args ...*string) ([]byte, error) {
+	values, _, err := pgConn.ExecRow(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, &UnexpectedColumnCountError{Columns: len(values)}
+	}
+
+	return values[0], nil
+}
+
 // ExecPrepared enqueues the execution of a prepared statement via the PostgreSQL extended query protocol.
 //
 // paramValues are the parameter values. It must be encoded in the format given by paramFormats.
@@ -1138,18 +2261,27 @@ func (pgConn *PgConn) ExecParams(ctx context.Context, sql string, paramValues []
 // len(paramFormats) is not 0, 1, or len(paramValues).
 //
 // resultFormats is a slice of format codes determining for each result column whether it is encoded in text or
-// binary format. If resultFormats is nil all results will be in text format.
+// binary format. If resultFormats is nil all results will be in the format of Config.DefaultResultFormat, which is text
+// unless configured otherwise.
 //
 // ResultReader must be closed before PgConn can be used again.
 func (pgConn *PgConn) ExecPrepared(ctx context.Context, stmtName string, paramValues [][]byte, paramFormats []int16, resultFormats []int16) *ResultReader {
-	result := pgConn.execExtendedPrefix(ctx, paramValues)
+	result := pgConn.execExtendedPrefix(ctx, "ExecPrepared", len(paramValues))
 	if result.closed {
 		return result
 	}
 
+	if err := pgConn.checkPooler("ExecPrepared"); err != nil {
+		result.concludeCommand(nil, err)
+		pgConn.contextWatcher.Unwatch()
+		result.closed = true
+		pgConn.unlock()
+		return result
+	}
+
 	buf := pgConn.wbuf
 	var err error
-	buf, err = (&pgproto3.Bind{PreparedStatement: stmtName, ParameterFormatCodes: paramFormats, Parameters: paramValues, ResultFormatCodes: resultFormats}).Encode(buf)
+	buf, err = (&pgproto3.Bind{PreparedStatement: stmtName, ParameterFormatCodes: paramFormats, Parameters: paramValues, ResultFormatCodes: pgConn.resultFormatsOrDefault(resultFormats)}).Encode(buf)
 	if err != nil {
 		result.concludeCommand(nil, err)
 		pgConn.contextWatcher.Unwatch()
@@ -1163,27 +2295,34 @@ func (pgConn *PgConn) ExecPrepared(ctx context.Context, stmtName string, paramVa
 	return result
 }
 
-func (pgConn *PgConn) execExtendedPrefix(ctx context.Context, paramValues [][]byte) *ResultReader {
+func (pgConn *PgConn) execExtendedPrefix(ctx context.Context, op string, paramCount int) *ResultReader {
 	pgConn.resultReader = ResultReader{
 		pgConn: pgConn,
 		ctx:    ctx,
 	}
 	result := &pgConn.resultReader
 
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock(op); err != nil {
 		result.concludeCommand(nil, err)
 		result.closed = true
 		return result
 	}
 
-	if len(paramValues) > math.MaxUint16 {
+	if paramCount > math.MaxUint16 {
 		result.concludeCommand(nil, fmt.Errorf("extended protocol limited to %v parameters", math.MaxUint16))
 		result.closed = true
 		pgConn.unlock()
 		return result
 	}
 
-	if ctx != context.Background() {
+	if err := pgConn.checkExtendedProtocol(op); err != nil {
+		result.concludeCommand(nil, err)
+		result.closed = true
+		pgConn.unlock()
+		return result
+	}
+
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			result.concludeCommand(nil, newContextAlreadyDoneError(ctx))
@@ -1225,10 +2364,11 @@ func (pgConn *PgConn) execExtendedSuffix(buf []byte, result *ResultReader) {
 		return
 	}
 
-	n, err := pgConn.conn.Write(buf)
+	n, err := pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
-		result.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
+		result.concludeCommand(nil, err)
 		pgConn.contextWatcher.Unwatch()
 		result.closed = true
 		pgConn.unlock()
@@ -1238,13 +2378,15 @@ func (pgConn *PgConn) execExtendedSuffix(buf []byte, result *ResultReader) {
 	result.readUntilRowDescription()
 }
 
-// CopyTo executes the copy command sql and copies the results to w.
+// CopyTo executes the copy command sql and copies the results to w. Each CopyData message is written to w before the
+// next message is read from the connection, so a slow w slows down the server rather than causing data to be buffered.
+// See CopyToStream to control reading directly.
 func (pgConn *PgConn) CopyTo(ctx context.Context, w io.Writer, sql string) (CommandTag, error) {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("CopyTo"); err != nil {
 		return nil, err
 	}
 
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			pgConn.unlock()
@@ -1264,11 +2406,12 @@ func (pgConn *PgConn) CopyTo(ctx context.Context, w io.Writer, sql string) (Comm
 		return nil, err
 	}
 
-	n, err := pgConn.conn.Write(buf)
+	n, err := pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
 		pgConn.unlock()
-		return nil, &writeError{err: err, safeToRetry: n == 0}
+		return nil, err
 	}
 
 	// Read results
@@ -1277,7 +2420,7 @@ func (pgConn *PgConn) CopyTo(ctx context.Context, w io.Writer, sql string) (Comm
 	for {
 		msg, err := pgConn.receiveMessage()
 		if err != nil {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 			return nil, preferContextOverNetTimeoutError(ctx, err)
 		}
 
@@ -1286,7 +2429,7 @@ func (pgConn *PgConn) CopyTo(ctx context.Context, w io.Writer, sql string) (Comm
 		case *pgproto3.CopyData:
 			_, err := w.Write(msg.Data)
 			if err != nil {
-				pgConn.asyncClose()
+				pgConn.asyncClose(err)
 				return nil, err
 			}
 		case *pgproto3.ReadyForQuery:
@@ -1300,17 +2443,148 @@ func (pgConn *PgConn) CopyTo(ctx context.Context, w io.Writer, sql string) (Comm
 	}
 }
 
+// CopyToReader is a reader for the data of a copy to command started with PgConn.CopyToStream. Data is only read from
+// the connection when Read is called. A caller can pause the copy by not calling Read and resume it by calling Read
+// again. While the copy is paused, the server stops sending once the socket buffers are full. The connection is busy
+// until Read returns an error or Close is called.
+type CopyToReader struct {
+	pgConn *PgConn
+	ctx    context.Context
+
+	data       []byte // unread data of the current CopyData message
+	commandTag CommandTag
+	closed     bool
+	err        error
+}
+
+// CopyToStream executes the copy command sql and returns a reader for the data copied from the PostgreSQL server. It
+// is an alternative to CopyTo for callers that implement their own flow control. Close must be called when done
+// reading.
+func (pgConn *PgConn) CopyToStream(ctx context.Context, sql string) *CopyToReader {
+	cr := &CopyToReader{pgConn: pgConn, ctx: ctx}
+
+	if err := pgConn.lock("CopyToStream"); err != nil {
+		cr.closed = true
+		cr.err = err
+		return cr
+	}
+
+	if pgConn.watchRequired(ctx) {
+		select {
+		case <-ctx.Done():
+			cr.closed = true
+			cr.err = newContextAlreadyDoneError(ctx)
+			pgConn.unlock()
+			return cr
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+	}
+
+	buf, err := (&pgproto3.Query{String: sql}).Encode(pgConn.wbuf)
+	if err != nil {
+		cr.closed = true
+		cr.err = err
+		pgConn.contextWatcher.Unwatch()
+		pgConn.unlock()
+		return cr
+	}
+
+	n, err := pgConn.write(buf)
+	if err != nil {
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
+		cr.closed = true
+		cr.err = err
+		pgConn.contextWatcher.Unwatch()
+		pgConn.unlock()
+		return cr
+	}
+
+	return cr
+}
+
+// Read reads copied data into p. It returns io.EOF after all data has been read and the command completed
+// successfully. If the command failed the error is returned instead.
+func (cr *CopyToReader) Read(p []byte) (int, error) {
+	for len(cr.data) == 0 {
+		if cr.closed {
+			if cr.err != nil {
+				return 0, cr.err
+			}
+			return 0, io.EOF
+		}
+		cr.receiveMessage()
+	}
+
+	n := copy(p, cr.data)
+	cr.data = cr.data[n:]
+	return n, nil
+}
+
+// Close discards any unread data and waits for the command to complete. It returns the command tag or the error of the
+// command.
+func (cr *CopyToReader) Close() (CommandTag, error) {
+	cr.data = nil
+	for !cr.closed {
+		cr.receiveMessage()
+		cr.data = nil
+	}
+
+	return cr.commandTag, cr.err
+}
+
+// receiveMessage receives the next message of the command. It does not return an error as any error will be stored in
+// the CopyToReader.
+func (cr *CopyToReader) receiveMessage() {
+	msg, err := cr.pgConn.receiveMessage()
+	if err != nil {
+		cr.pgConn.asyncClose(err)
+		cr.closed = true
+		cr.err = preferContextOverNetTimeoutError(cr.ctx, err)
+		cr.commandTag = nil
+		cr.pgConn.contextWatcher.Unwatch()
+		return
+	}
+
+	switch msg := msg.(type) {
+	case *pgproto3.CopyData:
+		// msg.Data is only valid until the next message is received. That only happens after all of it has been read.
+		cr.data = msg.Data
+	case *pgproto3.CommandComplete:
+		cr.commandTag = CommandTag(msg.CommandTag)
+	case *pgproto3.ErrorResponse:
+		if cr.err == nil {
+			cr.err = ErrorResponseToPgError(msg)
+		}
+	case *pgproto3.ReadyForQuery:
+		cr.closed = true
+		cr.pgConn.contextWatcher.Unwatch()
+		cr.pgConn.unlock()
+	}
+}
+
 // CopyFrom executes the copy command sql and copies all of r to the PostgreSQL server.
 //
-// Note: context cancellation will only interrupt operations on the underlying PostgreSQL network connection. Reads on r
-// could still block.
+// The data returned by each Read of r is sent right away in a CopyData message of up to 64 KiB. Set
+// Config.CopyFromMaxBufferDelay to coalesce the data of small Reads into fewer messages.
+//
+// r is read from a separate goroutine. If ctx is canceled or the server reports an error while a Read of r is blocked,
+// the Read is abandoned and the copy is aborted without waiting for it. If r has a SetReadDeadline method, such as a
+// net.Conn, a deadline in the past is set when ctx is canceled to interrupt the Read. Otherwise the goroutine remains
+// blocked until the Read returns, but nothing read after CopyFrom has returned is sent. The connection is then either
+// usable, if the server acknowledged the aborted copy, or closed.
+//
+// Messages are received while the data is sent. Notices, notifications, and parameter statuses that the server sends
+// during the copy, e.g. from a trigger or a NOTIFY in another session, are dispatched to OnNotice, OnNotification, and
+// OnParameterStatus as they arrive rather than when the copy ends, even while a Read of r is blocked.
 func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (CommandTag, error) {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("CopyFrom"); err != nil {
 		return nil, err
 	}
 	defer pgConn.unlock()
 
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			return nil, newContextAlreadyDoneError(ctx)
@@ -1329,22 +2603,31 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 		return nil, err
 	}
 
-	n, err := pgConn.conn.Write(buf)
+	n, err := pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
-		return nil, &writeError{err: err, safeToRetry: n == 0}
+		err = pgConn.newWriteError(err, n)
+		pgConn.asyncClose(err)
+		return nil, err
 	}
 
 	// Send copy data
 	abortCopyChan := make(chan struct{})
 	copyErrChan := make(chan error, 1)
 	signalMessageChan := pgConn.signalMessage()
-	var wg sync.WaitGroup
-	wg.Add(1)
+
+	sender := &copyDataSender{pgConn: pgConn}
 
 	go func() {
-		defer wg.Done()
-		buf := make([]byte, 0, 65536)
+		if delay := pgConn.config.CopyFromMaxBufferDelay; delay > 0 {
+			if err := sender.copyWithDelay(r, pgConn.config.clock(), delay, abortCopyChan); err != nil {
+				copyErrChan <- err
+			}
+			return
+		}
+
+		// Source data is read directly into buf after room for the CopyData header, so the data of each Read is sent as
+		// a CopyData message without being copied.
+		buf := make([]byte, 0, copyDataBufferLen)
 		buf = append(buf, 'd')
 		sp := len(buf)
 
@@ -1354,11 +2637,11 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 				buf = buf[0 : n+5]
 				pgio.SetInt32(buf[sp:], int32(n+4))
 
-				_, writeErr := pgConn.conn.Write(buf)
+				written, writeErr := sender.write(buf)
+				if !written {
+					return
+				}
 				if writeErr != nil {
-					// Write errors are always fatal, but we can't use asyncClose because we are in a different goroutine.
-					pgConn.conn.Close()
-
 					copyErrChan <- writeErr
 					return
 				}
@@ -1381,10 +2664,15 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 	for copyErr == nil && pgErr == nil {
 		select {
 		case copyErr = <-copyErrChan:
+		case <-ctx.Done():
+			copyErr = ctx.Err()
+			if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok {
+				d.SetReadDeadline(pgConn.config.clock().Now())
+			}
 		case <-signalMessageChan:
 			msg, err := pgConn.receiveMessage()
 			if err != nil {
-				pgConn.asyncClose()
+				pgConn.asyncClose(err)
 				return nil, preferContextOverNetTimeoutError(ctx, err)
 			}
 
@@ -1397,8 +2685,8 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 		}
 	}
 	close(abortCopyChan)
-	// Make sure io goroutine finishes before writing.
-	wg.Wait()
+	// Make sure io goroutine does not write anymore. It may still be blocked reading r.
+	sender.abandon()
 
 	buf = buf[:0]
 	if copyErr == io.EOF || pgErr != nil {
@@ -1406,7 +2694,7 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 		var err error
 		buf, err = copyDone.Encode(buf)
 		if err != nil {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 			return nil, err
 		}
 	} else {
@@ -1414,14 +2702,14 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 		var err error
 		buf, err = copyFail.Encode(buf)
 		if err != nil {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 			return nil, err
 		}
 	}
-	_, err = pgConn.conn.Write(buf)
+	_, err = pgConn.write(buf)
 	if err != nil {
-		pgConn.asyncClose()
-		return nil, err
+		pgConn.asyncClose(err)
+		return nil, preferContextOverNetTimeoutError(ctx, err)
 	}
 
 	// Read results
@@ -1429,7 +2717,7 @@ func (pgConn *PgConn) CopyFrom(ctx context.Context, r io.Reader, sql string) (Co
 	for {
 		msg, err := pgConn.receiveMessage()
 		if err != nil {
-			pgConn.asyncClose()
+			pgConn.asyncClose(err)
 			return nil, preferContextOverNetTimeoutError(ctx, err)
 		}
 
@@ -1451,6 +2739,14 @@ type MultiResultReader struct {
 
 	rr *ResultReader
 
+	emptyQuery bool
+
+	// continueOnError is set by ExecBatchContinueOnError. Errors of individual queries are then reported by their
+	// ResultReader and the first of them is kept in queryErr instead of err so reading continues with the next query.
+	continueOnError bool
+	pendingSyncs    int // number of ReadyForQuery messages still expected when continueOnError is set
+	queryErr        error
+
 	closed bool
 	err    error
 }
@@ -1467,6 +2763,64 @@ func (mrr *MultiResultReader) ReadAll() ([]*Result, error) {
 	return results, err
 }
 
+// ReadAllLimit limits the amount of data buffered by ReadAllWithLimit. A zero value means no limit.
+type ReadAllLimit struct {
+	MaxRows  int // maximum number of rows buffered across all results
+	MaxBytes int // maximum total size in bytes of the row values buffered across all results
+}
+
+// ReadAllWithLimit reads all available results like ReadAll but stops buffering rows once limit would be exceeded. The
+// remaining results are read and discarded so the connection remains usable. The results buffered until then are
+// returned with a *ResultTruncatedError. Any other error takes precedence over the *ResultTruncatedError. Calling
+// ReadAllWithLimit is mutually exclusive with all other MultiResultReader methods.
+func (mrr *MultiResultReader) ReadAllWithLimit(limit ReadAllLimit) ([]*Result, error) {
+	var results []*Result
+	var rows, bytes int
+	truncated := false
+
+	for mrr.NextResult() {
+		rr := mrr.ResultReader()
+		if truncated {
+			rr.Close()
+			continue
+		}
+
+		br := &Result{}
+		for rr.NextRow() {
+			rowBytes := 0
+			for _, v := range rr.Values() {
+				rowBytes += len(v)
+			}
+			if (limit.MaxRows > 0 && rows+1 > limit.MaxRows) || (limit.MaxBytes > 0 && bytes+rowBytes > limit.MaxBytes) {
+				truncated = true
+				break
+			}
+
+			if br.FieldDescriptions == nil {
+				br.FieldDescriptions = make([]pgproto3.FieldDescription, len(rr.FieldDescriptions()))
+				copy(br.FieldDescriptions, rr.FieldDescriptions())
+			}
+
+			row := make([][]byte, len(rr.Values()))
+			copy(row, rr.Values())
+			br.Rows = append(br.Rows, row)
+			rows++
+			bytes += rowBytes
+		}
+
+		br.CommandTag, br.Err = rr.Close()
+		br.EmptyQuery = rr.emptyQuery
+		results = append(results, br)
+	}
+	err := mrr.Close()
+
+	if err == nil && truncated {
+		err = &ResultTruncatedError{Rows: rows, Bytes: bytes}
+	}
+
+	return results, err
+}
+
 func (mrr *MultiResultReader) receiveMessage() (pgproto3.BackendMessage, error) {
 	msg, err := mrr.pgConn.receiveMessage()
 
@@ -1474,17 +2828,29 @@ func (mrr *MultiResultReader) receiveMessage() (pgproto3.BackendMessage, error)
 		mrr.pgConn.contextWatcher.Unwatch()
 		mrr.err = preferContextOverNetTimeoutError(mrr.ctx, err)
 		mrr.closed = true
-		mrr.pgConn.asyncClose()
+		mrr.pgConn.asyncClose(mrr.err)
 		return nil, mrr.err
 	}
 
 	switch msg := msg.(type) {
 	case *pgproto3.ReadyForQuery:
+		if mrr.pendingSyncs > 1 {
+			mrr.pendingSyncs--
+			break
+		}
 		mrr.pgConn.contextWatcher.Unwatch()
 		mrr.closed = true
 		mrr.pgConn.unlock()
+	case *pgproto3.EmptyQueryResponse:
+		mrr.emptyQuery = true
 	case *pgproto3.ErrorResponse:
-		mrr.err = ErrorResponseToPgError(msg)
+		if mrr.continueOnError {
+			if mrr.queryErr == nil {
+				mrr.queryErr = ErrorResponseToPgError(msg)
+			}
+		} else {
+			mrr.err = ErrorResponseToPgError(msg)
+		}
 	}
 
 	return msg, nil
@@ -1518,6 +2884,18 @@ func (mrr *MultiResultReader) NextResult() bool {
 			return true
 		case *pgproto3.EmptyQueryResponse:
 			return false
+		case *pgproto3.ErrorResponse:
+			// Without continueOnError mrr.err is set and the loop ends. Otherwise the query failed before producing a
+			// result.
+			if mrr.continueOnError {
+				mrr.pgConn.resultReader = ResultReader{
+					commandConcluded: true,
+					closed:           true,
+					err:              ErrorResponseToPgError(msg),
+				}
+				mrr.rr = &mrr.pgConn.resultReader
+				return true
+			}
 		}
 	}
 
@@ -1529,6 +2907,40 @@ func (mrr *MultiResultReader) ResultReader() *ResultReader {
 	return mrr.rr
 }
 
+// SetContext replaces the context of mrr for the remaining reads, including the rest of the current result. The
+// previous context is no longer watched. This lets each statement of a multi-statement Exec or each query of a batch
+// have its own timeout, so a slow statement can be limited without the timeout of earlier fast statements applying to
+// it. It has no effect once mrr is closed.
+//
+//	mrr := pgConn.Exec(ctx, "select 1; select slow()")
+//	mrr.NextResult()
+//	// read the result of select 1
+//	slowCtx, cancel := context.WithTimeout(ctx, time.Minute)
+//	defer cancel()
+//	mrr.SetContext(slowCtx)
+//	mrr.NextResult()
+func (mrr *MultiResultReader) SetContext(ctx context.Context) {
+	if mrr.closed {
+		return
+	}
+
+	mrr.pgConn.contextWatcher.Unwatch()
+	mrr.ctx = ctx
+	if mrr.rr != nil && !mrr.rr.closed {
+		mrr.rr.ctx = ctx
+	}
+	if mrr.pgConn.watchRequired(ctx) {
+		mrr.pgConn.contextWatcher.Watch(ctx)
+	}
+}
+
+// EmptyQuery returns true if the server responded with EmptyQueryResponse because the SQL was empty or only contained
+// whitespace or comments. An empty query does not produce a result so NextResult returns false. This distinguishes it
+// from a query that produced no rows.
+func (mrr *MultiResultReader) EmptyQuery() bool {
+	return mrr.emptyQuery
+}
+
 // Close closes the MultiResultReader and returns the first error that occurred during the MultiResultReader's use.
 func (mrr *MultiResultReader) Close() error {
 	for !mrr.closed {
@@ -1538,6 +2950,9 @@ func (mrr *MultiResultReader) Close() error {
 		}
 	}
 
+	if mrr.err == nil {
+		return mrr.queryErr
+	}
 	return mrr.err
 }
 
@@ -1549,8 +2964,10 @@ type ResultReader struct {
 
 	fieldDescriptions []pgproto3.FieldDescription
 	rowValues         [][]byte
+	rawRow            []byte
 	commandTag        CommandTag
 	commandConcluded  bool
+	emptyQuery        bool
 	closed            bool
 	err               error
 }
@@ -1560,29 +2977,55 @@ type Result struct {
 	FieldDescriptions []pgproto3.FieldDescription
 	Rows              [][][]byte
 	CommandTag        CommandTag
+	EmptyQuery        bool // true if the server responded with EmptyQueryResponse
 	Err               error
 }
 
-// Read saves the query response to a Result.
+// Read saves the query response to a Result. The Result may be returned with ReleaseResult when it is no longer needed.
 func (rr *ResultReader) Read() *Result {
-	br := &Result{}
+	br := resultPool.Get().(*Result)
+
+	// Reuse the backing arrays of a Result that was returned with ReleaseResult.
+	fieldDescriptions := br.FieldDescriptions[:0]
+	rows := br.Rows[:0]
+	br.FieldDescriptions = nil
+	br.Rows = nil
 
 	for rr.NextRow() {
 		if br.FieldDescriptions == nil {
-			br.FieldDescriptions = make([]pgproto3.FieldDescription, len(rr.FieldDescriptions()))
-			copy(br.FieldDescriptions, rr.FieldDescriptions())
+			br.FieldDescriptions = append(fieldDescriptions, rr.FieldDescriptions()...)
 		}
 
-		row := make([][]byte, len(rr.Values()))
-		copy(row, rr.Values())
-		br.Rows = append(br.Rows, row)
+		var row [][]byte
+		if len(rows) < cap(rows) {
+			row = rows[:len(rows)+1][len(rows)]
+		}
+		row = append(row[:0], rr.Values()...)
+		rows = append(rows, row)
+		br.Rows = rows
 	}
 
 	br.CommandTag, br.Err = rr.Close()
+	br.EmptyQuery = rr.emptyQuery
 
 	return br
 }
 
+var resultPool = sync.Pool{New: func() interface{} { return &Result{} }}
+
+// ReleaseResult returns result to a pool so its memory can be reused by a later ResultReader.Read or
+// MultiResultReader.ReadAll. Tight loops that read many small results can use it to avoid allocating a Result and its
+// slices for each query. result and anything obtained from it, in particular its FieldDescriptions and Rows slices,
+// must not be used after calling ReleaseResult. Releasing a Result is optional.
+func ReleaseResult(result *Result) {
+	if result == nil {
+		return
+	}
+
+	*result = Result{FieldDescriptions: result.FieldDescriptions[:0], Rows: result.Rows[:0]}
+	resultPool.Put(result)
+}
+
 // NextRow advances the ResultReader to the next row and returns true if a row is available.
 func (rr *ResultReader) NextRow() bool {
 	for !rr.commandConcluded {
@@ -1594,6 +3037,7 @@ func (rr *ResultReader) NextRow() bool {
 		switch msg := msg.(type) {
 		case *pgproto3.DataRow:
 			rr.rowValues = msg.Values
+			rr.rawRow = rr.pgConn.receivedMessageBody()
 			return true
 		}
 	}
@@ -1614,6 +3058,31 @@ func (rr *ResultReader) Values() [][]byte {
 	return rr.rowValues
 }
 
+// EmptyQuery returns true if the server responded with EmptyQueryResponse because the SQL was empty or only contained
+// whitespace or comments. This distinguishes it from a query that produced no rows. It is only meaningful once the
+// command has concluded (e.g. NextRow has returned false or Close has been called).
+func (rr *ResultReader) EmptyQuery() bool {
+	return rr.emptyQuery
+}
+
+// RawRow returns the current row in the wire format of a DataRow message body: a 16-bit field count followed by each
+// value as a 32-bit length (-1 for NULL) and the value bytes. This allows rows to be decoded by other means or forwarded
+// verbatim. It is the body as received from the server, not a re-encoding of Values. NextRow must have been previously
+// been called. The returned []byte is only valid until the next NextRow call or the ResultReader is closed. RawRow
+// returns nil if the Frontend was not built by the default Config.BuildFrontend.
+func (rr *ResultReader) RawRow() []byte {
+	return rr.rawRow
+}
+
+// receivedMessageBody returns the undecoded body of the message last received by the Frontend. The body is owned by the
+// message, e.g. the Values of a DataRow are slices of it. It returns nil if pgConn does not use the default Frontend.
+func (pgConn *PgConn) receivedMessageBody() []byte {
+	if pgConn.statsReader == nil || pgConn.statsReader.chunkReader == nil {
+		return nil
+	}
+	return pgConn.statsReader.chunkReader.body
+}
+
 // Close consumes any remaining result data and returns the command tag or
 // error.
 func (rr *ResultReader) Close() (CommandTag, error) {
@@ -1684,7 +3153,7 @@ func (rr *ResultReader) receiveMessage() (msg pgproto3.BackendMessage, err error
 		rr.pgConn.contextWatcher.Unwatch()
 		rr.closed = true
 		if rr.multiResultReader == nil {
-			rr.pgConn.asyncClose()
+			rr.pgConn.asyncClose(err)
 		}
 
 		return nil, rr.err
@@ -1696,6 +3165,7 @@ func (rr *ResultReader) receiveMessage() (msg pgproto3.BackendMessage, err error
 	case *pgproto3.CommandComplete:
 		rr.concludeCommand(CommandTag(msg.CommandTag), nil)
 	case *pgproto3.EmptyQueryResponse:
+		rr.emptyQuery = true
 		rr.concludeCommand(nil, nil)
 	case *pgproto3.ErrorResponse:
 		rr.concludeCommand(nil, ErrorResponseToPgError(msg))
@@ -1717,13 +3187,39 @@ func (rr *ResultReader) concludeCommand(commandTag CommandTag, err error) {
 
 	rr.commandTag = commandTag
 	rr.rowValues = nil
+	rr.rawRow = nil
 	rr.commandConcluded = true
 }
 
-// Batch is a collection of queries that can be sent to the PostgreSQL server in a single round-trip.
+// Batch is a collection of queries that can be sent to the PostgreSQL server in a single round-trip. A Batch may be
+// executed multiple times. It can be reused for different queries by calling Reset, which keeps the allocated buffer.
 type Batch struct {
-	buf []byte
-	err error
+	buf       []byte
+	queryEnds []int // offset in buf of the end of each query
+	err       error
+
+	defaultFormatBinds []int // offset in buf of each Bind message without result formats
+	prepared           bool  // ExecPrepared was called, which is not supported behind a transaction pooler
+
+	writing sync.WaitGroup // writes of buf by execBatch that have not finished
+}
+
+// Len returns the number of queries in the batch.
+func (batch *Batch) Len() int {
+	return len(batch.queryEnds)
+}
+
+// Reset removes all queries and any error from the batch so it can be reused. The memory allocated for the queries is
+// retained. Reset waits until the batch has been completely sent by any previous ExecBatch call. It should be called
+// only after the MultiResultReader returned by ExecBatch has been closed. Otherwise it may wait until the connection
+// fails.
+func (batch *Batch) Reset() {
+	batch.writing.Wait()
+	batch.buf = batch.buf[:0]
+	batch.queryEnds = batch.queryEnds[:0]
+	batch.defaultFormatBinds = batch.defaultFormatBinds[:0]
+	batch.prepared = false
+	batch.err = nil
 }
 
 // ExecParams appends an ExecParams command to the batch. See PgConn.ExecParams for parameter descriptions.
@@ -1731,12 +3227,13 @@ func (batch *Batch) ExecParams(sql string, paramValues [][]byte, paramOIDs []uin
 	if batch.err != nil {
 		return
 	}
+	batch.writing.Wait()
 
 	batch.buf, batch.err = (&pgproto3.Parse{Query: sql, ParameterOIDs: paramOIDs}).Encode(batch.buf)
 	if batch.err != nil {
 		return
 	}
-	batch.ExecPrepared("", paramValues, paramFormats, resultFormats)
+	batch.execPrepared("", paramValues, paramFormats, resultFormats)
 }
 
 // ExecPrepared appends an ExecPrepared e command to the batch. See PgConn.ExecPrepared for parameter descriptions.
@@ -1744,11 +3241,22 @@ func (batch *Batch) ExecPrepared(stmtName string, paramValues [][]byte, paramFor
 	if batch.err != nil {
 		return
 	}
+	batch.writing.Wait()
+
+	batch.prepared = true
+	batch.execPrepared(stmtName, paramValues, paramFormats, resultFormats)
+}
 
+// execPrepared appends the messages that bind and execute stmtName to the batch.
+func (batch *Batch) execPrepared(stmtName string, paramValues [][]byte, paramFormats []int16, resultFormats []int16) {
+	bindStart := len(batch.buf)
 	batch.buf, batch.err = (&pgproto3.Bind{PreparedStatement: stmtName, ParameterFormatCodes: paramFormats, Parameters: paramValues, ResultFormatCodes: resultFormats}).Encode(batch.buf)
 	if batch.err != nil {
 		return
 	}
+	if resultFormats == nil {
+		batch.defaultFormatBinds = append(batch.defaultFormatBinds, bindStart)
+	}
 
 	batch.buf, batch.err = (&pgproto3.Describe{ObjectType: 'P'}).Encode(batch.buf)
 	if batch.err != nil {
@@ -1759,11 +3267,50 @@ func (batch *Batch) ExecPrepared(stmtName string, paramValues [][]byte, paramFor
 	if batch.err != nil {
 		return
 	}
+
+	batch.queryEnds = append(batch.queryEnds, len(batch.buf))
 }
 
 // ExecBatch executes all the queries in batch in a single round-trip. Execution is implicitly transactional unless a
 // transaction is already in progress or SQL contains transaction control statements.
 func (pgConn *PgConn) ExecBatch(ctx context.Context, batch *Batch) *MultiResultReader {
+	return pgConn.execBatch(ctx, "ExecBatch", batch, 0)
+}
+
+// ExecBatchContinueOnError executes all the queries in batch in a single round-trip like ExecBatch, but a Sync is sent
+// after each query so the server continues executing the remaining queries after one fails. Each query is executed in
+// its own implicit transaction unless a transaction is already in progress, in which case an error aborts the
+// transaction and the remaining queries fail too.
+//
+// Every query produces a result. A query that fails returns its error from the ResultReader (or in Result.Err from
+// ReadAll) and reading continues with the next query. Close returns the first query error unless a more serious error,
+// such as a network failure, occurs.
+func (pgConn *PgConn) ExecBatchContinueOnError(ctx context.Context, batch *Batch) *MultiResultReader {
+	return pgConn.execBatch(ctx, "ExecBatchContinueOnError", batch, 1)
+}
+
+// ExecPreparedMany executes the prepared statement stmtName once for each element of paramSets in a single round-trip.
+// It is equivalent to calling Batch.ExecPrepared for each parameter set and executing the batch, but it is more
+// convenient for bulk inserts and upserts. See PgConn.ExecPrepared for the other parameter descriptions. They apply
+// to all parameter sets. Each parameter set produces a result in the same order as paramSets.
+//
+// syncInterval is the number of parameter sets after which a Sync is sent. If it is 0 a single Sync is sent at the end
+// and the executions behave like ExecBatch: they are implicitly transactional and an error stops the remaining
+// executions. Otherwise each group of syncInterval parameter sets is executed like a query of
+// ExecBatchContinueOnError: a failing execution returns its error from its ResultReader, the remaining parameter sets
+// of its group are skipped without a result, and reading continues with the next group. Use a syncInterval of 1 if
+// every parameter set must produce a result.
+func (pgConn *PgConn) ExecPreparedMany(ctx context.Context, stmtName string, paramSets [][][]byte, paramFormats []int16, resultFormats []int16, syncInterval int) *MultiResultReader {
+	batch := &Batch{}
+	for _, paramValues := range paramSets {
+		batch.ExecPrepared(stmtName, paramValues, paramFormats, resultFormats)
+	}
+	return pgConn.execBatch(ctx, "ExecPreparedMany", batch, syncInterval)
+}
+
+// execBatch executes batch for the method op with a Sync after every syncInterval queries. If syncInterval is 0 there
+// is only a single Sync at the end.
+func (pgConn *PgConn) execBatch(ctx context.Context, op string, batch *Batch, syncInterval int) *MultiResultReader {
 	if batch.err != nil {
 		return &MultiResultReader{
 			closed: true,
@@ -1771,20 +3318,39 @@ func (pgConn *PgConn) ExecBatch(ctx context.Context, batch *Batch) *MultiResultR
 		}
 	}
 
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock(op); err != nil {
+		return &MultiResultReader{
+			closed: true,
+			err:    err,
+		}
+	}
+
+	if err := pgConn.checkExtendedProtocol(op); err != nil {
+		pgConn.unlock()
 		return &MultiResultReader{
 			closed: true,
 			err:    err,
 		}
 	}
 
+	if batch.prepared {
+		if err := pgConn.checkPooler(op + " with ExecPrepared"); err != nil {
+			pgConn.unlock()
+			return &MultiResultReader{
+				closed: true,
+				err:    err,
+			}
+		}
+	}
+
 	pgConn.multiResultReader = MultiResultReader{
-		pgConn: pgConn,
-		ctx:    ctx,
+		pgConn:          pgConn,
+		ctx:             ctx,
+		continueOnError: syncInterval > 0,
 	}
 	multiResult := &pgConn.multiResultReader
 
-	if ctx != context.Background() {
+	if pgConn.watchRequired(ctx) {
 		select {
 		case <-ctx.Done():
 			multiResult.closed = true
@@ -1796,7 +3362,27 @@ func (pgConn *PgConn) ExecBatch(ctx context.Context, batch *Batch) *MultiResultR
 		pgConn.contextWatcher.Watch(ctx)
 	}
 
-	batch.buf, batch.err = (&pgproto3.Sync{}).Encode(batch.buf)
+	queries, queryEnds := batch.buf, batch.queryEnds
+	if pgConn.config.DefaultResultFormat == BinaryFormatCode && len(batch.defaultFormatBinds) > 0 {
+		queries, queryEnds = batch.withBinaryResultFormats()
+	}
+
+	buf := queries
+	if syncInterval > 0 && len(queryEnds) > syncInterval {
+		syncs := (len(queryEnds) + syncInterval - 1) / syncInterval
+		buf = make([]byte, 0, len(queries)+syncs*5)
+		start := 0
+		for i := syncInterval - 1; i < len(queryEnds)-1; i += syncInterval {
+			end := queryEnds[i]
+			buf = append(buf, queries[start:end]...)
+			buf, _ = (&pgproto3.Sync{}).Encode(buf)
+			start = end
+		}
+		buf = append(buf, queries[start:]...)
+		multiResult.pendingSyncs = syncs
+	}
+
+	buf, batch.err = (&pgproto3.Sync{}).Encode(buf)
 	if batch.err != nil {
 		multiResult.closed = true
 		multiResult.err = batch.err
@@ -1810,8 +3396,12 @@ func (pgConn *PgConn) ExecBatch(ctx context.Context, batch *Batch) *MultiResultR
 	// The error the code reading the batch results receives will be a closed connection error.
 	//
 	// See https://github.com/jackc/pgx/issues/374.
+	//
+	// buf may share memory with batch.buf. Changes to batch wait for the write to finish.
+	batch.writing.Add(1)
 	go func() {
-		_, err := pgConn.conn.Write(batch.buf)
+		defer batch.writing.Done()
+		_, err := pgConn.write(buf)
 		if err != nil {
 			pgConn.conn.Close()
 		}
@@ -1820,21 +3410,86 @@ func (pgConn *PgConn) ExecBatch(ctx context.Context, batch *Batch) *MultiResultR
 	return multiResult
 }
 
+// withBinaryResultFormats returns a copy of the queries of batch in which the Bind messages without result formats
+// request all results in binary format and the offset of the end of each query in the copy.
+func (batch *Batch) withBinaryResultFormats() ([]byte, []int) {
+	buf := make([]byte, 0, len(batch.buf)+2*len(batch.defaultFormatBinds))
+	queryEnds := make([]int, 0, len(batch.queryEnds))
+
+	start := 0
+	q := 0
+	for _, bindStart := range batch.defaultFormatBinds {
+		for ; batch.queryEnds[q] <= bindStart; q++ {
+			queryEnds = append(queryEnds, batch.queryEnds[q]+len(buf)-start)
+		}
+
+		// The Bind message ends with a zero result format code count that is replaced with a single binary format code.
+		bindEnd := bindStart + 1 + int(binary.BigEndian.Uint32(batch.buf[bindStart+1:]))
+		buf = append(buf, batch.buf[start:bindStart]...)
+		buf = append(buf, 'B')
+		buf = pgio.AppendInt32(buf, int32(bindEnd-bindStart-1+2))
+		buf = append(buf, batch.buf[bindStart+5:bindEnd-2]...)
+		buf = pgio.AppendUint16(buf, 1)
+		buf = pgio.AppendInt16(buf, BinaryFormatCode)
+		start = bindEnd
+	}
+	for ; q < len(batch.queryEnds); q++ {
+		queryEnds = append(queryEnds, batch.queryEnds[q]+len(buf)-start)
+	}
+	buf = append(buf, batch.buf[start:]...)
+
+	return buf, queryEnds
+}
+
+// ExecStatements splits sql into individual statements and executes each of them via the extended protocol in a
+// single round-trip. This allows SQL containing multiple statements to use parameters which Exec does not support and
+// ExecParams does not allow. Each statement produces one result.
+//
+// Statements are split at semicolons outside of string literals, quoted identifiers, dollar-quoted strings, comments,
+// and parentheses. Statements that contain only comments are skipped. SQL-standard function bodies (BEGIN ATOMIC ...
+// END) are not supported.
+//
+// paramValues are the parameter values for the placeholders in all of sql. e.g. In "insert into t values($1); select
+// $2" the second statement is executed with only the second parameter value. All parameters are in the text format
+// and have their data types inferred by the server. A nil element is sent as NULL.
+//
+// Execution is implicitly transactional unless a transaction is already in progress or SQL contains transaction control
+// statements. An error stops execution of the remaining statements.
+func (pgConn *PgConn) ExecStatements(ctx context.Context, sql string, paramValues ...[]byte) *MultiResultReader {
+	batch := &Batch{}
+	for _, statement := range sqlsplit.Split(sql) {
+		var statementParamValues [][]byte
+		for _, n := range statement.Params {
+			if n > len(paramValues) {
+				return &MultiResultReader{
+					closed: true,
+					err:    fmt.Errorf("sql references parameter $%d but only %d parameter values were provided", n, len(paramValues)),
+				}
+			}
+			statementParamValues = append(statementParamValues, paramValues[n-1])
+		}
+		batch.ExecParams(statement.SQL, statementParamValues, nil, nil, nil)
+	}
+
+	return pgConn.ExecBatch(ctx, batch)
+}
+
 // EscapeString escapes a string such that it can safely be interpolated into a SQL command string. It does not include
-// the surrounding single quotes.
+// the surrounding single quotes. Backslashes are also escaped if standard_conforming_strings is off. See EscapeLiteral
+// and EscapeIdentifier to quote literals and identifiers.
 //
-// The current implementation requires that standard_conforming_strings=on and client_encoding="UTF8". If these
-// conditions are not met an error will be returned. It is possible these restrictions will be lifted in the future.
+// No round trip to the server is required. The escaping is derived from the standard_conforming_strings and
+// client_encoding parameter statuses. An error is returned if client_encoding is one whose multibyte characters may
+// contain ASCII bytes (e.g. SJIS or BIG5) or s contains a zero byte or is not valid UTF-8 with client_encoding=UTF8.
 func (pgConn *PgConn) EscapeString(s string) (string, error) {
-	if pgConn.ParameterStatus("standard_conforming_strings") != "on" {
-		return "", errors.New("EscapeString must be run with standard_conforming_strings=on")
+	if err := pgConn.checkEscapable("EscapeString", s); err != nil {
+		return "", err
 	}
 
-	if pgConn.ParameterStatus("client_encoding") != "UTF8" {
-		return "", errors.New("EscapeString must be run with client_encoding=UTF8")
+	if !pgConn.StandardConformingStrings() {
+		return quoteBackslashEscaper.Replace(s), nil
 	}
-
-	return strings.Replace(s, "'", "''", -1), nil
+	return quoteEscaper.Replace(s), nil
 }
 
 // HijackedConn is the result of hijacking a connection.
@@ -1845,10 +3500,13 @@ type HijackedConn struct {
 	Conn              net.Conn          // the underlying TCP or unix domain socket connection
 	PID               uint32            // backend pid
 	SecretKey         uint32            // key to use to send a cancel query message to the server
+	CancelKey         []byte            // key that is not 4 bytes long, e.g. with protocol 3.2, or nil to use SecretKey
+	ProtocolVersion   ProtocolVersion   // negotiated protocol version
 	ParameterStatuses map[string]string // parameters that have been reported by the server
 	TxStatus          byte
 	Frontend          Frontend
 	Config            *Config
+	Pooler            *Pooler // transaction pooler the connection adapts to or nil
 }
 
 // Hijack extracts the internal connection data. pgConn must be in an idle state. pgConn is unusable after hijacking.
@@ -1858,19 +3516,25 @@ type HijackedConn struct {
 // Due to the necessary exposure of internal implementation details, it is not covered by the semantic versioning
 // compatibility.
 func (pgConn *PgConn) Hijack() (*HijackedConn, error) {
-	if err := pgConn.lock(); err != nil {
+	if err := pgConn.lock("Hijack"); err != nil {
 		return nil, err
 	}
-	pgConn.status = connStatusClosed
+	pgConn.storeStatus(connStatusClosed)
+	if pgConn.keepalive != nil {
+		pgConn.keepalive.stop()
+	}
 
 	return &HijackedConn{
 		Conn:              pgConn.conn,
 		PID:               pgConn.pid,
 		SecretKey:         pgConn.secretKey,
+		CancelKey:         pgConn.cancelKey,
+		ProtocolVersion:   pgConn.protocolVersion,
 		ParameterStatuses: pgConn.parameterStatuses,
-		TxStatus:          pgConn.txStatus,
+		TxStatus:          pgConn.loadTxStatus(),
 		Frontend:          pgConn.frontend,
 		Config:            pgConn.config,
+		Pooler:            pgConn.pooler,
 	}, nil
 }
 
@@ -1884,18 +3548,24 @@ func Construct(hc *HijackedConn) (*PgConn, error) {
 		conn:              hc.Conn,
 		pid:               hc.PID,
 		secretKey:         hc.SecretKey,
+		cancelKey:         hc.CancelKey,
+		protocolVersion:   hc.ProtocolVersion,
 		parameterStatuses: hc.ParameterStatuses,
-		txStatus:          hc.TxStatus,
+		txStatus:          uint32(hc.TxStatus),
 		frontend:          hc.Frontend,
 		config:            hc.Config,
+		pooler:            hc.Pooler,
 
 		status: connStatusIdle,
 
 		wbuf:        make([]byte, 0, wbufLen),
+		ioStats:     &ioStats{},
+		history:     &messageHistory{},
 		cleanupDone: make(chan struct{}),
+		done:        make(chan struct{}),
 	}
 
-	pgConn.contextWatcher = newContextWatcher(pgConn.conn)
+	pgConn.contextWatcher = pgConn.buildContextWatcher()
 
 	return pgConn, nil
 }
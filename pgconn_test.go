@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,9 +17,13 @@ import (
 	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/jackc/pgconn"
@@ -110,6 +118,14 @@ func TestConnectTLS(t *testing.T) {
 	closeConn(t, conn)
 }
 
+// pgmockSignalStep closes the channel when it is reached.
+type pgmockSignalStep chan struct{}
+
+func (s pgmockSignalStep) Step(*pgproto3.Backend) error {
+	close(s)
+	return nil
+}
+
 type pgmockWaitStep time.Duration
 
 func (s pgmockWaitStep) Step(*pgproto3.Backend) error {
@@ -329,6 +345,56 @@ func TestConnectCustomDialer(t *testing.T) {
 	closeConn(t, conn)
 }
 
+func TestConnectUnixSocketDirectoryList(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	connString, serverErrChan := runPgmockServer(t, script)
+	serverConfig, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	dirs := []string{"/nonexistent/a", "/nonexistent/b"}
+	config, err := pgconn.ParseConfig(fmt.Sprintf("host=%s port=%d sslmode=disable", strings.Join(dirs, string(filepath.ListSeparator)), serverConfig.Port))
+	require.NoError(t, err)
+	require.Empty(t, config.Fallbacks)
+
+	var dialed []string
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		if len(dialed) == 1 {
+			return nil, errors.New("no such socket")
+		}
+		return net.Dial("tcp", net.JoinHostPort(serverConfig.Host, strconv.Itoa(int(serverConfig.Port))))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	closeConn(t, conn)
+	require.NoError(t, <-serverErrChan)
+
+	want := make([]string, len(dirs))
+	for i, dir := range dirs {
+		_, address := pgconn.NetworkAddress(dir, serverConfig.Port)
+		want[i] = "unix " + address
+	}
+	assert.Equal(t, want, dialed)
+
+	// All directories failing is reported as one error.
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no such socket")
+	}
+	_, err = pgconn.ConnectConfig(ctx, config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to dial socket directories /nonexistent/a, /nonexistent/b")
+}
+
 func TestConnectCustomLookup(t *testing.T) {
 	t.Parallel()
 
@@ -386,6 +452,232 @@ func TestConnectCustomLookupWithPort(t *testing.T) {
 	closeConn(t, conn)
 }
 
+func TestConnectRefusesCleartextPasswordOnUnencryptedConnection(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name       string
+		authMsg    pgproto3.BackendMessage
+		connParams string
+		errMsg     string
+	}{
+		{"cleartext", &pgproto3.AuthenticationCleartextPassword{}, "unencrypted_password_auth=refuse-cleartext", "server requested cleartext password authentication on an unencrypted connection"},
+		{"md5", &pgproto3.AuthenticationMD5Password{}, "unencrypted_password_auth=refuse-md5", "server requested MD5 password authentication on an unencrypted connection"},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			script := &pgmock.Script{
+				Steps: []pgmock.Step{
+					pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+					pgmock.SendMessage(tt.authMsg),
+					pgmock.WaitForClose(),
+				},
+			}
+			connStr, _ := runPgmockServer(t, script)
+
+			_, err := pgconn.Connect(context.Background(), connStr+" password=secret "+tt.connParams)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestConnectWithAuthenticationHandler(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationCleartextPassword{}),
+			pgmock.ExpectMessage(&pgproto3.PasswordMessage{Password: "token"}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	// The handler takes over from the built-in handling so the unencrypted password policy does not apply.
+	config, err := pgconn.ParseConfig(connString + " password=secret unencrypted_password_auth=refuse-md5")
+	require.NoError(t, err)
+
+	var handlerCalls int
+	config.AuthenticationHandlers = map[uint32]pgconn.AuthenticationHandler{
+		pgproto3.AuthTypeCleartextPassword: func(ctx context.Context, frontend pgconn.AuthenticationFrontend, msg pgproto3.BackendMessage) error {
+			handlerCalls++
+			assert.IsType(t, &pgproto3.AuthenticationCleartextPassword{}, msg)
+			return frontend.Send(&pgproto3.PasswordMessage{Password: "token"})
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, 1, handlerCalls)
+	closeConn(t, conn)
+
+	require.NoError(t, <-serverErrChan)
+}
+
+// setAuthTypeStep tells the pgmock backend which authentication message the client sends next. pgproto3.Backend cannot
+// tell SASL messages apart from password messages otherwise.
+type setAuthTypeStep uint32
+
+func (s setAuthTypeStep) Step(backend *pgproto3.Backend) error {
+	return backend.SetAuthType(uint32(s))
+}
+
+// TestConnectSCRAMExchange checks the exact SCRAM-SHA-256 messages for a fixed client nonce. The expected messages
+// were computed independently of pgconn as described in RFC 5802 and RFC 7677. It does not run in parallel because it
+// replaces the global nonce source.
+func TestConnectSCRAMExchange(t *testing.T) {
+	restore := pgconn.SetSCRAMNonceReader(strings.NewReader("abcdefghijklmnopqr"))
+	defer restore()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}}),
+			setAuthTypeStep(pgproto3.AuthTypeSASL),
+			pgmock.ExpectMessage(&pgproto3.SASLInitialResponse{
+				AuthMechanism: "SCRAM-SHA-256",
+				Data:          []byte("n,,n=,r=YWJjZGVmZ2hpamtsbW5vcHFy"),
+			}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASLContinue{
+				Data: []byte("r=YWJjZGVmZ2hpamtsbW5vcHFy3rfcNHYJY1ZVvWVs7j,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"),
+			}),
+			setAuthTypeStep(pgproto3.AuthTypeSASLContinue),
+			pgmock.ExpectMessage(&pgproto3.SASLResponse{
+				Data: []byte("c=biws,r=YWJjZGVmZ2hpamtsbW5vcHFy3rfcNHYJY1ZVvWVs7j,p=yiNKVZzWrmsTcbzlwn2exrnFlpftf1ffXU21CGf+WWc="),
+			}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASLFinal{Data: []byte("v=Ooy0fJ/Wkhe0kh0fhKEIA6tvmexCeZWyDi+KC2jhZzo=")}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString+" password=pencil")
+	require.NoError(t, err)
+	closeConn(t, conn)
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestConnectSCRAMRejectsInvalidServerSignature(t *testing.T) {
+	restore := pgconn.SetSCRAMNonceReader(strings.NewReader("abcdefghijklmnopqr"))
+	defer restore()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}}),
+			setAuthTypeStep(pgproto3.AuthTypeSASL),
+			pgmock.ExpectAnyMessage(&pgproto3.SASLInitialResponse{}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASLContinue{
+				Data: []byte("r=YWJjZGVmZ2hpamtsbW5vcHFy3rfcNHYJY1ZVvWVs7j,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"),
+			}),
+			setAuthTypeStep(pgproto3.AuthTypeSASLContinue),
+			pgmock.ExpectAnyMessage(&pgproto3.SASLResponse{}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASLFinal{Data: []byte("v=AAAAfJ/Wkhe0kh0fhKEIA6tvmexCeZWyDi+KC2jhZzo=")}),
+			pgmock.WaitForClose(),
+		},
+	}
+	connString, _ := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := pgconn.Connect(ctx, connString+" password=pencil")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid SCRAM ServerSignature")
+}
+
+func TestConnectNoticesDuringStartupAndAuthentication(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: "before auth"}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{"X-TEST"}}),
+			pgmock.ExpectMessage(&pgproto3.PasswordMessage{Password: "first"}),
+			pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "WARNING", Code: "01000", Message: "during auth"}),
+			pgmock.SendMessage(&pgproto3.AuthenticationSASLContinue{Data: []byte("challenge")}),
+			pgmock.ExpectMessage(&pgproto3.PasswordMessage{Password: "second"}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "WARNING", Code: "01000", Message: "after auth"}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	var notices []string
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) { notices = append(notices, n.Message) }
+	config.AuthenticationHandlers = map[uint32]pgconn.AuthenticationHandler{
+		pgproto3.AuthTypeSASL: func(ctx context.Context, frontend pgconn.AuthenticationFrontend, msg pgproto3.BackendMessage) error {
+			if err := frontend.Send(&pgproto3.PasswordMessage{Password: "first"}); err != nil {
+				return err
+			}
+			msg, err := frontend.Receive()
+			if err != nil {
+				return err
+			}
+			if !assert.IsType(t, &pgproto3.AuthenticationSASLContinue{}, msg) {
+				return errors.New("unexpected message")
+			}
+			return frontend.Send(&pgproto3.PasswordMessage{Password: "second"})
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"before auth", "during auth", "after auth"}, notices)
+	closeConn(t, conn)
+
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestConnectWithAuthenticationHandlerError(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationMD5Password{}),
+			pgmock.WaitForClose(),
+		},
+	}
+	connString, _ := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.AuthenticationHandlers = map[uint32]pgconn.AuthenticationHandler{
+		pgproto3.AuthTypeMD5Password: func(ctx context.Context, frontend pgconn.AuthenticationFrontend, msg pgproto3.BackendMessage) error {
+			return errors.New("no credentials available")
+		},
+	}
+
+	_, err = pgconn.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no credentials available")
+}
+
 func TestConnectWithRuntimeParams(t *testing.T) {
 	t.Parallel()
 
@@ -502,6 +794,176 @@ func TestConnectWithValidateConnectTargetSessionAttrsReadWrite(t *testing.T) {
 	}
 }
 
+func TestConnectWithValidateConnectHelpers(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+
+	config.ValidateConnect = pgconn.ValidateConnectAll(
+		pgconn.ValidateConnectPing(5*time.Second),
+		pgconn.ValidateConnectServerVersionAtLeast(90000),
+		pgconn.ValidateConnectExtensionPresent("plpgsql"),
+	)
+
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	closeConn(t, conn)
+
+	config.ValidateConnect = pgconn.ValidateConnectExtensionPresent("no_such_extension")
+	conn, err = pgconn.ConnectConfig(context.Background(), config)
+	if !assert.Error(t, err) {
+		closeConn(t, conn)
+	}
+	assert.Contains(t, err.Error(), "extension no_such_extension is not installed")
+}
+
+func TestConnectWithValidateConnectPingTimeoutWithClock(t *testing.T) {
+	t.Parallel()
+
+	pingReceived := make(chan struct{})
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "-- ping"}),
+		pgmockSignalStep(pingReceived),
+		pgmock.WaitForClose(),
+	)
+	// The connection is closed without a Terminate message when ValidateConnect fails so the result of the script is
+	// not checked.
+	connString, _ := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	clock := newFakeClock()
+	config.Clock = clock
+	config.ValidateConnect = pgconn.ValidateConnectPing(time.Hour)
+
+	go func() {
+		<-pingReceived
+		clock.Advance(time.Hour)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pgconn.ConnectConfig(ctx, config)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestConnectWithValidateConnectServerVersionAtLeast(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		serverVersion string
+		minVersion    int
+		ok            bool
+	}{
+		{"14.5 (Debian 14.5-1.pgdg110+1)", 140000, true},
+		{"14.5 (Debian 14.5-1.pgdg110+1)", 140006, false},
+		{"9.6.24", 90624, true},
+		{"9.6.24", 100000, false},
+		{"16beta1", 160000, true},
+	} {
+		script := &pgmock.Script{
+			Steps: []pgmock.Step{
+				pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+				pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+				pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "server_version", Value: tt.serverVersion}),
+				pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+				pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			},
+		}
+		if tt.ok {
+			script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+		}
+
+		connString, _ := runPgmockServer(t, script)
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+		config.ValidateConnect = pgconn.ValidateConnectServerVersionAtLeast(tt.minVersion)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := pgconn.ConnectConfig(ctx, config)
+		if tt.ok {
+			require.NoErrorf(t, err, "%s >= %d", tt.serverVersion, tt.minVersion)
+			closeConn(t, conn)
+		} else {
+			require.Errorf(t, err, "%s >= %d", tt.serverVersion, tt.minVersion)
+			assert.Contains(t, err.Error(), "is less than required version")
+		}
+		cancel()
+	}
+}
+
+func TestConnectWithValidateConnectServerVersionAtLeastUnexpectedResult(t *testing.T) {
+	t.Parallel()
+
+	// Without a server_version parameter status the version is queried. A proxy may answer without a row.
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.NoData{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SHOW")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	)
+	connString, _ := runPgmockServer(t, script)
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.ValidateConnect = pgconn.ValidateConnectServerVersionAtLeast(90000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pgconn.ConnectConfig(ctx, config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected result of show server_version_num")
+}
+
+func TestValidateConnectAll(t *testing.T) {
+	t.Parallel()
+
+	notPreferredErr := &pgconn.NotPreferredError{}
+	failErr := errors.New("fail")
+	var calls []int
+	validateFunc := func(n int, err error) pgconn.ValidateConnectFunc {
+		return func(ctx context.Context, pgConn *pgconn.PgConn) error {
+			calls = append(calls, n)
+			return err
+		}
+	}
+
+	err := pgconn.ValidateConnectAll(validateFunc(1, nil), validateFunc(2, nil))(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, calls)
+
+	calls = nil
+	err = pgconn.ValidateConnectAll(validateFunc(1, failErr), validateFunc(2, nil))(context.Background(), nil)
+	assert.Equal(t, failErr, err)
+	assert.Equal(t, []int{1}, calls)
+
+	calls = nil
+	err = pgconn.ValidateConnectAll(validateFunc(1, notPreferredErr), validateFunc(2, nil))(context.Background(), nil)
+	assert.Equal(t, notPreferredErr, err)
+	assert.Equal(t, []int{1, 2}, calls)
+
+	calls = nil
+	err = pgconn.ValidateConnectAll(validateFunc(1, notPreferredErr), validateFunc(2, failErr))(context.Background(), nil)
+	assert.Equal(t, failErr, err)
+	assert.Equal(t, []int{1, 2}, calls)
+}
+
 func TestConnectWithAfterConnect(t *testing.T) {
 	t.Parallel()
 
@@ -599,553 +1061,4840 @@ func TestConnExecEmpty(t *testing.T) {
 	assert.Equal(t, 0, resultCount)
 	err = multiResult.Close()
 	assert.NoError(t, err)
+	assert.True(t, multiResult.EmptyQuery())
+
+	multiResult = pgConn.Exec(context.Background(), "select 1 where false")
+	_, err = multiResult.ReadAll()
+	assert.NoError(t, err)
+	assert.False(t, multiResult.EmptyQuery())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecMultipleQueries(t *testing.T) {
+func TestConnExecReadAllWithLimit(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	fields := []pgproto3.FieldDescription{{Name: []byte("s"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}}
+	queryResponse := []pgmock.Step{
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select s from a; select s from b"}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("abc")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("def")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("ghi")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 3")}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("jkl")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
 
-	results, err := pgConn.Exec(context.Background(), "select 'Hello, world'; select 1").ReadAll()
-	assert.NoError(t, err)
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	for i := 0; i < 3; i++ {
+		script.Steps = append(script.Steps, queryResponse...)
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
 
-	assert.Len(t, results, 2)
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	assert.Nil(t, results[0].Err)
-	assert.Equal(t, "SELECT 1", string(results[0].CommandTag))
-	assert.Len(t, results[0].Rows, 1)
-	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	assert.Nil(t, results[1].Err)
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	results, err := pgConn.Exec(ctx, "select s from a; select s from b").ReadAllWithLimit(pgconn.ReadAllLimit{MaxRows: 2})
+	var truncatedErr *pgconn.ResultTruncatedError
+	require.True(t, errors.As(err, &truncatedErr))
+	assert.Equal(t, 2, truncatedErr.Rows)
+	assert.Equal(t, 6, truncatedErr.Bytes)
+	require.Len(t, results, 1)
+	assert.Equal(t, [][][]byte{{[]byte("abc")}, {[]byte("def")}}, results[0].Rows)
+	assert.Equal(t, "SELECT 3", string(results[0].CommandTag))
+	assert.False(t, pgConn.IsBusy())
+
+	results, err = pgConn.Exec(ctx, "select s from a; select s from b").ReadAllWithLimit(pgconn.ReadAllLimit{MaxBytes: 10})
+	require.True(t, errors.As(err, &truncatedErr))
+	assert.Equal(t, 3, truncatedErr.Rows)
+	assert.Equal(t, 9, truncatedErr.Bytes)
+	require.Len(t, results, 2)
+	assert.Len(t, results[0].Rows, 3)
+	assert.Empty(t, results[1].Rows)
 	assert.Equal(t, "SELECT 1", string(results[1].CommandTag))
+
+	results, err = pgConn.Exec(ctx, "select s from a; select s from b").ReadAllWithLimit(pgconn.ReadAllLimit{MaxRows: 4, MaxBytes: 12})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Len(t, results[0].Rows, 3)
 	assert.Len(t, results[1].Rows, 1)
-	assert.Equal(t, "1", string(results[1].Rows[0][0]))
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecMultipleQueriesEagerFieldDescriptions(t *testing.T) {
+func TestResultReaderWriteCSVAndJSON(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	fields := []pgproto3.FieldDescription{
+		{Name: []byte("id"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1},
+		{Name: []byte("name"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1},
+	}
+	queryResponse := []pgmock.Step{
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select id, name from t"}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1"), []byte(`say "hi", bye`)}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("2"), nil}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
+	emptyResponse := []pgmock.Step{
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select id, name from t where false"}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, queryResponse...)
+	script.Steps = append(script.Steps, queryResponse...)
+	script.Steps = append(script.Steps, emptyResponse...)
+	script.Steps = append(script.Steps, emptyResponse...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	mrr := pgConn.Exec(context.Background(), "select 'Hello, world' as msg; select 1 as num")
+	execResultReader := func(sql string) (*pgconn.MultiResultReader, *pgconn.ResultReader) {
+		mrr := pgConn.Exec(ctx, sql)
+		require.True(t, mrr.NextResult())
+		return mrr, mrr.ResultReader()
+	}
 
-	require.True(t, mrr.NextResult())
-	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
-	assert.Equal(t, []byte("msg"), mrr.ResultReader().FieldDescriptions()[0].Name)
-	_, err = mrr.ResultReader().Close()
+	var sb strings.Builder
+	mrr, rr := execResultReader("select id, name from t")
+	commandTag, err := rr.WriteCSV(csv.NewWriter(&sb), true)
 	require.NoError(t, err)
+	require.NoError(t, mrr.Close())
+	assert.Equal(t, "SELECT 2", string(commandTag))
+	assert.Equal(t, "id,name\n1,\"say \"\"hi\"\", bye\"\n2,\n", sb.String())
 
-	require.True(t, mrr.NextResult())
-	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
-	assert.Equal(t, []byte("num"), mrr.ResultReader().FieldDescriptions()[0].Name)
-	_, err = mrr.ResultReader().Close()
+	sb.Reset()
+	mrr, rr = execResultReader("select id, name from t")
+	commandTag, err = rr.WriteJSON(&sb)
 	require.NoError(t, err)
+	require.NoError(t, mrr.Close())
+	assert.Equal(t, "SELECT 2", string(commandTag))
+	assert.JSONEq(t, `[{"id":"1","name":"say \"hi\", bye"},{"id":"2","name":null}]`, sb.String())
 
-	require.False(t, mrr.NextResult())
+	sb.Reset()
+	mrr, rr = execResultReader("select id, name from t where false")
+	_, err = rr.WriteCSV(csv.NewWriter(&sb), true)
+	require.NoError(t, err)
+	require.NoError(t, mrr.Close())
+	assert.Equal(t, "id,name\n", sb.String())
 
+	sb.Reset()
+	mrr, rr = execResultReader("select id, name from t where false")
+	_, err = rr.WriteJSON(&sb)
+	require.NoError(t, err)
 	require.NoError(t, mrr.Close())
+	assert.Equal(t, "[]", sb.String())
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecMultipleQueriesError(t *testing.T) {
+func TestConnReceiveCopyBothMessage(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "START_REPLICATION SLOT s LOGICAL 0/0"}),
+		pgmock.SendMessage(&pgproto3.CopyBothResponse{OverallFormat: 0}),
+		pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: "hello"}),
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "TimeZone", Value: "UTC"}),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "chan", Payload: "payload"}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("w")}),
+		pgmock.SendMessage(&pgproto3.CopyDone{}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	results, err := pgConn.Exec(context.Background(), "select 1; select 1/0; select 1").ReadAll()
-	require.NotNil(t, err)
-	if pgErr, ok := err.(*pgconn.PgError); ok {
-		assert.Equal(t, "22012", pgErr.Code)
-	} else {
-		t.Errorf("unexpected error: %v", err)
-	}
+	var notices []string
+	var notifications []string
+	parameterStatuses := map[string]string{}
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) { notices = append(notices, n.Message) }
+	config.OnNotification = func(_ *pgconn.PgConn, n *pgconn.Notification) { notifications = append(notifications, n.Payload) }
+	config.OnParameterStatus = func(_ *pgconn.PgConn, name, value string) { parameterStatuses[name] = value }
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		// CockroachDB starts the second query result set and then sends the divide by zero error.
-		require.Len(t, results, 2)
-		assert.Len(t, results[0].Rows, 1)
-		assert.Equal(t, "1", string(results[0].Rows[0][0]))
-		assert.Len(t, results[1].Rows, 0)
-	} else {
-		// PostgreSQL sends the divide by zero and never sends the second query result set.
-		require.Len(t, results, 1)
-		assert.Len(t, results[0].Rows, 1)
-		assert.Equal(t, "1", string(results[0].Rows[0][0]))
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	ensureConnValid(t, pgConn)
-}
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
 
-func TestConnExecDeferredError(t *testing.T) {
-	t.Parallel()
+	buf, err := (&pgproto3.Query{String: "START_REPLICATION SLOT s LOGICAL 0/0"}).Encode(nil)
+	require.NoError(t, err)
+	_, err = pgConn.Conn().Write(buf)
+	require.NoError(t, err)
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	msg, err := pgConn.ReceiveCopyBothMessage(ctx)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	require.IsType(t, &pgproto3.CopyBothResponse{}, msg)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	msg, err = pgConn.ReceiveCopyBothMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyData{}, msg)
+	assert.Equal(t, []byte("w"), msg.(*pgproto3.CopyData).Data)
+	assert.Equal(t, []string{"hello"}, notices)
+	assert.Equal(t, []string{"payload"}, notifications)
+	assert.Equal(t, "UTC", parameterStatuses["TimeZone"])
+	assert.Equal(t, "UTC", pgConn.ParameterStatus("TimeZone"))
+
+	msg, err = pgConn.ReceiveCopyBothMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyDone{}, msg)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnOnUnexpectedMessage(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
 	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(&pgproto3.FunctionCallResponse{Result: []byte("x")}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("n"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1}}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	setupSQL := `create temporary table t (
-		id text primary key,
-		n int not null,
-		unique (n) deferrable initially deferred
-	);
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
 
-	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+	var unexpected []pgproto3.BackendMessage
+	config.OnUnexpectedMessage = func(_ *pgconn.PgConn, msg pgproto3.BackendMessage) {
+		unexpected = append(unexpected, msg)
+	}
 
-	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
-	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	_, err = pgConn.Exec(context.Background(), `update t set n=n+1 where id='b' returning *`).ReadAll()
-	require.NotNil(t, err)
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	assert.Empty(t, unexpected)
 
-	var pgErr *pgconn.PgError
-	require.True(t, errors.As(err, &pgErr))
-	require.Equal(t, "23505", pgErr.Code)
+	results, err := pgConn.Exec(ctx, "select 1").ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "1", string(results[0].Rows[0][0]))
+	require.Len(t, unexpected, 1)
+	assert.Equal(t, &pgproto3.FunctionCallResponse{Result: []byte("x")}, unexpected[0])
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecContextCanceled(t *testing.T) {
+func TestConnOnReadyForQuery(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "begin"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'T'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1/0"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "22012", Message: "division by zero"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'E'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "rollback"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("ROLLBACK")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-	multiResult := pgConn.Exec(ctx, "select 'Hello, world', pg_sleep(1)")
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
 
-	for multiResult.NextResult() {
+	type readyForQuery struct {
+		txStatus byte
+		op       string
 	}
-	err = multiResult.Close()
-	assert.True(t, pgconn.Timeout(err))
-	assert.ErrorIs(t, err, context.DeadlineExceeded)
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+	var received []readyForQuery
+	config.OnReadyForQuery = func(_ *pgconn.PgConn, txStatus byte, op string) {
+		received = append(received, readyForQuery{txStatus: txStatus, op: op})
 	}
-}
 
-func TestConnExecContextPrecanceled(t *testing.T) {
-	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	_, err = pgConn.Exec(ctx, "select 'Hello, world'").ReadAll()
-	assert.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
+	_, err = pgConn.Exec(ctx, "begin").ReadAll()
+	require.NoError(t, err)
+	_, err = pgConn.Exec(ctx, "select 1/0").ReadAll()
+	require.Error(t, err)
+	_, err = pgConn.Exec(ctx, "rollback").ReadAll()
+	require.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
+	assert.Equal(t, []readyForQuery{
+		{txStatus: 'I', op: "Connect"},
+		{txStatus: 'T', op: "Exec"},
+		{txStatus: 'E', op: "Exec"},
+		{txStatus: 'I', op: "Exec"},
+	}, received)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecParams(t *testing.T) {
+func TestConnectOnUnexpectedMessage(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("x")}),
+			pgmock.WaitForClose(),
+		},
+	}
+	connString, _ := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
-	require.Len(t, result.FieldDescriptions(), 1)
-	assert.Equal(t, []byte("msg"), result.FieldDescriptions()[0].Name)
+	var unexpected []pgproto3.BackendMessage
+	config.OnUnexpectedMessage = func(_ *pgconn.PgConn, msg pgproto3.BackendMessage) {
+		unexpected = append(unexpected, msg)
+	}
 
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	_, err = pgconn.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "received unexpected message")
+	var protocolErr *pgconn.ProtocolError
+	require.ErrorAs(t, err, &protocolErr)
+	assert.Equal(t, pgconn.ProtocolMessage{FromServer: true, Type: 'd', Length: 5}, protocolErr.History[len(protocolErr.History)-1])
+	require.Len(t, unexpected, 1)
+	assert.IsType(t, &pgproto3.CopyData{}, unexpected[0])
+}
+
+// syncSignalingConn signals on syncWritten whenever a Sync message is written.
+type syncSignalingConn struct {
+	net.Conn
+	syncWritten chan struct{}
+}
+
+func (c *syncSignalingConn) Write(b []byte) (int, error) {
+	if bytes.Equal(b, []byte{'S', 0, 0, 0, 4}) {
+		defer func() { c.syncWritten <- struct{}{} }()
 	}
-	assert.Equal(t, 1, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, "SELECT 1", string(commandTag))
-	assert.NoError(t, err)
+	return c.Conn.Write(b)
+}
 
-	ensureConnValid(t, pgConn)
+func connectWithIdleKeepalive(t *testing.T, ctx context.Context, connString string) (*pgconn.PgConn, *pgconn.Config, chan struct{}) {
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.IdleKeepaliveInterval = 100 * time.Millisecond
+
+	syncWritten := make(chan struct{}, 10)
+	dialFunc := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialFunc(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &syncSignalingConn{Conn: conn, syncWritten: syncWritten}, nil
+	}
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	return pgConn, config, syncWritten
 }
 
-func TestConnExecParamsDeferredError(t *testing.T) {
+func TestConnIdleKeepalive(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "foo", Payload: "bar"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, config, syncWritten := connectWithIdleKeepalive(t, ctx, connString)
+	var notifications []string
+	config.OnNotification = func(_ *pgconn.PgConn, n *pgconn.Notification) { notifications = append(notifications, n.Payload) }
+
+	select {
+	case <-syncWritten:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for keepalive")
+	}
+
+	// The notification received by the keepalive is processed by the next operation.
+	_, err := pgConn.Exec(ctx, "select 1").ReadAll()
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	assert.Equal(t, []string{"bar"}, notifications)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnIdleKeepaliveWithClock(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
 	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	setupSQL := `create temporary table t (
-		id text primary key,
-		n int not null,
-		unique (n) deferrable initially deferred
-	);
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	clock := newFakeClock()
+	config.Clock = clock
+	config.IdleKeepaliveInterval = time.Hour
 
-	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
-	assert.NoError(t, err)
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
 
-	result := pgConn.ExecParams(context.Background(), `update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil).Read()
-	require.NotNil(t, result.Err)
-	var pgErr *pgconn.PgError
-	require.True(t, errors.As(result.Err, &pgErr))
-	require.Equal(t, "23505", pgErr.Code)
+	// Nothing is sent before the interval has passed. The script fails if more than one Sync is sent.
+	clock.Advance(time.Hour - time.Second)
+	clock.Advance(time.Second)
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecParamsMaxNumberOfParams(t *testing.T) {
+func TestConnIdleKeepaliveSuspendedDuringCopyBoth(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "START_REPLICATION SLOT s LOGICAL 0/0"}),
+		pgmock.SendMessage(&pgproto3.CopyBothResponse{}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("k")}),
+		// The script fails if a keepalive Sync is sent during the stream.
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	clock := newFakeClock()
+	config.Clock = clock
+	config.IdleKeepaliveInterval = time.Hour
 
-	paramCount := math.MaxUint16
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	buf, err := (&pgproto3.Query{String: "START_REPLICATION SLOT s LOGICAL 0/0"}).Encode(nil)
+	require.NoError(t, err)
+	require.NoError(t, pgConn.SendBytes(ctx, buf))
+
+	msg, err := pgConn.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyBothResponse{}, msg)
+	msg, err = pgConn.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyData{}, msg)
+
+	// The connection is idle between the raw operations but the stream has not ended.
+	clock.Advance(2 * time.Hour)
+
+	require.NoError(t, pgConn.SendMessage(ctx, &pgproto3.CopyDone{}))
+	for _, expected := range []pgproto3.BackendMessage{&pgproto3.CopyDone{}, &pgproto3.CommandComplete{}, &pgproto3.ReadyForQuery{}} {
+		msg, err = pgConn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.IsType(t, expected, msg)
 	}
-	sql := "values" + strings.Join(params, ", ")
 
-	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
-	require.Len(t, result.Rows, paramCount)
+	// The keepalive resumes once the ReadyForQuery that ends the stream has been received.
+	clock.Advance(time.Hour)
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecParamsTooManyParams(t *testing.T) {
+func TestConnIdleKeepaliveNotUsedForReplication(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	clock := newFakeClock()
+	config.Clock = clock
+	config.IdleKeepaliveInterval = time.Hour
+	config.ReplicationMode = pgconn.ReplicationModePhysical
 
-	paramCount := math.MaxUint16 + 1
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
-	}
-	sql := "values" + strings.Join(params, ", ")
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
 
-	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
-	require.Error(t, result.Err)
-	require.Equal(t, "extended protocol limited to 65535 parameters", result.Err.Error())
+	// The script fails if a Sync is sent.
+	clock.Advance(2 * time.Hour)
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecParamsCanceled(t *testing.T) {
+func TestConnIdleKeepaliveFailure(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Sync{}))
+	connString, _ := runPgmockServer(t, script)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	result := pgConn.ExecParams(ctx, "select current_database(), pg_sleep(1)", nil, nil, nil, nil)
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
+
+	pgConn, _, syncWritten := connectWithIdleKeepalive(t, ctx, connString)
+
+	select {
+	case <-syncWritten:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for keepalive")
 	}
-	assert.Equal(t, 0, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, pgconn.CommandTag(nil), commandTag)
-	assert.True(t, pgconn.Timeout(err))
-	assert.ErrorIs(t, err, context.DeadlineExceeded)
 
-	assert.True(t, pgConn.IsClosed())
+	// The failed keepalive is detected without using the connection.
 	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+	case <-pgConn.Done():
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for Done")
 	}
+
+	_, err := pgConn.Exec(ctx, "select 1").ReadAll()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "idle keepalive failed")
+	assert.True(t, pgconn.SafeToRetry(err))
+	assert.True(t, pgConn.IsClosed())
 }
 
-func TestConnExecParamsPrecanceled(t *testing.T) {
+func TestConnTryLock(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "begin"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'T'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	assert.True(t, pgConn.IsIdle())
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	result := pgConn.ExecParams(ctx, "select $1::text", [][]byte{[]byte("Hello, world")}, nil, nil, nil).Read()
+	require.True(t, pgConn.TryLock())
+	assert.True(t, pgConn.IsBusy())
+	assert.False(t, pgConn.IsIdle())
+	assert.False(t, pgConn.TryLock())
+
+	_, err = pgConn.Exec(ctx, "select 1").ReadAll()
+	require.Error(t, err)
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	pgConn.Unlock()
+	assert.True(t, pgConn.IsIdle())
+
+	_, err = pgConn.Exec(ctx, "begin").ReadAll()
+	require.NoError(t, err)
+	assert.False(t, pgConn.IsIdle())
+	assert.False(t, pgConn.TryLock())
+	assert.False(t, pgConn.IsBusy())
+
+	closeConn(t, pgConn)
+	assert.False(t, pgConn.IsIdle())
+	assert.False(t, pgConn.TryLock())
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnTryLockConcurrent(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	// Pausing the keepalive widens the window between checking and changing the status of the connection.
+	config.IdleKeepaliveInterval = time.Hour
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		var wg sync.WaitGroup
+		var winners int32
+		start := make(chan struct{})
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if pgConn.TryLock() {
+					atomic.AddInt32(&winners, 1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		require.EqualValues(t, 1, winners)
+		assert.True(t, pgConn.IsBusy())
+		pgConn.Unlock()
+	}
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnStatusConcurrentReaders(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	for i := 0; i < 10; i++ {
+		script.Steps = append(script.Steps,
+			pgmock.ExpectMessage(&pgproto3.Query{String: "begin"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'T'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "commit"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COMMIT")}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "server_version", Value: fmt.Sprintf("14.%d", i)}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: fmt.Sprintf("custom.%d", i), Value: "on"}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		)
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			pgConn.IsClosed()
+			pgConn.IsBusy()
+			pgConn.IsIdle()
+			pgConn.TxStatus()
+			pgConn.PID()
+			pgConn.SecretKey()
+			pgConn.Identity()
+			pgConn.ParameterStatus("server_version")
+			pgConn.ParameterStatuses()
+			pgConn.ServerVersion()
+			pgConn.TimeZone()
+			pgConn.ClientEncoding()
+			pgConn.IOStats()
+			pgConn.FatalError()
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		_, err = pgConn.Exec(ctx, "begin").ReadAll()
+		require.NoError(t, err)
+		_, err = pgConn.Exec(ctx, "commit").ReadAll()
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 140009, pgConn.ServerVersion())
+	assert.Equal(t, "on", pgConn.ParameterStatus("custom.9"))
+
+	closeConn(t, pgConn)
+	close(stop)
+	<-readerDone
+	assert.True(t, pgConn.IsClosed())
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestPreConnect(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Connect", func(t *testing.T) {
+		script := &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+		}
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+
+		pendingConn, err := pgconn.PreConnect(ctx, config)
+		require.NoError(t, err)
+
+		pgConn, err := pendingConn.Connect(ctx)
+		require.NoError(t, err)
+		assert.True(t, pgConn.IsIdle())
+
+		_, err = pendingConn.Connect(ctx)
+		require.Error(t, err)
+		require.NoError(t, pendingConn.Close())
+
+		closeConn(t, pgConn)
+		assert.NoError(t, <-serverErrChan)
+	})
+
+	t.Run("ConnectionLost", func(t *testing.T) {
+		script := &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+		}
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+
+		// The connection established in advance is closed by the server before it is used.
+		dialFunc := config.DialFunc
+		var dialCount int32
+		config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if atomic.AddInt32(&dialCount, 1) == 1 {
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			}
+			return dialFunc(ctx, network, address)
+		}
+
+		pendingConn, err := pgconn.PreConnect(ctx, config)
+		require.NoError(t, err)
+
+		pgConn, err := pendingConn.Connect(ctx)
+		require.NoError(t, err)
+		assert.True(t, pgConn.IsIdle())
+		assert.EqualValues(t, 2, atomic.LoadInt32(&dialCount))
+
+		closeConn(t, pgConn)
+		assert.NoError(t, <-serverErrChan)
+	})
+
+	rejectingServer := func(t *testing.T, code string) (string, <-chan error) {
+		script := &pgmock.Script{
+			Steps: []pgmock.Step{
+				pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+				pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: code, Message: "rejected"}),
+			},
+		}
+		return runPgmockServer(t, script)
+	}
+
+	t.Run("ServerError", func(t *testing.T) {
+		rejectingConnString, rejectingErrChan := rejectingServer(t, "53300")
+		rejectingConfig, err := pgconn.ParseConfig(rejectingConnString)
+		require.NoError(t, err)
+
+		script := &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+		}
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+
+		// The connection established in advance is rejected with an error ConnectConfig tries the next host for.
+		dialFunc := config.DialFunc
+		var dialCount int32
+		config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if atomic.AddInt32(&dialCount, 1) == 1 {
+				return dialFunc(ctx, network, fmt.Sprintf("%s:%d", rejectingConfig.Host, rejectingConfig.Port))
+			}
+			return dialFunc(ctx, network, address)
+		}
+
+		pendingConn, err := pgconn.PreConnect(ctx, config)
+		require.NoError(t, err)
+
+		pgConn, err := pendingConn.Connect(ctx)
+		require.NoError(t, err)
+		assert.True(t, pgConn.IsIdle())
+		assert.EqualValues(t, 2, atomic.LoadInt32(&dialCount))
+		assert.NoError(t, <-rejectingErrChan)
+
+		closeConn(t, pgConn)
+		assert.NoError(t, <-serverErrChan)
+	})
+
+	t.Run("FinalServerError", func(t *testing.T) {
+		connString, serverErrChan := rejectingServer(t, "28P01")
+
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+
+		pendingConn, err := pgconn.PreConnect(ctx, config)
+		require.NoError(t, err)
+
+		_, err = pendingConn.Connect(ctx)
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, "28P01", pgErr.Code)
+		assert.NoError(t, <-serverErrChan)
+	})
+}
+
+func TestConnCloser(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	var closer io.Closer = pgConn.Closer()
+	require.NoError(t, closer.Close())
+	assert.True(t, pgConn.IsClosed())
+	require.NoError(t, closer.Close())
+
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnParameterStatusAccessors(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "server_version", Value: "14.5 (Debian 14.5-1.pgdg110+1)"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "TimeZone", Value: "UTC"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "integer_datetimes", Value: "on"}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "set timezone = '<+03>-03'"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "TimeZone", Value: "<+03>-03"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SET")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	assert.Equal(t, 140005, pgConn.ServerVersion())
+	assert.Equal(t, time.UTC, pgConn.TimeZone())
+	assert.Equal(t, "UTF8", pgConn.ClientEncoding())
+	assert.True(t, pgConn.StandardConformingStrings())
+	assert.True(t, pgConn.IntegerDatetimes())
+
+	// A changed parameter status is parsed again.
+	_, err = pgConn.Exec(ctx, "set timezone = '<+03>-03'").ReadAll()
+	require.NoError(t, err)
+	assert.Nil(t, pgConn.TimeZone())
+	assert.Equal(t, "<+03>-03", pgConn.ParameterStatus("TimeZone"))
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnectProgress(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	_, closedPort, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.Fallbacks = []*pgconn.FallbackConfig{{Host: config.Host, Port: config.Port}}
+	port, err := strconv.ParseUint(closedPort, 10, 16)
+	require.NoError(t, err)
+	config.Port = uint16(port)
+	config.ValidateConnect = func(ctx context.Context, pgConn *pgconn.PgConn) error { return nil }
+
+	var progress []pgconn.ConnectProgress
+	config.OnConnectProgress = func(p pgconn.ConnectProgress) {
+		progress = append(progress, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+
+	type event struct {
+		stage  pgconn.ConnectStage
+		port   uint16
+		done   bool
+		failed bool
+	}
+	var events []event
+	for _, p := range progress {
+		assert.Equal(t, "127.0.0.1", p.Host)
+		events = append(events, event{stage: p.Stage, port: p.Port, done: p.Done, failed: p.Err != nil})
+	}
+	serverPort := config.Fallbacks[0].Port
+	assert.Equal(t, []event{
+		{stage: pgconn.ConnectStageResolve, port: config.Port},
+		{stage: pgconn.ConnectStageResolve, port: config.Port, done: true},
+		{stage: pgconn.ConnectStageResolve, port: serverPort},
+		{stage: pgconn.ConnectStageResolve, port: serverPort, done: true},
+		{stage: pgconn.ConnectStageDial, port: config.Port},
+		{stage: pgconn.ConnectStageDial, port: config.Port, done: true, failed: true},
+		{stage: pgconn.ConnectStageDial, port: serverPort},
+		{stage: pgconn.ConnectStageDial, port: serverPort, done: true},
+		{stage: pgconn.ConnectStageAuth, port: serverPort},
+		{stage: pgconn.ConnectStageAuth, port: serverPort, done: true},
+		{stage: pgconn.ConnectStageValidate, port: serverPort},
+		{stage: pgconn.ConnectStageValidate, port: serverPort, done: true},
+	}, events)
+	assert.Contains(t, progress[5].Err.Error(), "dial error")
+	assert.Equal(t, "validate", progress[10].Stage.String())
+}
+
+func TestConnIdentity(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 1234, SecretKey: 5678}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString+" dbname=mydb user=jack")
+	require.NoError(t, err)
+
+	expected := fmt.Sprintf("%s/mydb user=jack pid=1234 local=%s", pgConn.Conn().RemoteAddr(), pgConn.Conn().LocalAddr())
+	assert.Equal(t, expected, pgConn.Identity())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnDone(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Close", func(t *testing.T) {
+		script := &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+		}
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		pgConn, err := pgconn.Connect(ctx, connString)
+		require.NoError(t, err)
+
+		select {
+		case <-pgConn.Done():
+			t.Fatal("Done closed before the connection was closed")
+		default:
+		}
+
+		closeConn(t, pgConn)
+		select {
+		case <-pgConn.Done():
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for Done")
+		}
+		assert.NoError(t, <-serverErrChan)
+	})
+
+	t.Run("ServerFatalError", func(t *testing.T) {
+		script := &pgmock.Script{
+			Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+		}
+		script.Steps = append(script.Steps,
+			pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+			pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"}),
+		)
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		pgConn, err := pgconn.Connect(ctx, connString)
+		require.NoError(t, err)
+
+		_, err = pgConn.Exec(ctx, "select 1").ReadAll()
+		require.Error(t, err)
+
+		select {
+		case <-pgConn.Done():
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for Done")
+		}
+		assert.True(t, pgConn.IsClosed())
+		assert.NoError(t, <-serverErrChan)
+	})
+}
+
+func TestConnOnClose(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connect := func(t *testing.T, script *pgmock.Script) (*pgconn.PgConn, *[]pgconn.CloseReason, <-chan error) {
+		connString, serverErrChan := runPgmockServer(t, script)
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+
+		var reasons []pgconn.CloseReason
+		config.OnClose = func(pgConn *pgconn.PgConn, reason pgconn.CloseReason) {
+			reasons = append(reasons, reason)
+		}
+
+		pgConn, err := pgconn.ConnectConfig(ctx, config)
+		require.NoError(t, err)
+		return pgConn, &reasons, serverErrChan
+	}
+
+	t.Run("Close", func(t *testing.T) {
+		pgConn, reasons, serverErrChan := connect(t, &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+		})
+
+		closeConn(t, pgConn)
+		closeConn(t, pgConn)
+		assert.Equal(t, []pgconn.CloseReason{{Kind: pgconn.CloseReasonClose}}, *reasons)
+		assert.NoError(t, <-serverErrChan)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		// The server does not respond. Its result is not checked as it only sees the connection being closed.
+		pgConn, reasons, _ := connect(t, &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+				pgmock.ExpectMessage(&pgproto3.Query{String: "select pg_sleep(10)"}),
+				pgmock.WaitForClose(),
+			),
+		})
+
+		execCtx, execCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer execCancel()
+		_, err := pgConn.Exec(execCtx, "select pg_sleep(10)").ReadAll()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// The reason is reported when the connection is marked as closed, before the asynchronous cleanup finishes.
+		assert.True(t, pgConn.IsClosed())
+		require.Len(t, *reasons, 1)
+		assert.Equal(t, pgconn.CloseReasonCanceled, (*reasons)[0].Kind)
+		assert.Equal(t, "Exec", (*reasons)[0].Op)
+		assert.ErrorIs(t, (*reasons)[0].Err, context.DeadlineExceeded)
+	})
+
+	t.Run("NetworkError", func(t *testing.T) {
+		pgConn, reasons, serverErrChan := connect(t, &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+				pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+				pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+				pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+				pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+				pgmock.ExpectMessage(&pgproto3.Sync{}),
+			),
+		})
+
+		err := pgConn.ExecParams(ctx, "select 1", nil, nil, nil, nil).Read().Err
+		require.Error(t, err)
+
+		require.Len(t, *reasons, 1)
+		assert.Equal(t, pgconn.CloseReasonNetworkError, (*reasons)[0].Kind)
+		assert.Equal(t, "ExecParams", (*reasons)[0].Op)
+		assert.NoError(t, <-serverErrChan)
+	})
+
+	t.Run("FatalError", func(t *testing.T) {
+		pgConn, reasons, serverErrChan := connect(t, &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+				pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"}),
+			),
+		})
+
+		var err error
+		for err == nil {
+			err = pgConn.ProcessPendingMessages()
+		}
+
+		require.Len(t, *reasons, 1)
+		assert.Equal(t, pgconn.CloseReasonFatalError, (*reasons)[0].Kind)
+		assert.Equal(t, "ProcessPendingMessages", (*reasons)[0].Op)
+		assert.Equal(t, pgConn.FatalError(), (*reasons)[0].Err)
+		assert.NoError(t, <-serverErrChan)
+	})
+}
+
+// failingWriteConn is a net.Conn whose writes fail once failWrites is set.
+type failingWriteConn struct {
+	net.Conn
+	failWrites int32
+}
+
+func (c *failingWriteConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.failWrites) != 0 {
+		return 0, errors.New("broken pipe")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestConnFatalErrorWhileIdle(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, failWrites := range []bool{false, true} {
+		script := &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+				pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"}),
+			),
+		}
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+		var conn *failingWriteConn
+		dialFunc := config.DialFunc
+		config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			netConn, err := dialFunc(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			conn = &failingWriteConn{Conn: netConn}
+			return conn, nil
+		}
+
+		pgConn, err := pgconn.ConnectConfig(ctx, config)
+		require.NoError(t, err)
+		assert.Nil(t, pgConn.FatalError())
+
+		// The server has sent the error and closed the connection once the script is done.
+		require.NoError(t, <-serverErrChan)
+		if failWrites {
+			atomic.StoreInt32(&conn.failWrites, 1)
+		}
+
+		_, err = pgConn.Exec(ctx, "select 1").ReadAll()
+		var pgErr *pgconn.PgError
+		require.Truef(t, errors.As(err, &pgErr), "failWrites=%v: %v", failWrites, err)
+		assert.Equal(t, "57P01", pgErr.Code)
+		assert.Equal(t, failWrites, pgconn.SafeToRetry(err))
+
+		require.NotNil(t, pgConn.FatalError())
+		assert.Equal(t, "57P01", pgConn.FatalError().Code)
+		assert.True(t, pgConn.IsClosed())
+	}
+}
+
+func TestConnErrorSeverityUnlocalized(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "HINWEIS", SeverityUnlocalized: "NOTICE", Code: "00000", Message: "hallo"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FEHLER", SeverityUnlocalized: "ERROR", Code: "42601", Message: "Syntaxfehler"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 2"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "SCHWERWIEGEND", SeverityUnlocalized: "FATAL", Code: "57P01", Message: "Verbindung wird abgebrochen"}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	var notice *pgconn.Notice
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) { notice = n }
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	_, err = pgConn.Exec(ctx, "select 1").ReadAll()
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	assert.Equal(t, "FEHLER", pgErr.Severity)
+	assert.Equal(t, "ERROR", pgErr.SeverityUnlocalized)
+	require.NotNil(t, notice)
+	assert.Equal(t, "HINWEIS", notice.Severity)
+	assert.Equal(t, "NOTICE", notice.SeverityUnlocalized)
+	assert.False(t, pgConn.IsClosed())
+
+	// A FATAL error is recognized by the unlocalized severity.
+	_, err = pgConn.Exec(ctx, "select 2").ReadAll()
+	require.True(t, errors.As(err, &pgErr))
+	assert.Equal(t, "FATAL", pgErr.SeverityUnlocalized)
+	assert.True(t, pgConn.IsClosed())
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnSetConnContext(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select pg_sleep(10)"}),
+		pgmock.WaitForClose(),
+	)
+	connString, _ := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	connCtx, connCancel := context.WithCancel(context.Background())
+	defer connCancel()
+	pgConn.SetConnContext(connCtx)
+
+	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	require.NoError(t, err)
+
+	// Canceling the connection context interrupts an operation even though its own context cannot be canceled.
+	time.AfterFunc(50*time.Millisecond, connCancel)
+	_, err = pgConn.Exec(context.Background(), "select pg_sleep(10)").ReadAll()
+	require.Error(t, err)
+	assert.True(t, pgConn.IsClosed())
+}
+
+func TestConnIOStats(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Parse{Query: "select 1"}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	stats := pgConn.IOStats()
+	assert.EqualValues(t, 0, stats.BufferedUnread)
+	assert.Greater(t, stats.BufferedUnreadHighWater, int64(0))
+	assert.EqualValues(t, 0, stats.QueuedUnflushed)
+
+	parse, err := (&pgproto3.Parse{Query: "select 1"}).Encode(nil)
+	require.NoError(t, err)
+	require.NoError(t, pgConn.BufferMessage(&pgproto3.Parse{Query: "select 1"}))
+	stats = pgConn.IOStats()
+	assert.EqualValues(t, len(parse), stats.QueuedUnflushed)
+	assert.EqualValues(t, len(parse), stats.QueuedUnflushedHighWater)
+
+	require.NoError(t, pgConn.SendMessage(ctx, &pgproto3.Sync{}))
+	stats = pgConn.IOStats()
+	assert.EqualValues(t, 0, stats.QueuedUnflushed)
+	assert.EqualValues(t, len(parse), stats.QueuedUnflushedHighWater)
+
+	for i := 0; i < 2; i++ {
+		_, err = pgConn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 0, pgConn.IOStats().BufferedUnread)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnSendMessage(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Parse{Query: "select 1"}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	require.NoError(t, pgConn.BufferMessage(&pgproto3.Parse{Query: "select 1"}))
+	require.NoError(t, pgConn.SendMessage(ctx, &pgproto3.Sync{}))
+
+	msg, err := pgConn.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ParseComplete{}, msg)
+	msg, err = pgConn.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+
+	// The buffer is reused and previously sent messages are not sent again.
+	require.NoError(t, pgConn.SendMessage(ctx, &pgproto3.Sync{}))
+	msg, err = pgConn.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+
+	err = pgConn.BufferMessage(&pgproto3.Sync{})
+	require.EqualError(t, err, "conn closed")
+	err = pgConn.SendMessage(ctx, &pgproto3.Sync{})
+	require.EqualError(t, err, "conn closed")
+}
+
+func TestConnectTimeoutWithClock(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig("host=localhost port=5432 sslmode=disable")
+	require.NoError(t, err)
+	clock := newFakeClock()
+	config.Clock = clock
+	config.ConnectTimeout = time.Hour
+
+	dialStarted := make(chan struct{})
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		close(dialStarted)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	go func() {
+		<-dialStarted
+		clock.Advance(time.Hour)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pgconn.ConnectConfig(ctx, config)
+	require.Error(t, err)
+	assert.True(t, pgconn.Timeout(err))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.NoError(t, ctx.Err())
+}
+
+func TestReleaseResult(t *testing.T) {
+	t.Parallel()
+
+	field := func(name string) pgproto3.FieldDescription {
+		return pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{field("a"), field("b")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1"), []byte("2")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("3"), []byte("4")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{field("c")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("5")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{field("d")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	result := pgConn.ExecParams(ctx, "select a, b", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.FieldDescriptions, 2)
+	assert.Equal(t, [][][]byte{{[]byte("1"), []byte("2")}, {[]byte("3"), []byte("4")}}, result.Rows)
+	pgconn.ReleaseResult(result)
+
+	// A reused Result must not contain anything from the previous result.
+	result = pgConn.ExecParams(ctx, "select c", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.FieldDescriptions, 1)
+	assert.Equal(t, "c", string(result.FieldDescriptions[0].Name))
+	assert.Equal(t, [][][]byte{{[]byte("5")}}, result.Rows)
+	assert.Equal(t, "SELECT 1", result.CommandTag.String())
+	pgconn.ReleaseResult(result)
+
+	result = pgConn.ExecParams(ctx, "select d", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Nil(t, result.FieldDescriptions)
+	assert.Nil(t, result.Rows)
+	assert.Equal(t, "SELECT 0", result.CommandTag.String())
+	pgconn.ReleaseResult(result)
+	pgconn.ReleaseResult(nil)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+// textField returns the description of a text column as sent by the server.
+func textField(name string) pgproto3.FieldDescription {
+	return pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}
+}
+
+// extendedQuerySteps returns the pgmock steps for executing a query with ExecParams that returns rows.
+func extendedQuerySteps(fields []pgproto3.FieldDescription, rows ...[][]byte) []pgmock.Step {
+	steps := []pgmock.Step{
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields}),
+	}
+	for _, row := range rows {
+		steps = append(steps, pgmock.SendMessage(&pgproto3.DataRow{Values: row}))
+	}
+	return append(steps,
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("SELECT %d", len(rows)))}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	)
+}
+
+func TestConnFieldDescriptions(t *testing.T) {
+	t.Parallel()
+
+	fields := []pgproto3.FieldDescription{
+		{Name: []byte("name"), TableOID: 16384, TableAttributeNumber: 2, DataTypeOID: 1043, DataTypeSize: -1, TypeModifier: 14},
+		{Name: []byte("price"), TableOID: 16384, TableAttributeNumber: 3, DataTypeOID: 1700, DataTypeSize: -1, TypeModifier: (10<<16 | 2) + 4},
+		{Name: []byte("created_at"), TableOID: 16384, TableAttributeNumber: 4, DataTypeOID: 1184, DataTypeSize: 8, TypeModifier: 3},
+		{Name: []byte("n"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1, Format: pgconn.BinaryFormatCode},
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, extendedQuerySteps(fields, [][]byte{[]byte("a"), []byte("1.50"), nil, {0, 0, 0, 1}})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("other")}, [][]byte{nil})...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	result := pgConn.ExecParams(ctx, "select name, price, created_at, 1 from products", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	other := pgConn.ExecParams(ctx, "select other", nil, nil, nil, nil).Read()
+	require.NoError(t, other.Err)
+
+	// The field descriptions of the first result are not affected by the second query.
+	fds := result.FieldDescriptions
+	require.Len(t, fds, 4)
+	assert.Equal(t, fields, fds)
+	assert.Equal(t, []byte("other"), other.FieldDescriptions[0].Name)
+
+	assert.True(t, pgconn.FieldFromTable(fds[0]))
+	assert.False(t, pgconn.FieldFromTable(fds[3]))
+	assert.EqualValues(t, pgconn.TextFormatCode, fds[0].Format)
+	assert.EqualValues(t, pgconn.BinaryFormatCode, fds[3].Format)
+
+	length, ok := pgconn.FieldLength(fds[0])
+	assert.True(t, ok)
+	assert.EqualValues(t, 10, length)
+	_, ok = pgconn.FieldLength(fds[1])
+	assert.False(t, ok)
+
+	precision, scale, ok := pgconn.FieldPrecisionScale(fds[1])
+	assert.True(t, ok)
+	assert.EqualValues(t, 10, precision)
+	assert.EqualValues(t, 2, scale)
+	precision, scale, ok = pgconn.FieldPrecisionScale(fds[2])
+	assert.True(t, ok)
+	assert.EqualValues(t, 3, precision)
+	assert.EqualValues(t, 0, scale)
+	_, _, ok = pgconn.FieldPrecisionScale(fds[3])
+	assert.False(t, ok)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestFieldDescriptionTypeModifiers(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name      string
+		fd        pgproto3.FieldDescription
+		length    int64
+		lengthOK  bool
+		precision int64
+		scale     int64
+		precOK    bool
+	}{
+		{name: "varchar without length", fd: pgproto3.FieldDescription{DataTypeOID: 1043, TypeModifier: -1}},
+		{name: "char(1)", fd: pgproto3.FieldDescription{DataTypeOID: 1042, TypeModifier: 5}, length: 1, lengthOK: true},
+		{name: "bit(8)", fd: pgproto3.FieldDescription{DataTypeOID: 1560, TypeModifier: 8}, length: 8, lengthOK: true},
+		{name: "numeric without precision", fd: pgproto3.FieldDescription{DataTypeOID: 1700, TypeModifier: -1}},
+		{name: "timestamp without precision", fd: pgproto3.FieldDescription{DataTypeOID: 1114, TypeModifier: -1}},
+		{name: "interval day to second(2)", fd: pgproto3.FieldDescription{DataTypeOID: 1186, TypeModifier: 0x7fff0002}, precision: 2, precOK: true},
+		{name: "interval day to second", fd: pgproto3.FieldDescription{DataTypeOID: 1186, TypeModifier: 0x7fffffff}},
+		{name: "text", fd: pgproto3.FieldDescription{DataTypeOID: 25, TypeModifier: -1}},
+	} {
+		length, ok := pgconn.FieldLength(tt.fd)
+		assert.Equalf(t, tt.lengthOK, ok, "%s", tt.name)
+		assert.Equalf(t, tt.length, length, "%s", tt.name)
+
+		precision, scale, ok := pgconn.FieldPrecisionScale(tt.fd)
+		assert.Equalf(t, tt.precOK, ok, "%s", tt.name)
+		assert.Equalf(t, tt.precision, precision, "%s", tt.name)
+		assert.Equalf(t, tt.scale, scale, "%s", tt.name)
+	}
+}
+
+func TestConnExecRow(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a"), textField("b")}, [][]byte{[]byte("1"), nil})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a")})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a")}, [][]byte{[]byte("1")}, [][]byte{[]byte("2")})...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	values, commandTag, err := pgConn.ExecRow(ctx, "select a, b from t")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), nil}, values)
+	assert.Equal(t, "SELECT 1", commandTag.String())
+
+	var rowCountErr *pgconn.UnexpectedRowCountError
+	_, commandTag, err = pgConn.ExecRow(ctx, "select a from t where false")
+	require.ErrorAs(t, err, &rowCountErr)
+	assert.Equal(t, 0, rowCountErr.Rows)
+	assert.Equal(t, "SELECT 0", commandTag.String())
+
+	_, _, err = pgConn.ExecRow(ctx, "select a from t")
+	require.ErrorAs(t, err, &rowCountErr)
+	assert.Equal(t, 2, rowCountErr.Rows)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecScalar(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("count")}, [][]byte{[]byte("42")})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a")}, [][]byte{nil})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a")})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a")}, [][]byte{[]byte("1")}, [][]byte{[]byte("2")})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("a"), textField("b")}, [][]byte{[]byte("1"), []byte("2")})...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	value, err := pgConn.ExecScalar(ctx, "select count(*) from t")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("42"), value)
+
+	value, err = pgConn.ExecScalar(ctx, "select null")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	var rowCountErr *pgconn.UnexpectedRowCountError
+	_, err = pgConn.ExecScalar(ctx, "select a from t where false")
+	require.ErrorAs(t, err, &rowCountErr)
+	assert.Equal(t, 0, rowCountErr.Rows)
+
+	_, err = pgConn.ExecScalar(ctx, "select a from t")
+	require.ErrorAs(t, err, &rowCountErr)
+	assert.Equal(t, 2, rowCountErr.Rows)
+
+	var columnCountErr *pgconn.UnexpectedColumnCountError
+	_, err = pgConn.ExecScalar(ctx, "select a, b from t")
+	require.ErrorAs(t, err, &columnCountErr)
+	assert.Equal(t, 2, columnCountErr.Columns)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+// int4ParamEncoder encodes int32 values in binary format and defers to the text encoding of strings for other values.
+type int4ParamEncoder struct{}
+
+func (int4ParamEncoder) EncodeParam(value interface{}) ([]byte, uint32, int16, error) {
+	switch value := value.(type) {
+	case int32:
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, uint32(value))
+		return buf, 23, 1, nil
+	case string:
+		return []byte(value), 0, 0, nil
+	}
+	return nil, 0, 0, fmt.Errorf("unsupported type %T", value)
+}
+
+func TestConnExecParamsValues(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Parse{Query: "select $1, $2", ParameterOIDs: []uint32{23, 0}}),
+		pgmock.ExpectMessage(&pgproto3.Bind{ParameterFormatCodes: []int16{1, 0}, Parameters: [][]byte{{0, 0, 0, 42}, []byte("foo")}, ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("a"), textField("b")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("42"), []byte("foo")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectMessage(&pgproto3.Bind{PreparedStatement: "ps", ParameterFormatCodes: []int16{1}, Parameters: [][]byte{{0, 0, 0, 7}}, ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("a")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("7")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.ParamEncoder = int4ParamEncoder{}
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	result := pgConn.ExecParamsValues(ctx, "select $1, $2", int32(42), "foo").Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("42"), []byte("foo")}}, result.Rows)
+
+	// An encoding error is returned without using the connection.
+	_, err = pgConn.ExecParamsValues(ctx, "select $1", 1.5).Close()
+	require.EqualError(t, err, "cannot encode parameter 1: unsupported type float64")
+	assert.True(t, pgConn.IsIdle())
+
+	result = pgConn.ExecPreparedValues(ctx, "ps", int32(7)).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("7")}}, result.Rows)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecParamsStream(t *testing.T) {
+	t.Parallel()
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Parse{Query: "insert into t values ($1, $2, $3, $4)"}),
+		pgmock.ExpectMessage(&pgproto3.Bind{ParameterFormatCodes: []int16{0, 1, 0, 1}, Parameters: [][]byte{[]byte("a"), large, nil, {}}, ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.NoData{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectMessage(&pgproto3.Bind{PreparedStatement: "ps", Parameters: [][]byte{[]byte("xyz")}, ResultFormatCodes: []int16{1}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.NoData{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	params := []pgconn.StreamParam{
+		{Value: []byte("a")},
+		{Reader: bytes.NewReader(large), Len: len(large)},
+		{},
+		{Reader: strings.NewReader(""), Len: 0},
+	}
+	commandTag, err := pgConn.ExecParamsStream(ctx, "insert into t values ($1, $2, $3, $4)", params, nil, []int16{0, 1, 0, 1}, nil).Close()
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT 0 1", commandTag.String())
+
+	// Only Len bytes are read from Reader.
+	params = []pgconn.StreamParam{{Reader: strings.NewReader("xyz and more"), Len: 3}}
+	commandTag, err = pgConn.ExecPreparedStream(ctx, "ps", params, nil, []int16{1}).Close()
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT 0 1", commandTag.String())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecParamsStreamShortReader(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.WaitForClose())
+	// The server receives an incomplete message so its result is not checked.
+	connString, _ := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	params := []pgconn.StreamParam{{Reader: strings.NewReader("short"), Len: 10}}
+	_, err = pgConn.ExecParamsStream(ctx, "insert into t values ($1)", params, nil, nil, nil).Close()
+	require.EqualError(t, err, "cannot read parameter 1: unexpected EOF")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.True(t, pgConn.IsClosed())
+}
+
+func TestConnExecAuto(t *testing.T) {
+	t.Parallel()
+
+	sql := "select $1::text"
+	sum := sha256.Sum256([]byte(sql))
+	name := "pgconn_auto_" + hex.EncodeToString(sum[:16])
+
+	prepareSteps := []pgmock.Step{
+		pgmock.ExpectMessage(&pgproto3.Parse{Name: name, Query: sql}),
+		pgmock.ExpectMessage(&pgproto3.Describe{ObjectType: 'S', Name: name}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.ParameterDescription{ParameterOIDs: []uint32{25}}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("text")}}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
+	executeSteps := func(value string) []pgmock.Step {
+		return []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+			pgmock.ExpectMessage(&pgproto3.Sync{}),
+			pgmock.SendMessage(&pgproto3.BindComplete{}),
+			pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("text")}}),
+			pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte(value)}}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		}
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, prepareSteps...)
+	script.Steps = append(script.Steps, executeSteps("a")...)
+	script.Steps = append(script.Steps, executeSteps("b")...)
+	// The statement was deallocated on the server.
+	script.Steps = append(script.Steps,
+		pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "26000", Message: fmt.Sprintf("prepared statement \"%s\" does not exist", name)}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	)
+	script.Steps = append(script.Steps, prepareSteps...)
+	script.Steps = append(script.Steps, executeSteps("c")...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	for _, value := range []string{"a", "b", "c"} {
+		result := pgConn.ExecAuto(ctx, sql, []byte(value))
+		require.NoError(t, result.Err)
+		assert.Equal(t, [][][]byte{{[]byte(value)}}, result.Rows)
+	}
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnPoolerMode(t *testing.T) {
+	t.Parallel()
+
+	// A pooler that does not send BackendKeyData.
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "server_version", Value: "16.2"}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		},
+	}
+	// ExecAuto uses the unnamed statement.
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("text")}, [][]byte{[]byte("a")})...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString + " pooler_mode=detect")
+	require.NoError(t, err)
+	var dialCount int32
+	dialFunc := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dialCount, 1)
+		return dialFunc(ctx, network, address)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, &pgconn.Pooler{Detected: true}, pgConn.Pooler())
+
+	_, err = pgConn.Prepare(ctx, "ps1", "select 1", nil)
+	require.Error(t, err)
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	_, err = pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Close()
+	require.Error(t, err)
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	batch := &pgconn.Batch{}
+	batch.ExecPrepared("ps1", nil, nil, nil)
+	_, err = pgConn.ExecBatch(ctx, batch).ReadAll()
+	require.Error(t, err)
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	result := pgConn.ExecAuto(ctx, "select $1::text", []byte("a"))
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("a")}}, result.Rows)
+
+	err = pgConn.CancelRequest(ctx)
+	require.Error(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&dialCount))
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnPoolerDetection(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		mode          string
+		serverVersion string
+		pooler        *pgconn.Pooler
+	}{
+		{"off", "1.21.0/bouncer", nil},
+		{"detect", "16.2", nil},
+		{"detect", "1.21.0/bouncer", &pgconn.Pooler{Name: "pgbouncer", Detected: true, HasCancelKey: true}},
+		{"on", "16.2", &pgconn.Pooler{HasCancelKey: true}},
+	} {
+		script := &pgmock.Script{
+			Steps: []pgmock.Step{
+				pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+				pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+				pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 2}),
+				pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "server_version", Value: tt.serverVersion}),
+				pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+				pgmock.ExpectMessage(&pgproto3.Terminate{}),
+			},
+		}
+		connString, serverErrChan := runPgmockServer(t, script)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		pgConn, err := pgconn.Connect(ctx, connString+" pooler_mode="+tt.mode)
+		require.NoError(t, err)
+		assert.Equalf(t, tt.pooler, pgConn.Pooler(), "%s %s", tt.mode, tt.serverVersion)
+
+		hc, err := pgConn.Hijack()
+		require.NoError(t, err)
+		pgConn, err = pgconn.Construct(hc)
+		require.NoError(t, err)
+		assert.Equal(t, tt.pooler, pgConn.Pooler())
+
+		closeConn(t, pgConn)
+		assert.NoError(t, <-serverErrChan)
+		cancel()
+	}
+}
+
+func TestConnCopyToStream(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo to stdout"}),
+		pgmock.SendMessage(&pgproto3.CopyOutResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("22\n")}),
+		pgmock.SendMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COPY 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy bar to stdout"}),
+		pgmock.SendMessage(&pgproto3.CopyOutResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("3\n")}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	cr := pgConn.CopyToStream(ctx, "copy foo to stdout")
+	assert.True(t, pgConn.IsBusy())
+
+	p := make([]byte, 2)
+	n, err := cr.Read(p)
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", string(p[:n]))
+
+	rest, err := io.ReadAll(iotest.OneByteReader(cr))
+	require.NoError(t, err)
+	assert.Equal(t, "22\n", string(rest))
+
+	ct, err := cr.Close()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ct.RowsAffected())
+	assert.False(t, pgConn.IsBusy())
+
+	// Close discards unread data and returns the error of the command.
+	cr = pgConn.CopyToStream(ctx, "copy bar to stdout")
+	_, err = cr.Close()
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	assert.Equal(t, "57014", pgErr.Code)
+	_, err = cr.Read(p)
+	assert.Equal(t, pgErr, err)
+	assert.False(t, pgConn.IsBusy())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnCopyFromSendsDataOfEachRead(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("2\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("3\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COPY 3")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	// The data of each Read is sent right away in its own CopyData message.
+	r := io.MultiReader(strings.NewReader("1\n"), strings.NewReader("2\n"), strings.NewReader("3\n"))
+	ct, err := pgConn.CopyFrom(ctx, r, "copy foo from stdin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), ct.RowsAffected())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+// blockingReader blocks in Read until unblock is closed.
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, io.EOF
+}
+
+func TestConnCopyFromServerErrorWhileReaderBlocked(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement due to statement timeout"}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	r := &blockingReader{unblock: make(chan struct{})}
+	defer close(r.unblock)
+
+	ct, err := pgConn.CopyFrom(ctx, r, "copy foo from stdin")
+	assert.Nil(t, ct)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "57014", pgErr.Code)
+	assert.False(t, pgConn.IsClosed())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnCopyFromCanceledWhileReaderBlocked(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmockWaitStep(time.Second),
+	)
+	connString, _ := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	r := &blockingReader{unblock: make(chan struct{})}
+	defer close(r.unblock)
+
+	copyCtx, copyCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer copyCancel()
+
+	start := time.Now()
+	ct, err := pgConn.CopyFrom(copyCtx, r, "copy foo from stdin")
+	assert.Nil(t, ct)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 900*time.Millisecond)
+	assert.True(t, pgConn.IsClosed())
+}
+
+func TestConnCopyFromDispatchesAsyncMessages(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "NOTICE", Message: "row processed"}),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 2, Channel: "foo", Payload: "bar"}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COPY 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	var notices []string
+	notified := make(chan struct{})
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) {
+		notices = append(notices, n.Message)
+	}
+	config.OnNotification = func(_ *pgconn.PgConn, n *pgconn.Notification) {
+		close(notified)
+	}
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	// The data is only produced once the notification has been dispatched, so the notice and the notification must be
+	// dispatched while the copy is in progress.
+	pr, pw := io.Pipe()
+	go func() {
+		select {
+		case <-notified:
+		case <-ctx.Done():
+		}
+		pw.Write([]byte("1\n"))
+		pw.Close()
+	}()
+
+	ct, err := pgConn.CopyFrom(ctx, pr, "copy foo from stdin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), ct.RowsAffected())
+	assert.Equal(t, []string{"row processed"}, notices)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+// pgmockCloseStep closes the channel when the step is reached.
+type pgmockCloseStep chan struct{}
+
+func (s pgmockCloseStep) Step(*pgproto3.Backend) error {
+	close(s)
+	return nil
+}
+
+func TestConnCopyFromMaxBufferDelay(t *testing.T) {
+	t.Parallel()
+
+	firstReceived := make(chan struct{})
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmockCloseStep(firstReceived),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("2\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COPY 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.CopyFromMaxBufferDelay = 10 * time.Millisecond
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	// The second row is only produced after the server received the first, so the first must be sent while the Read
+	// for the second is blocked.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("1\n"))
+		select {
+		case <-firstReceived:
+		case <-ctx.Done():
+		}
+		pw.Write([]byte("2\n"))
+		pw.Close()
+	}()
+
+	ct, err := pgConn.CopyFrom(ctx, pr, "copy foo from stdin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ct.RowsAffected())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestCopyBetween(t *testing.T) {
+	t.Parallel()
+
+	srcScript := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	srcScript.Steps = append(srcScript.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo to stdout"}),
+		pgmock.SendMessage(&pgproto3.CopyOutResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("22\n")}),
+		pgmock.SendMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COPY 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo to stdout"}),
+		pgmock.SendMessage(&pgproto3.CopyOutResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("3\n")}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: "boom"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	srcConnString, srcErrChan := runPgmockServer(t, srcScript)
+
+	dstScript := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	dstScript.Steps = append(dstScript.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy bar from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("22\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COPY 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy bar from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("3\n")}),
+		pgmock.ExpectMessage(&pgproto3.CopyFail{Message: "ERROR: boom (SQLSTATE XX000)"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "COPY from stdin failed"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	dstConnString, dstErrChan := runPgmockServer(t, dstScript)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	src, err := pgconn.Connect(ctx, srcConnString)
+	require.NoError(t, err)
+	dst, err := pgconn.Connect(ctx, dstConnString)
+	require.NoError(t, err)
+
+	_, err = pgconn.CopyBetween(ctx, src, "copy bar from stdin", src, "copy foo to stdout", pgconn.CopyBetweenOptions{})
+	require.Error(t, err)
+
+	var progress []int64
+	ct, err := pgconn.CopyBetween(ctx, dst, "copy bar from stdin", src, "copy foo to stdout", pgconn.CopyBetweenOptions{
+		OnProgress: func(bytesCopied int64) { progress = append(progress, bytesCopied) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ct.RowsAffected())
+	assert.Equal(t, []int64{2, 5}, progress)
+
+	// An error of the source is returned and aborts the copy on the destination.
+	_, err = pgconn.CopyBetween(ctx, dst, "copy bar from stdin", src, "copy foo to stdout", pgconn.CopyBetweenOptions{})
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), "%v", err)
+	assert.Equal(t, "XX000", pgErr.Code)
+	assert.True(t, src.IsIdle())
+	assert.True(t, dst.IsIdle())
+
+	closeConn(t, src)
+	closeConn(t, dst)
+	assert.NoError(t, <-srcErrChan)
+	assert.NoError(t, <-dstErrChan)
+}
+
+// pgmockChanStep waits until the channel is closed.
+type pgmockChanStep <-chan struct{}
+
+func (s pgmockChanStep) Step(*pgproto3.Backend) error {
+	select {
+	case <-s:
+		return nil
+	case <-time.After(5 * time.Second):
+		return errors.New("timed out waiting for step to be released")
+	}
+}
+
+func TestCopyBetweenDestinationErrorWhileSourceBlocked(t *testing.T) {
+	t.Parallel()
+
+	// The source does not send more data until it is canceled.
+	canceledChan := make(chan struct{})
+	srcScript := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 1234, SecretKey: 5678}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "copy foo to stdout"}),
+			pgmock.SendMessage(&pgproto3.CopyOutResponse{ColumnFormatCodes: []uint16{0}}),
+			pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+			pgmockChanStep(canceledChan),
+			pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement due to user request"}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+	srcConnString, srcErrChan := runPgmockServer(t, srcScript)
+
+	dstScript := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	dstScript.Steps = append(dstScript.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "copy bar from stdin"}),
+		pgmock.SendMessage(&pgproto3.CopyInResponse{ColumnFormatCodes: []uint16{0}}),
+		pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("1\n")}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "22P02", Message: "invalid input syntax"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	dstConnString, dstErrChan := runPgmockServer(t, dstScript)
+
+	srcConfig, err := pgconn.ParseConfig(srcConnString)
+	require.NoError(t, err)
+	dialFunc := srcConfig.DialFunc
+	var dialCount int32
+	cancelRequestChan := make(chan []byte, 1)
+	srcConfig.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dialCount, 1) == 1 {
+			return dialFunc(ctx, network, address)
+		}
+		clientConn, serverConn := net.Pipe()
+		go func() {
+			defer serverConn.Close()
+			buf := make([]byte, 16)
+			_, err := io.ReadFull(serverConn, buf)
+			if err == nil {
+				cancelRequestChan <- buf
+			}
+			close(canceledChan)
+		}()
+		return clientConn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	src, err := pgconn.ConnectConfig(ctx, srcConfig)
+	require.NoError(t, err)
+	dst, err := pgconn.Connect(ctx, dstConnString)
+	require.NoError(t, err)
+
+	// The error of dst is returned rather than the error of src caused by canceling it.
+	_, err = pgconn.CopyBetween(ctx, dst, "copy bar from stdin", src, "copy foo to stdout", pgconn.CopyBetweenOptions{})
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), "%v", err)
+	assert.Equal(t, "22P02", pgErr.Code)
+
+	cancelRequest := <-cancelRequestChan
+	assert.Equal(t, uint32(1234), binary.BigEndian.Uint32(cancelRequest[8:12]))
+	assert.True(t, src.IsIdle())
+	assert.True(t, dst.IsIdle())
+
+	closeConn(t, src)
+	closeConn(t, dst)
+	assert.NoError(t, <-srcErrChan)
+	assert.NoError(t, <-dstErrChan)
+}
+
+func TestConnExecEmptyQueryResponse(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: " "}),
+		pgmock.SendMessage(&pgproto3.EmptyQueryResponse{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	mrr := pgConn.Exec(ctx, " ")
+	assert.False(t, mrr.NextResult())
+	require.NoError(t, mrr.Close())
+	assert.True(t, mrr.EmptyQuery())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+type deadlineRecordingConn struct {
+	net.Conn
+	deadlines []time.Time
+}
+
+func (c *deadlineRecordingConn) SetDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return c.Conn.SetDeadline(t)
+}
+
+func TestConnSetNextOperationDeadline(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	for i := 0; i < 2; i++ {
+		script.Steps = append(script.Steps,
+			pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		)
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.WaitForClose(),
+	)
+
+	connString, _ := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	var conn *deadlineRecordingConn
+	dialFunc := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		netConn, err := dialFunc(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		conn = &deadlineRecordingConn{Conn: netConn}
+		return conn, nil
+	}
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	conn.deadlines = nil
+
+	// The deadline is applied for the next operation only.
+	deadline := time.Now().Add(5 * time.Second)
+	pgConn.SetNextOperationDeadline(deadline)
+	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{deadline, {}}, conn.deadlines)
+
+	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, conn.deadlines, 2)
+
+	pgConn.SetNextOperationDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	require.Error(t, err)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+	assert.True(t, pgConn.IsClosed())
+}
+
+func TestConnWithStatementTimeout(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	showStatementTimeout := func() string {
+		result := pgConn.ExecParams(context.Background(), "show statement_timeout", nil, nil, nil, nil).Read()
+		require.NoError(t, result.Err)
+		return string(result.Rows[0][0])
+	}
+
+	_, err = pgConn.Exec(context.Background(), "set statement_timeout = '30s'").ReadAll()
+	require.NoError(t, err)
+
+	err = pgConn.WithStatementTimeout(context.Background(), 50*time.Millisecond, func() error {
+		assert.Equal(t, "50ms", showStatementTimeout())
+		_, err := pgConn.Exec(context.Background(), "select pg_sleep(1)").ReadAll()
+		return err
+	})
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "57014", pgErr.Code)
+	assert.Equal(t, "30s", showStatementTimeout())
+
+	// Within a transaction the setting is restored and also reverted by rolling back.
+	_, err = pgConn.Exec(context.Background(), "begin").ReadAll()
+	require.NoError(t, err)
+	err = pgConn.WithStatementTimeout(context.Background(), time.Second, func() error {
+		assert.Equal(t, "1s", showStatementTimeout())
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "30s", showStatementTimeout())
+	err = pgConn.WithStatementTimeout(context.Background(), 50*time.Millisecond, func() error {
+		_, err := pgConn.Exec(context.Background(), "select pg_sleep(1)").ReadAll()
+		return err
+	})
+	require.Error(t, err)
+	_, err = pgConn.Exec(context.Background(), "rollback").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "30s", showStatementTimeout())
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnWithStatementTimeoutRoundsUp(t *testing.T) {
+	t.Parallel()
+
+	fields := []pgproto3.FieldDescription{textField("current_setting"), textField("set_config")}
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectMessage(&pgproto3.Bind{Parameters: [][]byte{[]byte("1"), []byte("false")}, ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("0"), []byte("1ms")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	)
+	script.Steps = append(script.Steps,
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectMessage(&pgproto3.Bind{Parameters: [][]byte{[]byte("0"), []byte("false")}, ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: fields[1:]}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("0")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	// A timeout of zero would disable statement_timeout.
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		err = pgConn.WithStatementTimeout(ctx, timeout, func() error { return nil })
+		require.Error(t, err)
+	}
+
+	called := false
+	err = pgConn.WithStatementTimeout(ctx, 500*time.Microsecond, func() error {
+		called = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnWithStatementTimeoutClosesConnWhenTransactionLeftOpen(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	err = pgConn.WithStatementTimeout(context.Background(), time.Second, func() error {
+		_, err := pgConn.Exec(context.Background(), "begin").ReadAll()
+		return err
+	})
+	require.Error(t, err)
+	assert.True(t, pgConn.IsClosed())
+}
+
+func TestConnExecMultipleQueries(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	results, err := pgConn.Exec(context.Background(), "select 'Hello, world'; select 1").ReadAll()
+	assert.NoError(t, err)
+
+	assert.Len(t, results, 2)
+
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", string(results[0].CommandTag))
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+
+	assert.Nil(t, results[1].Err)
+	assert.Equal(t, "SELECT 1", string(results[1].CommandTag))
+	assert.Len(t, results[1].Rows, 1)
+	assert.Equal(t, "1", string(results[1].Rows[0][0]))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecMultipleQueriesEagerFieldDescriptions(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	mrr := pgConn.Exec(context.Background(), "select 'Hello, world' as msg; select 1 as num")
+
+	require.True(t, mrr.NextResult())
+	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
+	assert.Equal(t, []byte("msg"), mrr.ResultReader().FieldDescriptions()[0].Name)
+	_, err = mrr.ResultReader().Close()
+	require.NoError(t, err)
+
+	require.True(t, mrr.NextResult())
+	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
+	assert.Equal(t, []byte("num"), mrr.ResultReader().FieldDescriptions()[0].Name)
+	_, err = mrr.ResultReader().Close()
+	require.NoError(t, err)
+
+	require.False(t, mrr.NextResult())
+
+	require.NoError(t, mrr.Close())
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecMultipleQueriesError(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	results, err := pgConn.Exec(context.Background(), "select 1; select 1/0; select 1").ReadAll()
+	require.NotNil(t, err)
+	if pgErr, ok := err.(*pgconn.PgError); ok {
+		assert.Equal(t, "22012", pgErr.Code)
+	} else {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		// CockroachDB starts the second query result set and then sends the divide by zero error.
+		require.Len(t, results, 2)
+		assert.Len(t, results[0].Rows, 1)
+		assert.Equal(t, "1", string(results[0].Rows[0][0]))
+		assert.Len(t, results[1].Rows, 0)
+	} else {
+		// PostgreSQL sends the divide by zero and never sends the second query result set.
+		require.Len(t, results, 1)
+		assert.Len(t, results[0].Rows, 1)
+		assert.Equal(t, "1", string(results[0].Rows[0][0]))
+	}
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecMultipleQueriesErrorPgmock(t *testing.T) {
+	t.Parallel()
+
+	sql := "select 1; select 1/0; select 1"
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: sql}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("?column?")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "22012", Message: "division by zero"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	results, err := pgConn.Exec(ctx, sql).ReadAll()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "22012", pgErr.Code)
+
+	// The error does not produce a result of its own.
+	require.Len(t, results, 1)
+	assert.Equal(t, [][][]byte{{[]byte("1")}}, results[0].Rows)
+	assert.False(t, pgConn.IsBusy())
+
+	require.NoError(t, pgConn.Close(ctx))
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestMultiResultReaderSetContext(t *testing.T) {
+	t.Parallel()
+
+	sql := "select 1; select 2; select pg_sleep(10)"
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: sql}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("n")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmockWaitStep(100*time.Millisecond),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("n")}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("2")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.WaitForClose(),
+	)
+	// The connection is closed while the server is executing the last statement so its result is not checked.
+	connString, _ := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	firstCtx, firstCancel := context.WithCancel(ctx)
+	defer firstCancel()
+	mrr := pgConn.Exec(firstCtx, sql)
+	require.True(t, mrr.NextResult())
+	result := mrr.ResultReader().Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("1")}}, result.Rows)
+
+	// Canceling the context of the first result does not affect the second.
+	mrr.SetContext(ctx)
+	firstCancel()
+	require.True(t, mrr.NextResult())
+	result = mrr.ResultReader().Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("2")}}, result.Rows)
+
+	// The last statement has its own timeout.
+	lastCtx, lastCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer lastCancel()
+	mrr.SetContext(lastCtx)
+	assert.False(t, mrr.NextResult())
+	err = mrr.Close()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, pgConn.IsClosed())
+}
+
+func TestConnExecDeferredError(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	}
+
+	setupSQL := `create temporary table t (
+		id text primary key,
+		n int not null,
+		unique (n) deferrable initially deferred
+	);
+
+	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+
+	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
+	assert.NoError(t, err)
+
+	_, err = pgConn.Exec(context.Background(), `update t set n=n+1 where id='b' returning *`).ReadAll()
+	require.NotNil(t, err)
+
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	require.Equal(t, "23505", pgErr.Code)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	multiResult := pgConn.Exec(ctx, "select 'Hello, world', pg_sleep(1)")
+
+	for multiResult.NextResult() {
+	}
+	err = multiResult.Close()
+	assert.True(t, pgconn.Timeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
+}
+
+func TestConnExecContextPrecanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pgConn.Exec(ctx, "select 'Hello, world'").ReadAll()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
+	require.Len(t, result.FieldDescriptions(), 1)
+	assert.Equal(t, []byte("msg"), result.FieldDescriptions()[0].Name)
+
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	}
+	assert.Equal(t, 1, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, "SELECT 1", string(commandTag))
+	assert.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParamsText(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	msg := "Hello, world"
+	result := pgConn.ExecParamsText(context.Background(), "select $1::text, $2::int4 is null", &msg, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, 1)
+	assert.Equal(t, "Hello, world", string(result.Rows[0][0]))
+	assert.Equal(t, "t", string(result.Rows[0][1]))
+
+	result = pgConn.ExecParamsText(context.Background(), "select 1").Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, "1", string(result.Rows[0][0]))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParamsDeferredError(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	}
+
+	setupSQL := `create temporary table t (
+		id text primary key,
+		n int not null,
+		unique (n) deferrable initially deferred
+	);
+
+	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+
+	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
+	assert.NoError(t, err)
+
+	result := pgConn.ExecParams(context.Background(), `update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil).Read()
+	require.NotNil(t, result.Err)
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(result.Err, &pgErr))
+	require.Equal(t, "23505", pgErr.Code)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParamsMaxNumberOfParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	paramCount := math.MaxUint16
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
+
+	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, paramCount)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParamsTooManyParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	paramCount := math.MaxUint16 + 1
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
+
+	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
+	require.Error(t, result.Err)
+	require.Equal(t, "extended protocol limited to 65535 parameters", result.Err.Error())
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParamsCanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result := pgConn.ExecParams(ctx, "select current_database(), pg_sleep(1)", nil, nil, nil, nil)
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+	}
+	assert.Equal(t, 0, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, pgconn.CommandTag(nil), commandTag)
+	assert.True(t, pgconn.Timeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
+}
+
+func TestConnExecParamsPrecanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := pgConn.ExecParams(ctx, "select $1::text", [][]byte{[]byte("Hello, world")}, nil, nil, nil).Read()
+	require.Error(t, result.Err)
+	assert.True(t, errors.Is(result.Err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(result.Err))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecParamsEmptySQL(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	result := pgConn.ExecParams(ctx, "", nil, nil, nil, nil).Read()
+	assert.Nil(t, result.CommandTag)
+	assert.Len(t, result.Rows, 0)
+	assert.NoError(t, result.Err)
+	assert.True(t, result.EmptyQuery)
+
+	result = pgConn.ExecParams(ctx, "select 1 where false", nil, nil, nil, nil).Read()
+	assert.NoError(t, result.Err)
+	assert.False(t, result.EmptyQuery)
+
+	ensureConnValid(t, pgConn)
+}
+
+// https://github.com/jackc/pgx/issues/859
+func TestResultReaderValuesHaveSameCapacityAsLength(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
+	require.Len(t, result.FieldDescriptions(), 1)
+	assert.Equal(t, []byte("msg"), result.FieldDescriptions()[0].Name)
+
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+		assert.Equal(t, len(result.Values()[0]), cap(result.Values()[0]))
+	}
+	assert.Equal(t, 1, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, "SELECT 1", string(commandTag))
+	assert.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestResultReaderNextColumnBatch(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, extendedQuerySteps(
+		[]pgproto3.FieldDescription{textField("id"), textField("name")},
+		[][]byte{[]byte("1"), []byte("foo")},
+		[][]byte{[]byte("2"), nil},
+		[][]byte{[]byte("3"), []byte("")},
+	)...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	rr := pgConn.ExecParams(ctx, "select id, name from t", nil, nil, nil, nil)
+
+	var batch pgconn.ColumnBatch
+	require.True(t, rr.NextColumnBatch(&batch, 2))
+	require.Equal(t, 2, batch.Len)
+	require.Len(t, batch.Fields, 2)
+	assert.Equal(t, []byte("name"), batch.Fields[1].Name)
+	require.Len(t, batch.Columns, 2)
+	assert.Equal(t, []byte("12"), batch.Columns[0].Data)
+	assert.Equal(t, []int{0, 1, 2}, batch.Columns[0].Offsets)
+	assert.Equal(t, []byte("foo"), batch.Columns[1].Value(0))
+	assert.Nil(t, batch.Columns[1].Value(1))
+	assert.Equal(t, []bool{false, true}, batch.Columns[1].Nulls)
+
+	// The batch is reused.
+	require.True(t, rr.NextColumnBatch(&batch, 2))
+	require.Equal(t, 1, batch.Len)
+	assert.Equal(t, []byte("3"), batch.Columns[0].Value(0))
+	assert.Equal(t, []byte{}, batch.Columns[1].Value(0))
+	assert.Equal(t, []bool{false}, batch.Columns[1].Nulls)
+
+	assert.False(t, rr.NextColumnBatch(&batch, 2))
+	assert.Equal(t, 0, batch.Len)
+
+	commandTag, err := rr.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 3", commandTag.String())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestResultReaderDiscard(t *testing.T) {
+	t.Parallel()
+
+	large := bytes.Repeat([]byte("x"), 100000)
+	steps := extendedQuerySteps([]pgproto3.FieldDescription{textField("a")}, [][]byte{[]byte("1")}, [][]byte{large}, [][]byte{[]byte("3")}, [][]byte{nil})
+	// A notice between the rows is still handled.
+	steps = append(steps[:len(steps)-3], append([]pgmock.Step{pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "NOTICE", Message: "hello"})}, steps[len(steps)-3:]...)...)
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, steps...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("b")}, [][]byte{[]byte("after")})...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	var notices []string
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) {
+		notices = append(notices, n.Message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	rr := pgConn.ExecParams(ctx, "select a from t", nil, nil, nil, nil)
+	require.True(t, rr.NextRow())
+	assert.Equal(t, [][]byte{[]byte("1")}, rr.Values())
+	commandTag, err := rr.Discard()
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 4", commandTag.String())
+	assert.Equal(t, []string{"hello"}, notices)
+	assert.EqualValues(t, 0, pgConn.IOStats().BufferedUnread)
+
+	// Discarding a closed ResultReader returns the same result as Close.
+	commandTag, err = rr.Discard()
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 4", commandTag.String())
+
+	result := pgConn.ExecParams(ctx, "select b", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("after")}}, result.Rows)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestStatsChunkReaderRejectsUnexpectedReads(t *testing.T) {
+	t.Parallel()
+
+	msg, err := (&pgproto3.DataRow{Values: [][]byte{[]byte("ab")}}).Encode(nil)
+	require.NoError(t, err)
+
+	// A Frontend that does not read the header first fails instead of misinterpreting the stream.
+	cr := pgconn.NewStatsChunkReader(bytes.NewReader(msg))
+	_, err = cr.Next(3)
+	require.EqualError(t, err, "Frontend read 3 bytes instead of the 5 byte message header (unsupported pgproto3 version?)")
+
+	cr = pgconn.NewStatsChunkReader(bytes.NewReader(msg))
+	header, err := cr.Next(5)
+	require.NoError(t, err)
+	assert.Equal(t, msg[:5], header)
+	_, err = cr.Next(2)
+	require.EqualError(t, err, "Frontend read 2 bytes instead of the 8 byte message body (unsupported pgproto3 version?)")
+	body, err := cr.Next(8)
+	require.NoError(t, err)
+	assert.Equal(t, msg[5:], body)
+}
+
+func TestResultReaderRawRow(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 'ab', null"}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			{Name: []byte("a"), DataTypeOID: 25},
+			{Name: []byte("b"), DataTypeOID: 25},
+		}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("ab"), nil}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{nil, []byte("c")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	mrr := pgConn.Exec(ctx, "select 'ab', null")
+	require.True(t, mrr.NextResult())
+	rr := mrr.ResultReader()
+	require.True(t, rr.NextRow())
+	assert.Equal(t, []byte{0, 2, 0, 0, 0, 2, 'a', 'b', 0xff, 0xff, 0xff, 0xff}, rr.RawRow())
+	require.True(t, rr.NextRow())
+	assert.Equal(t, []byte{0, 2, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1, 'c'}, rr.RawRow())
+	assert.False(t, rr.NextRow())
+	assert.Nil(t, rr.RawRow())
+	_, err = rr.Close()
+	require.NoError(t, err)
+	require.NoError(t, mrr.Close())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecPrepared(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	psd, err := pgConn.Prepare(context.Background(), "ps1", "select $1::text as msg", nil)
+	require.NoError(t, err)
+	require.NotNil(t, psd)
+	assert.Len(t, psd.ParamOIDs, 1)
+	assert.Len(t, psd.Fields, 1)
+
+	result := pgConn.ExecPrepared(context.Background(), "ps1", [][]byte{[]byte("Hello, world")}, nil, nil)
+	require.Len(t, result.FieldDescriptions(), 1)
+	assert.Equal(t, []byte("msg"), result.FieldDescriptions()[0].Name)
+
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	}
+	assert.Equal(t, 1, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, "SELECT 1", string(commandTag))
+	assert.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecPreparedMaxNumberOfParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	paramCount := math.MaxUint16
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
+
+	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
+	require.NoError(t, err)
+	require.NotNil(t, psd)
+	assert.Len(t, psd.ParamOIDs, paramCount)
+	assert.Len(t, psd.Fields, 1)
+
+	result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, paramCount)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecPreparedTooManyParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	paramCount := math.MaxUint16 + 1
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
+
+	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		// CockroachDB rejects preparing a statement with more than 65535 parameters.
+		require.EqualError(t, err, "ERROR: more than 65535 arguments to prepared statement: 65536 (SQLSTATE 08P01)")
+	} else {
+		// PostgreSQL accepts preparing a statement with more than 65535 parameters and only fails when executing it through the extended protocol.
+		require.NoError(t, err)
+		require.NotNil(t, psd)
+		assert.Len(t, psd.ParamOIDs, paramCount)
+		assert.Len(t, psd.Fields, 1)
+
+		result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
+		require.EqualError(t, result.Err, "extended protocol limited to 65535 parameters")
+	}
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecPreparedCanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil)
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+	}
+	assert.Equal(t, 0, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, pgconn.CommandTag(nil), commandTag)
+	assert.True(t, pgconn.Timeout(err))
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
+}
+
+func TestConnExecPreparedPrecanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
+	require.Error(t, result.Err)
+	assert.True(t, errors.Is(result.Err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(result.Err))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecPreparedEmptySQL(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	_, err = pgConn.Prepare(ctx, "ps1", "", nil)
+	require.NoError(t, err)
+
+	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
+	assert.Nil(t, result.CommandTag)
+	assert.Len(t, result.Rows, 0)
+	assert.NoError(t, result.Err)
+	assert.True(t, result.EmptyQuery)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecBatch(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
+	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
+	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	require.Len(t, results[0].Rows, 1)
+	require.Equal(t, "ExecParams 1", string(results[0].Rows[0][0]))
+	assert.Equal(t, "SELECT 1", string(results[0].CommandTag))
+
+	require.Len(t, results[1].Rows, 1)
+	require.Equal(t, "ExecPrepared 1", string(results[1].Rows[0][0]))
+	assert.Equal(t, "SELECT 1", string(results[1].CommandTag))
+
+	require.Len(t, results[2].Rows, 1)
+	require.Equal(t, "ExecParams 2", string(results[2].Rows[0][0]))
+	assert.Equal(t, "SELECT 1", string(results[2].CommandTag))
+}
+
+func TestConnExecStatements(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	sql := `create temporary table t (id int, name text);
+insert into t values ($1, $2), ($3, 'semi;colon');
+-- a comment; with a semicolon
+select name from t where id = $3;`
+	results, err := pgConn.ExecStatements(context.Background(), sql, []byte("1"), []byte("one"), []byte("2")).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	assert.Equal(t, "CREATE TABLE", string(results[0].CommandTag))
+	assert.Equal(t, "INSERT 0 2", string(results[1].CommandTag))
+	require.Len(t, results[2].Rows, 1)
+	assert.Equal(t, "semi;colon", string(results[2].Rows[0][0]))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecStatementsMissingParamValue(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	_, err = pgConn.ExecStatements(ctx, "select $1; select $2", []byte("1")).ReadAll()
+	require.EqualError(t, err, "sql references parameter $2 but only 1 parameter values were provided")
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecBatchReset(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	for _, query := range []string{"select 1", "select 2"} {
+		script.Steps = append(script.Steps,
+			pgmock.ExpectMessage(&pgproto3.Parse{Query: query}),
+			pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		)
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Sync{}))
+	for _, value := range []string{"1", "2"} {
+		script.Steps = append(script.Steps,
+			pgmock.SendMessage(&pgproto3.ParseComplete{}),
+			pgmock.SendMessage(&pgproto3.BindComplete{}),
+			pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{textField("n")}}),
+			pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte(value)}}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		)
+	}
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	// After Reset only the new query is sent.
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Parse{Query: "select 3"}))
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("n")}, [][]byte{[]byte("3")})[1:]...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+	assert.Equal(t, 0, batch.Len())
+	batch.ExecParams("select 1", nil, nil, nil, nil)
+	batch.ExecParams("select 2", nil, nil, nil, nil)
+	assert.Equal(t, 2, batch.Len())
+
+	results, err := pgConn.ExecBatch(ctx, batch).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, [][][]byte{{[]byte("2")}}, results[1].Rows)
+
+	batch.Reset()
+	assert.Equal(t, 0, batch.Len())
+	batch.ExecParams("select 3", nil, nil, nil, nil)
+	assert.Equal(t, 1, batch.Len())
+
+	results, err = pgConn.ExecBatch(ctx, batch).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, [][][]byte{{[]byte("3")}}, results[0].Rows)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecBatchDeferredError(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	}
+
+	setupSQL := `create temporary table t (
+		id text primary key,
+		n int not null,
+		unique (n) deferrable initially deferred
+	);
+
+	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+
+	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams(`update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil)
+	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	require.NotNil(t, err)
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	require.Equal(t, "23505", pgErr.Code)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecBatchPrecanceled(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
+	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pgConn.ExecBatch(ctx, batch).ReadAll()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	ensureConnValid(t, pgConn)
+}
+
+// Without concurrent reading and writing large batches can deadlock.
+//
+// See https://github.com/jackc/pgx/issues/374.
+func TestConnExecBatchHuge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	batch := &pgconn.Batch{}
+
+	queryCount := 100000
+	args := make([]string, queryCount)
+
+	for i := range args {
+		args[i] = strconv.Itoa(i)
+		batch.ExecParams("select $1::text", [][]byte{[]byte(args[i])}, nil, nil, nil)
+	}
+
+	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, queryCount)
+
+	for i := range args {
+		require.Len(t, results[i].Rows, 1)
+		require.Equal(t, args[i], string(results[i].Rows[0][0]))
+		assert.Equal(t, "SELECT 1", string(results[i].CommandTag))
+	}
+}
+
+func TestConnExecBatchImplicitTransaction(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Skipping due to known server issue: (https://github.com/cockroachdb/cockroach/issues/44803)")
+	}
+
+	_, err = pgConn.Exec(context.Background(), "create temporary table t(id int)").ReadAll()
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams("insert into t(id) values(1)", nil, nil, nil, nil)
+	batch.ExecParams("insert into t(id) values(2)", nil, nil, nil, nil)
+	batch.ExecParams("insert into t(id) values(3)", nil, nil, nil, nil)
+	batch.ExecParams("select 1/0", nil, nil, nil, nil)
+	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	require.Error(t, err)
+
+	result := pgConn.ExecParams(context.Background(), "select count(*) from t", nil, nil, nil, nil).Read()
+	require.Equal(t, "0", string(result.Rows[0][0]))
+}
+
+func TestConnExecBatchContinueOnError(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	_, err = pgConn.Exec(context.Background(), "create temporary table t(id int primary key)").ReadAll()
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+	batch.ExecParams("insert into t(id) values(1)", nil, nil, nil, nil)
+	batch.ExecParams("insert into t(id) values(1)", nil, nil, nil, nil)
+	batch.ExecParams("select 'a' || 1/0", nil, nil, nil, nil)
+	batch.ExecParams("insert into t(id) values(2)", nil, nil, nil, nil)
+	results, err := pgConn.ExecBatchContinueOnError(context.Background(), batch).ReadAll()
+	require.Error(t, err)
+	require.IsType(t, &pgconn.PgError{}, err)
+	assert.Equal(t, "23505", err.(*pgconn.PgError).Code)
+	require.Len(t, results, 4)
+
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "INSERT 0 1", string(results[0].CommandTag))
+	require.IsType(t, &pgconn.PgError{}, results[1].Err)
+	assert.Equal(t, "23505", results[1].Err.(*pgconn.PgError).Code)
+	require.IsType(t, &pgconn.PgError{}, results[2].Err)
+	assert.Equal(t, "22012", results[2].Err.(*pgconn.PgError).Code)
+	assert.NoError(t, results[3].Err)
+	assert.Equal(t, "INSERT 0 1", string(results[3].CommandTag))
+
+	// Each query is committed independently.
+	result := pgConn.ExecParams(context.Background(), "select count(*) from t", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Equal(t, "2", string(result.Rows[0][0]))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnExecBatchContinueOnErrorSendsSyncPerQuery(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	for i := 0; i < 3; i++ {
+		script.Steps = append(script.Steps,
+			pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+			pgmock.ExpectMessage(&pgproto3.Sync{}),
+		)
+	}
+	script.Steps = append(script.Steps,
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.NoData{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42601", Message: "syntax error"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("n"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1}}}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "22012", Message: "division by zero"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+	batch.ExecParams("insert into t values (1)", nil, nil, nil, nil)
+	batch.ExecParams("insert into", nil, nil, nil, nil)
+	batch.ExecParams("select 1/0", nil, nil, nil, nil)
+	results, err := pgConn.ExecBatchContinueOnError(ctx, batch).ReadAll()
+	require.Error(t, err)
+	assert.Equal(t, "42601", err.(*pgconn.PgError).Code)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "INSERT 0 1", string(results[0].CommandTag))
+	assert.Equal(t, "42601", results[1].Err.(*pgconn.PgError).Code)
+	assert.Equal(t, "22012", results[2].Err.(*pgconn.PgError).Code)
+	assert.False(t, pgConn.IsBusy())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnExecPreparedMany(t *testing.T) {
+	t.Parallel()
+
+	bindSteps := func(value string) []pgmock.Step {
+		return []pgmock.Step{
+			pgmock.ExpectMessage(&pgproto3.Bind{PreparedStatement: "ins", Parameters: [][]byte{[]byte(value)}, ResultFormatCodes: []int16{}}),
+			pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		}
+	}
+	insertedSteps := []pgmock.Step{
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.NoData{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 1")}),
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	// Five parameter sets with a Sync after every two.
+	for i, value := range []string{"1", "2", "3", "4", "5"} {
+		script.Steps = append(script.Steps, bindSteps(value)...)
+		if i%2 == 1 || i == 4 {
+			script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Sync{}))
+		}
+	}
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	script.Steps = append(script.Steps,
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "23505", Message: "duplicate key value violates unique constraint"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	)
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}))
+
+	// Two parameter sets with a single Sync.
+	script.Steps = append(script.Steps, bindSteps("6")...)
+	script.Steps = append(script.Steps, bindSteps("7")...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Sync{}))
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps,
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	paramSets := [][][]byte{{[]byte("1")}, {[]byte("2")}, {[]byte("3")}, {[]byte("4")}, {[]byte("5")}}
+	results, err := pgConn.ExecPreparedMany(ctx, "ins", paramSets, nil, nil, 2).ReadAll()
+	require.Error(t, err)
+	assert.Equal(t, "23505", err.(*pgconn.PgError).Code)
+
+	// The fourth parameter set is skipped because it is in the same group as the failing third.
+	require.Len(t, results, 4)
+	assert.Equal(t, "INSERT 0 1", string(results[0].CommandTag))
+	assert.Equal(t, "INSERT 0 1", string(results[1].CommandTag))
+	assert.Equal(t, "23505", results[2].Err.(*pgconn.PgError).Code)
+	assert.Equal(t, "INSERT 0 1", string(results[3].CommandTag))
+	assert.True(t, pgConn.IsIdle())
+
+	paramSets = [][][]byte{{[]byte("6")}, {[]byte("7")}}
+	results, err = pgConn.ExecPreparedMany(ctx, "ins", paramSets, nil, nil, 0).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnDefaultResultFormat(t *testing.T) {
+	t.Parallel()
+
+	bindSteps := func(stmtName, value string, resultFormats []int16) []pgmock.Step {
+		return []pgmock.Step{
+			pgmock.ExpectMessage(&pgproto3.Bind{PreparedStatement: stmtName, Parameters: [][]byte{[]byte(value)}, ResultFormatCodes: resultFormats}),
+			pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+			pgmock.ExpectMessage(&pgproto3.Sync{}),
+		}
+	}
+	insertedSteps := []pgmock.Step{
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.NoData{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectAnyMessage(&pgproto3.Parse{}))
+	script.Steps = append(script.Steps, bindSteps("", "1", []int16{pgconn.BinaryFormatCode})...)
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.ParseComplete{}))
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps, bindSteps("ps", "2", []int16{})...)
+	script.Steps = append(script.Steps, insertedSteps...)
+	script.Steps = append(script.Steps, bindSteps("ps", "3", []int16{pgconn.BinaryFormatCode})...)
+	script.Steps = append(script.Steps, bindSteps("ps", "4", []int16{pgconn.TextFormatCode})...)
+	script.Steps = append(script.Steps, bindSteps("ps", "5", []int16{pgconn.BinaryFormatCode})...)
+	for i := 0; i < 3; i++ {
+		script.Steps = append(script.Steps, insertedSteps...)
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	config.DefaultResultFormat = pgconn.BinaryFormatCode
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	_, err = pgConn.ExecParams(ctx, "insert into t values ($1)", [][]byte{[]byte("1")}, nil, nil, nil).Close()
+	require.NoError(t, err)
+
+	// Explicit result formats are not changed.
+	_, err = pgConn.ExecPrepared(ctx, "ps", [][]byte{[]byte("2")}, nil, []int16{}).Close()
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+	batch.ExecPrepared("ps", [][]byte{[]byte("3")}, nil, nil)
+	batch.ExecPrepared("ps", [][]byte{[]byte("4")}, nil, []int16{pgconn.TextFormatCode})
+	batch.ExecPrepared("ps", [][]byte{[]byte("5")}, nil, nil)
+	results, err := pgConn.ExecBatchContinueOnError(ctx, batch).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.True(t, pgConn.IsIdle())
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestRetryConnRetriesOnNewConnection(t *testing.T) {
+	t.Parallel()
+
+	script1 := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString1, serverErrChan1 := runPgmockServer(t, script1)
+
+	script2 := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+			pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Bind{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+			pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+			pgmock.ExpectMessage(&pgproto3.Sync{}),
+			pgmock.SendMessage(&pgproto3.ParseComplete{}),
+			pgmock.SendMessage(&pgproto3.BindComplete{}),
+			pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("n"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1}}}),
+			pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1")}}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		),
+	}
+	connString2, serverErrChan2 := runPgmockServer(t, script2)
+	config2, err := pgconn.ParseConfig(connString2)
+	require.NoError(t, err)
+
+	config, err := pgconn.ParseConfig(connString1)
+	require.NoError(t, err)
+	dialFunc := config.DialFunc
+	var dialCount int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dialCount, 1) > 1 {
+			address = net.JoinHostPort(config2.Host, strconv.Itoa(int(config2.Port)))
+		}
+		return dialFunc(ctx, network, address)
+	}
+
+	var backoffs []int
+	policy := pgconn.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff: func(n int) time.Duration {
+			backoffs = append(backoffs, n)
+			return time.Millisecond
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rc, err := pgconn.ConnectRetryConn(ctx, config, policy)
+	require.NoError(t, err)
+	firstConn := rc.PgConn()
+	require.NoError(t, firstConn.Close(ctx))
+	require.NoError(t, <-serverErrChan1)
+
+	result := rc.ExecParams(ctx, "select 1", nil, nil, nil, nil)
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, 1)
+	assert.Equal(t, "1", string(result.Rows[0][0]))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&dialCount))
+	assert.Equal(t, []int{1}, backoffs)
+	assert.NotEqual(t, firstConn, rc.PgConn())
+
+	require.NoError(t, rc.Close(ctx))
+	require.NoError(t, <-serverErrChan2)
+}
+
+func TestRetryConnAfterReconnect(t *testing.T) {
+	t.Parallel()
+
+	// The first server closes the connection while the client is waiting for a notification.
+	script1 := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	connString1, serverErrChan1 := runPgmockServer(t, script1)
+
+	script2 := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "listen foo"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("LISTEN")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "foo", Payload: "bar"}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		),
+	}
+	connString2, serverErrChan2 := runPgmockServer(t, script2)
+	config2, err := pgconn.ParseConfig(connString2)
+	require.NoError(t, err)
+
+	config, err := pgconn.ParseConfig(connString1)
+	require.NoError(t, err)
+	// The cancel request sent when the first connection breaks does not use the context of the operation. It is sent to
+	// a connection that discards it so the second server only receives the new connection.
+	type connectKey struct{}
+	dialFunc := config.DialFunc
+	var dialCount int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if ctx.Value(connectKey{}) == nil {
+			client, server := net.Pipe()
+			go func() {
+				io.ReadFull(server, make([]byte, 16))
+				server.Close()
+			}()
+			return client, nil
+		}
+		if atomic.AddInt32(&dialCount, 1) > 1 {
+			address = net.JoinHostPort(config2.Host, strconv.Itoa(int(config2.Port)))
+		}
+		return dialFunc(ctx, network, address)
+	}
+	var notification *pgconn.Notification
+	config.OnNotification = func(_ *pgconn.PgConn, n *pgconn.Notification) {
+		notification = n
+	}
+
+	var reconnects int
+	policy := pgconn.RetryPolicy{
+		MaxAttempts: 3,
+		AfterReconnect: func(ctx context.Context, pgConn *pgconn.PgConn) error {
+			reconnects++
+			_, err := pgConn.Exec(ctx, "listen foo").ReadAll()
+			return err
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), connectKey{}, true), 5*time.Second)
+	defer cancel()
+
+	rc, err := pgconn.ConnectRetryConn(ctx, config, policy)
+	require.NoError(t, err)
+	firstConn := rc.PgConn()
+
+	require.NoError(t, rc.WaitForNotification(ctx))
+	require.NoError(t, <-serverErrChan1)
+	assert.Equal(t, 1, reconnects)
+	require.NotNil(t, notification)
+	assert.Equal(t, "bar", notification.Payload)
+	assert.True(t, firstConn != rc.PgConn())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&dialCount))
+
+	require.NoError(t, rc.Close(ctx))
+	require.NoError(t, <-serverErrChan2)
+}
+
+func TestRetryConnDoesNotRetryUnsafeErrors(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+			pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement due to user request"}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		),
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	rc, err := pgconn.ConnectRetryConn(ctx, config, pgconn.RetryPolicy{MaxAttempts: 3})
+	require.NoError(t, err)
+
+	_, err = rc.Exec(ctx, "select 1")
+	require.Error(t, err)
+	assert.Equal(t, "57014", err.(*pgconn.PgError).Code)
+
+	require.NoError(t, rc.Close(ctx))
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestRetryConnStopsAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString, _ := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	dialFunc := config.DialFunc
+	var dialCount int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dialCount, 1) > 1 {
+			return nil, errors.New("server unavailable")
+		}
+		return dialFunc(ctx, network, address)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rc, err := pgconn.ConnectRetryConn(ctx, config, pgconn.RetryPolicy{MaxAttempts: 3})
+	require.NoError(t, err)
+	require.NoError(t, rc.Close(ctx))
+
+	_, err = rc.Exec(ctx, "select 1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server unavailable")
+	assert.EqualValues(t, 3, atomic.LoadInt32(&dialCount))
+
+	// ExecParams reports the error of the failed reconnect, not that of its first attempt.
+	result := rc.ExecParams(ctx, "select 1", nil, nil, nil, nil)
 	require.Error(t, result.Err)
-	assert.True(t, errors.Is(result.Err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(result.Err))
+	assert.Contains(t, result.Err.Error(), "server unavailable")
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	healthChecks := 0
+	pool := pgconn.NewPool(config, pgconn.PoolConfig{
+		MaxConns: 1,
+		HealthCheck: func(ctx context.Context, pgConn *pgconn.PgConn) error {
+			healthChecks++
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pc, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	firstConn := pc.PgConn()
+	assert.Equal(t, 0, healthChecks)
+
+	// The pool is at MaxConns so Acquire waits until the context is done.
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = pool.Acquire(waitCtx)
+	waitCancel()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	pc.Release()
+	pc.Release()
+
+	firstPC := pc
+	pc, err = pool.Acquire(ctx)
+	require.NoError(t, err)
+	assert.Same(t, firstConn, pc.PgConn())
+	assert.Equal(t, 1, healthChecks)
+
+	// Releasing the first PooledConn again does not release the connection of the new holder.
+	firstPC.Release()
+	waitCtx, waitCancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = pool.Acquire(waitCtx)
+	waitCancel()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	pc.Release()
+
+	pool.Close()
+	assert.True(t, firstConn.IsClosed())
+	require.NoError(t, <-serverErrChan)
+
+	_, err = pool.Acquire(ctx)
+	require.EqualError(t, err, "pool is closed")
+}
+
+func TestPoolMaxConnLifetime(t *testing.T) {
+	t.Parallel()
+
+	script1 := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString1, serverErrChan1 := runPgmockServer(t, script1)
+
+	script2 := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString2, serverErrChan2 := runPgmockServer(t, script2)
+	config2, err := pgconn.ParseConfig(connString2)
+	require.NoError(t, err)
+
+	config, err := pgconn.ParseConfig(connString1)
+	require.NoError(t, err)
+	dialFunc := config.DialFunc
+	var dialCount int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dialCount, 1) > 1 {
+			address = net.JoinHostPort(config2.Host, strconv.Itoa(int(config2.Port)))
+		}
+		return dialFunc(ctx, network, address)
+	}
+
+	clock := newFakeClock()
+	config.Clock = clock
+
+	pool := pgconn.NewPool(config, pgconn.PoolConfig{MaxConns: 2, MaxConnLifetime: time.Hour})
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pc, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	firstConn := pc.PgConn()
+	clock.Advance(time.Hour + time.Second)
+
+	// The expired connection is closed on release and a new connection is established.
+	pc.Release()
+	assert.True(t, firstConn.IsClosed())
+	require.NoError(t, <-serverErrChan1)
+
+	pc, err = pool.Acquire(ctx)
+	require.NoError(t, err)
+	assert.NotSame(t, firstConn, pc.PgConn())
+	pc.Release()
+
+	pool.Close()
+	require.NoError(t, <-serverErrChan2)
+}
+
+func TestConnLocking(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	mrr := pgConn.Exec(context.Background(), "select 'Hello, world'")
+	_, err = pgConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
+	assert.Error(t, err)
+	assert.Equal(t, "conn busy", err.Error())
+	assert.True(t, pgconn.SafeToRetry(err))
+
+	results, err := mrr.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", string(results[0].CommandTag))
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestCommandTag(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		commandTag   pgconn.CommandTag
+		rowsAffected int64
+		isInsert     bool
+		isUpdate     bool
+		isDelete     bool
+		isSelect     bool
+	}{
+		{commandTag: pgconn.CommandTag("INSERT 0 5"), rowsAffected: 5, isInsert: true},
+		{commandTag: pgconn.CommandTag("UPDATE 0"), rowsAffected: 0, isUpdate: true},
+		{commandTag: pgconn.CommandTag("UPDATE 1"), rowsAffected: 1, isUpdate: true},
+		{commandTag: pgconn.CommandTag("DELETE 0"), rowsAffected: 0, isDelete: true},
+		{commandTag: pgconn.CommandTag("DELETE 1"), rowsAffected: 1, isDelete: true},
+		{commandTag: pgconn.CommandTag("DELETE 1234567890"), rowsAffected: 1234567890, isDelete: true},
+		{commandTag: pgconn.CommandTag("SELECT 1"), rowsAffected: 1, isSelect: true},
+		{commandTag: pgconn.CommandTag("SELECT 99999999999"), rowsAffected: 99999999999, isSelect: true},
+		{commandTag: pgconn.CommandTag("CREATE TABLE"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("ALTER TABLE"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("DROP TABLE"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("MERGE 0"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("MERGE 7"), rowsAffected: 7},
+		{commandTag: pgconn.CommandTag("COPY 42"), rowsAffected: 42},
+		{commandTag: pgconn.CommandTag("FETCH 3"), rowsAffected: 3},
+		{commandTag: pgconn.CommandTag("MOVE 10"), rowsAffected: 10},
+		{commandTag: pgconn.CommandTag("SELECT 0"), rowsAffected: 0, isSelect: true},
+		{commandTag: pgconn.CommandTag("FUTURE COMMAND 12"), rowsAffected: 12},
+		{commandTag: pgconn.CommandTag("BEGIN"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("COMMIT"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("ROLLBACK"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("SET"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("DISCARD ALL"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("TRUNCATE TABLE"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("NOTIFY"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("LISTEN"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("PREPARE"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("CREATE EXTENSION"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("PG16"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag("42"), rowsAffected: 0},
+		{commandTag: pgconn.CommandTag(""), rowsAffected: 0},
+	}
+
+	for i, tt := range tests {
+		ct := tt.commandTag
+		assert.Equalf(t, tt.rowsAffected, ct.RowsAffected(), "%d. %v", i, tt.commandTag)
+		assert.Equalf(t, tt.isInsert, ct.Insert(), "%d. %v", i, tt.commandTag)
+		assert.Equalf(t, tt.isUpdate, ct.Update(), "%d. %v", i, tt.commandTag)
+		assert.Equalf(t, tt.isDelete, ct.Delete(), "%d. %v", i, tt.commandTag)
+		assert.Equalf(t, tt.isSelect, ct.Select(), "%d. %v", i, tt.commandTag)
+	}
+}
+
+func TestConnOnNotice(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+
+	var msg string
+	config.OnNotice = func(c *pgconn.PgConn, notice *pgconn.Notice) {
+		msg = notice.Message
+	}
+	config.RuntimeParams["client_min_messages"] = "notice" // Ensure we only get the message we expect.
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support PL/PGSQL (https://github.com/cockroachdb/cockroach/issues/17511)")
+	}
+
+	multiResult := pgConn.Exec(context.Background(), `do $$
+begin
+  raise notice 'hello, world';
+end$$;`)
+	err = multiResult.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", msg)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnOnNotification(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+
+	var msg string
+	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
+		msg = n.Payload
+	}
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
+	}
+
+	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
+	require.NoError(t, err)
+
+	notifier, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, notifier)
+	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+	require.NoError(t, err)
+
+	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	require.NoError(t, err)
+
+	assert.Equal(t, "bar", msg)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnWaitForNotification(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+
+	var msg string
+	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
+		msg = n.Payload
+	}
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
+	}
+
+	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
+	require.NoError(t, err)
+
+	notifier, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, notifier)
+	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+	require.NoError(t, err)
+
+	err = pgConn.WaitForNotification(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "bar", msg)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecParamsEmptySQL(t *testing.T) {
-	t.Parallel()
+func TestConnWaitForNotificationOnChannels(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "other", Payload: "ignored"}),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "bar", Payload: "second"}),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "other", Payload: "any"}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	var payloads []string
+	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
+		payloads = append(payloads, n.Payload)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_CONN_STRING"))
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(ctx, "", nil, nil, nil, nil).Read()
-	assert.Nil(t, result.CommandTag)
-	assert.Len(t, result.Rows, 0)
-	assert.NoError(t, result.Err)
+	n, err := pgConn.WaitForNotificationOnChannels(ctx, "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, &pgconn.Notification{PID: 1, Channel: "bar", Payload: "second"}, n)
+	assert.Equal(t, []string{"ignored", "second"}, payloads)
 
-	ensureConnValid(t, pgConn)
+	n, err = pgConn.WaitForNotificationOnChannels(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "any", n.Payload)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-// https://github.com/jackc/pgx/issues/859
-func TestResultReaderValuesHaveSameCapacityAsLength(t *testing.T) {
+func TestWaitForNotifications(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	scriptA := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	scriptA.Steps = append(scriptA.Steps,
+		pgmockWaitStep(200*time.Millisecond),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "foo", Payload: "a"}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connStringA, serverErrChanA := runPgmockServer(t, scriptA)
+
+	scriptB := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	scriptB.Steps = append(scriptB.Steps,
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 2, Channel: "other", Payload: "ignored"}),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 2, Channel: "foo", Payload: "b"}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connStringB, serverErrChanB := runPgmockServer(t, scriptB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConnA, err := pgconn.Connect(ctx, connStringA)
+	require.NoError(t, err)
+	pgConnB, err := pgconn.Connect(ctx, connStringB)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
-	require.Len(t, result.FieldDescriptions(), 1)
-	assert.Equal(t, []byte("msg"), result.FieldDescriptions()[0].Name)
+	// The wait on A is interrupted when B receives a notification.
+	notifications, err := pgconn.WaitForNotifications(ctx, []*pgconn.PgConn{pgConnA, pgConnB}, "foo")
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Same(t, pgConnB, notifications[0].Conn)
+	assert.Equal(t, &pgconn.Notification{PID: 2, Channel: "foo", Payload: "b"}, notifications[0].Notification)
+	assert.False(t, pgConnA.IsClosed())
 
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-		assert.Equal(t, "Hello, world", string(result.Values()[0]))
-		assert.Equal(t, len(result.Values()[0]), cap(result.Values()[0]))
-	}
-	assert.Equal(t, 1, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, "SELECT 1", string(commandTag))
-	assert.NoError(t, err)
+	notifications, err = pgconn.WaitForNotifications(ctx, []*pgconn.PgConn{pgConnA})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Same(t, pgConnA, notifications[0].Conn)
+	assert.Equal(t, "a", notifications[0].Notification.Payload)
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConnA)
+	closeConn(t, pgConnB)
+	assert.NoError(t, <-serverErrChanA)
+	assert.NoError(t, <-serverErrChanB)
 }
 
-func TestConnExecPrepared(t *testing.T) {
+func TestConnProcessPendingMessages(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmockWaitStep(100*time.Millisecond),
+		pgmock.SendMessage(&pgproto3.NotificationResponse{PID: 1, Channel: "foo", Payload: "bar"}),
+		pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "NOTICE", Message: "hello"}),
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "TimeZone", Value: "UTC"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"}),
+	)
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", "select $1::text as msg", nil)
+	var events []string
+	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
+		events = append(events, "notification "+n.Payload)
+	}
+	config.OnNotice = func(c *pgconn.PgConn, n *pgconn.Notice) {
+		events = append(events, "notice "+n.Message)
+	}
+	config.OnParameterStatus = func(c *pgconn.PgConn, name, value string) {
+		events = append(events, "parameter "+name+"="+value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
 	require.NoError(t, err)
-	require.NotNil(t, psd)
-	assert.Len(t, psd.ParamOIDs, 1)
-	assert.Len(t, psd.Fields, 1)
 
-	result := pgConn.ExecPrepared(context.Background(), "ps1", [][]byte{[]byte("Hello, world")}, nil, nil)
-	require.Len(t, result.FieldDescriptions(), 1)
-	assert.Equal(t, []byte("msg"), result.FieldDescriptions()[0].Name)
+	// Nothing has been sent yet so it returns without waiting for the server.
+	start := time.Now()
+	require.NoError(t, pgConn.ProcessPendingMessages())
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	assert.Empty(t, events)
+	assert.True(t, pgConn.IsIdle())
 
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	for err == nil && time.Since(start) < time.Second {
+		err = pgConn.ProcessPendingMessages()
+		time.Sleep(10 * time.Millisecond)
 	}
-	assert.Equal(t, 1, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, "SELECT 1", string(commandTag))
-	assert.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), "%v", err)
+	assert.Equal(t, "57P01", pgErr.Code)
+	assert.Equal(t, []string{"notification bar", "notice hello", "parameter TimeZone=UTC"}, events)
+	assert.Equal(t, "UTC", pgConn.ParameterStatus("TimeZone"))
+	assert.True(t, pgConn.IsClosed())
+	assert.Equal(t, pgErr, pgConn.FatalError())
+
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecPreparedMaxNumberOfParams(t *testing.T) {
+func TestConnWaitForNotificationPrecanceled(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	paramCount := math.MaxUint16
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
-	}
-	sql := "values" + strings.Join(params, ", ")
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
 	require.NoError(t, err)
-	require.NotNil(t, psd)
-	assert.Len(t, psd.ParamOIDs, paramCount)
-	assert.Len(t, psd.Fields, 1)
+	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
-	require.NoError(t, result.Err)
-	require.Len(t, result.Rows, paramCount)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pgConn.WaitForNotification(ctx)
+	require.ErrorIs(t, err, context.Canceled)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedTooManyParams(t *testing.T) {
+func TestConnWaitForNotificationTimeout(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	paramCount := math.MaxUint16 + 1
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
-	}
-	sql := "values" + strings.Join(params, ", ")
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		// CockroachDB rejects preparing a statement with more than 65535 parameters.
-		require.EqualError(t, err, "ERROR: more than 65535 arguments to prepared statement: 65536 (SQLSTATE 08P01)")
-	} else {
-		// PostgreSQL accepts preparing a statement with more than 65535 parameters and only fails when executing it through the extended protocol.
-		require.NoError(t, err)
-		require.NotNil(t, psd)
-		assert.Len(t, psd.ParamOIDs, paramCount)
-		assert.Len(t, psd.Fields, 1)
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-		result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
-		require.EqualError(t, result.Err, "extended protocol limited to 65535 parameters")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	err = pgConn.WaitForNotification(ctx)
+	cancel()
+	assert.True(t, pgconn.Timeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedCanceled(t *testing.T) {
+func TestConnReset(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
-	require.NoError(t, err)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil)
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-	}
-	assert.Equal(t, 0, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, pgconn.CommandTag(nil), commandTag)
-	assert.True(t, pgconn.Timeout(err))
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support DISCARD ALL")
 	}
-}
 
-func TestConnExecPreparedPrecanceled(t *testing.T) {
-	t.Parallel()
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select 1", nil)
+	require.NoError(t, err)
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	_, err = pgConn.Exec(context.Background(), "set search_path to foobar; begin").ReadAll()
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	require.Equal(t, byte('T'), pgConn.TxStatus())
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
+	err = pgConn.Reset(context.Background())
 	require.NoError(t, err)
+	assert.Equal(t, byte('I'), pgConn.TxStatus())
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
-	require.Error(t, result.Err)
-	assert.True(t, errors.Is(result.Err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(result.Err))
+	result := pgConn.ExecParams(context.Background(), "select count(*) from pg_prepared_statements", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, "0", string(result.Rows[0][0]))
+
+	results, err := pgConn.Exec(context.Background(), "show search_path").ReadAll()
+	require.NoError(t, err)
+	assert.NotEqual(t, "foobar", string(results[0].Rows[0][0]))
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedEmptySQL(t *testing.T) {
+func TestConnResetRollsBackTransaction(t *testing.T) {
 	t.Parallel()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'E'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "rollback"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("ROLLBACK")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "discard all"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("DISCARD ALL")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_CONN_STRING"))
+	pgConn, err := pgconn.Connect(ctx, connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	require.Equal(t, byte('E'), pgConn.TxStatus())
 
-	_, err = pgConn.Prepare(ctx, "ps1", "", nil)
+	err = pgConn.Reset(ctx)
 	require.NoError(t, err)
+	assert.Equal(t, byte('I'), pgConn.TxStatus())
 
-	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
-	assert.Nil(t, result.CommandTag)
-	assert.Len(t, result.Rows, 0)
-	assert.NoError(t, result.Err)
-
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecBatch(t *testing.T) {
+func TestConnSetRuntimeParam(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
-	require.NoError(t, err)
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("set_config")}, [][]byte{[]byte("app")})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("set_config")}, [][]byte{[]byte("42")})...)
+	script.Steps = append(script.Steps, extendedQuerySteps([]pgproto3.FieldDescription{textField("set_config")}, [][]byte{[]byte("public")})...)
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "discard all"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("DISCARD ALL")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	batch := &pgconn.Batch{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
-	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
-	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	pgConn, err := pgconn.Connect(ctx, connString)
 	require.NoError(t, err)
-	require.Len(t, results, 3)
+	assert.Empty(t, pgConn.ModifiedRuntimeParams())
 
-	require.Len(t, results[0].Rows, 1)
-	require.Equal(t, "ExecParams 1", string(results[0].Rows[0][0]))
-	assert.Equal(t, "SELECT 1", string(results[0].CommandTag))
+	require.NoError(t, pgConn.SetRuntimeParam(ctx, "search_path", "app"))
+	require.NoError(t, pgConn.SetRuntimeParam(ctx, "App.Tenant_ID", "42"))
+	require.NoError(t, pgConn.SetRuntimeParam(ctx, "SEARCH_PATH", "public"))
+	assert.Equal(t, []string{"app.tenant_id", "search_path"}, pgConn.ModifiedRuntimeParams())
 
-	require.Len(t, results[1].Rows, 1)
-	require.Equal(t, "ExecPrepared 1", string(results[1].Rows[0][0]))
-	assert.Equal(t, "SELECT 1", string(results[1].CommandTag))
+	require.NoError(t, pgConn.Reset(ctx))
+	assert.Empty(t, pgConn.ModifiedRuntimeParams())
 
-	require.Len(t, results[2].Rows, 1)
-	require.Equal(t, "ExecParams 2", string(results[2].Rows[0][0]))
-	assert.Equal(t, "SELECT 1", string(results[2].CommandTag))
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecBatchDeferredError(t *testing.T) {
+func TestConnCopyToSmall(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
@@ -1153,94 +5902,100 @@ func TestConnExecBatchDeferredError(t *testing.T) {
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+		t.Skip("Server does support COPY TO")
 	}
 
-	setupSQL := `create temporary table t (
-		id text primary key,
-		n int not null,
-		unique (n) deferrable initially deferred
-	);
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int2,
+		b int4,
+		c int8,
+		d varchar,
+		e text,
+		f date,
+		g json
+	)`).ReadAll()
+	require.NoError(t, err)
 
-	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+	_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}')`).ReadAll()
+	require.NoError(t, err)
 
-	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
+	_, err = pgConn.Exec(context.Background(), `insert into foo values (null, null, null, null, null, null, null)`).ReadAll()
 	require.NoError(t, err)
 
-	batch := &pgconn.Batch{}
+	inputBytes := []byte("0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\n" +
+		"\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\n")
 
-	batch.ExecParams(`update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil)
-	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
-	require.NotNil(t, err)
-	var pgErr *pgconn.PgError
-	require.True(t, errors.As(err, &pgErr))
-	require.Equal(t, "23505", pgErr.Code)
+	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+
+	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(2), res.RowsAffected())
+	assert.Equal(t, inputBytes, outputWriter.Bytes())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecBatchPrecanceled(t *testing.T) {
+func TestConnCopyToLarge(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does support COPY TO")
+	}
+
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int2,
+		b int4,
+		c int8,
+		d varchar,
+		e text,
+		f date,
+		g json,
+		h bytea
+	)`).ReadAll()
 	require.NoError(t, err)
 
-	batch := &pgconn.Batch{}
+	inputBytes := make([]byte, 0)
 
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
-	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
+	for i := 0; i < 1000; i++ {
+		_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}', 'oooo')`).ReadAll()
+		require.NoError(t, err)
+		inputBytes = append(inputBytes, "0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\t\\\\x6f6f6f6f\n"...)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	_, err = pgConn.ExecBatch(ctx, batch).ReadAll()
-	require.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
+	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+
+	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1000), res.RowsAffected())
+	assert.Equal(t, inputBytes, outputWriter.Bytes())
 
 	ensureConnValid(t, pgConn)
 }
 
-// Without concurrent reading and writing large batches can deadlock.
-//
-// See https://github.com/jackc/pgx/issues/374.
-func TestConnExecBatchHuge(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
-	}
-
+func TestConnCopyToQueryError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	batch := &pgconn.Batch{}
-
-	queryCount := 100000
-	args := make([]string, queryCount)
-
-	for i := range args {
-		args[i] = strconv.Itoa(i)
-		batch.ExecParams("select $1::text", [][]byte{[]byte(args[i])}, nil, nil, nil)
-	}
+	outputWriter := bytes.NewBuffer(make([]byte, 0))
 
-	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
-	require.NoError(t, err)
-	require.Len(t, results, queryCount)
+	res, err := pgConn.CopyTo(context.Background(), outputWriter, "cropy foo to stdout")
+	require.Error(t, err)
+	assert.IsType(t, &pgconn.PgError{}, err)
+	assert.Equal(t, int64(0), res.RowsAffected())
 
-	for i := range args {
-		require.Len(t, results[i].Rows, 1)
-		require.Equal(t, args[i], string(results[i].Rows[0][0]))
-		assert.Equal(t, "SELECT 1", string(results[i].CommandTag))
-	}
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecBatchImplicitTransaction(t *testing.T) {
+func TestConnCopyToCanceled(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
@@ -1248,372 +6003,408 @@ func TestConnExecBatchImplicitTransaction(t *testing.T) {
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Skipping due to known server issue: (https://github.com/cockroachdb/cockroach/issues/44803)")
+		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
 	}
 
-	_, err = pgConn.Exec(context.Background(), "create temporary table t(id int)").ReadAll()
-	require.NoError(t, err)
-
-	batch := &pgconn.Batch{}
+	outputWriter := &bytes.Buffer{}
 
-	batch.ExecParams("insert into t(id) values(1)", nil, nil, nil, nil)
-	batch.ExecParams("insert into t(id) values(2)", nil, nil, nil, nil)
-	batch.ExecParams("insert into t(id) values(3)", nil, nil, nil, nil)
-	batch.ExecParams("select 1/0", nil, nil, nil, nil)
-	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
-	require.Error(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select *, pg_sleep(0.01) from generate_series(1,1000)) to stdout")
+	assert.Error(t, err)
+	assert.Equal(t, pgconn.CommandTag(nil), res)
 
-	result := pgConn.ExecParams(context.Background(), "select count(*) from t", nil, nil, nil, nil).Read()
-	require.Equal(t, "0", string(result.Rows[0][0]))
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
 }
 
-func TestConnLocking(t *testing.T) {
+func TestConnCopyToPrecanceled(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	mrr := pgConn.Exec(context.Background(), "select 'Hello, world'")
-	_, err = pgConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
-	assert.Error(t, err)
-	assert.Equal(t, "conn busy", err.Error())
-	assert.True(t, pgconn.SafeToRetry(err))
+	outputWriter := &bytes.Buffer{}
 
-	results, err := mrr.ReadAll()
-	assert.NoError(t, err)
-	assert.Len(t, results, 1)
-	assert.Nil(t, results[0].Err)
-	assert.Equal(t, "SELECT 1", string(results[0].CommandTag))
-	assert.Len(t, results[0].Rows, 1)
-	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select * from generate_series(1,1000)) to stdout")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
+	assert.Equal(t, pgconn.CommandTag(nil), res)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestCommandTag(t *testing.T) {
+func TestConnCopyFrom(t *testing.T) {
 	t.Parallel()
 
-	var tests = []struct {
-		commandTag   pgconn.CommandTag
-		rowsAffected int64
-		isInsert     bool
-		isUpdate     bool
-		isDelete     bool
-		isSelect     bool
-	}{
-		{commandTag: pgconn.CommandTag("INSERT 0 5"), rowsAffected: 5, isInsert: true},
-		{commandTag: pgconn.CommandTag("UPDATE 0"), rowsAffected: 0, isUpdate: true},
-		{commandTag: pgconn.CommandTag("UPDATE 1"), rowsAffected: 1, isUpdate: true},
-		{commandTag: pgconn.CommandTag("DELETE 0"), rowsAffected: 0, isDelete: true},
-		{commandTag: pgconn.CommandTag("DELETE 1"), rowsAffected: 1, isDelete: true},
-		{commandTag: pgconn.CommandTag("DELETE 1234567890"), rowsAffected: 1234567890, isDelete: true},
-		{commandTag: pgconn.CommandTag("SELECT 1"), rowsAffected: 1, isSelect: true},
-		{commandTag: pgconn.CommandTag("SELECT 99999999999"), rowsAffected: 99999999999, isSelect: true},
-		{commandTag: pgconn.CommandTag("CREATE TABLE"), rowsAffected: 0},
-		{commandTag: pgconn.CommandTag("ALTER TABLE"), rowsAffected: 0},
-		{commandTag: pgconn.CommandTag("DROP TABLE"), rowsAffected: 0},
-	}
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	for i, tt := range tests {
-		ct := tt.commandTag
-		assert.Equalf(t, tt.rowsAffected, ct.RowsAffected(), "%d. %v", i, tt.commandTag)
-		assert.Equalf(t, tt.isInsert, ct.Insert(), "%d. %v", i, tt.commandTag)
-		assert.Equalf(t, tt.isUpdate, ct.Update(), "%d. %v", i, tt.commandTag)
-		assert.Equalf(t, tt.isDelete, ct.Delete(), "%d. %v", i, tt.commandTag)
-		assert.Equalf(t, tt.isSelect, ct.Select(), "%d. %v", i, tt.commandTag)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not fully support COPY FROM (https://www.cockroachlabs.com/docs/v20.2/copy-from.html)")
 	}
-}
 
-func TestConnOnNotice(t *testing.T) {
-	t.Parallel()
-
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
 	require.NoError(t, err)
 
-	var msg string
-	config.OnNotice = func(c *pgconn.PgConn, notice *pgconn.Notice) {
-		msg = notice.Message
+	srcBuf := &bytes.Buffer{}
+
+	inputRows := [][][]byte{}
+	for i := 0; i < 1000; i++ {
+		a := strconv.Itoa(i)
+		b := "foo " + a + " bar"
+		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
+		_, err = srcBuf.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+		require.NoError(t, err)
 	}
-	config.RuntimeParams["client_min_messages"] = "notice" // Ensure we only get the message we expect.
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	ct, err := pgConn.CopyFrom(context.Background(), srcBuf, "COPY foo FROM STDIN WITH (FORMAT csv)")
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support PL/PGSQL (https://github.com/cockroachdb/cockroach/issues/17511)")
-	}
+	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
 
-	multiResult := pgConn.Exec(context.Background(), `do $$
-begin
-  raise notice 'hello, world';
-end$$;`)
-	err = multiResult.Close()
-	require.NoError(t, err)
-	assert.Equal(t, "hello, world", msg)
+	assert.Equal(t, inputRows, result.Rows)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnOnNotification(t *testing.T) {
+func TestConnCopyFromCanceled(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	var msg string
-	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
-		msg = n.Payload
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
 	}
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
+	r, w := io.Pipe()
+	go func() {
+		for i := 0; i < 1000000; i++ {
+			a := strconv.Itoa(i)
+			b := "foo " + a + " bar"
+			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Microsecond)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	ct, err := pgConn.CopyFrom(ctx, r, "COPY foo FROM STDIN WITH (FORMAT csv)")
+	cancel()
+	assert.Equal(t, int64(0), ct.RowsAffected())
+	assert.Error(t, err)
+
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
 	}
+}
 
-	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
-	require.NoError(t, err)
+func TestConnCopyFromPrecanceled(t *testing.T) {
+	t.Parallel()
 
-	notifier, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	defer closeConn(t, notifier)
-	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
 	require.NoError(t, err)
 
-	assert.Equal(t, "bar", msg)
+	r, w := io.Pipe()
+	go func() {
+		for i := 0; i < 1000000; i++ {
+			a := strconv.Itoa(i)
+			b := "foo " + a + " bar"
+			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Microsecond)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ct, err := pgConn.CopyFrom(ctx, r, "COPY foo FROM STDIN WITH (FORMAT csv)")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
+	assert.Equal(t, pgconn.CommandTag(nil), ct)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnWaitForNotification(t *testing.T) {
+func TestConnCopyFromGzipReader(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-
-	var msg string
-	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
-		msg = n.Payload
-	}
-
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
+		t.Skip("Server does not fully support COPY FROM (https://www.cockroachlabs.com/docs/v20.2/copy-from.html)")
 	}
 
-	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
-	require.NoError(t, err)
-
-	notifier, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	defer closeConn(t, notifier)
-	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
 	require.NoError(t, err)
 
-	err = pgConn.WaitForNotification(context.Background())
+	f, err := ioutil.TempFile("", "*")
 	require.NoError(t, err)
 
-	assert.Equal(t, "bar", msg)
-
-	ensureConnValid(t, pgConn)
-}
+	gw := gzip.NewWriter(f)
 
-func TestConnWaitForNotificationPrecanceled(t *testing.T) {
-	t.Parallel()
+	inputRows := [][][]byte{}
+	for i := 0; i < 1000; i++ {
+		a := strconv.Itoa(i)
+		b := "foo " + a + " bar"
+		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
+		_, err = gw.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+		require.NoError(t, err)
+	}
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	err = gw.Close()
 	require.NoError(t, err)
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	_, err = f.Seek(0, 0)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	err = pgConn.WaitForNotification(ctx)
-	require.ErrorIs(t, err, context.Canceled)
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
-}
+	ct, err := pgConn.CopyFrom(context.Background(), gr, "COPY foo FROM STDIN WITH (FORMAT csv)")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
 
-func TestConnWaitForNotificationTimeout(t *testing.T) {
-	t.Parallel()
+	err = gr.Close()
+	require.NoError(t, err)
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	err = f.Close()
 	require.NoError(t, err)
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	err = os.Remove(f.Name())
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
-	err = pgConn.WaitForNotification(ctx)
-	cancel()
-	assert.True(t, pgconn.Timeout(err))
-	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+
+	assert.Equal(t, inputRows, result.Rows)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToSmall(t *testing.T) {
+func TestConnCopyFromQuerySyntaxError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does support COPY TO")
-	}
-
 	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int2,
-		b int4,
-		c int8,
-		d varchar,
-		e text,
-		f date,
-		g json
+		a int4,
+		b varchar
 	)`).ReadAll()
 	require.NoError(t, err)
 
-	_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}')`).ReadAll()
-	require.NoError(t, err)
+	srcBuf := &bytes.Buffer{}
 
-	_, err = pgConn.Exec(context.Background(), `insert into foo values (null, null, null, null, null, null, null)`).ReadAll()
-	require.NoError(t, err)
+	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "cropy foo to stdout")
+	require.Error(t, err)
+	assert.IsType(t, &pgconn.PgError{}, err)
+	assert.Equal(t, int64(0), res.RowsAffected())
 
-	inputBytes := []byte("0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\n" +
-		"\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\n")
+	ensureConnValid(t, pgConn)
+}
 
-	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+func TestConnCopyFromQueryNoTableError(t *testing.T) {
+	t.Parallel()
 
-	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	assert.Equal(t, int64(2), res.RowsAffected())
-	assert.Equal(t, inputBytes, outputWriter.Bytes())
+	srcBuf := &bytes.Buffer{}
+
+	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "copy foo to stdout")
+	require.Error(t, err)
+	assert.IsType(t, &pgconn.PgError{}, err)
+	assert.Equal(t, int64(0), res.RowsAffected())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToLarge(t *testing.T) {
+// https://github.com/jackc/pgconn/issues/21
+func TestConnCopyFromNoticeResponseReceivedMidStream(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	ctx := context.Background()
+	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does support COPY TO")
+		t.Skip("Server does not support triggers (https://github.com/cockroachdb/cockroach/issues/28296)")
 	}
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int2,
-		b int4,
-		c int8,
-		d varchar,
-		e text,
-		f date,
-		g json,
-		h bytea
+	_, err = pgConn.Exec(ctx, `create temporary table sentences(
+		t text,
+		ts tsvector
 	)`).ReadAll()
 	require.NoError(t, err)
 
-	inputBytes := make([]byte, 0)
+	_, err = pgConn.Exec(ctx, `create function pg_temp.sentences_trigger() returns trigger as $$
+	begin
+	  new.ts := to_tsvector(new.t);
+		return new;
+	end
+	$$ language plpgsql;`).ReadAll()
+	require.NoError(t, err)
 
-	for i := 0; i < 1000; i++ {
-		_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}', 'oooo')`).ReadAll()
-		require.NoError(t, err)
-		inputBytes = append(inputBytes, "0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\t\\\\x6f6f6f6f\n"...)
+	_, err = pgConn.Exec(ctx, `create trigger sentences_update before insert on sentences for each row execute procedure pg_temp.sentences_trigger();`).ReadAll()
+	require.NoError(t, err)
+
+	longString := make([]byte, 10001)
+	for i := range longString {
+		longString[i] = 'x'
 	}
 
-	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+	buf := &bytes.Buffer{}
+	for i := 0; i < 1000; i++ {
+		buf.Write([]byte(fmt.Sprintf("%s\n", string(longString))))
+	}
 
-	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
+	_, err = pgConn.CopyFrom(ctx, buf, "COPY sentences(t) FROM STDIN WITH (FORMAT csv)")
 	require.NoError(t, err)
-
-	assert.Equal(t, int64(1000), res.RowsAffected())
-	assert.Equal(t, inputBytes, outputWriter.Bytes())
-
-	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToQueryError(t *testing.T) {
+func TestConnEscapeString(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	outputWriter := bytes.NewBuffer(make([]byte, 0))
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{in: "", out: ""},
+		{in: "42", out: "42"},
+		{in: "'", out: "''"},
+		{in: "hi'there", out: "hi''there"},
+		{in: "'hi there'", out: "''hi there''"},
+	}
 
-	res, err := pgConn.CopyTo(context.Background(), outputWriter, "cropy foo to stdout")
-	require.Error(t, err)
-	assert.IsType(t, &pgconn.PgError{}, err)
-	assert.Equal(t, int64(0), res.RowsAffected())
+	for i, tt := range tests {
+		value, err := pgConn.EscapeString(tt.in)
+		if assert.NoErrorf(t, err, "%d.", i) {
+			assert.Equalf(t, tt.out, value, "%d.", i)
+		}
+	}
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToCanceled(t *testing.T) {
+func TestConnEscapeWithParameterStatuses(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "set standard_conforming_strings = off"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "off"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SET")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "set client_encoding = 'SJIS'"}),
+			pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "SJIS"}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SET")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
 	}
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	outputWriter := &bytes.Buffer{}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select *, pg_sleep(0.01) from generate_series(1,1000)) to stdout")
-	assert.Error(t, err)
-	assert.Equal(t, pgconn.CommandTag(nil), res)
 
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
-	}
-}
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
 
-func TestConnCopyToPrecanceled(t *testing.T) {
-	t.Parallel()
+	s, err := pgConn.EscapeString(`it's a \ test`)
+	require.NoError(t, err)
+	assert.Equal(t, `it''s a \ test`, s)
+	s, err = pgConn.EscapeLiteral(`it's a \ test`)
+	require.NoError(t, err)
+	assert.Equal(t, `'it''s a \ test'`, s)
+	s, err = pgConn.EscapeIdentifier(`My "table"`)
+	require.NoError(t, err)
+	assert.Equal(t, `"My ""table"""`, s)
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	_, err = pgConn.EscapeLiteral("zero\x00byte")
+	assert.EqualError(t, err, "EscapeLiteral: string contains a zero byte")
+	_, err = pgConn.EscapeIdentifier("\xff")
+	assert.EqualError(t, err, "EscapeIdentifier: string is not valid UTF-8")
+
+	_, err = pgConn.Exec(ctx, "set standard_conforming_strings = off").ReadAll()
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	outputWriter := &bytes.Buffer{}
+	s, err = pgConn.EscapeString(`it's a \ test`)
+	require.NoError(t, err)
+	assert.Equal(t, `it''s a \\ test`, s)
+	s, err = pgConn.EscapeLiteral(`it's a \ test`)
+	require.NoError(t, err)
+	assert.Equal(t, `E'it''s a \\ test'`, s)
+	s, err = pgConn.EscapeLiteral(`it's`)
+	require.NoError(t, err)
+	assert.Equal(t, `'it''s'`, s)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select * from generate_series(1,1000)) to stdout")
-	require.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
-	assert.Equal(t, pgconn.CommandTag(nil), res)
+	_, err = pgConn.Exec(ctx, "set client_encoding = 'SJIS'").ReadAll()
+	require.NoError(t, err)
+
+	_, err = pgConn.EscapeString("test")
+	assert.EqualError(t, err, "EscapeString must not be run with client_encoding=SJIS")
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnCopyFrom(t *testing.T) {
+func TestConnCancelRequest(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
@@ -1621,321 +6412,559 @@ func TestConnCopyFrom(t *testing.T) {
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not fully support COPY FROM (https://www.cockroachlabs.com/docs/v20.2/copy-from.html)")
+		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
 	}
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
-	require.NoError(t, err)
-
-	srcBuf := &bytes.Buffer{}
+	multiResult := pgConn.Exec(context.Background(), "select 'Hello, world', pg_sleep(2)")
 
-	inputRows := [][][]byte{}
-	for i := 0; i < 1000; i++ {
-		a := strconv.Itoa(i)
-		b := "foo " + a + " bar"
-		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
-		_, err = srcBuf.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-		require.NoError(t, err)
-	}
+	// This test flickers without the Sleep. It appears that since Exec only sends the query and returns without awaiting a
+	// response that the CancelRequest can race it and be received before the query is running and cancellable. So wait a
+	// few milliseconds.
+	time.Sleep(50 * time.Millisecond)
 
-	ct, err := pgConn.CopyFrom(context.Background(), srcBuf, "COPY foo FROM STDIN WITH (FORMAT csv)")
+	err = pgConn.CancelRequest(context.Background())
 	require.NoError(t, err)
-	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
 
-	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
+	for multiResult.NextResult() {
+	}
+	err = multiResult.Close()
 
-	assert.Equal(t, inputRows, result.Rows)
+	require.IsType(t, &pgconn.PgError{}, err)
+	require.Equal(t, "57014", err.(*pgconn.PgError).Code)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromCanceled(t *testing.T) {
+func TestConnCancelRequestTimeouts(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	for _, tt := range []struct {
+		name        string
+		setTimeouts func(config *pgconn.Config)
+		dialCancel  func(ctx context.Context) (net.Conn, error)
+	}{
+		{
+			name:        "dial timeout",
+			setTimeouts: func(config *pgconn.Config) { config.CancelRequestDialTimeout = 50 * time.Millisecond },
+			dialCancel: func(ctx context.Context) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+		{
+			name:        "overall timeout",
+			setTimeouts: func(config *pgconn.Config) { config.CancelRequestTimeout = 50 * time.Millisecond },
+			dialCancel: func(ctx context.Context) (net.Conn, error) {
+				// The server side reads the cancel request but never closes the connection.
+				client, server := net.Pipe()
+				go func() {
+					defer server.Close()
+					io.ReadFull(server, make([]byte, 16))
+					time.Sleep(5 * time.Second)
+				}()
+				return client, nil
+			},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
-	}
+			script := &pgmock.Script{
+				Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+			}
+			connString, _ := runPgmockServer(t, script)
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
-	require.NoError(t, err)
+			config, err := pgconn.ParseConfig(connString)
+			require.NoError(t, err)
+			tt.setTimeouts(config)
 
-	r, w := io.Pipe()
-	go func() {
-		for i := 0; i < 1000000; i++ {
-			a := strconv.Itoa(i)
-			b := "foo " + a + " bar"
-			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-			if err != nil {
-				return
+			dialFunc := config.DialFunc
+			var dialCount int32
+			config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+				if atomic.AddInt32(&dialCount, 1) == 1 {
+					return dialFunc(ctx, network, address)
+				}
+				return tt.dialCancel(ctx)
 			}
-			time.Sleep(time.Microsecond)
-		}
-	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	ct, err := pgConn.CopyFrom(ctx, r, "COPY foo FROM STDIN WITH (FORMAT csv)")
-	cancel()
-	assert.Equal(t, int64(0), ct.RowsAffected())
-	assert.Error(t, err)
+			pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+			require.NoError(t, err)
+			defer closeConn(t, pgConn)
 
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+			start := time.Now()
+			err = pgConn.CancelRequest(context.Background())
+			require.Error(t, err)
+			assert.Less(t, time.Since(start), 2*time.Second)
+			assert.EqualValues(t, 2, atomic.LoadInt32(&dialCount))
+		})
 	}
 }
 
-func TestConnCopyFromPrecanceled(t *testing.T) {
+func TestConnCancelRequestWithCancelConnPool(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	script := &pgmock.Script{
+		Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(), pgmock.ExpectMessage(&pgproto3.Terminate{})),
+	}
+	connString, serverErrChan := runPgmockServer(t, script)
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
+	pool := pgconn.NewCancelConnPool(1, time.Minute)
+	defer pool.Close()
+	config.CancelConnPool = pool
 
-	r, w := io.Pipe()
-	go func() {
-		for i := 0; i < 1000000; i++ {
-			a := strconv.Itoa(i)
-			b := "foo " + a + " bar"
-			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-			if err != nil {
-				return
-			}
-			time.Sleep(time.Microsecond)
+	// Every connection after the first is a cancel request connection. The server side reports which connection
+	// received a request.
+	type cancelRequest struct {
+		dial int32
+		buf  []byte
+	}
+	requests := make(chan cancelRequest, 10)
+	dialFunc := config.DialFunc
+	var dialCount int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		n := atomic.AddInt32(&dialCount, 1)
+		if n == 1 {
+			return dialFunc(ctx, network, address)
 		}
-	}()
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			buf := make([]byte, 16)
+			if _, err := io.ReadFull(server, buf); err == nil {
+				requests <- cancelRequest{dial: n, buf: buf}
+			}
+		}()
+		return client, nil
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	ct, err := pgConn.CopyFrom(ctx, r, "COPY foo FROM STDIN WITH (FORMAT csv)")
-	require.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
-	assert.Equal(t, pgconn.CommandTag(nil), ct)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	ensureConnValid(t, pgConn)
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	// A cancel request connection is established in advance.
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&dialCount) == 2 }, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, pgConn.CancelRequest(ctx))
+	request := <-requests
+	assert.EqualValues(t, 2, request.dial)
+	assert.EqualValues(t, 80877102, binary.BigEndian.Uint32(request.buf[4:8]))
+	assert.Equal(t, pgConn.PID(), binary.BigEndian.Uint32(request.buf[8:12]))
+
+	// The used connection is replaced.
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&dialCount) == 3 }, 5*time.Second, time.Millisecond)
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnCopyFromGzipReader(t *testing.T) {
+func TestSendCancelRequest(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	config, err := pgconn.ParseConfig("host=localhost user=jack")
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not fully support COPY FROM (https://www.cockroachlabs.com/docs/v20.2/copy-from.html)")
+	type cancelRequest struct {
+		network string
+		address string
+		buf     []byte
+	}
+	requests := make(chan cancelRequest, 1)
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			buf := make([]byte, 16)
+			if _, err := io.ReadFull(server, buf); err == nil {
+				requests <- cancelRequest{network: network, address: address, buf: buf}
+			}
+		}()
+		return client, nil
 	}
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
-	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	f, err := ioutil.TempFile("", "*")
-	require.NoError(t, err)
+	serverAddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5433}
+	require.NoError(t, pgconn.SendCancelRequest(ctx, config, serverAddr, 1234, 5678))
 
-	gw := gzip.NewWriter(f)
+	request := <-requests
+	assert.Equal(t, "tcp", request.network)
+	assert.Equal(t, "10.0.0.1:5433", request.address)
+	assert.EqualValues(t, 16, binary.BigEndian.Uint32(request.buf[0:4]))
+	assert.EqualValues(t, 80877102, binary.BigEndian.Uint32(request.buf[4:8]))
+	assert.EqualValues(t, 1234, binary.BigEndian.Uint32(request.buf[8:12]))
+	assert.EqualValues(t, 5678, binary.BigEndian.Uint32(request.buf[12:16]))
 
-	inputRows := [][][]byte{}
-	for i := 0; i < 1000; i++ {
-		a := strconv.Itoa(i)
-		b := "foo " + a + " bar"
-		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
-		_, err = gw.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-		require.NoError(t, err)
+	// A dial error is returned as is as there is no connection to fall back to.
+	config.CancelRequestFallbackToQuery = true
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("dial failed")
 	}
+	require.EqualError(t, pgconn.SendCancelRequest(ctx, config, serverAddr, 1234, 5678), "dial failed")
+}
 
-	err = gw.Close()
+// runProtocolServer accepts a connection for each handler and calls the handler concurrently with the protocol version
+// and the rest of the startup message. It is used instead of pgmock as pgproto3 does not support protocol versions
+// other than 3.0.
+func runProtocolServer(t *testing.T, handlers ...func(conn net.Conn, protocolVersion uint32, body []byte) error) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
 	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
 
-	_, err = f.Seek(0, 0)
-	require.NoError(t, err)
+	serverErrChan := make(chan error, len(handlers))
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(serverErrChan)
+		}()
+
+		for _, handler := range handlers {
+			conn, err := ln.Accept()
+			if err != nil {
+				serverErrChan <- err
+				return
+			}
 
-	gr, err := gzip.NewReader(f)
-	require.NoError(t, err)
+			wg.Add(1)
+			go func(handler func(net.Conn, uint32, []byte) error) {
+				defer wg.Done()
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+				header := make([]byte, 8)
+				_, err := io.ReadFull(conn, header)
+				if err == nil {
+					body := make([]byte, binary.BigEndian.Uint32(header)-8)
+					_, err = io.ReadFull(conn, body)
+					if err == nil {
+						err = handler(conn, binary.BigEndian.Uint32(header[4:]), body)
+					}
+				}
+				if err != nil {
+					serverErrChan <- err
+				}
+			}(handler)
+		}
+	}()
 
-	ct, err := pgConn.CopyFrom(context.Background(), gr, "COPY foo FROM STDIN WITH (FORMAT csv)")
-	require.NoError(t, err)
-	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return fmt.Sprintf("host=%s port=%s user=jack sslmode=disable", host, port), serverErrChan
+}
 
-	err = gr.Close()
-	require.NoError(t, err)
+func writeMessages(w io.Writer, msgs ...interface {
+	Encode([]byte) ([]byte, error)
+}) error {
+	var buf []byte
+	for _, msg := range msgs {
+		var err error
+		buf, err = msg.Encode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
 
-	err = f.Close()
-	require.NoError(t, err)
+func TestConnectProtocolVersion32(t *testing.T) {
+	t.Parallel()
 
-	err = os.Remove(f.Name())
-	require.NoError(t, err)
+	cancelKey := bytes.Repeat([]byte{0xab}, 32)
+	connString, serverErrChan := runProtocolServer(t,
+		func(conn net.Conn, protocolVersion uint32, body []byte) error {
+			if protocolVersion != uint32(pgconn.ProtocolVersion32) {
+				return fmt.Errorf("unexpected protocol version %d", protocolVersion)
+			}
+			err := writeMessages(conn,
+				&pgproto3.AuthenticationOk{},
+				&pgconn.ExtendedBackendKeyData{ProcessID: 42, SecretKey: cancelKey},
+				&pgproto3.ReadyForQuery{TxStatus: 'I'},
+			)
+			if err != nil {
+				return err
+			}
+			_, err = io.ReadAll(conn)
+			return err
+		},
+		func(conn net.Conn, protocolVersion uint32, body []byte) error {
+			// The cancel request has the place of the protocol version of a startup message.
+			if protocolVersion != 80877102 {
+				return fmt.Errorf("unexpected request code %d", protocolVersion)
+			}
+			if !bytes.Equal(body, append([]byte{0, 0, 0, 42}, cancelKey...)) {
+				return fmt.Errorf("unexpected cancel request %x", body)
+			}
+			return nil
+		},
+	)
 
-	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	assert.Equal(t, inputRows, result.Rows)
+	pgConn, err := pgconn.Connect(ctx, connString+" max_protocol_version=latest")
+	require.NoError(t, err)
+	assert.Equal(t, pgconn.ProtocolVersion32, pgConn.ProtocolVersion())
+	assert.Equal(t, uint32(42), pgConn.PID())
+	assert.Equal(t, uint32(0), pgConn.SecretKey())
+	assert.Equal(t, cancelKey, pgConn.CancelKey())
 
-	ensureConnValid(t, pgConn)
+	require.NoError(t, pgConn.CancelRequest(ctx))
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnCopyFromQuerySyntaxError(t *testing.T) {
+func TestConnectProtocolVersionDowngrade(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	downgrade := func(conn net.Conn, protocolVersion uint32, body []byte) error {
+		if protocolVersion != uint32(pgconn.ProtocolVersion32) {
+			return fmt.Errorf("unexpected protocol version %d", protocolVersion)
+		}
+		err := writeMessages(conn,
+			&pgconn.NegotiateProtocolVersion{NewestMinorProtocol: 0, UnrecognizedOptions: []string{}},
+			&pgproto3.AuthenticationOk{},
+			&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: 7},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(conn)
+		return err
+	}
+	connString, serverErrChan := runProtocolServer(t, downgrade, downgrade)
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
-	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	srcBuf := &bytes.Buffer{}
+	pgConn, err := pgconn.Connect(ctx, connString+" max_protocol_version=3.2")
+	require.NoError(t, err)
+	assert.Equal(t, pgconn.ProtocolVersion30, pgConn.ProtocolVersion())
+	assert.Equal(t, uint32(7), pgConn.SecretKey())
+	assert.Equal(t, []byte{0, 0, 0, 7}, pgConn.CancelKey())
+	closeConn(t, pgConn)
 
-	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "cropy foo to stdout")
+	_, err = pgconn.Connect(ctx, connString+" min_protocol_version=3.2 max_protocol_version=3.2")
 	require.Error(t, err)
-	assert.IsType(t, &pgconn.PgError{}, err)
-	assert.Equal(t, int64(0), res.RowsAffected())
-
-	ensureConnValid(t, pgConn)
+	assert.Contains(t, err.Error(), "server supports protocol version 3.0 but min_protocol_version is 3.2")
+	assert.NoError(t, <-serverErrChan)
 }
 
-func TestConnCopyFromQueryNoTableError(t *testing.T) {
+func TestConnProtocolError(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	connString, serverErrChan := runProtocolServer(t,
+		func(conn net.Conn, protocolVersion uint32, body []byte) error {
+			err := writeMessages(conn,
+				&pgproto3.AuthenticationOk{},
+				&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: 7},
+				&pgproto3.ReadyForQuery{TxStatus: 'I'},
+			)
+			if err != nil {
+				return err
+			}
 
-	srcBuf := &bytes.Buffer{}
+			query := make([]byte, 14)
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return err
+			}
+			// A message of an unknown type.
+			_, err = conn.Write([]byte{'~', 0, 0, 0, 4})
+			return err
+		},
+	)
 
-	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "copy foo to stdout")
-	require.Error(t, err)
-	assert.IsType(t, &pgconn.PgError{}, err)
-	assert.Equal(t, int64(0), res.RowsAffected())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	ensureConnValid(t, pgConn)
+	pgConn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+
+	_, err = pgConn.Exec(ctx, "select 1").ReadAll()
+	var protocolErr *pgconn.ProtocolError
+	require.ErrorAs(t, err, &protocolErr)
+	assert.Equal(t, "invalid message", protocolErr.Msg)
+	require.Len(t, protocolErr.History, 6)
+	assert.Equal(t, byte(0), protocolErr.History[0].Type)
+	assert.Equal(t, []pgconn.ProtocolMessage{
+		{FromServer: true, Type: 'R', Length: 8},
+		{FromServer: true, Type: 'K', Length: 12},
+		{FromServer: true, Type: 'Z', Length: 5},
+		{Type: 'Q', Length: 13},
+		{FromServer: true, Type: '~', Length: 4},
+	}, protocolErr.History[1:])
+	assert.Contains(t, err.Error(), "F Q(13), B ~(4))")
+	assert.True(t, pgConn.IsClosed())
+
+	assert.NoError(t, <-serverErrChan)
 }
 
-// https://github.com/jackc/pgconn/issues/21
-func TestConnCopyFromNoticeResponseReceivedMidStream(t *testing.T) {
+func TestConnReplicationMode(t *testing.T) {
 	t.Parallel()
 
-	ctx := context.Background()
-	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	for _, tt := range []struct {
+		replication string
+		startup     string
+	}{
+		{"database", "database"},
+		{"on", "true"},
+	} {
+		connString, serverErrChan := runServerConnServer(t, &pgconn.ServerConnConfig{}, func(sc *pgconn.ServerConn, err error) error {
+			if err != nil {
+				return err
+			}
+			if replication := sc.StartupMessage().Parameters["replication"]; replication != tt.startup {
+				return fmt.Errorf("unexpected replication %q", replication)
+			}
+			return serveReadyAndTerminate(sc, nil)
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support triggers (https://github.com/cockroachdb/cockroach/issues/28296)")
-	}
+		config, err := pgconn.ParseConfig(connString + " sslmode=disable replication=" + tt.replication)
+		require.NoError(t, err)
+		config.RuntimeParams["replication"] = "bogus"
+		pgConn, err := pgconn.ConnectConfig(ctx, config)
+		require.NoError(t, err)
 
-	_, err = pgConn.Exec(ctx, `create temporary table sentences(
-		t text,
-		ts tsvector
-	)`).ReadAll()
-	require.NoError(t, err)
+		_, err = pgConn.Prepare(ctx, "ps1", "select 1", nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Prepare is not supported on a")
 
-	_, err = pgConn.Exec(ctx, `create function pg_temp.sentences_trigger() returns trigger as $$
-	begin
-	  new.ts := to_tsvector(new.t);
-		return new;
-	end
-	$$ language plpgsql;`).ReadAll()
-	require.NoError(t, err)
+		_, err = pgConn.ExecParams(ctx, "select 1", nil, nil, nil, nil).Close()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ExecParams is not supported on a")
 
-	_, err = pgConn.Exec(ctx, `create trigger sentences_update before insert on sentences for each row execute procedure pg_temp.sentences_trigger();`).ReadAll()
-	require.NoError(t, err)
+		batch := &pgconn.Batch{}
+		batch.ExecParams("select 1", nil, nil, nil, nil)
+		_, err = pgConn.ExecBatch(ctx, batch).ReadAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ExecBatch is not supported on a")
 
-	longString := make([]byte, 10001)
-	for i := range longString {
-		longString[i] = 'x'
+		assert.True(t, pgConn.IsIdle())
+		closeConn(t, pgConn)
+		assert.NoErrorf(t, <-serverErrChan, "replication=%s", tt.replication)
+		cancel()
 	}
+}
 
-	buf := &bytes.Buffer{}
-	for i := 0; i < 1000; i++ {
-		buf.Write([]byte(fmt.Sprintf("%s\n", string(longString))))
-	}
+// remoteAddrConn is a net.Conn that reports addr as its remote address.
+type remoteAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
 
-	_, err = pgConn.CopyFrom(ctx, buf, "COPY sentences(t) FROM STDIN WITH (FORMAT csv)")
-	require.NoError(t, err)
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.addr
 }
 
-func TestConnEscapeString(t *testing.T) {
+func TestConnCancelRequestFallbackToQuery(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	tests := []struct {
-		in  string
-		out string
-	}{
-		{in: "", out: ""},
-		{in: "42", out: "42"},
-		{in: "'", out: "''"},
-		{in: "hi'there", out: "hi''there"},
-		{in: "'hi there'", out: "''hi there''"},
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 1234, SecretKey: 5678}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
 	}
-
-	for i, tt := range tests {
-		value, err := pgConn.EscapeString(tt.in)
-		if assert.NoErrorf(t, err, "%d.", i) {
-			assert.Equalf(t, tt.out, value, "%d.", i)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	cancelBackendServer := func(result string) *pgconn.Config {
+		script := &pgmock.Script{
+			Steps: append(pgmock.AcceptUnauthenticatedConnRequestSteps(),
+				pgmock.ExpectMessage(&pgproto3.Query{String: "select pg_cancel_backend(1234)"}),
+				pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("pg_cancel_backend"), DataTypeOID: 16, DataTypeSize: 1, TypeModifier: -1}}}),
+				pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte(result)}}),
+				pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+				pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+				pgmock.ExpectMessage(&pgproto3.Terminate{}),
+			),
 		}
+		connString, serverErrChan := runPgmockServer(t, script)
+		t.Cleanup(func() { assert.NoError(t, <-serverErrChan) })
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
+		return config
 	}
+	signaledConfig := cancelBackendServer("t")
+	notSignaledConfig := cancelBackendServer("f")
 
-	ensureConnValid(t, pgConn)
-}
-
-func TestConnCancelRequest(t *testing.T) {
-	t.Parallel()
-
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_CONN_STRING"))
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
+	// The hooks only run for the connection to cancel, not for the connections that call pg_cancel_backend.
+	var afterConnectCount int32
+	config.AfterConnect = func(ctx context.Context, pgConn *pgconn.PgConn) error {
+		atomic.AddInt32(&afterConnectCount, 1)
+		return nil
+	}
+	var readyForQueryCount int32
+	config.OnReadyForQuery = func(pgConn *pgconn.PgConn, txStatus byte, op string) {
+		atomic.AddInt32(&readyForQueryCount, 1)
+	}
+	config.IdleKeepaliveInterval = time.Hour
+
+	// The first dial is the connection to cancel and the cancel request connections fail. The fourth and sixth dials are
+	// the connections for pg_cancel_backend. They are redirected to the cancel backend servers but appear to reach the
+	// same address.
+	dialFunc := config.DialFunc
+	var dialCount int32
+	var serverAddr net.Addr
+	redirect := func(ctx context.Context, network string, config *pgconn.Config) (net.Conn, error) {
+		conn, err := dialFunc(ctx, network, net.JoinHostPort(config.Host, strconv.Itoa(int(config.Port))))
+		if err != nil {
+			return nil, err
+		}
+		return &remoteAddrConn{Conn: conn, addr: serverAddr}, nil
+	}
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		switch atomic.AddInt32(&dialCount, 1) {
+		case 1:
+			conn, err := dialFunc(ctx, network, address)
+			if err == nil {
+				serverAddr = conn.RemoteAddr()
+			}
+			return conn, err
+		case 4:
+			return redirect(ctx, network, signaledConfig)
+		case 6:
+			return redirect(ctx, network, notSignaledConfig)
+		default:
+			return nil, errors.New("cancel port unreachable")
+		}
 	}
 
-	multiResult := pgConn.Exec(context.Background(), "select 'Hello, world', pg_sleep(2)")
-
-	// This test flickers without the Sleep. It appears that since Exec only sends the query and returns without awaiting a
-	// response that the CancelRequest can race it and be received before the query is running and cancellable. So wait a
-	// few milliseconds.
-	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	err = pgConn.CancelRequest(context.Background())
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
 	require.NoError(t, err)
 
-	for multiResult.NextResult() {
-	}
-	err = multiResult.Close()
+	err = pgConn.CancelRequest(ctx)
+	require.EqualError(t, err, "cancel port unreachable")
 
-	require.IsType(t, &pgconn.PgError{}, err)
-	require.Equal(t, "57014", err.(*pgconn.PgError).Code)
+	config.CancelRequestFallbackToQuery = true
+	require.NoError(t, pgConn.CancelRequest(ctx))
+	assert.EqualValues(t, 4, atomic.LoadInt32(&dialCount))
 
-	ensureConnValid(t, pgConn)
+	err = pgConn.CancelRequest(ctx)
+	require.EqualError(t, err, "pg_cancel_backend could not signal backend 1234")
+	assert.EqualValues(t, 6, atomic.LoadInt32(&dialCount))
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&afterConnectCount))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&readyForQueryCount))
+
+	closeConn(t, pgConn)
+	assert.NoError(t, <-serverErrChan)
 }
 
 // https://github.com/jackc/pgx/issues/659
@@ -1986,6 +7015,143 @@ func TestConnContextCanceledCancelsRunningQueryOnServer(t *testing.T) {
 	}
 }
 
+type countingContextWatcherHandler struct {
+	handler                       pgconn.ContextWatcherHandler
+	handleCancelCount             int32
+	handleUnwatchAfterCancelCount int32
+}
+
+func (h *countingContextWatcherHandler) HandleCancel(ctx context.Context) {
+	atomic.AddInt32(&h.handleCancelCount, 1)
+	h.handler.HandleCancel(ctx)
+}
+
+func (h *countingContextWatcherHandler) HandleUnwatchAfterCancel() {
+	atomic.AddInt32(&h.handleUnwatchAfterCancelCount, 1)
+	h.handler.HandleUnwatchAfterCancel()
+}
+
+func TestConnBuildContextWatcherHandler(t *testing.T) {
+	t.Parallel()
+
+	steps := pgmock.AcceptUnauthenticatedConnRequestSteps()
+	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Query{}))
+	steps = append(steps, pgmockWaitStep(time.Second))
+
+	connStr, _ := runPgmockServer(t, &pgmock.Script{Steps: steps})
+
+	config, err := pgconn.ParseConfig(connStr)
+	require.NoError(t, err)
+
+	var handler *countingContextWatcherHandler
+	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) pgconn.ContextWatcherHandler {
+		handler = &countingContextWatcherHandler{handler: &pgconn.DeadlineContextWatcherHandler{Conn: pgConn.Conn()}}
+		return handler
+	}
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	require.NotNil(t, handler)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pgConn.Exec(ctx, "select 1").ReadAll()
+	require.Error(t, err)
+	assert.True(t, pgconn.Timeout(err))
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&handler.handleCancelCount))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&handler.handleUnwatchAfterCancelCount))
+}
+
+func TestConnCancelRequestContextWatcherHandler(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_CONN_STRING"))
+	require.NoError(t, err)
+
+	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) pgconn.ContextWatcherHandler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: 5 * time.Second}
+	}
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = pgConn.Exec(ctx, "select pg_sleep(30)").ReadAll()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "57014", pgErr.Code)
+
+	require.False(t, pgConn.IsClosed())
+	ensureConnValid(t, pgConn)
+}
+
+// afterFuncNotifyingClock is a fakeClock that reports the delay of each timer created by AfterFunc.
+type afterFuncNotifyingClock struct {
+	*fakeClock
+	afterFuncChan chan time.Duration
+}
+
+func (c afterFuncNotifyingClock) AfterFunc(d time.Duration, f func()) pgconn.Timer {
+	timer := c.fakeClock.AfterFunc(d, f)
+	c.afterFuncChan <- d
+	return timer
+}
+
+func TestConnCancelRequestContextWatcherHandlerDeadlineWithClock(t *testing.T) {
+	t.Parallel()
+
+	queryReceived := make(chan struct{})
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select pg_sleep(30)"}),
+		pgmockSignalStep(queryReceived),
+		pgmock.WaitForClose(),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	clock := afterFuncNotifyingClock{fakeClock: newFakeClock(), afterFuncChan: make(chan time.Duration, 10)}
+	config.Clock = clock
+	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) pgconn.ContextWatcherHandler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, CancelRequestDelay: 2 * time.Hour, DeadlineDelay: time.Hour}
+	}
+	// The cancel request sent when the connection is closed can not reach the server.
+	dialFunc := config.DialFunc
+	var dialCount int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dialCount, 1) == 1 {
+			return dialFunc(ctx, network, address)
+		}
+		return nil, errors.New("cancel port unreachable")
+	}
+
+	testCtx, testCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer testCancel()
+
+	pgConn, err := pgconn.ConnectConfig(testCtx, config)
+	require.NoError(t, err)
+
+	// The server does not respond and the cancel request is not sent before the deadline of the clock is reached.
+	ctx, cancel := context.WithCancel(testCtx)
+	go func() {
+		<-queryReceived
+		cancel()
+		assert.Equal(t, time.Hour, <-clock.afterFuncChan)
+		clock.Advance(time.Hour)
+	}()
+	_, err = pgConn.Exec(ctx, "select pg_sleep(30)").ReadAll()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, pgConn.IsClosed())
+	assert.NoError(t, testCtx.Err())
+	assert.NoError(t, <-serverErrChan)
+}
+
 func TestConnSendBytesAndReceiveMessage(t *testing.T) {
 	t.Parallel()
 
@@ -2343,6 +7509,164 @@ func TestSNISupport(t *testing.T) {
 	}
 }
 
+func TestConnectTLSServerNameAndVerifyServerCertificate(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	serverErrChan := make(chan error, 1)
+	serverSNINameChan := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		backend := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+		if _, err := backend.ReceiveStartupMessage(); err != nil {
+			serverErrChan <- err
+			return
+		}
+		if _, err := conn.Write([]byte("S")); err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		var sniHost string
+		srv := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			GetConfigForClient: func(argHello *tls.ClientHelloInfo) (*tls.Config, error) {
+				sniHost = argHello.ServerName
+				return nil, nil
+			},
+		})
+		defer srv.Close()
+
+		// The client aborts the handshake so an error is expected.
+		srv.Handshake()
+		serverSNINameChan <- sniHost
+	}()
+
+	port := strings.Split(ln.Addr().String(), ":")[1]
+	config, err := pgconn.ParseConfig(fmt.Sprintf("sslmode=require host=127.0.0.1 port=%s", port))
+	require.NoError(t, err)
+
+	config.TLSServerName = "db.example.com"
+	var verifiedServerName string
+	config.VerifyServerCertificate = func(cs tls.ConnectionState) error {
+		verifiedServerName = cs.ServerName
+		return errors.New("rejected by VerifyServerCertificate")
+	}
+
+	_, err = pgconn.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rejected by VerifyServerCertificate")
+	assert.Equal(t, "db.example.com", verifiedServerName)
+
+	select {
+	case sniHost := <-serverSNINameChan:
+		assert.Equal(t, "db.example.com", sniHost)
+	case err = <-serverErrChan:
+		t.Fatalf("server failed with error: %+v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server")
+	}
+}
+
+func TestConnectGetClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	serverErrChan := make(chan error, 1)
+	clientCertCountChan := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		backend := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+		if _, err := backend.ReceiveStartupMessage(); err != nil {
+			serverErrChan <- err
+			return
+		}
+		if _, err := conn.Write([]byte("S")); err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		srv := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAnyClientCert,
+		})
+		defer srv.Close()
+
+		if err := srv.Handshake(); err != nil {
+			serverErrChan <- err
+			return
+		}
+		clientCertCountChan <- len(srv.ConnectionState().PeerCertificates)
+	}()
+
+	port := strings.Split(ln.Addr().String(), ":")[1]
+	config, err := pgconn.ParseConfig(fmt.Sprintf("sslmode=require host=127.0.0.1 port=%s", port))
+	require.NoError(t, err)
+
+	var getClientCertificateCalls int32
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		atomic.AddInt32(&getClientCertificateCalls, 1)
+		cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+		return &cert, err
+	}
+
+	// The server closes the connection after the handshake so the connection attempt fails.
+	_, err = pgconn.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+
+	select {
+	case clientCertCount := <-clientCertCountChan:
+		assert.Equal(t, 1, clientCertCount)
+	case err = <-serverErrChan:
+		t.Fatalf("server failed with error: %+v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server")
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&getClientCertificateCalls))
+}
+
 type delayedReader struct {
 	r io.Reader
 }
@@ -0,0 +1,187 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PoolConfig controls the behavior of a Pool.
+type PoolConfig struct {
+	// MaxConns is the maximum number of connections, idle or acquired, the pool holds at once. Values less than 1 are
+	// treated as 1.
+	MaxConns int
+
+	// MaxConnLifetime is the duration since creation after which a connection is closed instead of being reused. Zero
+	// means connections are reused indefinitely.
+	MaxConnLifetime time.Duration
+
+	// HealthCheck is called with the context of Acquire before an idle connection is handed out. If it returns an error
+	// the connection is closed and another one is tried. If nil only connections that are closed are discarded.
+	HealthCheck func(ctx context.Context, pgConn *PgConn) error
+}
+
+// Pool is a minimal pool of connections established with the same Config. Connections are established on demand.
+// It is safe for concurrent usage.
+//
+// Pool is intended for programs that only depend on pgconn. It does not maintain a minimum number of connections,
+// close idle connections, or restore session state. Connections that are released while busy or in a transaction are
+// closed instead of being reused.
+type Pool struct {
+	config     *Config
+	poolConfig PoolConfig
+	sem        chan struct{} // holds a value for each idle or acquired connection or connection in progress
+
+	mux    sync.Mutex
+	idle   []*poolConn
+	closed bool
+}
+
+// poolConn is a connection held by a Pool. It outlives the PooledConn of each Acquire.
+type poolConn struct {
+	pgConn    *PgConn
+	createdAt time.Time
+}
+
+// PooledConn is a connection acquired from a Pool. It must be returned with Release when it is no longer needed.
+type PooledConn struct {
+	pool     *Pool
+	conn     *poolConn
+	released bool
+}
+
+// NewPool creates a Pool that establishes connections with config. No connection is established until Acquire is
+// called. config must not be modified after calling NewPool.
+func NewPool(config *Config, poolConfig PoolConfig) *Pool {
+	maxConns := poolConfig.MaxConns
+	if maxConns < 1 {
+		maxConns = 1
+	}
+
+	return &Pool{
+		config:     config,
+		poolConfig: poolConfig,
+		sem:        make(chan struct{}, maxConns),
+	}
+}
+
+// Acquire returns an idle connection or establishes a new one. If the pool is at PoolConfig.MaxConns it waits until a
+// connection is released or ctx is done.
+func (p *Pool) Acquire(ctx context.Context) (*PooledConn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, newContextAlreadyDoneError(ctx)
+	}
+
+	for {
+		conn, err := p.popIdle()
+		if err != nil {
+			<-p.sem
+			return nil, err
+		}
+		if conn == nil {
+			break
+		}
+
+		if p.usable(ctx, conn) {
+			// Each Acquire gets its own PooledConn so a repeated Release by an earlier holder has no effect.
+			return &PooledConn{pool: p, conn: conn}, nil
+		}
+		conn.pgConn.Close(ctx)
+	}
+
+	pgConn, err := ConnectConfig(ctx, p.config)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+
+	return &PooledConn{pool: p, conn: &poolConn{pgConn: pgConn, createdAt: p.config.clock().Now()}}, nil
+}
+
+// popIdle returns the most recently released idle connection or nil if there are none.
+func (p *Pool) popIdle() (*poolConn, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.closed {
+		return nil, errors.New("pool is closed")
+	}
+
+	if len(p.idle) == 0 {
+		return nil, nil
+	}
+
+	conn := p.idle[len(p.idle)-1]
+	p.idle[len(p.idle)-1] = nil
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn, nil
+}
+
+func (p *Pool) usable(ctx context.Context, conn *poolConn) bool {
+	if conn.pgConn.IsClosed() || p.expired(conn) {
+		return false
+	}
+
+	if p.poolConfig.HealthCheck != nil {
+		return p.poolConfig.HealthCheck(ctx, conn.pgConn) == nil
+	}
+
+	return true
+}
+
+func (p *Pool) expired(conn *poolConn) bool {
+	return p.poolConfig.MaxConnLifetime > 0 && p.config.clock().Now().Sub(conn.createdAt) > p.poolConfig.MaxConnLifetime
+}
+
+// Close closes all idle connections and prevents further connections from being acquired. Acquired connections are
+// closed when they are released.
+func (p *Pool) Close() {
+	p.mux.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mux.Unlock()
+
+	for _, conn := range idle {
+		closePooledConn(conn.pgConn)
+	}
+}
+
+// PgConn returns the underlying connection. It must not be used after Release.
+func (pc *PooledConn) PgConn() *PgConn {
+	return pc.conn.pgConn
+}
+
+// Release returns the connection to the pool. The connection is closed instead if it is closed, busy, in a
+// transaction, past PoolConfig.MaxConnLifetime, or if the pool is closed. Calling Release more than once has no effect.
+func (pc *PooledConn) Release() {
+	if pc.released {
+		return
+	}
+	pc.released = true
+
+	p := pc.pool
+	defer func() { <-p.sem }()
+
+	reusable := pc.conn.pgConn.IsIdle() && !p.expired(pc.conn)
+	if reusable {
+		p.mux.Lock()
+		if !p.closed {
+			p.idle = append(p.idle, pc.conn)
+			p.mux.Unlock()
+			return
+		}
+		p.mux.Unlock()
+	}
+
+	closePooledConn(pc.conn.pgConn)
+}
+
+func closePooledConn(pgConn *PgConn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	pgConn.Close(ctx)
+}
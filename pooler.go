@@ -0,0 +1,72 @@
+package pgconn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pooler describes the transaction pooling connection pooler a connection adapts to. See Config.PoolerMode.
+type Pooler struct {
+	// Name is the name of the pooler (pgbouncer or odyssey) if it could be determined from the server_version parameter
+	// status. Poolers usually forward the parameter statuses of the server, so it is often empty.
+	Name string
+
+	// Detected is true if the pooler was detected while the connection was established and false if it is assumed
+	// because Config.PoolerMode is PoolerModeOn.
+	Detected bool
+
+	// HasCancelKey is false if the pooler did not send a cancel key (BackendKeyData), so CancelRequest can not be used.
+	HasCancelKey bool
+}
+
+// poolerVersionNames maps a substring of a server_version reported by a pooler instead of the version of the server to
+// the name of the pooler. E.g. the PgBouncer admin console reports 1.21.0/bouncer.
+var poolerVersionNames = []struct {
+	substr string
+	name   string
+}{
+	{"bouncer", "pgbouncer"},
+	{"odyssey", "odyssey"},
+}
+
+// Pooler returns the transaction pooling connection pooler the connection adapts to or nil if it is treated as a
+// direct connection to the server. With Config.PoolerMode set to PoolerModeDetect a pooler is detected by a
+// server_version that names it or by the absence of a cancel key, which servers always send. Poolers that forward
+// everything they receive from the server can not be detected and require PoolerModeOn.
+func (pgConn *PgConn) Pooler() *Pooler {
+	return pgConn.pooler
+}
+
+// detectPooler returns the pooler the connection adapts to according to Config.PoolerMode. receivedCancelKey is true if a
+// BackendKeyData message was received while the connection was established.
+func (pgConn *PgConn) detectPooler(receivedCancelKey bool) *Pooler {
+	var name string
+	serverVersion := strings.ToLower(pgConn.ParameterStatus("server_version"))
+	for _, pv := range poolerVersionNames {
+		if strings.Contains(serverVersion, pv.substr) {
+			name = pv.name
+			break
+		}
+	}
+
+	switch pgConn.config.PoolerMode {
+	case PoolerModeOn:
+		return &Pooler{Name: name, HasCancelKey: receivedCancelKey}
+	case PoolerModeDetect:
+		if name == "" && receivedCancelKey {
+			return nil
+		}
+		return &Pooler{Name: name, Detected: true, HasCancelKey: receivedCancelKey}
+	default:
+		return nil
+	}
+}
+
+// checkPooler returns an error if the connection adapts to a transaction pooler, which may run the next transaction on
+// a server connection where a prepared statement used by op does not exist.
+func (pgConn *PgConn) checkPooler(op string) error {
+	if pgConn.pooler != nil {
+		return &pgconnError{msg: fmt.Sprintf("%s is not supported behind a transaction pooler as prepared statements do not outlive a transaction", op), safeToRetry: true}
+	}
+	return nil
+}
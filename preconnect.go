@@ -0,0 +1,104 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// PendingConn is a connection to a PostgreSQL server whose network connection and TLS handshake have been established
+// by PreConnect but whose startup and authentication have not been performed yet.
+type PendingConn struct {
+	config         *Config
+	fallbackConfig *FallbackConfig
+
+	mux    sync.Mutex
+	pgConn *PgConn
+}
+
+// PreConnect establishes the network connection and performs the TLS handshake to the first server in config that is
+// reachable. The connection must be completed with PendingConn.Connect or closed with PendingConn.Close. This moves the
+// most expensive steps of connecting ahead of the time the connection is needed, e.g. when a pool warms up before a
+// burst of traffic.
+//
+// The server closes a connection that does not complete the startup within authentication_timeout (one minute by
+// default). A PendingConn should be completed or discarded before then. Connect falls back to connecting normally if the
+// server closed the connection in the meantime.
+func PreConnect(ctx context.Context, config *Config) (*PendingConn, error) {
+	// Default values are set in ParseConfig. Enforce initial creation by ParseConfig rather than setting defaults from
+	// zero values.
+	if !config.createdByParseConfig {
+		panic("config must be created by ParseConfig")
+	}
+
+	fallbackConfigs, err := resolveFallbackConfigs(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fc := range fallbackConfigs {
+		dialCtx := ctx
+		if config.ConnectTimeout != 0 {
+			var cancel context.CancelFunc
+			dialCtx, cancel = contextWithTimeout(ctx, config.clock(), config.ConnectTimeout)
+			defer cancel()
+		}
+
+		var pgConn *PgConn
+		pgConn, err = dial(dialCtx, config, fc)
+		if err == nil {
+			return &PendingConn{config: config, fallbackConfig: fc, pgConn: pgConn}, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Connect completes the connection with the startup and authentication and returns the established PgConn. It behaves
+// like ConnectConfig. If the network connection established by PreConnect was lost, the server is not acceptable (e.g.
+// because of target_session_attrs), or the server rejects the connection with an error that ConnectConfig would try
+// the next host for (anything but a wrong password, a missing database or a missing connect privilege) the connection
+// is established with ConnectConfig instead. Connect can only be called once.
+func (pc *PendingConn) Connect(ctx context.Context) (*PgConn, error) {
+	pc.mux.Lock()
+	pgConn := pc.pgConn
+	pc.pgConn = nil
+	pc.mux.Unlock()
+
+	if pgConn == nil {
+		return nil, errors.New("pending connection already used or closed")
+	}
+
+	startupCtx := ctx
+	if pc.config.ConnectTimeout != 0 {
+		var cancel context.CancelFunc
+		startupCtx, cancel = contextWithTimeout(ctx, pc.config.clock(), pc.config.ConnectTimeout)
+		defer cancel()
+	}
+
+	pgConn, err := pgConn.startup(startupCtx, pc.fallbackConfig, false)
+	if err != nil {
+		if pgerr, ok := err.(*PgError); ok && isFinalConnectError(pgerr, pc.fallbackConfig) {
+			return nil, &connectError{config: pc.config, msg: "server error", err: pgerr}
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return ConnectConfig(ctx, pc.config)
+	}
+
+	return finishConnect(startupCtx, pc.config, pgConn)
+}
+
+// Close closes the network connection if Connect has not been called.
+func (pc *PendingConn) Close() error {
+	pc.mux.Lock()
+	pgConn := pc.pgConn
+	pc.pgConn = nil
+	pc.mux.Unlock()
+
+	if pgConn == nil {
+		return nil
+	}
+	return pgConn.conn.Close()
+}
@@ -0,0 +1,216 @@
+package pgconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgio"
+	"github.com/jackc/pgproto3/v2"
+)
+
+// ProtocolVersion is a version of the PostgreSQL frontend/backend protocol. The major version is in the upper 16 bits
+// and the minor version in the lower 16 bits as in the StartupMessage.
+type ProtocolVersion uint32
+
+const (
+	ProtocolVersion30 ProtocolVersion = 3<<16 | 0 // supported by all servers
+	ProtocolVersion32 ProtocolVersion = 3<<16 | 2 // PostgreSQL 18 and later, adds cancel keys of up to 256 bytes
+)
+
+func (v ProtocolVersion) String() string {
+	return fmt.Sprintf("%d.%d", v>>16, v&0xffff)
+}
+
+// parseProtocolVersion parses a min_protocol_version or max_protocol_version setting.
+func parseProtocolVersion(s string) (ProtocolVersion, error) {
+	switch s {
+	case "3.0":
+		return ProtocolVersion30, nil
+	case "3.2", "latest":
+		return ProtocolVersion32, nil
+	default:
+		return 0, fmt.Errorf("unknown protocol version: %v", s)
+	}
+}
+
+// maxCancelKeyLen is the maximum length of a cancel key allowed by protocol 3.2.
+const maxCancelKeyLen = 256
+
+// NegotiateProtocolVersion is sent by a server that does not support the minor protocol version or the protocol
+// options requested by the client. The connection continues with the newest minor version the server supports.
+//
+// pgproto3 does not support it. The default Frontend returns it while the connection is established. A Frontend built
+// by a custom BuildFrontend must return it if a protocol version newer than 3.0 may be requested.
+type NegotiateProtocolVersion struct {
+	NewestMinorProtocol uint32
+	UnrecognizedOptions []string
+}
+
+// Backend identifies this message as sendable by the PostgreSQL backend.
+func (*NegotiateProtocolVersion) Backend() {}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier and 4 byte message length.
+func (dst *NegotiateProtocolVersion) Decode(src []byte) error {
+	if len(src) < 8 {
+		return &pgconnError{msg: "invalid NegotiateProtocolVersion message"}
+	}
+
+	dst.NewestMinorProtocol = binary.BigEndian.Uint32(src)
+	optionCount := int(binary.BigEndian.Uint32(src[4:]))
+	rp := 8
+
+	dst.UnrecognizedOptions = make([]string, 0, optionCount)
+	for i := 0; i < optionCount; i++ {
+		idx := bytes.IndexByte(src[rp:], 0)
+		if idx < 0 {
+			return &pgconnError{msg: "invalid NegotiateProtocolVersion message"}
+		}
+		dst.UnrecognizedOptions = append(dst.UnrecognizedOptions, string(src[rp:rp+idx]))
+		rp += idx + 1
+	}
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier and the 4 byte message length.
+func (src *NegotiateProtocolVersion) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, 'v')
+	sp := len(dst)
+	dst = pgio.AppendInt32(dst, -1)
+
+	dst = pgio.AppendUint32(dst, src.NewestMinorProtocol)
+	dst = pgio.AppendUint32(dst, uint32(len(src.UnrecognizedOptions)))
+	for _, option := range src.UnrecognizedOptions {
+		dst = append(dst, option...)
+		dst = append(dst, 0)
+	}
+
+	pgio.SetInt32(dst[sp:], int32(len(dst[sp:])))
+	return dst, nil
+}
+
+// MarshalJSON implements encoding/json.Marshaler.
+func (src NegotiateProtocolVersion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type                string
+		NewestMinorProtocol uint32
+		UnrecognizedOptions []string
+	}{
+		Type:                "NegotiateProtocolVersion",
+		NewestMinorProtocol: src.NewestMinorProtocol,
+		UnrecognizedOptions: src.UnrecognizedOptions,
+	})
+}
+
+// ExtendedBackendKeyData is a BackendKeyData message with a secret key that is not 4 bytes long. Servers may send
+// such a key with protocol 3.2.
+//
+// pgproto3 only supports 4 byte keys. The default Frontend returns ExtendedBackendKeyData for other keys while the
+// connection is established. A Frontend built by a custom BuildFrontend must return it if a protocol version newer than
+// 3.0 may be requested.
+type ExtendedBackendKeyData struct {
+	ProcessID uint32
+	SecretKey []byte
+}
+
+// Backend identifies this message as sendable by the PostgreSQL backend.
+func (*ExtendedBackendKeyData) Backend() {}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier and 4 byte message length.
+func (dst *ExtendedBackendKeyData) Decode(src []byte) error {
+	if len(src) < 8 || len(src) > 4+maxCancelKeyLen {
+		return &pgconnError{msg: fmt.Sprintf("invalid BackendKeyData message with a %d byte body", len(src))}
+	}
+
+	dst.ProcessID = binary.BigEndian.Uint32(src)
+	dst.SecretKey = append([]byte(nil), src[4:]...)
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier and the 4 byte message length.
+func (src *ExtendedBackendKeyData) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, 'K')
+	dst = pgio.AppendInt32(dst, int32(4+4+len(src.SecretKey)))
+	dst = pgio.AppendUint32(dst, src.ProcessID)
+	dst = append(dst, src.SecretKey...)
+	return dst, nil
+}
+
+// MarshalJSON implements encoding/json.Marshaler.
+func (src ExtendedBackendKeyData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type      string
+		ProcessID uint32
+		SecretKey []byte
+	}{
+		Type:      "BackendKeyData",
+		ProcessID: src.ProcessID,
+		SecretKey: src.SecretKey,
+	})
+}
+
+// secretKeyBytes returns the cancel key of a 4 byte secret key.
+func secretKeyBytes(secretKey uint32) []byte {
+	return pgio.AppendUint32(nil, secretKey)
+}
+
+// receiveStartupMessage receives a message while the connection is established. If a protocol version newer than 3.0
+// was requested and the default Frontend is used it decodes the messages that pgproto3 does not support.
+func (pgConn *PgConn) receiveStartupMessage() (pgproto3.BackendMessage, error) {
+	if pgConn.protocolVersion > ProtocolVersion30 && pgConn.statsReader.chunkReader != nil && pgConn.peekedMsg == nil {
+		msg, err := pgConn.statsReader.chunkReader.receiveProtocolMessage()
+		if msg != nil || err != nil {
+			return msg, pgConn.decodeError(err)
+		}
+	}
+
+	return pgConn.receiveAnyMessage()
+}
+
+// receiveProtocolMessage returns the next message if it is a NegotiateProtocolVersion or a BackendKeyData with a
+// secret key that is not 4 bytes long. Otherwise it returns nil and the header of the message is returned to the
+// Frontend by the next call to Next.
+func (scr *statsChunkReader) receiveProtocolMessage() (pgproto3.BackendMessage, error) {
+	if scr.inMessage || scr.headerRead {
+		return nil, nil
+	}
+
+	header, err := scr.nextHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	bodyLen := int(binary.BigEndian.Uint32(header[1:])) - 4
+	var msg pgproto3.BackendMessage
+	switch {
+	case bodyLen < 0:
+	case header[0] == 'v':
+		msg = &NegotiateProtocolVersion{}
+	case header[0] == 'K' && bodyLen != 8:
+		msg = &ExtendedBackendKeyData{}
+	}
+	if msg == nil {
+		copy(scr.header[:], header)
+		scr.headerRead = true
+		return nil, nil
+	}
+
+	body, err := scr.next(bodyLen)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return msg, msg.Decode(body)
+}
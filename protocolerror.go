@@ -0,0 +1,234 @@
+package pgconn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// messageHistoryLen is the number of the most recent messages that are kept for ProtocolError.
+const messageHistoryLen = 32
+
+// ProtocolMessage describes a message in the History of a ProtocolError.
+type ProtocolMessage struct {
+	FromServer bool
+	Type       byte // message type identifier; 0 for the startup message, which does not have one
+	Length     int  // message length as in the header, i.e. including the length itself but not the type identifier
+}
+
+func (m ProtocolMessage) String() string {
+	direction := "F"
+	if m.FromServer {
+		direction = "B"
+	}
+	if m.Type == 0 {
+		return fmt.Sprintf("%s startup(%d)", direction, m.Length)
+	}
+	return fmt.Sprintf("%s %c(%d)", direction, m.Type, m.Length)
+}
+
+// ProtocolError is returned when the server sends a message that is not valid at that point of the protocol or a
+// message that can not be decoded. This usually indicates a bug in the server or a proxy between the client and the
+// server. The connection is closed.
+//
+// History lists the most recent messages sent (F) and received (B) in order, ending with the offending message. It
+// makes such bugs diagnosable from the error alone. Received messages are only recorded if the Frontend was built by
+// the default Config.BuildFrontend.
+type ProtocolError struct {
+	Msg     string
+	History []ProtocolMessage
+
+	err error
+}
+
+func (e *ProtocolError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("protocol violation: ")
+	sb.WriteString(e.Msg)
+	if e.err != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.err.Error())
+	}
+	if len(e.History) > 0 {
+		sb.WriteString(" (recent messages:")
+		for i, m := range e.History {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteByte(' ')
+			sb.WriteString(m.String())
+		}
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.err
+}
+
+// newProtocolError returns a ProtocolError with the message history of the connection.
+func (pgConn *PgConn) newProtocolError(msg string, err error) *ProtocolError {
+	return &ProtocolError{Msg: msg, History: pgConn.history.messages(), err: err}
+}
+
+// decodeError returns a ProtocolError for err returned by the Frontend if the message was read but could not be
+// decoded, e.g. because its type is unknown or its length does not match its content. Otherwise it returns err. This
+// can only be distinguished for the default Frontend.
+func (pgConn *PgConn) decodeError(err error) error {
+	if err == nil || pgConn.statsReader == nil || pgConn.statsReader.chunkReader == nil ||
+		pgConn.statsReader.chunkReader.readErr != nil {
+		return err
+	}
+	return pgConn.newProtocolError("invalid message", err)
+}
+
+// messageHistory records the types and lengths of the most recent messages of a connection. Messages are sent and
+// received by different goroutines during CopyFrom and idle keepalives so it is protected by a mutex. All methods do
+// nothing if the messageHistory is nil.
+//
+// It also counts the sent messages that the server answers with a ReadyForQuery, so the idle keepalive knows whether
+// the server may still be responding to messages sent with SendBytes or SendMessage.
+type messageHistory struct {
+	mux     sync.Mutex
+	entries [messageHistoryLen]ProtocolMessage
+	next    int // index of the entry that is written next
+	full    bool
+
+	sendRemaining int     // bytes of the last message sent that have not been written yet
+	sendHeader    [5]byte // start of the header of a sent message that was split across writes
+	sendHeaderLen int
+
+	awaitingReadyForQuery int // Query, Sync, and FunctionCall messages sent that have not been answered yet
+}
+
+func (h *messageHistory) add(m ProtocolMessage) {
+	h.entries[h.next] = m
+	h.next++
+	if h.next == len(h.entries) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// received records a message received from the server by its 5 byte header.
+func (h *messageHistory) received(header []byte) {
+	if h == nil {
+		return
+	}
+
+	h.mux.Lock()
+	h.add(ProtocolMessage{FromServer: true, Type: header[0], Length: int(binary.BigEndian.Uint32(header[1:]))})
+	h.mux.Unlock()
+}
+
+// sentStartup records a startup message, which does not have a type identifier, of n bytes.
+func (h *messageHistory) sentStartup(n int) {
+	if h == nil {
+		return
+	}
+
+	h.mux.Lock()
+	h.add(ProtocolMessage{Length: n})
+	h.mux.Unlock()
+}
+
+// sent records the messages in buf, which is about to be written to the server. A message may be split across
+// multiple writes.
+func (h *messageHistory) sent(buf []byte) {
+	if h == nil {
+		return
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	for len(buf) > 0 {
+		if h.sendRemaining > 0 {
+			n := h.sendRemaining
+			if n > len(buf) {
+				n = len(buf)
+			}
+			h.sendRemaining -= n
+			buf = buf[n:]
+			continue
+		}
+
+		n := copy(h.sendHeader[h.sendHeaderLen:], buf)
+		h.sendHeaderLen += n
+		buf = buf[n:]
+		if h.sendHeaderLen < len(h.sendHeader) {
+			return
+		}
+
+		h.sendHeaderLen = 0
+		length := int(binary.BigEndian.Uint32(h.sendHeader[1:]))
+		h.add(ProtocolMessage{Type: h.sendHeader[0], Length: length})
+		switch h.sendHeader[0] {
+		case 'Q', 'S', 'F':
+			h.awaitingReadyForQuery++
+		}
+		if length > 4 {
+			h.sendRemaining = length - 4
+		}
+	}
+}
+
+// receivedReadyForQuery records that a ReadyForQuery was received. The ReadyForQuery that concludes the startup does
+// not answer a sent message.
+func (h *messageHistory) receivedReadyForQuery() {
+	if h == nil {
+		return
+	}
+
+	h.mux.Lock()
+	if h.awaitingReadyForQuery > 0 {
+		h.awaitingReadyForQuery--
+	}
+	h.mux.Unlock()
+}
+
+// isAwaitingReadyForQuery returns true if a sent message has not been answered with a ReadyForQuery yet.
+func (h *messageHistory) isAwaitingReadyForQuery() bool {
+	if h == nil {
+		return false
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.awaitingReadyForQuery > 0
+}
+
+// messages returns the recorded messages, oldest first.
+func (h *messageHistory) messages() []ProtocolMessage {
+	if h == nil {
+		return nil
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if !h.full {
+		return append([]ProtocolMessage(nil), h.entries[:h.next]...)
+	}
+	messages := make([]ProtocolMessage, 0, len(h.entries))
+	messages = append(messages, h.entries[h.next:]...)
+	return append(messages, h.entries[:h.next]...)
+}
+
+// write writes buf to the connection and records the messages in it. All messages after the startup message are
+// written with it.
+func (pgConn *PgConn) write(buf []byte) (int, error) {
+	pgConn.history.sent(buf)
+	return pgConn.conn.Write(buf)
+}
+
+// connWriter is an io.Writer that writes to the connection of pgConn with write.
+type connWriter struct {
+	pgConn *PgConn
+}
+
+func (w connWriter) Write(buf []byte) (int, error) {
+	return w.pgConn.write(buf)
+}
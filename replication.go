@@ -0,0 +1,14 @@
+package pgconn
+
+import (
+	"fmt"
+)
+
+// checkExtendedProtocol returns an error if the connection is a replication connection, which does not support the
+// extended query protocol used by op.
+func (pgConn *PgConn) checkExtendedProtocol(op string) error {
+	if mode := pgConn.config.ReplicationMode; mode != ReplicationModeOff {
+		return &pgconnError{msg: fmt.Sprintf("%s is not supported on a %v replication connection as it uses the extended query protocol", op, mode)}
+	}
+	return nil
+}
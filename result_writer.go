@@ -0,0 +1,136 @@
+package pgconn
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// WriteCSV writes the rows of rr to w and returns the command tag. If header is true the first record contains the
+// column names, even if there are no rows. NULL is written as an empty field. All columns must use the text format.
+//
+// WriteCSV always reads rr until it is closed so the connection remains usable even if writing fails. The first error
+// encountered is returned. w is flushed before returning.
+func (rr *ResultReader) WriteCSV(w *csv.Writer, header bool) (CommandTag, error) {
+	var writeErr error
+	wroteHeader := false
+	var record []string
+
+	for rr.NextRow() {
+		if writeErr != nil {
+			continue
+		}
+
+		if !wroteHeader {
+			writeErr = checkTextFormat(rr.FieldDescriptions())
+			if writeErr == nil && header {
+				writeErr = w.Write(columnNames(rr.FieldDescriptions()))
+			}
+			wroteHeader = true
+			if writeErr != nil {
+				continue
+			}
+		}
+
+		record = record[:0]
+		for _, v := range rr.Values() {
+			record = append(record, string(v))
+		}
+		writeErr = w.Write(record)
+	}
+
+	commandTag, err := rr.Close()
+	if writeErr == nil && !wroteHeader && header && rr.FieldDescriptions() != nil {
+		writeErr = w.Write(columnNames(rr.FieldDescriptions()))
+	}
+	if writeErr == nil {
+		w.Flush()
+		writeErr = w.Error()
+	}
+
+	if err != nil {
+		return commandTag, err
+	}
+	return commandTag, writeErr
+}
+
+// WriteJSON writes the rows of rr to w as a JSON array of objects and returns the command tag. The object keys are the
+// column names and the values are strings or null. A result without rows is written as an empty array. All columns
+// must use the text format.
+//
+// WriteJSON always reads rr until it is closed so the connection remains usable even if writing fails. The first error
+// encountered is returned.
+func (rr *ResultReader) WriteJSON(w io.Writer) (CommandTag, error) {
+	var writeErr error
+	var keys [][]byte
+	buf := []byte{'['}
+
+	for rr.NextRow() {
+		if writeErr != nil {
+			continue
+		}
+
+		if keys == nil {
+			writeErr = checkTextFormat(rr.FieldDescriptions())
+			if writeErr != nil {
+				continue
+			}
+			for _, name := range columnNames(rr.FieldDescriptions()) {
+				key, _ := json.Marshal(name)
+				keys = append(keys, key)
+			}
+		} else {
+			buf = append(buf, ',')
+		}
+
+		buf = append(buf, '{')
+		for i, v := range rr.Values() {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, keys[i]...)
+			buf = append(buf, ':')
+			if v == nil {
+				buf = append(buf, "null"...)
+			} else {
+				value, _ := json.Marshal(string(v))
+				buf = append(buf, value...)
+			}
+		}
+		buf = append(buf, '}')
+
+		_, writeErr = w.Write(buf)
+		buf = buf[:0]
+	}
+
+	commandTag, err := rr.Close()
+	if writeErr == nil {
+		buf = append(buf, ']')
+		_, writeErr = w.Write(buf)
+	}
+
+	if err != nil {
+		return commandTag, err
+	}
+	return commandTag, writeErr
+}
+
+func checkTextFormat(fieldDescriptions []pgproto3.FieldDescription) error {
+	for _, fd := range fieldDescriptions {
+		if fd.Format != TextFormatCode {
+			return fmt.Errorf("column %s is not in text format", fd.Name)
+		}
+	}
+	return nil
+}
+
+func columnNames(fieldDescriptions []pgproto3.FieldDescription) []string {
+	names := make([]string, len(fieldDescriptions))
+	for i, fd := range fieldDescriptions {
+		names[i] = string(fd.Name)
+	}
+	return names
+}
@@ -0,0 +1,165 @@
+package pgconn
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy controls how RetryConn retries operations.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times an operation is attempted including the first attempt. Values less
+	// than 2 disable retries.
+	MaxAttempts int
+
+	// Backoff returns the delay before retry n (starting at 1). If nil, retries are attempted immediately.
+	Backoff func(n int) time.Duration
+
+	// AfterReconnect, if set, is called with each new connection established to replace a broken one before the
+	// operation is retried on it. It can restore session state such as LISTEN registrations, session variables, and
+	// prepared statements. Unlike Config.AfterConnect it is not called for the initial connection. If it returns an
+	// error the new connection is closed and the reconnect is treated as failed.
+	AfterReconnect func(ctx context.Context, pgConn *PgConn) error
+}
+
+// RetryConn is a thin wrapper around a PgConn that transparently re-executes operations that failed before any data
+// was sent to the server (see SafeToRetry) because the connection was closed. A new connection is established with
+// the original Config before each retry. Operations are retried until they succeed, fail in a way that is not safe to
+// retry, the context is done, or RetryPolicy.MaxAttempts is reached.
+//
+// Only operations that do not depend on connection state are available. Session state such as prepared statements,
+// temporary tables, and session variables is not restored on the new connection unless RetryPolicy.AfterReconnect
+// restores it. Like PgConn, it is not safe for concurrent usage.
+type RetryConn struct {
+	config *Config
+	policy RetryPolicy
+	pgConn *PgConn
+}
+
+// ConnectRetryConn establishes a connection with config and returns a RetryConn that retries operations according to
+// policy. The initial connection attempt is not retried.
+func ConnectRetryConn(ctx context.Context, config *Config, policy RetryPolicy) (*RetryConn, error) {
+	pgConn, err := ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RetryConn{config: config, policy: policy, pgConn: pgConn}, nil
+}
+
+// PgConn returns the current underlying connection. It changes when an operation is retried on a new connection.
+func (rc *RetryConn) PgConn() *PgConn {
+	return rc.pgConn
+}
+
+// Close closes the current underlying connection.
+func (rc *RetryConn) Close(ctx context.Context) error {
+	return rc.pgConn.Close(ctx)
+}
+
+// Exec executes SQL via the PostgreSQL simple query protocol and reads all results. See PgConn.Exec for details.
+func (rc *RetryConn) Exec(ctx context.Context, sql string) ([]*Result, error) {
+	var results []*Result
+	err := rc.retry(ctx, func(pgConn *PgConn) error {
+		var err error
+		results, err = pgConn.Exec(ctx, sql).ReadAll()
+		return err
+	})
+	return results, err
+}
+
+// ExecParams executes a command via the PostgreSQL extended query protocol and reads the result. See
+// PgConn.ExecParams for parameter descriptions.
+func (rc *RetryConn) ExecParams(ctx context.Context, sql string, paramValues [][]byte, paramOIDs []uint32, paramFormats []int16, resultFormats []int16) *Result {
+	var result *Result
+	err := rc.retry(ctx, func(pgConn *PgConn) error {
+		result = pgConn.ExecParams(ctx, sql, paramValues, paramOIDs, paramFormats, resultFormats).Read()
+		return result.Err
+	})
+	// The last attempt may have been a failed reconnect rather than an execution.
+	if err != nil && (result == nil || err != result.Err) {
+		result = &Result{Err: err}
+	}
+	return result
+}
+
+// ExecBatch executes all the queries in batch in a single round-trip and reads all results. See PgConn.ExecBatch for
+// details. A batch that uses ExecPrepared will fail after a retry unless the statements are prepared again.
+func (rc *RetryConn) ExecBatch(ctx context.Context, batch *Batch) ([]*Result, error) {
+	var results []*Result
+	err := rc.retry(ctx, func(pgConn *PgConn) error {
+		var err error
+		results, err = pgConn.ExecBatch(ctx, batch).ReadAll()
+		return err
+	})
+	return results, err
+}
+
+// WaitForNotification waits for a LISTEN/NOTIFY message. See PgConn.WaitForNotification. If the connection breaks
+// while waiting a new connection is established and the wait continues on it. Notifications sent while no connection
+// was established are lost. RetryPolicy.AfterReconnect must LISTEN again on the new connection.
+func (rc *RetryConn) WaitForNotification(ctx context.Context) error {
+	return rc.retry(ctx, func(pgConn *PgConn) error {
+		err := pgConn.WaitForNotification(ctx)
+		if err != nil && pgConn.IsClosed() && ctx.Err() == nil {
+			// Waiting has no effect on the server so it is always safe to retry.
+			return &pgconnError{msg: "wait for notification failed", err: err, safeToRetry: true}
+		}
+		return err
+	})
+}
+
+func (rc *RetryConn) retry(ctx context.Context, f func(pgConn *PgConn) error) error {
+	reconnect := false
+	for attempt := 1; ; attempt++ {
+		var err error
+		if reconnect {
+			err = rc.reconnect(ctx)
+			if err == nil {
+				reconnect = false
+			}
+		}
+		if !reconnect {
+			err = f(rc.pgConn)
+		}
+
+		if err == nil || attempt >= rc.policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		// A failed reconnect is always retried. Otherwise only retry when nothing was sent and the connection is broken. A
+		// connection that is still open failed for another reason (e.g. it is busy) that a new attempt would not fix.
+		if !reconnect && !(SafeToRetry(err) && rc.pgConn.IsClosed()) {
+			return err
+		}
+		reconnect = true
+
+		if rc.policy.Backoff != nil {
+			timer := rc.config.clock().NewTimer(rc.policy.Backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C():
+			}
+		}
+	}
+}
+
+// reconnect replaces the current connection with a new connection.
+func (rc *RetryConn) reconnect(ctx context.Context) error {
+	pgConn, err := ConnectConfig(ctx, rc.config)
+	if err != nil {
+		return err
+	}
+
+	if rc.policy.AfterReconnect != nil {
+		err = rc.policy.AfterReconnect(ctx, pgConn)
+		if err != nil {
+			pgConn.Close(ctx)
+			return err
+		}
+	}
+
+	rc.pgConn = pgConn
+	return nil
+}
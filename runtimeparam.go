@@ -0,0 +1,41 @@
+package pgconn
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// SetRuntimeParam sets the run-time parameter name (e.g. search_path or a custom parameter such as app.tenant_id) to
+// value for the session like SET and records that it was modified. No escaping is necessary as name and value are sent
+// as query parameters of set_config.
+//
+// If a transaction is in progress and it is rolled back the parameter is restored by the server, but it remains
+// recorded as modified. ModifiedRuntimeParams may therefore list more parameters than differ from their defaults, but
+// never fewer than were changed with SetRuntimeParam.
+func (pgConn *PgConn) SetRuntimeParam(ctx context.Context, name, value string) error {
+	result := pgConn.ExecParams(ctx, "select set_config($1, $2, false)", [][]byte{[]byte(name), []byte(value)}, nil, nil, textResultFormats).Read()
+	if result.Err != nil {
+		return result.Err
+	}
+
+	if pgConn.modifiedRuntimeParams == nil {
+		pgConn.modifiedRuntimeParams = make(map[string]struct{})
+	}
+	// Parameter names are case-insensitive.
+	pgConn.modifiedRuntimeParams[strings.ToLower(name)] = struct{}{}
+	return nil
+}
+
+// ModifiedRuntimeParams returns the names of the run-time parameters, in lower case and sorted, that have been set with
+// SetRuntimeParam since the connection was established or last Reset. A connection pool can use it to reset only these
+// parameters (e.g. with RESET) before the connection is reused instead of discarding all session state. Parameters
+// changed by other means, e.g. a SET executed with Exec, are not included.
+func (pgConn *PgConn) ModifiedRuntimeParams() []string {
+	names := make([]string, 0, len(pgConn.modifiedRuntimeParams))
+	for name := range pgConn.modifiedRuntimeParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,545 @@
+package pgconn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgconn/internal/ctxwatch"
+	"github.com/jackc/pgproto3/v2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ServerAuthMethod is the authentication method that AcceptServerConn demands from the client.
+type ServerAuthMethod int
+
+const (
+	ServerAuthTrust             ServerAuthMethod = iota // no authentication
+	ServerAuthCleartextPassword                         // password sent in cleartext
+	ServerAuthMD5Password                               // MD5 digest of the password
+	ServerAuthSCRAMSHA256                               // SCRAM-SHA-256 without channel binding
+
+	// ServerAuthPassThrough leaves the authentication to the upstream server. AcceptServerConn does not authenticate the
+	// client and does not send AuthenticationOk. The exchange of the upstream server is relayed to the client (e.g. with
+	// ServerConn.RelaySASL) while the upstream PgConn connects. Ready then sends AuthenticationOk.
+	ServerAuthPassThrough
+)
+
+const (
+	// scramServerIterations is the iteration count that AcceptServerConn uses for SCRAM-SHA-256. It is the default of
+	// PostgreSQL.
+	scramServerIterations = 4096
+
+	scramServerSaltLen  = 16
+	scramServerNonceLen = 18
+)
+
+// ServerConnConfig configures the server side of the handshake performed by AcceptServerConn.
+type ServerConnConfig struct {
+	// TLSConfig, if set, is used to accept an SSLRequest from the client. If nil, an SSLRequest is declined and the
+	// client may continue unencrypted. A GSSEncRequest is always declined.
+	TLSConfig *tls.Config
+
+	// RequireTLS, if true, rejects clients that do not negotiate TLS. It requires TLSConfig.
+	RequireTLS bool
+
+	AuthMethod ServerAuthMethod
+
+	// GetPassword returns the password of the user that startupMsg connects as. It is required unless AuthMethod is
+	// ServerAuthTrust or ServerAuthPassThrough. If it returns an error the client is rejected as if it sent a wrong
+	// password.
+	GetPassword func(ctx context.Context, startupMsg *pgproto3.StartupMessage) (string, error)
+}
+
+// ServerConn is the client facing side of a connection accepted by a proxy or a server implemented in Go. It is
+// created by AcceptServerConn, which performs the startup and authentication. The handshake is completed with Ready,
+// usually with the parameter statuses and the key data of the upstream PgConn. It is not safe for concurrent usage.
+type ServerConn struct {
+	conn           net.Conn
+	backend        *pgproto3.Backend
+	contextWatcher *ctxwatch.ContextWatcher
+
+	startupMsg    *pgproto3.StartupMessage
+	cancelRequest *pgproto3.CancelRequest
+	authOKSent    bool
+}
+
+// AcceptServerConn performs the server side of the handshake of a client that connected with conn. It negotiates TLS as
+// configured, receives the StartupMessage, and authenticates the client with config.AuthMethod. ctx can be used to
+// cancel the handshake.
+//
+// If the client sent a CancelRequest instead of a StartupMessage the returned ServerConn has a non-nil CancelRequest and
+// the connection should be closed after the request is handled. Ready must not be called in that case.
+//
+// If the client fails to authenticate it is sent an ErrorResponse and a *PgError is returned. On any error conn is
+// closed.
+func AcceptServerConn(ctx context.Context, conn net.Conn, config *ServerConnConfig) (*ServerConn, error) {
+	if config.AuthMethod != ServerAuthTrust && config.AuthMethod != ServerAuthPassThrough && config.GetPassword == nil {
+		return nil, errors.New("GetPassword is required")
+	}
+	if config.RequireTLS && config.TLSConfig == nil {
+		return nil, errors.New("RequireTLS requires TLSConfig")
+	}
+
+	sc := &ServerConn{
+		conn:           conn,
+		backend:        pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn),
+		contextWatcher: newContextWatcher(conn),
+	}
+
+	sc.contextWatcher.Watch(ctx)
+	err := sc.receiveStartupMessage(ctx, config)
+	if err == nil && sc.cancelRequest == nil {
+		err = sc.authenticate(ctx, config)
+	}
+	sc.contextWatcher.Unwatch()
+
+	if err != nil {
+		sc.conn.Close()
+		if pgErr, ok := err.(*PgError); ok {
+			return nil, pgErr
+		}
+		return nil, &pgconnError{msg: "server handshake failed", err: preferContextOverNetTimeoutError(ctx, err)}
+	}
+
+	return sc, nil
+}
+
+func (sc *ServerConn) receiveStartupMessage(ctx context.Context, config *ServerConnConfig) error {
+	_, usingTLS := sc.conn.(*tls.Conn)
+
+	for {
+		msg, err := sc.backend.ReceiveStartupMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.StartupMessage:
+			if config.RequireTLS && !usingTLS {
+				return sc.reject("28000", "connection requires TLS")
+			}
+			sc.startupMsg = &pgproto3.StartupMessage{
+				ProtocolVersion: msg.ProtocolVersion,
+				Parameters:      make(map[string]string, len(msg.Parameters)),
+			}
+			for k, v := range msg.Parameters {
+				sc.startupMsg.Parameters[k] = v
+			}
+			return nil
+
+		case *pgproto3.CancelRequest:
+			sc.cancelRequest = &pgproto3.CancelRequest{ProcessID: msg.ProcessID, SecretKey: msg.SecretKey}
+			return nil
+
+		case *pgproto3.SSLRequest:
+			if config.TLSConfig == nil || usingTLS {
+				if _, err := sc.conn.Write([]byte("N")); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := sc.conn.Write([]byte("S")); err != nil {
+				return err
+			}
+			tlsConn := tls.Server(sc.conn, config.TLSConfig)
+			err := tlsConn.Handshake()
+			sc.contextWatcher.Unwatch() // Always unwatch the unencrypted conn after TLS.
+			if err != nil {
+				return err
+			}
+
+			sc.conn = tlsConn
+			sc.backend = pgproto3.NewBackend(pgproto3.NewChunkReader(tlsConn), tlsConn)
+			sc.contextWatcher = newContextWatcher(tlsConn)
+			sc.contextWatcher.Watch(ctx)
+			usingTLS = true
+
+		case *pgproto3.GSSEncRequest:
+			if _, err := sc.conn.Write([]byte("N")); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("received unexpected message %T", msg)
+		}
+	}
+}
+
+func (sc *ServerConn) authenticate(ctx context.Context, config *ServerConnConfig) error {
+	if config.AuthMethod == ServerAuthPassThrough {
+		return nil
+	}
+
+	if config.AuthMethod != ServerAuthTrust {
+		password, err := config.GetPassword(ctx, sc.startupMsg)
+		if err != nil {
+			return sc.rejectPassword()
+		}
+
+		var ok bool
+		switch config.AuthMethod {
+		case ServerAuthCleartextPassword:
+			ok, err = sc.cleartextPasswordAuth(password)
+		case ServerAuthMD5Password:
+			ok, err = sc.md5PasswordAuth(password)
+		case ServerAuthSCRAMSHA256:
+			ok, err = sc.scramAuth(password)
+		default:
+			return fmt.Errorf("unknown auth method %d", config.AuthMethod)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return sc.rejectPassword()
+		}
+	}
+
+	return sc.sendAuthenticationOk()
+}
+
+func (sc *ServerConn) sendAuthenticationOk() error {
+	err := sc.backend.Send(&pgproto3.AuthenticationOk{})
+	if err != nil {
+		return err
+	}
+	sc.authOKSent = true
+	return nil
+}
+
+func (sc *ServerConn) cleartextPasswordAuth(password string) (bool, error) {
+	err := sc.backend.Send(&pgproto3.AuthenticationCleartextPassword{})
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := sc.receivePasswordMessage(pgproto3.AuthTypeCleartextPassword)
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare([]byte(msg.Password), []byte(password)) == 1, nil
+}
+
+func (sc *ServerConn) md5PasswordAuth(password string) (bool, error) {
+	authMsg := &pgproto3.AuthenticationMD5Password{}
+	if _, err := io.ReadFull(rand.Reader, authMsg.Salt[:]); err != nil {
+		return false, err
+	}
+	err := sc.backend.Send(authMsg)
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := sc.receivePasswordMessage(pgproto3.AuthTypeMD5Password)
+	if err != nil {
+		return false, err
+	}
+
+	expected := "md5" + hexMD5(hexMD5(password+sc.startupMsg.Parameters["user"])+string(authMsg.Salt[:]))
+	return subtle.ConstantTimeCompare([]byte(msg.Password), []byte(expected)) == 1, nil
+}
+
+func (sc *ServerConn) receivePasswordMessage(authType uint32) (*pgproto3.PasswordMessage, error) {
+	msg, err := sc.receiveAuthResponse(authType)
+	if err != nil {
+		return nil, err
+	}
+	passwordMsg, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return nil, fmt.Errorf("expected PasswordMessage but received unexpected message %T", msg)
+	}
+	return passwordMsg, nil
+}
+
+// receiveAuthResponse receives the response of the client to an authentication request of authType.
+func (sc *ServerConn) receiveAuthResponse(authType uint32) (pgproto3.FrontendMessage, error) {
+	err := sc.backend.SetAuthType(authType)
+	if err != nil {
+		return nil, err
+	}
+	return sc.backend.Receive()
+}
+
+func (sc *ServerConn) scramAuth(password string) (bool, error) {
+	err := sc.backend.Send(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}})
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := sc.receiveAuthResponse(pgproto3.AuthTypeSASL)
+	if err != nil {
+		return false, err
+	}
+	initialResponse, ok := msg.(*pgproto3.SASLInitialResponse)
+	if !ok {
+		return false, fmt.Errorf("expected SASLInitialResponse but received unexpected message %T", msg)
+	}
+	if initialResponse.AuthMechanism != "SCRAM-SHA-256" {
+		return false, fmt.Errorf("client selected unsupported SASL mechanism %q", initialResponse.AuthMechanism)
+	}
+	gs2Header, clientFirstMessageBare, clientNonce, err := parseSCRAMClientFirstMessage(string(initialResponse.Data))
+	if err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, scramServerNonceLen+scramServerSaltLen)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return false, err
+	}
+	nonce := clientNonce + base64.RawStdEncoding.EncodeToString(buf[:scramServerNonceLen])
+	salt := buf[scramServerNonceLen:]
+	serverFirstMessage := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(salt), scramServerIterations)
+
+	err = sc.backend.Send(&pgproto3.AuthenticationSASLContinue{Data: []byte(serverFirstMessage)})
+	if err != nil {
+		return false, err
+	}
+
+	msg, err = sc.receiveAuthResponse(pgproto3.AuthTypeSASLContinue)
+	if err != nil {
+		return false, err
+	}
+	response, ok := msg.(*pgproto3.SASLResponse)
+	if !ok {
+		return false, fmt.Errorf("expected SASLResponse but received unexpected message %T", msg)
+	}
+
+	clientFinalMessage := string(response.Data)
+	idx := strings.LastIndex(clientFinalMessage, ",p=")
+	if idx == -1 {
+		return false, errors.New("invalid SCRAM client-final-message received from client: did not include p=")
+	}
+	clientFinalMessageWithoutProof := clientFinalMessage[:idx]
+	clientProof := clientFinalMessage[idx+len(",p="):]
+
+	if clientFinalMessageWithoutProof != "c="+base64.StdEncoding.EncodeToString([]byte(gs2Header))+",r="+nonce {
+		return false, errors.New("invalid SCRAM client-final-message received from client: channel binding or nonce does not match")
+	}
+
+	saltedPassword := pbkdf2.Key(scramNormalizePassword(password), salt, scramServerIterations, 32, sha256.New)
+	authMessage := []byte(clientFirstMessageBare + "," + serverFirstMessage + "," + clientFinalMessageWithoutProof)
+	if !hmac.Equal([]byte(clientProof), computeClientProof(saltedPassword, authMessage)) {
+		return false, nil
+	}
+
+	serverSignature := computeServerSignature(saltedPassword, authMessage)
+	err = sc.backend.Send(&pgproto3.AuthenticationSASLFinal{Data: append([]byte("v="), serverSignature...)})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// parseSCRAMClientFirstMessage splits a SCRAM client-first-message into the GS2 header and the bare message and
+// returns the client nonce. Channel binding is not supported.
+func parseSCRAMClientFirstMessage(msg string) (gs2Header, bare, nonce string, err error) {
+	if !strings.HasPrefix(msg, "n,") && !strings.HasPrefix(msg, "y,") {
+		return "", "", "", errors.New("invalid SCRAM client-first-message received from client: channel binding is not supported")
+	}
+
+	// The GS2 header ends after the optional authzid.
+	idx := strings.IndexByte(msg[2:], ',')
+	if idx == -1 {
+		return "", "", "", errors.New("invalid SCRAM client-first-message received from client")
+	}
+	gs2Header = msg[:2+idx+1]
+	bare = msg[len(gs2Header):]
+
+	for _, attr := range strings.Split(bare, ",") {
+		if strings.HasPrefix(attr, "r=") {
+			nonce = attr[2:]
+			break
+		}
+	}
+	if nonce == "" {
+		return "", "", "", errors.New("invalid SCRAM client-first-message received from client: did not include r=")
+	}
+
+	return gs2Header, bare, nonce, nil
+}
+
+// RelaySASL is an AuthenticationHandler that relays the SASL exchange of the upstream server to the client of sc,
+// which was accepted with ServerAuthPassThrough. The proxy does not need the credentials of the client as the client
+// authenticates with the upstream server itself. Mechanisms that use channel binding are not offered to the client as
+// the client and the upstream server see different TLS connections. If the upstream server rejects the client, the
+// error is sent to the client and returned.
+//
+//	config := upstreamConfig.Copy()
+//	config.User = sc.StartupMessage().Parameters["user"]
+//	config.AuthenticationHandlers = map[uint32]pgconn.AuthenticationHandler{pgproto3.AuthTypeSASL: sc.RelaySASL}
+//	upstream, err := pgconn.ConnectConfig(ctx, config)
+func (sc *ServerConn) RelaySASL(ctx context.Context, frontend AuthenticationFrontend, msg pgproto3.BackendMessage) error {
+	saslMsg, ok := msg.(*pgproto3.AuthenticationSASL)
+	if !ok {
+		return fmt.Errorf("expected AuthenticationSASL but received unexpected message %T", msg)
+	}
+
+	var mechanisms []string
+	for _, mechanism := range saslMsg.AuthMechanisms {
+		if !strings.HasSuffix(mechanism, "-PLUS") {
+			mechanisms = append(mechanisms, mechanism)
+		}
+	}
+	if len(mechanisms) == 0 {
+		return errors.New("upstream server only offers SASL mechanisms with channel binding")
+	}
+
+	sc.contextWatcher.Watch(ctx)
+	defer sc.contextWatcher.Unwatch()
+
+	err := sc.backend.Send(&pgproto3.AuthenticationSASL{AuthMechanisms: mechanisms})
+	if err != nil {
+		return err
+	}
+
+	authType := uint32(pgproto3.AuthTypeSASL)
+	for {
+		clientMsg, err := sc.receiveAuthResponse(authType)
+		if err != nil {
+			return fmt.Errorf("failed to receive SASL response from client: %w", err)
+		}
+		switch clientMsg.(type) {
+		case *pgproto3.SASLInitialResponse, *pgproto3.SASLResponse:
+		default:
+			return fmt.Errorf("received unexpected message %T from client during SASL exchange", clientMsg)
+		}
+
+		err = frontend.Send(clientMsg)
+		if err != nil {
+			return err
+		}
+
+		serverMsg, err := frontend.Receive()
+		if err != nil {
+			if pgErr, ok := err.(*PgError); ok {
+				sc.SendError(pgErr) // Ignore error as pgErr is more useful to the caller.
+			}
+			return err
+		}
+
+		switch serverMsg := serverMsg.(type) {
+		case *pgproto3.AuthenticationSASLContinue:
+			err = sc.backend.Send(serverMsg)
+			if err != nil {
+				return err
+			}
+			authType = pgproto3.AuthTypeSASLContinue
+		case *pgproto3.AuthenticationSASLFinal:
+			return sc.backend.Send(serverMsg)
+		case *pgproto3.ErrorResponse:
+			sc.backend.Send(serverMsg) // Ignore error as the server error is more useful to the caller.
+			return ErrorResponseToPgError(serverMsg)
+		default:
+			return fmt.Errorf("received unexpected message %T from server during SASL exchange", serverMsg)
+		}
+	}
+}
+
+// rejectPassword sends the error PostgreSQL sends for a failed password authentication and returns it.
+func (sc *ServerConn) rejectPassword() error {
+	return sc.reject("28P01", fmt.Sprintf("password authentication failed for user %q", sc.startupMsg.Parameters["user"]))
+}
+
+// reject sends an error with severity FATAL and returns it.
+func (sc *ServerConn) reject(code, message string) error {
+	pgErr := &PgError{Severity: "FATAL", SeverityUnlocalized: "FATAL", Code: code, Message: message}
+	sc.SendError(pgErr) // Ignore error as pgErr is more useful to the caller.
+	return pgErr
+}
+
+// Ready completes the handshake. It sends parameterStatuses (e.g. from PgConn.ParameterStatuses of the upstream
+// connection), BackendKeyData with processID and secretKey, and ReadyForQuery. AuthenticationOk is sent first if it was
+// not sent by AcceptServerConn. Afterwards the messages of the client can be received with Backend.
+func (sc *ServerConn) Ready(parameterStatuses map[string]string, processID, secretKey uint32) error {
+	if !sc.authOKSent {
+		if err := sc.sendAuthenticationOk(); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(parameterStatuses))
+	for name := range parameterStatuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf []byte
+	var err error
+	for _, name := range names {
+		buf, err = (&pgproto3.ParameterStatus{Name: name, Value: parameterStatuses[name]}).Encode(buf)
+		if err != nil {
+			return err
+		}
+	}
+	buf, err = (&pgproto3.BackendKeyData{ProcessID: processID, SecretKey: secretKey}).Encode(buf)
+	if err != nil {
+		return err
+	}
+	buf, err = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = sc.conn.Write(buf)
+	return err
+}
+
+// SendError sends pgErr to the client as an ErrorResponse, e.g. to report that the upstream connection could not be
+// established. The client closes the connection if the severity is FATAL.
+func (sc *ServerConn) SendError(pgErr *PgError) error {
+	return sc.backend.Send(&pgproto3.ErrorResponse{
+		Severity:            pgErr.Severity,
+		SeverityUnlocalized: pgErr.SeverityUnlocalized,
+		Code:                pgErr.Code,
+		Message:             pgErr.Message,
+		Detail:              pgErr.Detail,
+		Hint:                pgErr.Hint,
+		Position:            pgErr.Position,
+		InternalPosition:    pgErr.InternalPosition,
+		InternalQuery:       pgErr.InternalQuery,
+		Where:               pgErr.Where,
+		SchemaName:          pgErr.SchemaName,
+		TableName:           pgErr.TableName,
+		ColumnName:          pgErr.ColumnName,
+		DataTypeName:        pgErr.DataTypeName,
+		ConstraintName:      pgErr.ConstraintName,
+		File:                pgErr.File,
+		Line:                pgErr.Line,
+		Routine:             pgErr.Routine,
+	})
+}
+
+// Conn returns the connection to the client. It is a *tls.Conn if TLS was negotiated.
+func (sc *ServerConn) Conn() net.Conn {
+	return sc.conn
+}
+
+// Backend returns the Backend used to exchange messages with the client.
+func (sc *ServerConn) Backend() *pgproto3.Backend {
+	return sc.backend
+}
+
+// StartupMessage returns the StartupMessage sent by the client. The user and database are in its Parameters. It is nil
+// if the client sent a CancelRequest.
+func (sc *ServerConn) StartupMessage() *pgproto3.StartupMessage {
+	return sc.startupMsg
+}
+
+// CancelRequest returns the CancelRequest sent by the client in place of a StartupMessage or nil.
+func (sc *ServerConn) CancelRequest() *pgproto3.CancelRequest {
+	return sc.cancelRequest
+}
@@ -0,0 +1,291 @@
+package pgconn_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runServerConnServer accepts a connection with AcceptServerConn for each handler and calls the handler with the
+// result.
+func runServerConnServer(t *testing.T, config *pgconn.ServerConnConfig, handlers ...func(*pgconn.ServerConn, error) error) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
+
+		for _, handler := range handlers {
+			conn, err := ln.Accept()
+			if err != nil {
+				serverErrChan <- err
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			sc, err := pgconn.AcceptServerConn(ctx, conn, config)
+			cancel()
+			err = handler(sc, err)
+			conn.Close()
+			if err != nil {
+				serverErrChan <- err
+				return
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return fmt.Sprintf("host=%s port=%s user=jack password=secret dbname=mydb", host, port), serverErrChan
+}
+
+func serveReadyAndTerminate(sc *pgconn.ServerConn, err error) error {
+	if err != nil {
+		return err
+	}
+	if user := sc.StartupMessage().Parameters["user"]; user != "jack" {
+		return fmt.Errorf("unexpected user %q", user)
+	}
+	if database := sc.StartupMessage().Parameters["database"]; database != "mydb" {
+		return fmt.Errorf("unexpected database %q", database)
+	}
+
+	err = sc.Ready(map[string]string{"server_version": "14.5", "client_encoding": "UTF8"}, 42, 7)
+	if err != nil {
+		return err
+	}
+
+	msg, err := sc.Backend().Receive()
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*pgproto3.Terminate); !ok {
+		return fmt.Errorf("unexpected message %T", msg)
+	}
+	return nil
+}
+
+func TestAcceptServerConn(t *testing.T) {
+	t.Parallel()
+
+	cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+	require.NoError(t, err)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	getPassword := func(ctx context.Context, startupMsg *pgproto3.StartupMessage) (string, error) {
+		return "secret", nil
+	}
+
+	for _, tt := range []struct {
+		name    string
+		config  *pgconn.ServerConnConfig
+		sslmode string
+	}{
+		{name: "trust", config: &pgconn.ServerConnConfig{}, sslmode: "disable"},
+		{name: "cleartext", config: &pgconn.ServerConnConfig{AuthMethod: pgconn.ServerAuthCleartextPassword, GetPassword: getPassword}, sslmode: "disable"},
+		{name: "md5", config: &pgconn.ServerConnConfig{AuthMethod: pgconn.ServerAuthMD5Password, GetPassword: getPassword}, sslmode: "disable"},
+		{name: "scram", config: &pgconn.ServerConnConfig{AuthMethod: pgconn.ServerAuthSCRAMSHA256, GetPassword: getPassword}, sslmode: "disable"},
+		{name: "scram over tls", config: &pgconn.ServerConnConfig{TLSConfig: tlsConfig, RequireTLS: true, AuthMethod: pgconn.ServerAuthSCRAMSHA256, GetPassword: getPassword}, sslmode: "require"},
+	} {
+		connString, serverErrChan := runServerConnServer(t, tt.config, serveReadyAndTerminate)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		pgConn, err := pgconn.Connect(ctx, connString+" sslmode="+tt.sslmode)
+		require.NoErrorf(t, err, "%s", tt.name)
+
+		assert.Equalf(t, map[string]string{"server_version": "14.5", "client_encoding": "UTF8"}, pgConn.ParameterStatuses(), "%s", tt.name)
+		assert.Equalf(t, 140005, pgConn.ServerVersion(), "%s", tt.name)
+		assert.Equalf(t, uint32(42), pgConn.PID(), "%s", tt.name)
+		assert.Equalf(t, uint32(7), pgConn.SecretKey(), "%s", tt.name)
+		_, usingTLS := pgConn.Conn().(*tls.Conn)
+		assert.Equalf(t, tt.sslmode == "require", usingTLS, "%s", tt.name)
+
+		closeConn(t, pgConn)
+		assert.NoErrorf(t, <-serverErrChan, "%s", tt.name)
+		cancel()
+	}
+}
+
+func TestAcceptServerConnRejectsWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	getPassword := func(ctx context.Context, startupMsg *pgproto3.StartupMessage) (string, error) {
+		return "other", nil
+	}
+
+	for _, authMethod := range []pgconn.ServerAuthMethod{pgconn.ServerAuthCleartextPassword, pgconn.ServerAuthMD5Password, pgconn.ServerAuthSCRAMSHA256} {
+		config := &pgconn.ServerConnConfig{AuthMethod: authMethod, GetPassword: getPassword}
+		var serverErr error
+		connString, serverErrChan := runServerConnServer(t, config, func(sc *pgconn.ServerConn, err error) error {
+			serverErr = err
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := pgconn.Connect(ctx, connString+" sslmode=disable")
+		cancel()
+
+		var pgErr *pgconn.PgError
+		require.Truef(t, errors.As(err, &pgErr), "%d: %v", authMethod, err)
+		assert.Equal(t, "28P01", pgErr.Code)
+		assert.Equal(t, `password authentication failed for user "jack"`, pgErr.Message)
+
+		require.NoError(t, <-serverErrChan)
+		require.Truef(t, errors.As(serverErr, &pgErr), "%d: %v", authMethod, serverErr)
+		assert.Equal(t, "28P01", pgErr.Code)
+	}
+}
+
+func TestAcceptServerConnRequireTLS(t *testing.T) {
+	t.Parallel()
+
+	cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+	require.NoError(t, err)
+
+	config := &pgconn.ServerConnConfig{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}, RequireTLS: true}
+	connString, serverErrChan := runServerConnServer(t, config, func(sc *pgconn.ServerConn, err error) error {
+		if err == nil {
+			return errors.New("client without TLS was accepted")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pgconn.Connect(ctx, connString+" sslmode=disable")
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), "%v", err)
+	assert.Equal(t, "28000", pgErr.Code)
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestAcceptServerConnCancelRequest(t *testing.T) {
+	t.Parallel()
+
+	cancelRequestChan := make(chan *pgproto3.CancelRequest, 1)
+	connString, serverErrChan := runServerConnServer(t, &pgconn.ServerConnConfig{},
+		func(sc *pgconn.ServerConn, err error) error {
+			if err != nil {
+				return err
+			}
+			if sc.CancelRequest() != nil {
+				return errors.New("unexpected CancelRequest")
+			}
+			return sc.Ready(nil, 42, 7)
+		},
+		func(sc *pgconn.ServerConn, err error) error {
+			if err != nil {
+				return err
+			}
+			if sc.StartupMessage() != nil {
+				return errors.New("unexpected StartupMessage")
+			}
+			cancelRequestChan <- sc.CancelRequest()
+			return nil
+		},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(connString + " sslmode=disable")
+	require.NoError(t, err)
+	pgConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	require.NoError(t, pgConn.CancelRequest(ctx))
+	require.NoError(t, <-serverErrChan)
+	assert.Equal(t, &pgproto3.CancelRequest{ProcessID: 42, SecretKey: 7}, <-cancelRequestChan)
+
+	pgConn.Close(ctx)
+}
+
+func TestServerConnRelaySASL(t *testing.T) {
+	t.Parallel()
+
+	for _, password := range []string{"secret", "wrong"} {
+		upstreamConnString, upstreamErrChan := runServerConnServer(t, &pgconn.ServerConnConfig{
+			AuthMethod: pgconn.ServerAuthSCRAMSHA256,
+			GetPassword: func(ctx context.Context, startupMsg *pgproto3.StartupMessage) (string, error) {
+				return "secret", nil
+			},
+		}, func(sc *pgconn.ServerConn, err error) error {
+			if password == "wrong" {
+				if err == nil {
+					return errors.New("wrong password was accepted")
+				}
+				return nil
+			}
+			return serveReadyAndTerminate(sc, err)
+		})
+
+		upstreamConfig, err := pgconn.ParseConfig(upstreamConnString + " sslmode=disable")
+		require.NoError(t, err)
+		upstreamConfig.Password = "" // The proxy does not know the password.
+
+		proxyConnString, proxyErrChan := runServerConnServer(t, &pgconn.ServerConnConfig{AuthMethod: pgconn.ServerAuthPassThrough},
+			func(sc *pgconn.ServerConn, err error) error {
+				if err != nil {
+					return err
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				config := upstreamConfig.Copy()
+				config.User = sc.StartupMessage().Parameters["user"]
+				config.AuthenticationHandlers = map[uint32]pgconn.AuthenticationHandler{pgproto3.AuthTypeSASL: sc.RelaySASL}
+				upstream, err := pgconn.ConnectConfig(ctx, config)
+				if err != nil {
+					if password == "wrong" {
+						return nil
+					}
+					return err
+				}
+				defer upstream.Close(ctx)
+
+				err = sc.Ready(upstream.ParameterStatuses(), upstream.PID(), upstream.SecretKey())
+				if err != nil {
+					return err
+				}
+
+				msg, err := sc.Backend().Receive()
+				if err != nil {
+					return err
+				}
+				if _, ok := msg.(*pgproto3.Terminate); !ok {
+					return fmt.Errorf("unexpected message %T", msg)
+				}
+				return nil
+			},
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		pgConn, err := pgconn.Connect(ctx, proxyConnString+" sslmode=disable password="+password)
+		if password == "wrong" {
+			var pgErr *pgconn.PgError
+			require.True(t, errors.As(err, &pgErr), "%v", err)
+			assert.Equal(t, "28P01", pgErr.Code)
+		} else {
+			require.NoError(t, err)
+			assert.Equal(t, uint32(42), pgConn.PID())
+			assert.Equal(t, "14.5", pgConn.ParameterStatus("server_version"))
+			closeConn(t, pgConn)
+		}
+		cancel()
+
+		assert.NoErrorf(t, <-proxyErrChan, "password=%s", password)
+		assert.NoErrorf(t, <-upstreamErrChan, "password=%s", password)
+	}
+}
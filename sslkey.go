@@ -0,0 +1,249 @@
+// Client certificate private key loading
+//
+// Resources:
+//   https://tools.ietf.org/html/rfc5208
+//   https://tools.ietf.org/html/rfc8018
+
+package pgconn
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// UnsupportedSSLKeyError is returned (wrapped) by ParseConfig when sslkey is not in a supported format. Supported
+// formats are PKCS#1 RSA, SEC 1 EC, and PKCS#8 keys in PEM or DER encoding. PKCS#1 keys may be encrypted with the
+// legacy PEM encryption and PKCS#8 keys may be encrypted with PBES2 using PBKDF2 and AES-CBC or DES-EDE3-CBC.
+type UnsupportedSSLKeyError struct {
+	Format string // description of the unsupported format (e.g. the PEM block type)
+}
+
+func (e *UnsupportedSSLKeyError) Error() string {
+	return fmt.Sprintf("unsupported sslkey format: %s", e.Format)
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// maxPBKDF2IterationCount is the largest PBKDF2 iteration count of an encrypted PKCS#8 key that is accepted. The count
+// is read from the key, so without a limit a crafted key could make ParseConfig take arbitrarily long. OpenSSL uses
+// 2048 by default.
+const maxPBKDF2IterationCount = 10000000
+
+type sslKeyAlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm sslKeyAlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc sslKeyAlgorithmIdentifier
+	EncryptionScheme  sslKeyAlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                       `asn1:"optional"`
+	PRF            sslKeyAlgorithmIdentifier `asn1:"optional"`
+}
+
+// decodeSSLKey converts the contents of sslkey to an unencrypted PEM encoded key suitable for tls.X509KeyPair.
+// Encrypted keys are decrypted with sslpassword or, if that is empty or incorrect, the password returned by
+// parseConfigOptions.GetSSLPassword.
+func decodeSSLKey(buf []byte, sslpassword string, parseConfigOptions ParseConfigOptions) ([]byte, error) {
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return decodeDERSSLKey(buf, sslpassword, parseConfigOptions)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+		// If PEM is encrypted, attempt to decrypt using pass phrase
+		if x509.IsEncryptedPEMBlock(block) {
+			decryptedKey, err := decryptSSLKey(sslpassword, parseConfigOptions, func(password []byte) ([]byte, error) {
+				return x509.DecryptPEMBlock(block, password)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: decryptedKey}), nil
+		}
+		return pem.EncodeToMemory(block), nil
+	case "ENCRYPTED PRIVATE KEY":
+		decryptedKey, err := decryptPKCS8SSLKey(block.Bytes, sslpassword, parseConfigOptions)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: decryptedKey}), nil
+	default:
+		return nil, &UnsupportedSSLKeyError{Format: fmt.Sprintf("PEM block type %q", block.Type)}
+	}
+}
+
+func decodeDERSSLKey(der []byte, sslpassword string, parseConfigOptions ParseConfigOptions) ([]byte, error) {
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), nil
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	if _, err := x509.ParseECPrivateKey(der); err == nil {
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	}
+
+	var epki encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &epki); err == nil && len(rest) == 0 {
+		decryptedKey, err := decryptPKCS8SSLKey(der, sslpassword, parseConfigOptions)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: decryptedKey}), nil
+	}
+
+	return nil, &UnsupportedSSLKeyError{Format: "neither PEM nor a recognized DER encoding"}
+}
+
+// decryptSSLKey calls decrypt with sslpassword. If sslpassword is empty or decrypt fails it tries again with the
+// password from parseConfigOptions.GetSSLPassword.
+func decryptSSLKey(sslpassword string, parseConfigOptions ParseConfigOptions, decrypt func(password []byte) ([]byte, error)) ([]byte, error) {
+	if sslpassword != "" {
+		decryptedKey, err := decrypt([]byte(sslpassword))
+		if err == nil {
+			return decryptedKey, nil
+		}
+	}
+
+	if parseConfigOptions.GetSSLPassword != nil {
+		sslpassword = parseConfigOptions.GetSSLPassword(context.Background())
+	} else {
+		sslpassword = ""
+	}
+	if sslpassword == "" {
+		return nil, errors.New("unable to find sslpassword")
+	}
+
+	decryptedKey, err := decrypt([]byte(sslpassword))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt key: %w", err)
+	}
+	return decryptedKey, nil
+}
+
+func decryptPKCS8SSLKey(der []byte, sslpassword string, parseConfigOptions ParseConfigOptions) ([]byte, error) {
+	var epki encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &epki); err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted PKCS#8 sslkey: %w", err)
+	}
+
+	if !epki.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, &UnsupportedSSLKeyError{Format: fmt.Sprintf("encrypted PKCS#8 with encryption algorithm %v", epki.EncryptionAlgorithm.Algorithm)}
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(epki.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to decode PBES2 parameters: %w", err)
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, &UnsupportedSSLKeyError{Format: fmt.Sprintf("encrypted PKCS#8 with key derivation function %v", params.KeyDerivationFunc.Algorithm)}
+	}
+
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("failed to decode PBKDF2 parameters: %w", err)
+	}
+	if kdfParams.IterationCount <= 0 || kdfParams.IterationCount > maxPBKDF2IterationCount {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d", kdfParams.IterationCount)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, &UnsupportedSSLKeyError{Format: fmt.Sprintf("encrypted PKCS#8 with PBKDF2 PRF %v", kdfParams.PRF.Algorithm)}
+	}
+
+	var newCipher func(key []byte) (cipher.Block, error)
+	var keyLen int
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		newCipher, keyLen = aes.NewCipher, 16
+	case scheme.Equal(oidAES192CBC):
+		newCipher, keyLen = aes.NewCipher, 24
+	case scheme.Equal(oidAES256CBC):
+		newCipher, keyLen = aes.NewCipher, 32
+	case scheme.Equal(oidDESEDE3CBC):
+		newCipher, keyLen = des.NewTripleDESCipher, 24
+	default:
+		return nil, &UnsupportedSSLKeyError{Format: fmt.Sprintf("encrypted PKCS#8 with encryption scheme %v", scheme)}
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to decode encryption scheme IV: %w", err)
+	}
+
+	return decryptSSLKey(sslpassword, parseConfigOptions, func(password []byte) ([]byte, error) {
+		key := pbkdf2.Key(password, kdfParams.Salt, kdfParams.IterationCount, keyLen, prf)
+		block, err := newCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != block.BlockSize() || len(epki.EncryptedData) == 0 || len(epki.EncryptedData)%block.BlockSize() != 0 {
+			return nil, errors.New("invalid encrypted PKCS#8 data")
+		}
+
+		decrypted := make([]byte, len(epki.EncryptedData))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, epki.EncryptedData)
+
+		// Remove PKCS#7 padding. An incorrect password is almost always detected here or when parsing the key.
+		padLen := int(decrypted[len(decrypted)-1])
+		if padLen == 0 || padLen > block.BlockSize() || padLen > len(decrypted) {
+			return nil, x509.IncorrectPasswordError
+		}
+		for _, b := range decrypted[len(decrypted)-padLen:] {
+			if int(b) != padLen {
+				return nil, x509.IncorrectPasswordError
+			}
+		}
+		decrypted = decrypted[:len(decrypted)-padLen]
+
+		if _, err := x509.ParsePKCS8PrivateKey(decrypted); err != nil {
+			return nil, x509.IncorrectPasswordError
+		}
+
+		return decrypted, nil
+	})
+}
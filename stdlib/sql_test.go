@@ -0,0 +1,184 @@
+package stdlib_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stdlib"
+	"github.com/jackc/pgmock"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runPgmockServer(t *testing.T, script *pgmock.Script) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		serverErrChan <- script.Run(pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn))
+	}()
+
+	parts := strings.Split(ln.Addr().String(), ":")
+	return fmt.Sprintf("sslmode=disable host=%s port=%s", parts[0], parts[1]), serverErrChan
+}
+
+func TestConnector(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Parse{Query: "select $1::int, null"}),
+		pgmock.ExpectMessage(&pgproto3.Bind{Parameters: [][]byte{[]byte("42")}, ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ParseComplete{}),
+		pgmock.SendMessage(&pgproto3.BindComplete{}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			{Name: []byte("int4"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1},
+			{Name: []byte("text"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1},
+		}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("42"), nil}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectMessage(&pgproto3.Query{String: "begin isolation level serializable"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'T'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "delete from t"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("DELETE 3")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'T'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "commit"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("COMMIT")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	db := sql.OpenDB(stdlib.NewConnector(config))
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var n int
+	var s sql.NullString
+	err = db.QueryRowContext(ctx, "select $1::int, null", 42).Scan(&n, &s)
+	require.NoError(t, err)
+	assert.Equal(t, 42, n)
+	assert.False(t, s.Valid)
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	require.NoError(t, err)
+	result, err := tx.ExecContext(ctx, "delete from t")
+	require.NoError(t, err)
+	rowsAffected, err := result.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, rowsAffected)
+	require.NoError(t, tx.Commit())
+
+	require.NoError(t, db.Close())
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestConnQueryContextErrors(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Parse{Query: "select 1/0"}),
+		pgmock.ExpectMessage(&pgproto3.Bind{ResultFormatCodes: []int16{}}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Execute{}),
+		pgmock.ExpectMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "22012", Message: "division by zero"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	)
+	connString, serverErrChan := runPgmockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	// Writes fail once failWrites is set to simulate a connection that was lost while idle.
+	dialFunc := config.DialFunc
+	var failWrites int32
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialFunc(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &failingWriteConn{Conn: conn, fail: &failWrites}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driverConn, err := stdlib.NewConnector(config).Connect(ctx)
+	require.NoError(t, err)
+	conn := driverConn.(*stdlib.Conn)
+
+	_, err = conn.QueryContext(ctx, "select 1/0", nil)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "22012", pgErr.Code)
+	assert.NoError(t, <-serverErrChan)
+
+	atomic.StoreInt32(&failWrites, 1)
+	_, err = conn.QueryContext(ctx, "select 1", nil)
+	assert.Equal(t, driver.ErrBadConn, err)
+	assert.True(t, conn.PgConn().IsClosed())
+}
+
+type failingWriteConn struct {
+	net.Conn
+	fail *int32
+}
+
+func (c *failingWriteConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(c.fail) != 0 {
+		return 0, errors.New("connection lost")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestDriverRegistered(t *testing.T) {
+	db, err := sql.Open("pgconn", "host=localhost")
+	require.NoError(t, err)
+	assert.IsType(t, &stdlib.Driver{}, db.Driver())
+	require.NoError(t, db.Close())
+}
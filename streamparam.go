@@ -0,0 +1,187 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/jackc/pgio"
+	"github.com/jackc/pgproto3/v2"
+)
+
+// StreamParam is a parameter value for ExecParamsStream and ExecPreparedStream. If Reader is nil Value is sent like a
+// parameter of ExecParams. Otherwise exactly Len bytes are read from Reader and written directly to the connection as
+// the Bind message is sent, so a large value such as a file to be stored as bytea does not have to be held in memory.
+type StreamParam struct {
+	// Value is the value if Reader is nil. A nil Value is sent as NULL.
+	Value []byte
+
+	// Reader and Len are the source and the length of a streamed value. Reader must provide at least Len bytes. If it
+	// fails or ends early the message is incomplete and the connection is closed.
+	Reader io.Reader
+	Len    int
+}
+
+// ExecParamsStream is like ExecParams but the parameter values may be streamed from an io.Reader. See StreamParam.
+//
+// ResultReader must be closed before PgConn can be used again.
+func (pgConn *PgConn) ExecParamsStream(ctx context.Context, sql string, params []StreamParam, paramOIDs []uint32, paramFormats []int16, resultFormats []int16) *ResultReader {
+	return pgConn.execExtendedStream(ctx, "ExecParamsStream", &pgproto3.Parse{Query: sql, ParameterOIDs: paramOIDs}, "", params, paramFormats, resultFormats)
+}
+
+// ExecPreparedStream is like ExecPrepared but the parameter values may be streamed from an io.Reader. See StreamParam.
+//
+// ResultReader must be closed before PgConn can be used again.
+func (pgConn *PgConn) ExecPreparedStream(ctx context.Context, stmtName string, params []StreamParam, paramFormats []int16, resultFormats []int16) *ResultReader {
+	return pgConn.execExtendedStream(ctx, "ExecPreparedStream", nil, stmtName, params, paramFormats, resultFormats)
+}
+
+// execExtendedStream sends parse if it is not nil and a Bind message that is written in parts around the streamed
+// parameter values followed by the same messages as execExtendedSuffix.
+func (pgConn *PgConn) execExtendedStream(ctx context.Context, op string, parse *pgproto3.Parse, stmtName string, params []StreamParam, paramFormats []int16, resultFormats []int16) *ResultReader {
+	result := pgConn.execExtendedPrefix(ctx, op, len(params))
+	if result.closed {
+		return result
+	}
+	resultFormats = pgConn.resultFormatsOrDefault(resultFormats)
+
+	buf := pgConn.wbuf
+	var err error
+	if parse != nil {
+		buf, err = parse.Encode(buf)
+		if err != nil {
+			result.concludeCommand(nil, err)
+			pgConn.contextWatcher.Unwatch()
+			result.closed = true
+			pgConn.unlock()
+			return result
+		}
+	}
+
+	buf, err = appendStreamBindHeader(buf, stmtName, params, paramFormats, resultFormats)
+	if err != nil {
+		result.concludeCommand(nil, err)
+		pgConn.contextWatcher.Unwatch()
+		result.closed = true
+		pgConn.unlock()
+		return result
+	}
+
+	w := &streamParamWriter{w: connWriter{pgConn: pgConn}}
+	for i, p := range params {
+		if p.Reader == nil {
+			if p.Value == nil {
+				buf = pgio.AppendInt32(buf, -1)
+			} else {
+				buf = pgio.AppendInt32(buf, int32(len(p.Value)))
+				buf = append(buf, p.Value...)
+			}
+			continue
+		}
+
+		buf = pgio.AppendInt32(buf, int32(p.Len))
+		_, err = w.Write(buf)
+		if err == nil {
+			buf = buf[:0]
+			_, err = io.CopyN(w, p.Reader, int64(p.Len))
+		}
+		if err != nil {
+			if w.err != nil {
+				err = pgConn.newWriteError(w.err, w.n)
+			} else {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				err = &pgconnError{msg: fmt.Sprintf("cannot read parameter %d", i+1), err: err, safeToRetry: w.n == 0}
+			}
+			pgConn.asyncClose(err)
+			result.concludeCommand(nil, err)
+			pgConn.contextWatcher.Unwatch()
+			result.closed = true
+			pgConn.unlock()
+			return result
+		}
+	}
+
+	buf = pgio.AppendUint16(buf, uint16(len(resultFormats)))
+	for _, fc := range resultFormats {
+		buf = pgio.AppendInt16(buf, fc)
+	}
+
+	buf, _ = (&pgproto3.Describe{ObjectType: 'P'}).Encode(buf)
+	buf, _ = (&pgproto3.Execute{}).Encode(buf)
+	buf, _ = (&pgproto3.Sync{}).Encode(buf)
+
+	_, err = w.Write(buf)
+	if err != nil {
+		err = pgConn.newWriteError(err, w.n)
+		pgConn.asyncClose(err)
+		result.concludeCommand(nil, err)
+		pgConn.contextWatcher.Unwatch()
+		result.closed = true
+		pgConn.unlock()
+		return result
+	}
+
+	result.readUntilRowDescription()
+
+	return result
+}
+
+// appendStreamBindHeader appends the start of a Bind message for params up to the length of the first parameter
+// value. The message length includes the streamed values.
+func appendStreamBindHeader(buf []byte, stmtName string, params []StreamParam, paramFormats []int16, resultFormats []int16) ([]byte, error) {
+	if len(paramFormats) > math.MaxUint16 {
+		return buf, errors.New("too many parameter format codes")
+	}
+	if len(resultFormats) > math.MaxUint16 {
+		return buf, errors.New("too many result format codes")
+	}
+
+	msgLen := int64(4 + 1 + len(stmtName) + 1 + 2 + 2*len(paramFormats) + 2 + 2 + 2*len(resultFormats))
+	for i, p := range params {
+		msgLen += 4
+		if p.Reader == nil {
+			msgLen += int64(len(p.Value))
+		} else if p.Len < 0 {
+			return buf, fmt.Errorf("parameter %d has negative length %d", i+1, p.Len)
+		} else {
+			msgLen += int64(p.Len)
+		}
+	}
+	if msgLen > math.MaxInt32 {
+		return buf, fmt.Errorf("bind message of %d bytes is too large", msgLen)
+	}
+
+	buf = append(buf, 'B')
+	buf = pgio.AppendInt32(buf, int32(msgLen))
+	buf = append(buf, 0) // unnamed portal
+	buf = append(buf, stmtName...)
+	buf = append(buf, 0)
+	buf = pgio.AppendUint16(buf, uint16(len(paramFormats)))
+	for _, fc := range paramFormats {
+		buf = pgio.AppendInt16(buf, fc)
+	}
+	buf = pgio.AppendUint16(buf, uint16(len(params)))
+
+	return buf, nil
+}
+
+// streamParamWriter counts the bytes written and records the error of the underlying writer so io.CopyN errors can be
+// attributed to the connection or the parameter reader.
+type streamParamWriter struct {
+	w   io.Writer
+	n   int
+	err error
+}
+
+func (w *streamParamWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += n
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}